@@ -3,7 +3,13 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
+
+	"csi-driver/internal/pkg/seal"
 
 	"github.com/caarlos0/env/v10"
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -17,6 +23,23 @@ type envConfig struct {
 }
 
 func main() {
+	command := "info"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	switch command {
+	case "info":
+		nodeInfo()
+	case "seal":
+		sealData(os.Args[2:])
+	default:
+		log.Fatalf("unknown command %q, expected one of: info, seal", command)
+	}
+}
+
+// nodeInfo prints the NodeGetInfo response of the driver at SOCKET_PATH.
+func nodeInfo() {
 	logger := zap.Must(zap.NewDevelopment())
 
 	envVars := &envConfig{}
@@ -45,3 +68,45 @@ func main() {
 		zap.Any("response", resp),
 	)
 }
+
+// sealData seals stdin to the public key of a driver for the pods of a
+// namespace and prints the value for the
+// csi-driver.mattslater.io/encrypted-data attribute.
+func sealData(args []string) {
+	flags := flag.NewFlagSet("seal", flag.ExitOnError)
+	publicKeyPath := flags.String("public-key", "", "PEM encoded X25519 public key of the driver")
+	namespace := flags.String("namespace", "", "namespace of the pods that may open the data")
+	serviceAccount := flags.String("service-account", "", "service account the pods run as, empty allows all")
+
+	_ = flags.Parse(args)
+
+	if *publicKeyPath == "" {
+		log.Fatal("-public-key is required")
+	}
+
+	if *namespace == "" {
+		log.Fatal("-namespace is required")
+	}
+
+	keyPEM, err := os.ReadFile(*publicKeyPath)
+	if err != nil {
+		log.Fatalf("failed to read public key: %s", err)
+	}
+
+	publicKey, err := seal.ParsePublicKey(keyPEM)
+	if err != nil {
+		log.Fatalf("failed to parse public key: %s", err)
+	}
+
+	plaintext, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatalf("failed to read stdin: %s", err)
+	}
+
+	sealed, err := seal.Seal(publicKey, plaintext, seal.Scope{Namespace: *namespace, ServiceAccount: *serviceAccount})
+	if err != nil {
+		log.Fatalf("failed to seal data: %s", err)
+	}
+
+	fmt.Println(sealed) //nolint:forbidigo // output of the command.
+}
@@ -2,15 +2,33 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
-	"csi-driver/internal/pkg/driver"
+	"csi-driver/internal/pkg/admin"
+	"csi-driver/internal/pkg/bundle"
+	"csi-driver/internal/pkg/ca"
+	"csi-driver/internal/pkg/cache"
+	"csi-driver/internal/pkg/command"
+	"csi-driver/internal/pkg/fetch"
+	"csi-driver/internal/pkg/hook"
+	"csi-driver/internal/pkg/jwt"
+	"csi-driver/internal/pkg/metrics"
+	"csi-driver/internal/pkg/policy"
+	"csi-driver/internal/pkg/provider"
+	"csi-driver/internal/pkg/seal"
 	"csi-driver/internal/pkg/server"
+	"csi-driver/internal/pkg/signature"
 	"csi-driver/internal/pkg/storage"
+	"csi-driver/pkg/csidriver"
 
 	"github.com/caarlos0/env/v10"
 	"go.uber.org/zap"
@@ -18,13 +36,104 @@ import (
 )
 
 const (
-	unixDomain = "unix"
-	name       = "csi-driver.mattslater.io"
+	unixDomain      = "unix"
+	name            = "csi-driver.mattslater.io"
+	jwksPerms       = 0o644
+	shutdownTimeout = 10 * time.Second
+
+	jwksEndpoint    = "/.well-known/jwks.json"
+	metricsEndpoint = "/debug/vars"
+	bundlesEndpoint = "/bundles"
+	// sealingKeyEndpoint serves the public key to seal encrypted-data to.
+	sealingKeyEndpoint = "/.well-known/sealing-key.pem"
 )
 
 type envConfig struct {
-	NodeID        string `env:"NODE_ID"`
-	CSISocketPath string `env:"CSI_SOCKET_PATH"`
+	NodeID        string        `env:"NODE_ID"`
+	CSISocketPath string        `env:"CSI_SOCKET_PATH"`
+	CACertPath    string        `env:"CA_CERT_PATH"`
+	CAKeyPath     string        `env:"CA_KEY_PATH"`
+	CAValidity    time.Duration `env:"CA_VALIDITY" envDefault:"8760h"`
+	// CertificateAllowedNames are patterns of certificate names pods may
+	// request beyond those of their own namespace, e.g. *.example.com.
+	CertificateAllowedNames []string `env:"CERTIFICATE_ALLOWED_NAMES" envSeparator:","`
+	// HTTPListenAddr enables the node local HTTP endpoints, the JWKS and the
+	// sealing key, on a loopback address such as 127.0.0.1:9809.
+	HTTPListenAddr    string `env:"HTTP_LISTEN_ADDR"`
+	JWTSigningKeyPath string `env:"JWT_SIGNING_KEY_PATH"`
+	JWTIssuer         string `env:"JWT_ISSUER"`
+	JWKSPath          string `env:"JWKS_PATH"`
+	SealingKeyPath    string `env:"SEALING_KEY_PATH"`
+	TrustedKeysPath   string `env:"TRUSTED_KEYS_PATH"`
+	RequireSignatures bool   `env:"REQUIRE_SIGNATURES"`
+	// FetchAllowedHosts enables the url source for the hosts, wildcards such
+	// as *.example.com and CIDRs it lists. Loopback, link-local, shared and
+	// unique local addresses are only reached when a CIDR within them is
+	// listed.
+	FetchAllowedHosts []string `env:"FETCH_ALLOWED_HOSTS" envSeparator:","`
+	// FetchMaxSize, FetchTimeout and FetchRetries bound the url source.
+	FetchMaxSize int64         `env:"FETCH_MAX_SIZE" envDefault:"67108864"`
+	FetchTimeout time.Duration `env:"FETCH_TIMEOUT" envDefault:"30s"`
+	FetchRetries int           `env:"FETCH_RETRIES" envDefault:"3"`
+	FetchBackoff time.Duration `env:"FETCH_BACKOFF" envDefault:"1s"`
+	// PrepareWorkers bounds concurrent content preparation, PublishTimeout
+	// bounds how long NodePublishVolume waits for it.
+	PrepareWorkers int           `env:"PREPARE_WORKERS" envDefault:"4"`
+	PublishTimeout time.Duration `env:"PUBLISH_TIMEOUT" envDefault:"10s"`
+	// CacheDir holds the node content cache, OCILayoutsDir the image layouts
+	// the oci source may read.
+	CacheDir      string `env:"CACHE_DIR" envDefault:"/cache-dir"`
+	OCILayoutsDir string `env:"OCI_LAYOUTS_DIR"`
+	OCIMaxSize    int64  `env:"OCI_MAX_SIZE" envDefault:"1073741824"`
+	// MaxVolumeSize bounds the content of a volume in bytes, zero means no
+	// limit. Setting it enables the synthetic source.
+	MaxVolumeSize int64 `env:"MAX_VOLUME_SIZE" envDefault:"0"`
+	// ProvidersDir holds the sockets of content providers.
+	ProvidersDir string `env:"PROVIDERS_DIR"`
+	// BundlesDir holds named content bundles, polled every BundlesInterval.
+	BundlesDir      string        `env:"BUNDLES_DIR"`
+	BundlesInterval time.Duration `env:"BUNDLES_INTERVAL" envDefault:"10s"`
+	// CommandsConfig configures the commands of the command source, which
+	// run in scratch dirs below CommandsWorkDir.
+	CommandsConfig  string `env:"COMMANDS_CONFIG"`
+	CommandsWorkDir string `env:"COMMANDS_WORK_DIR" envDefault:"/tmp/csi-driver-commands"`
+	// HooksConfig configures lifecycle hooks, exec hooks run in scratch dirs
+	// below HooksWorkDir.
+	HooksConfig  string `env:"HOOKS_CONFIG"`
+	HooksWorkDir string `env:"HOOKS_WORK_DIR" envDefault:"/tmp/csi-driver-hooks"`
+	// TopologyNodeKey, TopologyZone, TopologyRegion and TopologySegments
+	// make up the topology of the node. Empty values are left out.
+	TopologyNodeKey  string            `env:"TOPOLOGY_NODE_KEY"`
+	TopologyZone     string            `env:"TOPOLOGY_ZONE"`
+	TopologyRegion   string            `env:"TOPOLOGY_REGION"`
+	TopologySegments map[string]string `env:"TOPOLOGY_SEGMENTS"`
+	// MaxVolumesPerNode limits the volumes on the node, zero means no limit.
+	MaxVolumesPerNode int64 `env:"MAX_VOLUMES_PER_NODE" envDefault:"0"`
+	// QuotasPath configures per-namespace quotas as JSON, e.g.
+	// {"default": {"bytes": 67108864, "volumes": 20}}.
+	QuotasPath string `env:"QUOTAS_PATH"`
+	// PolicyPath is the policy file admitting volume attributes, polled
+	// every PolicyInterval.
+	PolicyPath     string        `env:"POLICY_PATH"`
+	PolicyInterval time.Duration `env:"POLICY_INTERVAL" envDefault:"10s"`
+	// RefreshInterval is how often volumes are checked for expiring content.
+	RefreshInterval time.Duration `env:"REFRESH_INTERVAL" envDefault:"10s"`
+	// VerifyInterval is how often volume content is verified against what
+	// was written, zero disables it. RepairDrift restores drifted content.
+	VerifyInterval time.Duration `env:"VERIFY_INTERVAL" envDefault:"5m"`
+	RepairDrift    bool          `env:"REPAIR_DRIFT" envDefault:"false"`
+	// KeepVersions is how many replaced versions of its content every volume
+	// keeps for rollback. Kept versions are not counted towards quotas.
+	KeepVersions int `env:"KEEP_VERSIONS" envDefault:"0"`
+	// AdminSocketPath enables the admin API on a unix socket, e.g. to roll
+	// back volume content. The socket also serves the metrics and the bundle
+	// versions of every volume.
+	AdminSocketPath string `env:"ADMIN_SOCKET_PATH"`
+	// MaxRetention bounds the retention-period attribute. Zero, the default,
+	// disables it, so retaining data is opt-in. Retained data is swept every
+	// SweepInterval once its period ended.
+	MaxRetention  time.Duration `env:"MAX_RETENTION_PERIOD" envDefault:"0"`
+	SweepInterval time.Duration `env:"SWEEP_INTERVAL" envDefault:"1m"`
 }
 
 var (
@@ -32,6 +141,11 @@ var (
 	commit  string
 )
 
+var (
+	errNoTrustedKeys = errors.New("REQUIRE_SIGNATURES needs TRUSTED_KEYS_PATH")
+	errNotLoopback   = errors.New("HTTP_LISTEN_ADDR must be a loopback address")
+)
+
 func run() error {
 	logger := zap.Must(zap.NewProduction(zap.Fields(zap.String("component", "csi-driver"))))
 	defer logger.Sync() //nolint:errcheck
@@ -49,14 +163,161 @@ func run() error {
 		sugar.Fatal("failed to parse env vars", err)
 	}
 
-	storageBackend, err := storage.NewFilesystem(
-		logger.With(zap.String("subsystem", "fs storage backend")),
-		"/storage-dir",
-		os.DirFS("/"),
-		mount.New(""),
-	)
+	certificateAuthority, err := loadCA(envVars)
+	if err != nil {
+		return err
+	}
+
+	tokenIssuer, err := loadTokenIssuer(envVars)
+	if err != nil {
+		return err
+	}
+
+	contentCache, err := cache.New(logger.With(zap.String("subsystem", "cache")), envVars.CacheDir)
+	if err != nil {
+		return fmt.Errorf("failed to open content cache: %w", err)
+	}
+
+	storageOpts := []storage.Option{
+		storage.WithContentCache(contentCache),
+		storage.WithCertificateIssuer(certificateAuthority),
+		storage.WithAllowedCertificateNames(envVars.CertificateAllowedNames),
+		storage.WithTokenIssuer(tokenIssuer),
+	}
+
+	if len(envVars.FetchAllowedHosts) > 0 {
+		allowed, err := fetch.ParseAllowlist(envVars.FetchAllowedHosts)
+		if err != nil {
+			return fmt.Errorf("failed to parse FETCH_ALLOWED_HOSTS: %w", err)
+		}
+
+		storageOpts = append(storageOpts, storage.WithFetcher(fetch.NewFetcher(
+			logger.With(zap.String("subsystem", "fetcher")),
+			allowed,
+			envVars.FetchMaxSize,
+			envVars.FetchTimeout,
+			envVars.FetchRetries,
+			envVars.FetchBackoff,
+		)))
+	}
+
+	if envVars.OCILayoutsDir != "" {
+		storageOpts = append(storageOpts, storage.WithOCILayouts(envVars.OCILayoutsDir, envVars.OCIMaxSize))
+	}
+
+	if envVars.MaxVolumeSize > 0 {
+		storageOpts = append(storageOpts, storage.WithMaxVolumeSize(envVars.MaxVolumeSize))
+	}
+
+	if envVars.QuotasPath != "" {
+		quotas, err := loadQuotas(envVars.QuotasPath)
+		if err != nil {
+			return err
+		}
+
+		storageOpts = append(storageOpts, storage.WithQuotas(quotas))
+	}
+
+	if envVars.ProvidersDir != "" {
+		providers := provider.NewRegistry(envVars.ProvidersDir)
+		defer providers.Close() //nolint:errcheck
+
+		storageOpts = append(storageOpts, storage.WithProviders(providers))
+	}
+
+	if envVars.CommandsConfig != "" {
+		commands, err := command.Load(
+			logger.With(zap.String("subsystem", "commands")),
+			envVars.CommandsConfig,
+			envVars.CommandsWorkDir,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to load commands: %w", err)
+		}
+
+		storageOpts = append(storageOpts, storage.WithCommands(commands))
+	}
+
+	var bundles *bundle.Store
+
+	if envVars.BundlesDir != "" {
+		bundles, err = bundle.NewStore(logger.With(zap.String("subsystem", "bundles")), envVars.BundlesDir)
+		if err != nil {
+			return fmt.Errorf("failed to load bundles: %w", err)
+		}
+
+		storageOpts = append(storageOpts, storage.WithBundles(bundles))
+	}
+
+	var sealingPublicKey []byte
+
+	if envVars.SealingKeyPath != "" {
+		opener, err := loadOpener(envVars.SealingKeyPath)
+		if err != nil {
+			return err
+		}
+
+		sealingPublicKey, err = seal.MarshalPublicKey(opener.PublicKey())
+		if err != nil {
+			return fmt.Errorf("failed to marshal sealing public key: %w", err)
+		}
+
+		storageOpts = append(storageOpts, storage.WithUnsealer(opener))
+	}
+
+	if envVars.TrustedKeysPath != "" {
+		verifier, err := signature.LoadVerifier(envVars.TrustedKeysPath)
+		if err != nil {
+			return fmt.Errorf("failed to load trusted keys: %w", err)
+		}
+
+		storageOpts = append(storageOpts, storage.WithSignatureVerifier(verifier, envVars.RequireSignatures))
+	} else if envVars.RequireSignatures {
+		return errNoTrustedKeys
+	}
+
+	var hooks *hook.Runner
+
+	if envVars.HooksConfig != "" {
+		hooks, err = hook.Load(logger.Named("audit"), envVars.HooksConfig, envVars.HooksWorkDir)
+		if err != nil {
+			return fmt.Errorf("failed to load hooks: %w", err)
+		}
+	}
+
+	var admission *policy.Store
+
+	if envVars.PolicyPath != "" {
+		admission, err = policy.NewStore(logger.With(zap.String("subsystem", "policy")), envVars.PolicyPath)
+		if err != nil {
+			return fmt.Errorf("failed to load policy: %w", err)
+		}
+	}
+
+	csiDriver, err := csidriver.New(csidriver.Options{
+		Name:            name,
+		Version:         version,
+		NodeID:          envVars.NodeID,
+		Logger:          logger,
+		Mounter:         mount.New(""),
+		StorageDir:      "/storage-dir",
+		StorageOptions:  storageOpts,
+		PrepareWorkers:  envVars.PrepareWorkers,
+		PublishTimeout:  envVars.PublishTimeout,
+		RefreshInterval: envVars.RefreshInterval,
+		VerifyInterval:  envVars.VerifyInterval,
+		RepairDrift:     envVars.RepairDrift,
+		KeepVersions:    envVars.KeepVersions,
+		MaxRetention:    envVars.MaxRetention,
+		SweepInterval:   envVars.SweepInterval,
+		Hooks:           hooks,
+		Policy:          admission,
+
+		Topology:          topology(envVars),
+		MaxVolumesPerNode: envVars.MaxVolumesPerNode,
+	})
 	if err != nil {
-		sugar.Fatal("failed to create storage backend", err)
+		sugar.Fatal("failed to create driver", err)
 	}
 
 	err = os.Remove(envVars.CSISocketPath)
@@ -69,38 +330,118 @@ func run() error {
 		return fmt.Errorf("failed to listen: %w", err)
 	}
 
-	grpcServer := server.NewExtendedGRPCServer(
-		listener,
-		&driver.IdentityServer{
-			Name:    name,
-			Version: version,
-		},
-		&driver.NodeServer{
-			NodeID:         envVars.NodeID,
-			Logger:         logger,
-			Mounter:        mount.New(""),
-			StorageBackend: storageBackend,
-		},
-		logger,
-	)
-
-	errChan := make(chan error)
+	// buffered so senders never block once shutdown has started.
+	errChan := make(chan error, 3)
 	stopChan := make(chan os.Signal, 1)
 
 	signal.Notify(stopChan, syscall.SIGTERM, syscall.SIGINT)
 
+	serveCtx, stopServing := context.WithCancel(context.Background())
+	served := make(chan struct{})
+
 	go func() {
-		err = grpcServer.Run()
+		defer close(served)
+
+		err := csiDriver.Serve(serveCtx, listener)
 		if err != nil {
 			errChan <- err
 		}
 	}()
 
 	defer func() {
-		grpcServer.GracefulStop()
+		stopServing()
+		<-served
 		sugar.Info("shutdown gRPC server gracefully")
 	}()
 
+	if bundles != nil {
+		go bundles.Watch(serveCtx, envVars.BundlesInterval, func(ctx context.Context, changed *bundle.Bundle) {
+			// failures are logged per volume and retried on the next change.
+			_ = csiDriver.Storage.RefreshBundle(ctx, changed)
+		})
+	}
+
+	if admission != nil {
+		go admission.Watch(serveCtx, envVars.PolicyInterval)
+	}
+
+	if envVars.HTTPListenAddr != "" {
+		err := checkLoopback(envVars.HTTPListenAddr)
+		if err != nil {
+			return err
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle(jwksEndpoint, tokenIssuer)
+
+		if sealingPublicKey != nil {
+			mux.HandleFunc(sealingKeyEndpoint, func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/x-pem-file")
+				_, _ = w.Write(sealingPublicKey)
+			})
+		}
+
+		httpListener, err := net.Listen("tcp", envVars.HTTPListenAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for HTTP: %w", err)
+		}
+
+		httpServer := server.NewHTTPServer(httpListener, mux, logger.With(zap.String("subsystem", "http server")))
+
+		go func() {
+			err := httpServer.Run()
+			if err != nil {
+				errChan <- err
+			}
+		}()
+
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+
+			_ = httpServer.Shutdown(ctx)
+			sugar.Info("shutdown HTTP server gracefully")
+		}()
+	}
+
+	if envVars.AdminSocketPath != "" {
+		err := os.Remove(envVars.AdminSocketPath)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove admin socket file: %w", err)
+		}
+
+		adminListener, err := net.Listen(unixDomain, envVars.AdminSocketPath)
+		if err != nil {
+			return fmt.Errorf("failed to listen for admin API: %w", err)
+		}
+
+		// metrics and bundle versions reveal the volumes of the node, so they
+		// are only served on the admin socket.
+		mux := http.NewServeMux()
+		mux.Handle("/", admin.Handler(logger.Named("audit"), csiDriver.Storage))
+		mux.Handle(metricsEndpoint, metrics.Handler())
+
+		if bundles != nil {
+			mux.Handle(bundlesEndpoint, bundle.Handler(bundles, csiDriver.Storage.BundleVersions))
+		}
+
+		adminServer := server.NewHTTPServer(adminListener, mux, logger.With(zap.String("subsystem", "admin server")))
+
+		go func() {
+			err := adminServer.Run()
+			if err != nil {
+				errChan <- err
+			}
+		}()
+
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+
+			_ = adminServer.Shutdown(ctx)
+		}()
+	}
+
 	select {
 	case err := <-errChan:
 		sugar.Errorw("caught error", "error", err)
@@ -113,6 +454,140 @@ func run() error {
 	return nil
 }
 
+// loadCA loads the CA used to issue volume certificates, or generates one
+// that lives as long as the driver process if none is configured.
+func loadCA(envVars *envConfig) (*ca.CA, error) {
+	if envVars.CACertPath != "" || envVars.CAKeyPath != "" {
+		certificateAuthority, err := ca.Load(envVars.CACertPath, envVars.CAKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CA: %w", err)
+		}
+
+		return certificateAuthority, nil
+	}
+
+	certificateAuthority, err := ca.Generate(name+" "+envVars.NodeID, envVars.CAValidity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA: %w", err)
+	}
+
+	return certificateAuthority, nil
+}
+
+// loadTokenIssuer loads the key that signs identity tokens, or generates one
+// that lives as long as the driver process if none is configured. The JWKS is
+// written to JWKS_PATH so services on the node can verify tokens offline.
+func loadTokenIssuer(envVars *envConfig) (*jwt.Issuer, error) {
+	issuerName := envVars.JWTIssuer
+	if issuerName == "" {
+		issuerName = name + "/" + envVars.NodeID
+	}
+
+	var (
+		tokenIssuer *jwt.Issuer
+		err         error
+	)
+
+	if envVars.JWTSigningKeyPath != "" {
+		keyPEM, err := os.ReadFile(envVars.JWTSigningKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT signing key: %w", err)
+		}
+
+		key, err := ca.ParsePrivateKey(keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWT signing key: %w", err)
+		}
+
+		tokenIssuer, err = jwt.NewIssuer(issuerName, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create token issuer: %w", err)
+		}
+	} else {
+		tokenIssuer, err = jwt.GenerateIssuer(issuerName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate token issuer: %w", err)
+		}
+	}
+
+	if envVars.JWKSPath != "" {
+		err := os.WriteFile(envVars.JWKSPath, tokenIssuer.JWKS(), jwksPerms)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write JWKS: %w", err)
+		}
+	}
+
+	return tokenIssuer, nil
+}
+
+// loadOpener loads the X25519 key that decrypts encrypted-data attributes.
+func loadOpener(keyPath string) (*seal.Opener, error) {
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sealing key: %w", err)
+	}
+
+	key, err := seal.ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sealing key: %w", err)
+	}
+
+	return seal.NewOpener(key), nil
+}
+
+// topology returns the topology segments of the node.
+func topology(envVars *envConfig) map[string]string {
+	segments := make(map[string]string, len(envVars.TopologySegments)+3)
+
+	for key, value := range envVars.TopologySegments {
+		segments[key] = value
+	}
+
+	for key, value := range map[string]string{
+		envVars.TopologyNodeKey:         envVars.NodeID,
+		"topology.kubernetes.io/zone":   envVars.TopologyZone,
+		"topology.kubernetes.io/region": envVars.TopologyRegion,
+	} {
+		if key != "" && value != "" {
+			segments[key] = value
+		}
+	}
+
+	return segments
+}
+
+// loadQuotas reads the per-namespace quotas at path.
+func loadQuotas(path string) (*storage.Quotas, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quotas: %w", err)
+	}
+
+	quotas := &storage.Quotas{}
+
+	err = json.Unmarshal(data, quotas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse quotas: %w", err)
+	}
+
+	return quotas, nil
+}
+
+// checkLoopback makes sure the HTTP endpoints are only reachable from the
+// node itself.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid HTTP_LISTEN_ADDR: %w", err)
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("%w: got %q", errNotLoopback, addr)
+	}
+
+	return nil
+}
+
 func main() {
 	err := run()
 	if err != nil {
@@ -0,0 +1,83 @@
+// Package main is the entrypoint for the reference content provider.
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"csi-driver/internal/pkg/provider"
+	"csi-driver/internal/pkg/provider/podinfo"
+
+	"github.com/caarlos0/env/v10"
+	"go.uber.org/zap"
+)
+
+type envConfig struct {
+	// SocketPath must be <PROVIDERS_DIR of the driver>/<provider name>.sock.
+	SocketPath string `env:"PROVIDER_SOCKET_PATH" envDefault:"/providers/podinfo.sock"`
+}
+
+var (
+	version string
+	commit  string
+)
+
+func run() error {
+	logger := zap.Must(zap.NewProduction(zap.Fields(zap.String("component", "csi-provider-podinfo"))))
+	defer logger.Sync() //nolint:errcheck
+
+	logger.Info("starting up...",
+		zap.String("commit", commit),
+		zap.String("version", version),
+	)
+
+	envVars := &envConfig{}
+
+	err := env.Parse(envVars)
+	if err != nil {
+		return fmt.Errorf("failed to parse env vars: %w", err)
+	}
+
+	err = os.Remove(envVars.SocketPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove unix socket file: %w", err)
+	}
+
+	listener, err := net.Listen("unix", envVars.SocketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	server := provider.NewGRPCServer(&podinfo.Provider{RuntimeVersion: version})
+
+	errChan := make(chan error, 1)
+	stopChan := make(chan os.Signal, 1)
+
+	signal.Notify(stopChan, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		errChan <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errChan:
+		return fmt.Errorf("failed to serve: %w", err)
+	case <-stopChan:
+		logger.Info("caught os signal. shutting down")
+	}
+
+	server.GracefulStop()
+
+	return nil
+}
+
+func main() {
+	err := run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
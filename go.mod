@@ -5,10 +5,13 @@ go 1.21.5
 require (
 	github.com/caarlos0/env/v10 v10.0.0
 	github.com/container-storage-interface/spec v1.9.0
+	github.com/kubernetes-csi/csi-lib-utils v0.17.0
 	go.uber.org/zap v1.26.0
+	golang.org/x/net v0.18.0
+	golang.org/x/sys v0.14.0
 	google.golang.org/grpc v1.60.1
+	google.golang.org/protobuf v1.31.0
 	k8s.io/mount-utils v0.29.0
-	github.com/kubernetes-csi/csi-lib-utils v0.17.0
 )
 
 require (
@@ -16,11 +19,8 @@ require (
 	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/moby/sys/mountinfo v0.6.2 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
-	golang.org/x/net v0.18.0 // indirect
-	golang.org/x/sys v0.14.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20231106174013-bbf56f31fb17 // indirect
-	google.golang.org/protobuf v1.31.0 // indirect
 	k8s.io/klog/v2 v2.110.1 // indirect
 	k8s.io/utils v0.0.0-20230726121419-3b25d923346b // indirect
 )
@@ -0,0 +1,124 @@
+// Package admin serves the operations node administrators run against the
+// volumes of the driver, such as rolling back a bad content update. The API
+// changes what pods see, so it is meant to be served on a unix socket inside
+// the driver container rather than on the network.
+//
+//	GET  /volumes/{id}/versions             lists the kept versions of a volume
+//	POST /volumes/{id}/rollback?version=    rolls a volume back
+//	POST /bundles/{name}/rollback?version=  rolls every volume of a bundle back
+//
+// An empty version selects the version before the current one. A rolled back
+// bundle stays at the restored version until the bundle changes or it is
+// rolled forward again.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"csi-driver/internal/pkg/storage"
+
+	"go.uber.org/zap"
+)
+
+// Target is the storage backend the operations act on.
+type Target interface {
+	VolumeVersions(id string) ([]storage.Version, error)
+	RollbackVolume(id string, version string) (*storage.Version, error)
+	RollbackBundle(name string, version string) ([]string, error)
+}
+
+type rollbackResponse struct {
+	Volumes []string `json:"volumes"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Handler serves the admin API for target. Every change is logged to audit.
+func Handler(audit *zap.Logger, target Target) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kind, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		name, action, _ := strings.Cut(rest, "/")
+		version := r.URL.Query().Get("version")
+
+		if name == "" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		switch {
+		case kind == "volumes" && action == "versions" && r.Method == http.MethodGet:
+			versions, err := target.VolumeVersions(name)
+			if err != nil {
+				writeError(w, err)
+
+				return
+			}
+
+			writeJSON(w, http.StatusOK, versions)
+		case kind == "volumes" && action == "rollback" && r.Method == http.MethodPost:
+			restored, err := target.RollbackVolume(name, version)
+
+			audit.Info("rolled back volume", zap.String("volume_id", name), zap.String("version", version), zap.Error(err))
+
+			if err != nil {
+				writeError(w, err)
+
+				return
+			}
+
+			writeJSON(w, http.StatusOK, restored)
+		case kind == "bundles" && action == "rollback" && r.Method == http.MethodPost:
+			volumes, err := target.RollbackBundle(name, version)
+
+			audit.Info("rolled back bundle",
+				zap.String("bundle", name),
+				zap.String("version", version),
+				zap.Strings("volume_ids", volumes),
+				zap.Error(err),
+			)
+
+			// volumes that could not be rolled back do not stop the others,
+			// so report both.
+			resp := rollbackResponse{Volumes: volumes}
+			if resp.Volumes == nil {
+				resp.Volumes = []string{}
+			}
+
+			statusCode := http.StatusOK
+			if err != nil {
+				resp.Error = err.Error()
+				statusCode = errorStatus(err)
+			}
+
+			writeJSON(w, statusCode, resp)
+		case action == "versions" || action == "rollback":
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrVolumeNotFound), errors.Is(err, storage.ErrVersionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), errorStatus(err))
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,111 @@
+package admin_test
+
+import (
+	"csi-driver/internal/pkg/admin"
+	"csi-driver/internal/pkg/storage"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+)
+
+type fakeTarget struct{}
+
+func (fakeTarget) VolumeVersions(id string) ([]storage.Version, error) {
+	if id != "vol" {
+		return nil, fmt.Errorf("%w: %s", storage.ErrVolumeNotFound, id)
+	}
+
+	return []storage.Version{{Name: "..v2", Current: true}, {Name: "..v1"}}, nil
+}
+
+func (fakeTarget) RollbackVolume(id string, version string) (*storage.Version, error) {
+	if version == "..missing" {
+		return nil, fmt.Errorf("%w: volume %s keeps no version %q", storage.ErrVersionNotFound, id, version)
+	}
+
+	return &storage.Version{Name: "..v1", Current: true}, nil
+}
+
+func (fakeTarget) RollbackBundle(string, string) ([]string, error) {
+	return []string{"vol-1"}, fmt.Errorf("volume vol-2: %w", storage.ErrVersionNotFound)
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "versions",
+			method:     http.MethodGet,
+			target:     "/volumes/vol/versions",
+			wantStatus: http.StatusOK,
+			wantBody:   `"name":"..v1"`,
+		},
+		{
+			name:       "unknown volume",
+			method:     http.MethodGet,
+			target:     "/volumes/other/versions",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "rollback",
+			method:     http.MethodPost,
+			target:     "/volumes/vol/rollback",
+			wantStatus: http.StatusOK,
+			wantBody:   `"current":true`,
+		},
+		{
+			name:       "unknown version",
+			method:     http.MethodPost,
+			target:     "/volumes/vol/rollback?version=..missing",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "rollback with get",
+			method:     http.MethodGet,
+			target:     "/volumes/vol/rollback",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "partial bundle rollback",
+			method:     http.MethodPost,
+			target:     "/bundles/ca/rollback?version=v1",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `"volumes":["vol-1"]`,
+		},
+		{
+			name:       "unknown path",
+			method:     http.MethodGet,
+			target:     "/volumes",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	handler := admin.Handler(zaptest.NewLogger(t), fakeTarget{})
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(testCase.method, testCase.target, nil))
+
+			if recorder.Code != testCase.wantStatus || !strings.Contains(recorder.Body.String(), testCase.wantBody) {
+				t.Errorf("%s %s = %d %s, want %d with %s", testCase.method, testCase.target,
+					recorder.Code, recorder.Body, testCase.wantStatus, testCase.wantBody)
+			}
+		})
+	}
+}
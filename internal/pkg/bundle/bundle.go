@@ -0,0 +1,266 @@
+// Package bundle serves named content bundles that operators drop into a
+// directory on the node. Every subdirectory is a bundle named after it. The
+// directory is polled for changes so new bundle versions can be pushed into
+// the volumes that use them.
+package bundle
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// hiddenPrefix marks entries that are not bundle content, such as the
+	// ..data links of configMap volumes or editor swap files.
+	hiddenPrefix = "."
+	versionBytes = 8
+)
+
+// ErrNotFound is returned for bundles that do not exist.
+var ErrNotFound = errors.New("bundle not found")
+
+// File is a file of a bundle, relative to the bundle root.
+type File struct {
+	Path string
+	Mode fs.FileMode
+	Data []byte
+}
+
+// Bundle is a snapshot of a bundle.
+type Bundle struct {
+	Name string
+	// Version is derived from the content, so it only changes when the
+	// content does.
+	Version string
+	Files   []File
+}
+
+// Store holds the current snapshot of every bundle in a directory.
+type Store struct {
+	logger *zap.Logger
+	dir    string
+
+	mu      sync.RWMutex
+	bundles map[string]*Bundle
+}
+
+// NewStore returns a Store for the bundles in dir and loads them.
+func NewStore(logger *zap.Logger, dir string) (*Store, error) {
+	store := &Store{
+		logger:  logger,
+		dir:     dir,
+		bundles: make(map[string]*Bundle),
+	}
+
+	_, err := store.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Get returns the current snapshot of the bundle called name.
+func (s *Store) Get(name string) (*Bundle, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bundle, ok := s.bundles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+
+	return bundle, nil
+}
+
+// List returns the current snapshot of all bundles, sorted by name.
+func (s *Store) List() []*Bundle {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bundles := make([]*Bundle, 0, len(s.bundles))
+	for _, bundle := range s.bundles {
+		bundles = append(bundles, bundle)
+	}
+
+	sort.Slice(bundles, func(i, j int) bool { return bundles[i].Name < bundles[j].Name })
+
+	return bundles
+}
+
+// Reload reads all bundles again and returns the ones that are new or whose
+// version changed. Bundles that were removed are dropped, volumes keep the
+// last content they got.
+func (s *Store) Reload() ([]*Bundle, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundles dir: %w", err)
+	}
+
+	bundles := make(map[string]*Bundle, len(entries))
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, hiddenPrefix) {
+			continue
+		}
+
+		info, err := os.Stat(filepath.Join(s.dir, name))
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		bundle, err := load(filepath.Join(s.dir, name), name)
+		if err != nil {
+			// a bundle that is being copied may be incomplete, keep the
+			// previous snapshot until it can be read.
+			s.logger.Warn("failed to load bundle", zap.String("bundle", name), zap.Error(err))
+
+			if previous, ok := s.bundles[name]; ok {
+				bundles[name] = previous
+			}
+
+			continue
+		}
+
+		bundles[name] = bundle
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changed []*Bundle
+
+	for name, bundle := range bundles {
+		if previous, ok := s.bundles[name]; !ok || previous.Version != bundle.Version {
+			changed = append(changed, bundle)
+		}
+	}
+
+	s.bundles = bundles
+
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Name < changed[j].Name })
+
+	return changed, nil
+}
+
+// Watch reloads the bundles every interval until ctx is done and calls
+// onChange for every bundle that is new or changed. onChange is called for all
+// bundles once at the start, so volumes written before a restart catch up.
+func (s *Store) Watch(ctx context.Context, interval time.Duration, onChange func(context.Context, *Bundle)) {
+	for _, bundle := range s.List() {
+		onChange(ctx, bundle)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := s.Reload()
+		if err != nil {
+			s.logger.Error("failed to reload bundles", zap.Error(err))
+
+			continue
+		}
+
+		for _, bundle := range changed {
+			s.logger.Info("bundle changed",
+				zap.String("bundle", bundle.Name),
+				zap.String("version", bundle.Version),
+			)
+
+			onChange(ctx, bundle)
+		}
+	}
+}
+
+// load reads the bundle in dir. Symlinks to files are followed, which is what
+// a configMap mounted as a bundle looks like.
+func load(dir string, name string) (*Bundle, error) {
+	bundle := &Bundle{Name: name}
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve bundle dir: %w", err)
+	}
+
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(entry.Name(), hiddenPrefix) && path != dir {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		bundle.Files = append(bundle.Files, File{Path: filepath.ToSlash(rel), Mode: info.Mode().Perm(), Data: data})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+
+	bundle.Version = version(bundle.Files)
+
+	return bundle, nil
+}
+
+// version hashes the paths, modes and content of files, which WalkDir returns
+// in lexical order.
+func version(files []File) string {
+	hash := sha256.New()
+
+	for _, file := range files {
+		var header [8]byte
+
+		binary.BigEndian.PutUint32(header[:4], uint32(file.Mode))
+		binary.BigEndian.PutUint32(header[4:], uint32(len(file.Path)))
+		hash.Write(header[:])
+		hash.Write([]byte(file.Path))
+
+		binary.BigEndian.PutUint64(header[:], uint64(len(file.Data)))
+		hash.Write(header[:])
+		hash.Write(file.Data)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)[:versionBytes])
+}
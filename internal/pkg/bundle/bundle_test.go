@@ -0,0 +1,153 @@
+package bundle_test
+
+import (
+	"context"
+	"csi-driver/internal/pkg/bundle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+)
+
+func writeFile(t *testing.T, path string, data string) {
+	t.Helper()
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err == nil {
+		err = os.WriteFile(path, []byte(data), 0o644)
+	}
+
+	if err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestStore_Reload(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "ca", "ca.crt"), "v1")
+	writeFile(t, filepath.Join(dir, "flags", "nested", "flags.json"), "{}")
+	writeFile(t, filepath.Join(dir, "ca", ".swp"), "ignored")
+	writeFile(t, filepath.Join(dir, "not-a-bundle"), "ignored")
+
+	store, err := bundle.NewStore(zaptest.NewLogger(t), dir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	caBundle, err := store.Get("ca")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	want := []bundle.File{{Path: "ca.crt", Mode: 0o644, Data: []byte("v1")}}
+	if !reflect.DeepEqual(caBundle.Files, want) {
+		t.Errorf("Get() files = %+v, want %+v", caBundle.Files, want)
+	}
+
+	changed, err := store.Reload()
+	if err != nil || len(changed) != 0 {
+		t.Errorf("Reload() without changes = %v, %v, want nothing", changed, err)
+	}
+
+	writeFile(t, filepath.Join(dir, "ca", "ca.crt"), "v2")
+
+	changed, err = store.Reload()
+	if err != nil || len(changed) != 1 || changed[0].Name != "ca" || changed[0].Version == caBundle.Version {
+		t.Errorf("Reload() = %v, %v, want a new version of ca", changed, err)
+	}
+
+	_, err = store.Get("missing")
+	if !errors.Is(err, bundle.ErrNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, bundle.ErrNotFound)
+	}
+}
+
+func TestStore_Watch(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "ca", "ca.crt"), "v1")
+
+	store, err := bundle.NewStore(zaptest.NewLogger(t), dir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan string, 2)
+
+	go store.Watch(ctx, time.Millisecond, func(_ context.Context, changed *bundle.Bundle) {
+		changes <- string(changed.Files[0].Data)
+	})
+
+	// every bundle is reported once at the start.
+	if got := <-changes; got != "v1" {
+		t.Errorf("first change = %q, want %q", got, "v1")
+	}
+
+	writeFile(t, filepath.Join(dir, "ca", "ca.crt"), "v2")
+
+	if got := <-changes; got != "v2" {
+		t.Errorf("second change = %q, want %q", got, "v2")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "ca", "ca.crt"), "v1")
+
+	store, err := bundle.NewStore(zaptest.NewLogger(t), dir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	caBundle, _ := store.Get("ca")
+
+	handler := bundle.Handler(store, func() (map[string]map[string]string, error) {
+		return map[string]map[string]string{
+			"current": {"ca": caBundle.Version},
+			"stale":   {"ca": "old", "removed": "gone"},
+		}, nil
+	})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/bundles", nil))
+
+	var got struct {
+		Bundles []struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Volumes []struct {
+				ID      string `json:"id"`
+				Current bool   `json:"current"`
+			} `json:"volumes"`
+		} `json:"bundles"`
+	}
+
+	err = json.Unmarshal(recorder.Body.Bytes(), &got)
+	if err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if len(got.Bundles) != 2 || got.Bundles[0].Name != "ca" || got.Bundles[1].Version != "" {
+		t.Fatalf("Handler() bundles = %+v, want ca and the removed bundle", got.Bundles)
+	}
+
+	volumes := got.Bundles[0].Volumes
+	if len(volumes) != 2 || !volumes[0].Current || volumes[1].Current {
+		t.Errorf("Handler() ca volumes = %+v, want current up to date and stale behind", volumes)
+	}
+}
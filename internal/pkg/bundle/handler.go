@@ -0,0 +1,74 @@
+package bundle
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// VolumeVersions returns the version of every bundle each volume holds, keyed
+// by volume ID and bundle name.
+type VolumeVersions func() (map[string]map[string]string, error)
+
+type statusResponse struct {
+	Bundles []bundleStatus `json:"bundles"`
+}
+
+type bundleStatus struct {
+	Name string `json:"name"`
+	// Version is empty for bundles that were removed from the node.
+	Version string         `json:"version"`
+	Volumes []volumeStatus `json:"volumes"`
+}
+
+type volumeStatus struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	Current bool   `json:"current"`
+}
+
+// Handler serves which volumes hold which version of every bundle as JSON.
+func Handler(store *Store, volumes VolumeVersions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		versions, err := volumes()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		statuses := make(map[string]*bundleStatus)
+
+		for _, bundle := range store.List() {
+			statuses[bundle.Name] = &bundleStatus{Name: bundle.Name, Version: bundle.Version, Volumes: []volumeStatus{}}
+		}
+
+		for id, bundles := range versions {
+			for name, version := range bundles {
+				status, ok := statuses[name]
+				if !ok {
+					status = &bundleStatus{Name: name, Volumes: []volumeStatus{}}
+					statuses[name] = status
+				}
+
+				status.Volumes = append(status.Volumes, volumeStatus{
+					ID:      id,
+					Version: version,
+					Current: version == status.Version,
+				})
+			}
+		}
+
+		resp := statusResponse{Bundles: make([]bundleStatus, 0, len(statuses))}
+
+		for _, status := range statuses {
+			sort.Slice(status.Volumes, func(i, j int) bool { return status.Volumes[i].ID < status.Volumes[j].ID })
+			resp.Bundles = append(resp.Bundles, *status)
+		}
+
+		sort.Slice(resp.Bundles, func(i, j int) bool { return resp.Bundles[i].Name < resp.Bundles[j].Name })
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+}
@@ -0,0 +1,148 @@
+package ca_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/url"
+	"testing"
+	"time"
+
+	"csi-driver/internal/pkg/ca"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	certificateAuthority, err := ca.Generate("test-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error generating CA: %v", err)
+	}
+
+	block, _ := pem.Decode(certificateAuthority.CertificatePEM())
+	if block == nil {
+		t.Fatal("CA certificate is not PEM encoded")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+
+	if !cert.IsCA || cert.Subject.CommonName != "test-ca" {
+		t.Errorf("unexpected CA certificate: CA %v, CN %q", cert.IsCA, cert.Subject.CommonName)
+	}
+}
+
+func TestCA_Issue(t *testing.T) {
+	t.Parallel()
+
+	certificateAuthority, err := ca.Generate("test-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error generating CA: %v", err)
+	}
+
+	uri, _ := url.Parse("spiffe://cluster.local/ns/default/sa/app")
+
+	certPEM, keyPEM, err := certificateAuthority.Issue([]string{"app.default.svc"}, []*url.URL{uri}, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error issuing certificate: %v", err)
+	}
+
+	if _, err := ca.ParsePrivateKey(keyPEM); err != nil {
+		t.Errorf("failed to parse issued key: %v", err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		t.Fatal("certificate is not PEM encoded")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certificateAuthority.CertificatePEM())
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		DNSName: "app.default.svc",
+		Roots:   roots,
+	})
+	if err != nil {
+		t.Errorf("issued certificate does not verify: %v", err)
+	}
+
+	if len(cert.URIs) != 1 || cert.URIs[0].String() != uri.String() {
+		t.Errorf("unexpected URIs: %v", cert.URIs)
+	}
+
+	// the leaf may not outlive its CA.
+	if cert.NotAfter.After(time.Now().Add(time.Hour)) {
+		t.Errorf("certificate outlives CA: %v", cert.NotAfter)
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	generated, err := ca.Generate("test-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error generating CA: %v", err)
+	}
+
+	leafPEM, leafKeyPEM, err := generated.Issue([]string{"leaf"}, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error issuing certificate: %v", err)
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	otherKeyDER, _ := x509.MarshalECPrivateKey(otherKey)
+	otherKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: otherKeyDER})
+
+	tests := []struct {
+		name    string
+		certPEM []byte
+		keyPEM  []byte
+		wantErr bool
+	}{
+		{
+			name:    "not a CA",
+			certPEM: leafPEM,
+			keyPEM:  leafKeyPEM,
+			wantErr: true,
+		},
+		{
+			name:    "mismatched key",
+			certPEM: generated.CertificatePEM(),
+			keyPEM:  otherKeyPEM,
+			wantErr: true,
+		},
+		{
+			name:    "garbage",
+			certPEM: []byte("not pem"),
+			keyPEM:  []byte("not pem"),
+			wantErr: true,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ca.Parse(testCase.certPEM, testCase.keyPEM)
+			if (err != nil) != testCase.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
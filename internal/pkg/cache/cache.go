@@ -0,0 +1,140 @@
+// Package cache keeps content that is expensive to produce, such as unpacked
+// images, on the node so that repeated mounts of the same content are instant.
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"csi-driver/internal/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+const (
+	dirPerms = 0o700
+	// tmpPrefix marks entries that are still being filled. They are never
+	// returned and are removed when the cache is opened.
+	tmpPrefix = ".tmp-"
+)
+
+// ErrInvalidKey is returned for keys that cannot be used as a file name.
+var ErrInvalidKey = errors.New("invalid cache key")
+
+// Fill populates the empty directory dir with the content of an entry.
+type Fill func(ctx context.Context, dir string) error
+
+// Cache is a directory of immutable entries, each a directory named by a key
+// that identifies its content, e.g. a digest.
+type Cache struct {
+	logger *zap.Logger
+	dir    string
+
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+// New opens the cache in dir, creating it if needed.
+func New(logger *zap.Logger, dir string) (*Cache, error) {
+	err := os.MkdirAll(dir, dirPerms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cache dir: %w", err)
+	}
+
+	stale, err := filepath.Glob(filepath.Join(dir, tmpPrefix+"*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stale cache entries: %w", err)
+	}
+
+	for _, entry := range stale {
+		err := os.RemoveAll(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove stale cache entry: %w", err)
+		}
+	}
+
+	return &Cache{
+		logger: logger,
+		dir:    dir,
+		locks:  make(map[string]chan struct{}),
+	}, nil
+}
+
+// Get returns the directory of the entry for key. On a miss the entry is
+// filled by fill first, concurrent calls for the same key wait for it. The
+// returned directory must not be modified.
+func (c *Cache) Get(ctx context.Context, key string, fill Fill) (string, error) {
+	if key == "" || strings.ContainsAny(key, `/\`) || strings.HasPrefix(key, ".") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+
+	unlock, err := c.lock(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	entry := filepath.Join(c.dir, key)
+
+	_, err = os.Stat(entry)
+	if err == nil {
+		metrics.CacheLookups.Add(metrics.ResultHit, 1)
+
+		return entry, nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to check cache entry: %w", err)
+	}
+
+	metrics.CacheLookups.Add(metrics.ResultMiss, 1)
+
+	tmp, err := os.MkdirTemp(c.dir, tmpPrefix+key)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cache entry: %w", err)
+	}
+
+	err = fill(ctx, tmp)
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+
+		return "", err
+	}
+
+	err = os.Rename(tmp, entry)
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+
+		return "", fmt.Errorf("failed to commit cache entry: %w", err)
+	}
+
+	c.logger.Info("added cache entry", zap.String("key", key))
+
+	return entry, nil
+}
+
+// lock serializes work on key, giving up once ctx is done.
+func (c *Cache) lock(ctx context.Context, key string) (func(), error) {
+	c.mu.Lock()
+
+	keyLock, ok := c.locks[key]
+	if !ok {
+		keyLock = make(chan struct{}, 1)
+		c.locks[key] = keyLock
+	}
+
+	c.mu.Unlock()
+
+	select {
+	case keyLock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for cache entry: %w", ctx.Err())
+	}
+
+	return func() { <-keyLock }, nil
+}
@@ -0,0 +1,102 @@
+package cache_test
+
+import (
+	"context"
+	"csi-driver/internal/pkg/cache"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+)
+
+var errFill = errors.New("fill failed")
+
+func TestCache_Get(t *testing.T) {
+	t.Parallel()
+
+	contentCache, err := cache.New(zaptest.NewLogger(t), t.TempDir())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	fills := 0
+	fill := func(_ context.Context, dir string) error {
+		fills++
+
+		return os.WriteFile(filepath.Join(dir, "file"), []byte("content"), 0o600)
+	}
+
+	for i := 0; i < 2; i++ {
+		dir, err := contentCache.Get(context.Background(), "sha256-abc", fill)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "file"))
+		if err != nil || string(data) != "content" {
+			t.Errorf("file = %q, %v, want %q", data, err, "content")
+		}
+	}
+
+	if fills != 1 {
+		t.Errorf("entry was filled %d times, want 1", fills)
+	}
+}
+
+func TestCache_GetErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		key     string
+		fill    cache.Fill
+		wantErr error
+	}{
+		{
+			name:    "fill error",
+			key:     "failing",
+			fill:    func(context.Context, string) error { return errFill },
+			wantErr: errFill,
+		},
+		{
+			name:    "key escapes cache",
+			key:     "../escape",
+			fill:    func(context.Context, string) error { return nil },
+			wantErr: cache.ErrInvalidKey,
+		},
+		{
+			name:    "reserved key",
+			key:     ".tmp-key",
+			fill:    func(context.Context, string) error { return nil },
+			wantErr: cache.ErrInvalidKey,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+
+			contentCache, err := cache.New(zaptest.NewLogger(t), dir)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			_, err = contentCache.Get(context.Background(), testCase.key, testCase.fill)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Errorf("Get() error = %v, want %v", err, testCase.wantErr)
+			}
+
+			// failed entries leave nothing behind.
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 0 {
+				t.Errorf("cache dir has %d entries, want 0", len(entries))
+			}
+		})
+	}
+}
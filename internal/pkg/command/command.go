@@ -0,0 +1,458 @@
+// Package command runs allowlisted executables on the node to produce volume
+// content. Commands are configured on the driver, never in pod specs, and run
+// with a timeout, a cap on their output, a minimal environment and, when the
+// driver runs as root, as an unprivileged user.
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// OutputStdout makes the stdout of a command the content of a file.
+	OutputStdout = "stdout"
+	// OutputDir makes the files a command writes to CSI_OUTPUT_DIR the
+	// content of the volume.
+	OutputDir = "dir"
+
+	defaultTimeout       = 30 * time.Second
+	defaultMaxOutputSize = 16 << 20
+	// nobody on most distributions.
+	defaultID = 65534
+
+	maxStderr    = 4 << 10
+	waitDelay    = time.Second
+	workDirPerms = 0o711
+	safePath     = "/usr/local/bin:/usr/bin:/bin"
+)
+
+var (
+	// ErrInvalidConfig is returned for unusable command configurations.
+	ErrInvalidConfig = errors.New("invalid command config")
+	// ErrUnknownCommand is returned for commands that are not configured.
+	ErrUnknownCommand = errors.New("unknown command")
+	// ErrTimeout is returned when a command does not finish in time.
+	ErrTimeout = errors.New("command timed out")
+	// ErrTooLarge is returned when a command produces too much output.
+	ErrTooLarge = errors.New("command output too large")
+
+	errNotRegular = errors.New("output is not a regular file")
+
+	envUnsafe = regexp.MustCompile(`[^A-Z0-9_]`)
+)
+
+// ExitError is returned when a command exits with a non-zero status. Stderr
+// holds the start of what the command wrote to stderr.
+type ExitError struct {
+	Command string
+	Code    int
+	Stderr  string
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command %s exited with status %d", e.Command, e.Code)
+}
+
+// Spec configures a single command.
+type Spec struct {
+	// Path is the absolute path of the executable.
+	Path string   `json:"path"`
+	Args []string `json:"args,omitempty"`
+	// Output is OutputStdout or OutputDir, defaulting to OutputStdout.
+	Output string `json:"output,omitempty"`
+	// Timeout is a duration such as 30s, the default.
+	Timeout string `json:"timeout,omitempty"`
+	// MaxOutputSize caps the output in bytes and defaults to 16MiB.
+	MaxOutputSize int64 `json:"maxOutputSize,omitempty"`
+	// UID and GID the command runs as when the driver runs as root. They
+	// default to 65534 and must not be 0.
+	UID *uint32 `json:"uid,omitempty"`
+	GID *uint32 `json:"gid,omitempty"`
+	// Env is added to the environment of the command.
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// Config is the command configuration file of the driver.
+type Config struct {
+	Commands map[string]Spec `json:"commands"`
+}
+
+type command struct {
+	Spec
+	timeout  time.Duration
+	uid, gid uint32
+}
+
+// Runner runs the configured commands.
+type Runner struct {
+	logger   *zap.Logger
+	workDir  string
+	commands map[string]*command
+}
+
+// File is a file written by a command in OutputDir mode.
+type File struct {
+	Path string
+	Mode fs.FileMode
+	Data []byte
+}
+
+// Output is what a command produced. Dir is set for OutputDir commands,
+// whose content is in Files rather than Stdout.
+type Output struct {
+	Dir    bool
+	Stdout []byte
+	Files  []File
+}
+
+// Request describes the volume a command runs for.
+type Request struct {
+	VolumeID string
+	// Attributes are passed as CSI_ATTR_<KEY> environment variables, with
+	// the key upper-cased and everything but letters and digits replaced
+	// by underscores.
+	Attributes map[string]string
+	// Stdin is passed to the command on stdin.
+	Stdin []byte
+}
+
+// Load reads the command configuration at path. Commands get a scratch
+// directory below workDir.
+func Load(logger *zap.Logger, path string, workDir string) (*Runner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read command config: %w", err)
+	}
+
+	var config Config
+
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	return New(logger, &config, workDir)
+}
+
+// New returns a Runner for config.
+func New(logger *zap.Logger, config *Config, workDir string) (*Runner, error) {
+	runner := &Runner{
+		logger:   logger,
+		workDir:  workDir,
+		commands: make(map[string]*command, len(config.Commands)),
+	}
+
+	for name, spec := range config.Commands {
+		cmd, err := newCommand(spec)
+		if err != nil {
+			return nil, fmt.Errorf("command %s: %w", name, err)
+		}
+
+		runner.commands[name] = cmd
+	}
+
+	err := os.MkdirAll(workDir, workDirPerms)
+	if err == nil {
+		// commands running as another user have to reach their scratch dir.
+		err = os.Chmod(workDir, workDirPerms)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create command work dir: %w", err)
+	}
+
+	return runner, nil
+}
+
+func newCommand(spec Spec) (*command, error) {
+	if !filepath.IsAbs(spec.Path) {
+		return nil, fmt.Errorf("%w: path %q must be absolute", ErrInvalidConfig, spec.Path)
+	}
+
+	info, err := os.Stat(spec.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	// a command anyone can replace would let anyone run code as the driver.
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 || info.Mode().Perm()&0o022 != 0 {
+		return nil, fmt.Errorf("%w: %s must be an executable that only its owner can write", ErrInvalidConfig, spec.Path)
+	}
+
+	switch spec.Output {
+	case "":
+		spec.Output = OutputStdout
+	case OutputStdout, OutputDir:
+	default:
+		return nil, fmt.Errorf("%w: unknown output %q", ErrInvalidConfig, spec.Output)
+	}
+
+	cmd := &command{Spec: spec, timeout: defaultTimeout, uid: defaultID, gid: defaultID}
+
+	if spec.Timeout != "" {
+		cmd.timeout, err = time.ParseDuration(spec.Timeout)
+		if err != nil || cmd.timeout <= 0 {
+			return nil, fmt.Errorf("%w: invalid timeout %q", ErrInvalidConfig, spec.Timeout)
+		}
+	}
+
+	if cmd.MaxOutputSize <= 0 {
+		cmd.MaxOutputSize = defaultMaxOutputSize
+	}
+
+	if spec.UID != nil {
+		cmd.uid = *spec.UID
+	}
+
+	if spec.GID != nil {
+		cmd.gid = *spec.GID
+	}
+
+	if cmd.uid == 0 || cmd.gid == 0 {
+		return nil, fmt.Errorf("%w: commands must not run as root", ErrInvalidConfig)
+	}
+
+	return cmd, nil
+}
+
+// Run runs the command called name for req.
+func (r *Runner) Run(ctx context.Context, name string, req *Request) (*Output, error) {
+	cmd, ok := r.commands[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCommand, name)
+	}
+
+	scratch, err := os.MkdirTemp(r.workDir, "run-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create command dir: %w", err)
+	}
+	defer os.RemoveAll(scratch)
+
+	privileged := os.Geteuid() == 0
+	if privileged {
+		err := os.Chown(scratch, int(cmd.uid), int(cmd.gid))
+		if err != nil {
+			return nil, fmt.Errorf("failed to hand command dir over: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cmd.timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: cmd.MaxOutputSize}
+	stderr := &limitedBuffer{limit: maxStderr, truncate: true}
+
+	process := exec.CommandContext(ctx, cmd.Path, cmd.Args...)
+	process.Dir = scratch
+	process.Env = cmd.environment(req, scratch)
+	process.Stdin = bytes.NewReader(req.Stdin)
+	process.Stdout = stdout
+	process.Stderr = stderr
+	process.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+	process.WaitDelay = waitDelay
+	// kill the whole process group, not just the command itself.
+	process.Cancel = func() error {
+		return syscall.Kill(-process.Process.Pid, syscall.SIGKILL)
+	}
+
+	if privileged {
+		process.SysProcAttr.Credential = &syscall.Credential{Uid: cmd.uid, Gid: cmd.gid}
+	}
+
+	err = process.Run()
+
+	if process.Process != nil {
+		// children left behind could still change the output dir while it
+		// is read.
+		_ = syscall.Kill(-process.Process.Pid, syscall.SIGKILL)
+	}
+
+	if stderr.buffer.Len() > 0 {
+		r.logger.Info("command wrote to stderr",
+			zap.String("command", name),
+			zap.String("volume_id", req.VolumeID),
+			zap.String("stderr", stderr.buffer.String()),
+		)
+	}
+
+	switch {
+	case stdout.exceeded:
+		return nil, fmt.Errorf("%w: %s wrote more than %d bytes", ErrTooLarge, name, cmd.MaxOutputSize)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return nil, fmt.Errorf("%w: %s did not finish within %s", ErrTimeout, name, cmd.timeout)
+	case err != nil:
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			return nil, &ExitError{Command: name, Code: exitErr.ExitCode(), Stderr: stderr.buffer.String()}
+		}
+
+		return nil, fmt.Errorf("failed to run %s: %w", name, err)
+	}
+
+	if cmd.Output == OutputStdout {
+		return &Output{Stdout: stdout.buffer.Bytes()}, nil
+	}
+
+	files, err := readOutput(scratch, cmd.MaxOutputSize)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return &Output{Dir: true, Files: files}, nil
+}
+
+// environment returns the restricted environment of the command. Nothing of
+// the driver's own environment is passed on.
+func (c *command) environment(req *Request, scratch string) []string {
+	env := []string{
+		"PATH=" + safePath,
+		"HOME=" + scratch,
+		"LANG=C",
+		"CSI_VOLUME_ID=" + req.VolumeID,
+	}
+
+	if c.Output == OutputDir {
+		env = append(env, "CSI_OUTPUT_DIR="+scratch)
+	}
+
+	keys := make([]string, 0, len(req.Attributes))
+	for key := range req.Attributes {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		env = append(env, "CSI_ATTR_"+envUnsafe.ReplaceAllString(strings.ToUpper(key), "_")+"="+req.Attributes[key])
+	}
+
+	for key, value := range c.Env {
+		env = append(env, key+"="+value)
+	}
+
+	return env
+}
+
+// readOutput reads the regular files below dir. Files are opened relative to
+// dir without following any symlink, so a command cannot make the driver
+// read files it has no access to, not even by swapping a directory for a
+// symlink while the output is read.
+func readOutput(dir string, maxSize int64) ([]File, error) {
+	root, err := os.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output dir: %w", err)
+	}
+	defer root.Close()
+
+	var (
+		files []File
+		total int64
+	)
+
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		data, info, err := readBeneath(root, rel, maxSize-total)
+		if err != nil {
+			return err
+		}
+
+		total += int64(len(data))
+		files = append(files, File{Path: rel, Mode: info.Mode().Perm(), Data: data})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// readBeneath reads the regular file at rel below root, refusing symlinks in
+// any component of rel.
+func readBeneath(root *os.File, rel string, remaining int64) ([]byte, fs.FileInfo, error) {
+	fd, err := unix.Openat2(int(root.Fd()), rel, &unix.OpenHow{
+		// O_NONBLOCK keeps a FIFO swapped in for the file from blocking.
+		Flags:   unix.O_RDONLY | unix.O_CLOEXEC | unix.O_NOFOLLOW | unix.O_NONBLOCK,
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_SYMLINKS,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open output: %w", os.NewSyscallError("openat2", err))
+	}
+
+	file := os.NewFile(uintptr(fd), rel)
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to inspect output: %w", err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil, nil, fmt.Errorf("%w: %s", errNotRegular, rel)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, remaining+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read output: %w", err)
+	}
+
+	if int64(len(data)) > remaining {
+		return nil, nil, ErrTooLarge
+	}
+
+	return data, info, nil
+}
+
+// limitedBuffer fails writes beyond limit, or drops them if truncate is set.
+// The buffer is not embedded, as io.Copy would bypass Write through its
+// ReadFrom method.
+type limitedBuffer struct {
+	buffer   bytes.Buffer
+	limit    int64
+	truncate bool
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	remaining := b.limit - int64(b.buffer.Len())
+
+	if int64(len(p)) > remaining {
+		if !b.truncate {
+			b.exceeded = true
+
+			return 0, ErrTooLarge
+		}
+
+		b.buffer.Write(p[:max(remaining, 0)])
+
+		return len(p), nil
+	}
+
+	return b.buffer.Write(p)
+}
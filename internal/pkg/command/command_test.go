@@ -0,0 +1,212 @@
+package command_test
+
+import (
+	"context"
+	"csi-driver/internal/pkg/command"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+)
+
+// newScript writes an executable shell script the unprivileged command user
+// can reach, as the tests may run as root.
+func newScript(t *testing.T, body string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "command.sh")
+
+	err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755)
+	for _, allowed := range []string{dir, filepath.Dir(dir)} {
+		if err == nil {
+			err = os.Chmod(allowed, 0o755)
+		}
+	}
+
+	if err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	return path
+}
+
+func TestRunner_Run(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		body     string
+		spec     command.Spec
+		want     *command.Output
+		wantErr  error
+		wantCode int
+	}{
+		{
+			name: "stdout",
+			body: `printf '%s %s %s' "$CSI_VOLUME_ID" "$CSI_ATTR_CSI_DRIVER_MATTSLATER_IO_REGION" "$GREETING"`,
+			spec: command.Spec{Env: map[string]string{"GREETING": "hello"}},
+			want: &command.Output{Stdout: []byte("vol-1 eu-west-1 hello")},
+		},
+		{
+			name: "restricted environment",
+			body: `printf '%s %s' "$PATH" "$GOFLAGS"`,
+			want: &command.Output{Stdout: []byte("/usr/local/bin:/usr/bin:/bin ")},
+		},
+		{
+			name: "output dir",
+			body: `mkdir "$CSI_OUTPUT_DIR/conf" && printf a > "$CSI_OUTPUT_DIR/conf/a" && ` +
+				`ln -s /etc/hostname "$CSI_OUTPUT_DIR/link" && echo ignored`,
+			spec: command.Spec{Output: command.OutputDir},
+			want: &command.Output{Dir: true, Files: []command.File{{Path: "conf/a", Mode: 0o644, Data: []byte("a")}}},
+		},
+		{
+			name:     "exit status",
+			body:     "exit 75",
+			wantCode: 75,
+		},
+		{
+			name:    "timeout",
+			body:    "sleep 30 & wait",
+			spec:    command.Spec{Timeout: "100ms"},
+			wantErr: command.ErrTimeout,
+		},
+		{
+			name:    "stdout too large",
+			body:    "head -c 2048 /dev/zero",
+			spec:    command.Spec{MaxOutputSize: 1024},
+			wantErr: command.ErrTooLarge,
+		},
+		{
+			name:    "output dir too large",
+			body:    `head -c 2048 /dev/zero > "$CSI_OUTPUT_DIR/big"`,
+			spec:    command.Spec{Output: command.OutputDir, MaxOutputSize: 1024},
+			wantErr: command.ErrTooLarge,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			testCase.spec.Path = newScript(t, "umask 022\n"+testCase.body)
+
+			runner, err := command.New(zaptest.NewLogger(t), &command.Config{
+				Commands: map[string]command.Spec{"test": testCase.spec},
+			}, t.TempDir())
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			got, err := runner.Run(context.Background(), "test", &command.Request{
+				VolumeID:   "vol-1",
+				Attributes: map[string]string{"csi-driver.mattslater.io/region": "eu-west-1"},
+			})
+
+			var exitErr *command.ExitError
+
+			switch {
+			case testCase.wantCode != 0:
+				if !errors.As(err, &exitErr) || exitErr.Code != testCase.wantCode {
+					t.Errorf("Run() error = %v, want exit status %d", err, testCase.wantCode)
+				}
+			case testCase.wantErr != nil:
+				if !errors.Is(err, testCase.wantErr) {
+					t.Errorf("Run() error = %v, want %v", err, testCase.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("Run() error = %v", err)
+			case !reflect.DeepEqual(got, testCase.want):
+				t.Errorf("Run() = %+v, want %+v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestRunner_Run_BackgroundChildren(t *testing.T) {
+	t.Parallel()
+
+	// a child left behind by the command would leave a file here.
+	markerDir := t.TempDir()
+
+	err := os.Chmod(markerDir, 0o777)
+	if err != nil {
+		t.Fatalf("failed to open marker dir: %v", err)
+	}
+
+	runner, err := command.New(zaptest.NewLogger(t), &command.Config{
+		Commands: map[string]command.Spec{"test": {
+			Path: newScript(t, `(sleep 0.2; printf late > "$MARKER_DIR/late") >/dev/null 2>&1 &`+"\n"+
+				`printf a > "$CSI_OUTPUT_DIR/a"`),
+			Output: command.OutputDir,
+			Env:    map[string]string{"MARKER_DIR": markerDir},
+		}},
+	}, t.TempDir())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	_, err = runner.Run(context.Background(), "test", &command.Request{VolumeID: "vol-1"})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	if _, err := os.Stat(filepath.Join(markerDir, "late")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("child of the command outlived it: %v", err)
+	}
+}
+
+func TestRunner_Run_UnknownCommand(t *testing.T) {
+	t.Parallel()
+
+	runner, err := command.New(zaptest.NewLogger(t), &command.Config{}, t.TempDir())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	_, err = runner.Run(context.Background(), "missing", &command.Request{})
+	if !errors.Is(err, command.ErrUnknownCommand) {
+		t.Errorf("Run() error = %v, want %v", err, command.ErrUnknownCommand)
+	}
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	script := newScript(t, "true")
+	writable := newScript(t, "true")
+
+	err := os.Chmod(writable, 0o777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := uint32(0)
+
+	tests := map[string]command.Spec{
+		"relative path":   {Path: "command.sh"},
+		"missing":         {Path: filepath.Join(t.TempDir(), "missing")},
+		"world writable":  {Path: writable},
+		"unknown output":  {Path: script, Output: "stderr"},
+		"invalid timeout": {Path: script, Timeout: "soon"},
+		"root":            {Path: script, UID: &root},
+	}
+
+	for name, spec := range tests {
+		_, err := command.New(zaptest.NewLogger(t), &command.Config{
+			Commands: map[string]command.Spec{"test": spec},
+		}, t.TempDir())
+		if !errors.Is(err, command.ErrInvalidConfig) || !strings.Contains(err.Error(), "command test") {
+			t.Errorf("New() with %s error = %v, want %v", name, err, command.ErrInvalidConfig)
+		}
+	}
+}
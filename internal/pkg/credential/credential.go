@@ -0,0 +1,81 @@
+// Package credential contains generators for random credentials.
+package credential
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	uuidBytes = 16
+	// version 4 and variant 10 as described in RFC 4122.
+	uuidVersion     = 0x40
+	uuidVersionMask = 0x0f
+	uuidVariant     = 0x80
+	uuidVariantMask = 0x3f
+)
+
+// Alphabets that passwords can be generated from.
+var Alphabets = map[string]string{
+	"lower":        "abcdefghijklmnopqrstuvwxyz",
+	"upper":        "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"digits":       "0123456789",
+	"alphanumeric": "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+	"symbols":      "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!#%&*+-=?@^_~",
+	"hex":          "0123456789abcdef",
+}
+
+var errEmptyAlphabet = errors.New("alphabet is empty")
+
+// Password returns a random string of length characters drawn uniformly from
+// alphabet.
+func Password(length int, alphabet string) (string, error) {
+	if alphabet == "" {
+		return "", errEmptyAlphabet
+	}
+
+	chars := []rune(alphabet)
+	max := big.NewInt(int64(len(chars)))
+	password := make([]rune, length)
+
+	for i := range password {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to read random data: %w", err)
+		}
+
+		password[i] = chars[idx.Int64()]
+	}
+
+	return string(password), nil
+}
+
+// Hex returns n random bytes encoded as hex.
+func Hex(n int) (string, error) {
+	buf := make([]byte, n)
+
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to read random data: %w", err)
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// UUID returns a random version 4 UUID.
+func UUID() (string, error) {
+	buf := make([]byte, uuidBytes)
+
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to read random data: %w", err)
+	}
+
+	buf[6] = buf[6]&uuidVersionMask | uuidVersion
+	buf[8] = buf[8]&uuidVariantMask | uuidVariant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", buf[0:4], buf[4:6], buf[6:8], buf[8:10], buf[10:16]), nil
+}
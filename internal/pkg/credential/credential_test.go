@@ -0,0 +1,88 @@
+package credential_test
+
+import (
+	"csi-driver/internal/pkg/credential"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestPassword(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		length   int
+		alphabet string
+		wantErr  bool
+	}{
+		{
+			name:     "digits",
+			length:   16,
+			alphabet: credential.Alphabets["digits"],
+		},
+		{
+			name:     "symbols",
+			length:   64,
+			alphabet: credential.Alphabets["symbols"],
+		},
+		{
+			name:    "empty alphabet",
+			length:  8,
+			wantErr: true,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := credential.Password(testCase.length, testCase.alphabet)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("Password() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+
+			if testCase.wantErr {
+				return
+			}
+
+			if len([]rune(got)) != testCase.length {
+				t.Errorf("Password() has length %d, want %d", len([]rune(got)), testCase.length)
+			}
+
+			for _, char := range got {
+				if !strings.ContainsRune(testCase.alphabet, char) {
+					t.Errorf("Password() contains %q which is not in the alphabet", char)
+				}
+			}
+		})
+	}
+}
+
+func TestHex(t *testing.T) {
+	t.Parallel()
+
+	got, err := credential.Hex(16)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(got) {
+		t.Errorf("Hex() = %q, want 32 hex characters", got)
+	}
+}
+
+func TestUUID(t *testing.T) {
+	t.Parallel()
+
+	got, err := credential.UUID()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(got) {
+		t.Errorf("UUID() = %q, want a version 4 UUID", got)
+	}
+}
@@ -0,0 +1,139 @@
+package credential
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+const (
+	// SSHKeyTypeEd25519 is the key type of ed25519 SSH keys.
+	SSHKeyTypeEd25519 = "ssh-ed25519"
+	// SSHKeyTypeRSA is the key type of RSA SSH keys.
+	SSHKeyTypeRSA = "ssh-rsa"
+
+	// MinRSABits is the smallest RSA key size that is generated.
+	MinRSABits = 2048
+
+	opensshMagic     = "openssh-key-v1\x00"
+	opensshBlockSize = 8
+	checkIntBytes    = 4
+)
+
+var errKeyType = errors.New("unsupported SSH key type")
+
+// SSHKeyPair is a generated SSH key pair.
+type SSHKeyPair struct {
+	// PrivateKey is the PEM encoded private key in OpenSSH format.
+	PrivateKey []byte
+	// AuthorizedKey is the public key in authorized_keys format.
+	AuthorizedKey []byte
+}
+
+// GenerateSSHKeyPair creates an unencrypted SSH key pair. bits is only used for
+// RSA keys.
+func GenerateSSHKeyPair(keyType string, bits int, comment string) (*SSHKeyPair, error) {
+	var (
+		public  []byte
+		private []byte
+	)
+
+	switch keyType {
+	case SSHKeyTypeEd25519:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ed25519 key: %w", err)
+		}
+
+		public = appendString(appendString(nil, []byte(keyType)), pub)
+		private = appendString(appendString(appendString(nil, []byte(keyType)), pub), priv)
+	case SSHKeyTypeRSA:
+		if bits < MinRSABits {
+			bits = MinRSABits
+		}
+
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA key: %w", err)
+		}
+
+		exponent := big.NewInt(int64(key.E))
+
+		public = appendString(nil, []byte(keyType))
+		public = appendMPInt(public, exponent)
+		public = appendMPInt(public, key.N)
+
+		private = appendString(nil, []byte(keyType))
+		for _, value := range []*big.Int{key.N, exponent, key.D, key.Precomputed.Qinv, key.Primes[0], key.Primes[1]} {
+			private = appendMPInt(private, value)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", errKeyType, keyType)
+	}
+
+	privateKey, err := marshalOpenSSH(public, private, comment)
+	if err != nil {
+		return nil, err
+	}
+
+	authorizedKey := keyType + " " + base64.StdEncoding.EncodeToString(public)
+	if comment != "" {
+		authorizedKey += " " + comment
+	}
+
+	return &SSHKeyPair{
+		PrivateKey:    privateKey,
+		AuthorizedKey: []byte(authorizedKey + "\n"),
+	}, nil
+}
+
+// marshalOpenSSH encodes an unencrypted private key in the openssh-key-v1
+// format described in PROTOCOL.key of OpenSSH.
+func marshalOpenSSH(public []byte, private []byte, comment string) ([]byte, error) {
+	check := make([]byte, checkIntBytes)
+
+	_, err := rand.Read(check)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read random data: %w", err)
+	}
+
+	section := append(append([]byte{}, check...), check...)
+	section = append(section, private...)
+	section = appendString(section, []byte(comment))
+
+	for pad := byte(1); len(section)%opensshBlockSize != 0; pad++ {
+		section = append(section, pad)
+	}
+
+	buf := []byte(opensshMagic)
+	buf = appendString(buf, []byte("none"))
+	buf = appendString(buf, []byte("none"))
+	buf = appendString(buf, nil)
+	buf = binary.BigEndian.AppendUint32(buf, 1)
+	buf = appendString(buf, public)
+	buf = appendString(buf, section)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: buf}), nil
+}
+
+func appendString(buf []byte, value []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(value)))
+
+	return append(buf, value...)
+}
+
+// appendMPInt appends a positive integer in the SSH mpint encoding.
+func appendMPInt(buf []byte, value *big.Int) []byte {
+	bytes := value.Bytes()
+	if len(bytes) > 0 && bytes[0]&0x80 != 0 {
+		bytes = append([]byte{0}, bytes...)
+	}
+
+	return appendString(buf, bytes)
+}
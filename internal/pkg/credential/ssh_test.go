@@ -0,0 +1,68 @@
+package credential_test
+
+import (
+	"csi-driver/internal/pkg/credential"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func TestGenerateSSHKeyPair(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		keyType string
+		wantErr bool
+	}{
+		{
+			name:    "ed25519",
+			keyType: credential.SSHKeyTypeEd25519,
+		},
+		{
+			name:    "rsa",
+			keyType: credential.SSHKeyTypeRSA,
+		},
+		{
+			name:    "dsa",
+			keyType: "ssh-dss",
+			wantErr: true,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := credential.GenerateSSHKeyPair(testCase.keyType, credential.MinRSABits, "test")
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("GenerateSSHKeyPair() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+
+			if testCase.wantErr {
+				return
+			}
+
+			block, _ := pem.Decode(got.PrivateKey)
+			if block == nil || block.Type != "OPENSSH PRIVATE KEY" {
+				t.Fatalf("private key is not an OpenSSH PEM block")
+			}
+
+			if !strings.HasPrefix(string(block.Bytes), "openssh-key-v1\x00") {
+				t.Error("private key is missing the openssh-key-v1 magic")
+			}
+
+			fields := strings.Fields(string(got.AuthorizedKey))
+			if len(fields) != 3 || fields[0] != testCase.keyType || fields[2] != "test" {
+				t.Fatalf("unexpected authorized key: %q", got.AuthorizedKey)
+			}
+
+			if _, err := base64.StdEncoding.DecodeString(fields[1]); err != nil {
+				t.Errorf("authorized key is not base64: %v", err)
+			}
+		})
+	}
+}
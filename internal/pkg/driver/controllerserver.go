@@ -0,0 +1,199 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	"csi-driver/internal/pkg/storage"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// ControllerServer implements csi.ControllerServer. Volumes are ephemeral and
+// live on a single node, so the only controller feature is reporting the
+// capacity of the node for storage capacity tracking.
+type ControllerServer struct {
+	StorageBackend storage.Storage
+}
+
+// CreateVolume implements the csi.ControllerServer interface.
+// Not implemented.
+func (cs *ControllerServer) CreateVolume(
+	_ context.Context,
+	_ *csi.CreateVolumeRequest,
+) (*csi.CreateVolumeResponse, error) {
+	return nil, fmt.Errorf("failed CreateVolume: %w",
+		status.Error(codes.Unimplemented, "CreateVolume not implemented"),
+	)
+}
+
+// DeleteVolume implements the csi.ControllerServer interface.
+// Not implemented.
+func (cs *ControllerServer) DeleteVolume(
+	_ context.Context,
+	_ *csi.DeleteVolumeRequest,
+) (*csi.DeleteVolumeResponse, error) {
+	return nil, fmt.Errorf("failed DeleteVolume: %w",
+		status.Error(codes.Unimplemented, "DeleteVolume not implemented"),
+	)
+}
+
+// ControllerPublishVolume implements the csi.ControllerServer interface.
+// Not implemented.
+func (cs *ControllerServer) ControllerPublishVolume(
+	_ context.Context,
+	_ *csi.ControllerPublishVolumeRequest,
+) (*csi.ControllerPublishVolumeResponse, error) {
+	return nil, fmt.Errorf("failed ControllerPublishVolume: %w",
+		status.Error(codes.Unimplemented, "ControllerPublishVolume not implemented"),
+	)
+}
+
+// ControllerUnpublishVolume implements the csi.ControllerServer interface.
+// Not implemented.
+func (cs *ControllerServer) ControllerUnpublishVolume(
+	_ context.Context,
+	_ *csi.ControllerUnpublishVolumeRequest,
+) (*csi.ControllerUnpublishVolumeResponse, error) {
+	return nil, fmt.Errorf("failed ControllerUnpublishVolume: %w",
+		status.Error(codes.Unimplemented, "ControllerUnpublishVolume not implemented"),
+	)
+}
+
+// ValidateVolumeCapabilities implements the csi.ControllerServer interface.
+// Not implemented.
+func (cs *ControllerServer) ValidateVolumeCapabilities(
+	_ context.Context,
+	_ *csi.ValidateVolumeCapabilitiesRequest,
+) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	return nil, fmt.Errorf("failed ValidateVolumeCapabilities: %w",
+		status.Error(codes.Unimplemented, "ValidateVolumeCapabilities not implemented"),
+	)
+}
+
+// ListVolumes implements the csi.ControllerServer interface.
+// Not implemented.
+func (cs *ControllerServer) ListVolumes(
+	_ context.Context,
+	_ *csi.ListVolumesRequest,
+) (*csi.ListVolumesResponse, error) {
+	return nil, fmt.Errorf("failed ListVolumes: %w",
+		status.Error(codes.Unimplemented, "ListVolumes not implemented"),
+	)
+}
+
+// GetCapacity implements the csi.ControllerServer interface.
+// Reports the space left on the node, if the storage backend knows it.
+func (cs *ControllerServer) GetCapacity(
+	_ context.Context,
+	_ *csi.GetCapacityRequest,
+) (*csi.GetCapacityResponse, error) {
+	reporter, ok := cs.StorageBackend.(storage.CapacityReporter)
+	if !ok {
+		return nil, fmt.Errorf("failed GetCapacity: %w",
+			status.Error(codes.Unimplemented, "GetCapacity not implemented"),
+		)
+	}
+
+	capacity, err := reporter.Capacity()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get capacity: %w", err)
+	}
+
+	resp := &csi.GetCapacityResponse{AvailableCapacity: capacity.Available}
+
+	if capacity.MaxVolumeSize > 0 {
+		resp.MaximumVolumeSize = wrapperspb.Int64(capacity.MaxVolumeSize)
+	}
+
+	return resp, nil
+}
+
+// ControllerGetCapabilities implements the csi.ControllerServer interface.
+// Gets controller capabilities.
+func (cs *ControllerServer) ControllerGetCapabilities(
+	_ context.Context,
+	_ *csi.ControllerGetCapabilitiesRequest,
+) (*csi.ControllerGetCapabilitiesResponse, error) {
+	return &csi.ControllerGetCapabilitiesResponse{
+		Capabilities: []*csi.ControllerServiceCapability{
+			{
+				//nolint:nosnakecase // library code.
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// CreateSnapshot implements the csi.ControllerServer interface.
+// Not implemented.
+func (cs *ControllerServer) CreateSnapshot(
+	_ context.Context,
+	_ *csi.CreateSnapshotRequest,
+) (*csi.CreateSnapshotResponse, error) {
+	return nil, fmt.Errorf("failed CreateSnapshot: %w",
+		status.Error(codes.Unimplemented, "CreateSnapshot not implemented"),
+	)
+}
+
+// DeleteSnapshot implements the csi.ControllerServer interface.
+// Not implemented.
+func (cs *ControllerServer) DeleteSnapshot(
+	_ context.Context,
+	_ *csi.DeleteSnapshotRequest,
+) (*csi.DeleteSnapshotResponse, error) {
+	return nil, fmt.Errorf("failed DeleteSnapshot: %w",
+		status.Error(codes.Unimplemented, "DeleteSnapshot not implemented"),
+	)
+}
+
+// ListSnapshots implements the csi.ControllerServer interface.
+// Not implemented.
+func (cs *ControllerServer) ListSnapshots(
+	_ context.Context,
+	_ *csi.ListSnapshotsRequest,
+) (*csi.ListSnapshotsResponse, error) {
+	return nil, fmt.Errorf("failed ListSnapshots: %w",
+		status.Error(codes.Unimplemented, "ListSnapshots not implemented"),
+	)
+}
+
+// ControllerExpandVolume implements the csi.ControllerServer interface.
+// Not implemented.
+func (cs *ControllerServer) ControllerExpandVolume(
+	_ context.Context,
+	_ *csi.ControllerExpandVolumeRequest,
+) (*csi.ControllerExpandVolumeResponse, error) {
+	return nil, fmt.Errorf("failed ControllerExpandVolume: %w",
+		status.Error(codes.Unimplemented, "ControllerExpandVolume not implemented"),
+	)
+}
+
+// ControllerGetVolume implements the csi.ControllerServer interface.
+// Not implemented.
+func (cs *ControllerServer) ControllerGetVolume(
+	_ context.Context,
+	_ *csi.ControllerGetVolumeRequest,
+) (*csi.ControllerGetVolumeResponse, error) {
+	return nil, fmt.Errorf("failed ControllerGetVolume: %w",
+		status.Error(codes.Unimplemented, "ControllerGetVolume not implemented"),
+	)
+}
+
+// ControllerModifyVolume implements the csi.ControllerServer interface.
+// Not implemented.
+func (cs *ControllerServer) ControllerModifyVolume(
+	_ context.Context,
+	_ *csi.ControllerModifyVolumeRequest,
+) (*csi.ControllerModifyVolumeResponse, error) {
+	return nil, fmt.Errorf("failed ControllerModifyVolume: %w",
+		status.Error(codes.Unimplemented, "ControllerModifyVolume not implemented"),
+	)
+}
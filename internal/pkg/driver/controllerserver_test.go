@@ -0,0 +1,80 @@
+package driver_test
+
+import (
+	"context"
+	"csi-driver/internal/pkg/driver"
+	"csi-driver/internal/pkg/storage"
+	"testing"
+	"testing/fstest"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+)
+
+func TestControllerServer_CreateVolume(t *testing.T) {
+	t.Parallel()
+
+	controllerServer := &driver.ControllerServer{}
+
+	resp, err := controllerServer.CreateVolume(context.Background(), &csi.CreateVolumeRequest{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Fatalf("unexpected error for unimplemented gRPC method: %v", err)
+	}
+
+	if resp != nil {
+		t.Fatalf("unexpected non-nil response: %v", resp)
+	}
+}
+
+func TestControllerServer_ControllerGetCapabilities(t *testing.T) {
+	t.Parallel()
+
+	controllerServer := &driver.ControllerServer{}
+
+	resp, err := controllerServer.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	//nolint:nosnakecase // library code.
+	if len(resp.GetCapabilities()) != 1 ||
+		resp.GetCapabilities()[0].GetRpc().GetType() != csi.ControllerServiceCapability_RPC_GET_CAPACITY {
+		t.Errorf("ControllerServer.ControllerGetCapabilities() = %v, want GET_CAPACITY", resp)
+	}
+}
+
+func TestControllerServer_GetCapacity(t *testing.T) {
+	t.Parallel()
+
+	backend, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithMaxVolumeSize(1<<20),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	controllerServer := &driver.ControllerServer{StorageBackend: backend}
+
+	resp, err := controllerServer.GetCapacity(context.Background(), &csi.GetCapacityRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.GetAvailableCapacity() <= 0 || resp.GetMaximumVolumeSize().GetValue() != 1<<20 {
+		t.Errorf("ControllerServer.GetCapacity() = %v, want available capacity and a 1MiB volume limit", resp)
+	}
+
+	controllerServer = &driver.ControllerServer{StorageBackend: &storage.MockStorage{}}
+
+	_, err = controllerServer.GetCapacity(context.Background(), &csi.GetCapacityRequest{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("ControllerServer.GetCapacity() error = %v, want %v", err, codes.Unimplemented)
+	}
+}
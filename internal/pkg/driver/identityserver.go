@@ -14,6 +14,8 @@ import (
 type IdentityServer struct {
 	Name    string
 	Version string
+	// Topology advertises that volumes are only accessible from some nodes.
+	Topology bool
 }
 
 // GetPluginInfo implements csi.IdentityServer.GetPluginInfo.
@@ -44,18 +46,26 @@ func (is *IdentityServer) GetPluginCapabilities(
 	_ context.Context,
 	_ *csi.GetPluginCapabilitiesRequest,
 ) (*csi.GetPluginCapabilitiesResponse, error) {
-	return &csi.GetPluginCapabilitiesResponse{
-		Capabilities: []*csi.PluginCapability{
-			{
-				//nolint:nosnakecase // library code
-				Type: &csi.PluginCapability_Service_{
-					Service: &csi.PluginCapability_Service{
-						Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
-					},
-				},
+	capabilities := []*csi.PluginCapability{
+		pluginCapability(csi.PluginCapability_Service_CONTROLLER_SERVICE),
+	}
+
+	if is.Topology {
+		capabilities = append(capabilities, pluginCapability(csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS))
+	}
+
+	return &csi.GetPluginCapabilitiesResponse{Capabilities: capabilities}, nil
+}
+
+//nolint:nosnakecase // library code.
+func pluginCapability(service csi.PluginCapability_Service_Type) *csi.PluginCapability {
+	return &csi.PluginCapability{
+		Type: &csi.PluginCapability_Service_{
+			Service: &csi.PluginCapability_Service{
+				Type: service,
 			},
 		},
-	}, nil
+	}
 }
 
 // Probe implements csi.IdentityServer.Probe.
@@ -90,6 +90,32 @@ func TestIdentityServer_GetPluginCapabilities(t *testing.T) {
 	}
 }
 
+func TestIdentityServer_GetPluginCapabilities_Topology(t *testing.T) {
+	t.Parallel()
+
+	for _, topology := range []bool{false, true} {
+		identityServer := driver.IdentityServer{Topology: topology}
+
+		resp, err := identityServer.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+		if err != nil {
+			t.Fatalf("unexpected error getting capabilities: %v", err)
+		}
+
+		advertised := false
+
+		for _, capability := range resp.GetCapabilities() {
+			//nolint:nosnakecase // library code.
+			if capability.GetService().GetType() == csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS {
+				advertised = true
+			}
+		}
+
+		if advertised != topology {
+			t.Errorf("GetPluginCapabilities() with topology %v advertises constraints: %v", topology, advertised)
+		}
+	}
+}
+
 func TestIdentityServer_Probe(t *testing.T) {
 	t.Parallel()
 
@@ -2,9 +2,14 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
+	"csi-driver/internal/pkg/hook"
+	"csi-driver/internal/pkg/policy"
+	"csi-driver/internal/pkg/prepare"
 	"csi-driver/internal/pkg/storage"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -24,6 +29,21 @@ type NodeServer struct {
 	NodeID         string
 	Mounter        mount.Interface
 	StorageBackend storage.Storage
+	// Preparer writes new volumes in the background when set. Publishing waits
+	// up to PublishTimeout for the content and asks kubelet to retry after
+	// that.
+	Preparer       *prepare.Pool
+	PublishTimeout time.Duration
+	// Hooks run around publishing and unpublishing when set.
+	Hooks *hook.Runner
+	// Policy admits the attributes of published volumes when set.
+	Policy *policy.Store
+	// Topology holds the segments the node is in, e.g. its zone, and
+	// MaxVolumesPerNode how many volumes it takes, zero meaning no limit.
+	// The limit is only advertised here; the storage backend enforces it when
+	// built with storage.WithMaxVolumes, as csidriver.New does.
+	Topology          map[string]string
+	MaxVolumesPerNode int64
 }
 
 // NodeStageVolume implements the csi.NodeServer interface.
@@ -49,29 +69,47 @@ func (ns *NodeServer) NodeUnstageVolume(
 }
 
 // NodePublishVolume implements the csi.NodeServer interface.
-// Publishes volume.
+// Publishes volume unless the policy denies it or a pre-publish hook vetoes
+// it.
 func (ns *NodeServer) NodePublishVolume(
-	_ context.Context,
+	ctx context.Context,
 	req *csi.NodePublishVolumeRequest,
 ) (*csi.NodePublishVolumeResponse, error) {
-	targetPath := req.GetTargetPath()
-	vCtx := req.GetVolumeContext()
-	volumeID := req.GetVolumeId()
+	if ns.Policy != nil {
+		err := ns.Policy.Admit(req.GetVolumeContext())
+		if err != nil {
+			return nil, fmt.Errorf("failed to publish volume: %w",
+				status.Error(codes.PermissionDenied, err.Error()),
+			)
+		}
+	}
 
-	success := false
+	if ns.Hooks == nil {
+		return ns.publishVolume(ctx, req)
+	}
 
-	defer func() {
-		if !success {
-			_ = ns.Mounter.Unmount(targetPath)
-			_ = ns.StorageBackend.RemoveVolume(volumeID)
-		}
-	}()
+	event := hook.NewEvent(hook.PrePublish, req.GetVolumeId(), req.GetTargetPath(), req.GetVolumeContext())
 
-	_, err := ns.StorageBackend.WriteVolume(volumeID, vCtx)
+	err := ns.Hooks.Run(ctx, event)
 	if err != nil {
-		return nil, fmt.Errorf("unexpected error writing to storage backend: %w", err)
+		return nil, hookError(err)
 	}
 
+	resp, err := ns.publishVolume(ctx, req)
+
+	ns.runPostHook(ctx, event, hook.PostPublish, err)
+
+	return resp, err
+}
+
+func (ns *NodeServer) publishVolume(
+	ctx context.Context,
+	req *csi.NodePublishVolumeRequest,
+) (*csi.NodePublishVolumeResponse, error) {
+	targetPath := req.GetTargetPath()
+	vCtx := req.GetVolumeContext()
+	volumeID := req.GetVolumeId()
+
 	isMountPoint, err := ns.Mounter.IsMountPoint(targetPath)
 
 	switch {
@@ -87,11 +125,48 @@ func (ns *NodeServer) NodePublishVolume(
 	}
 
 	if isMountPoint {
-		success = true
+		// kubelet republishes mounted volumes when the CSIDriver requires it,
+		// e.g. to hand over fresh service account tokens. The content is
+		// refreshed in place and a failure must not tear down the running pod.
+		_, err := ns.StorageBackend.WriteVolume(ctx, volumeID, vCtx, req.GetSecrets())
+		if err != nil {
+			return nil, writeVolumeError(err)
+		}
 
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
+	success := false
+	// retained data goes back to being retained when republishing it fails,
+	// rather than being removed with the volume.
+	retained := ns.volumeRetained(volumeID)
+
+	defer func() {
+		if !success {
+			_ = ns.Mounter.Unmount(targetPath)
+
+			if retained {
+				_ = ns.releaseVolume(volumeID)
+			} else {
+				_ = ns.StorageBackend.RemoveVolume(volumeID)
+			}
+		}
+	}()
+
+	ready, err := ns.writeVolume(ctx, req)
+	if err != nil {
+		return nil, writeVolumeError(err)
+	}
+
+	if !ready {
+		// keep what has been prepared so far for the retry.
+		success = true
+
+		return nil, fmt.Errorf("volume content not ready: %w",
+			status.Error(codes.Aborted, "content preparation in progress"),
+		)
+	}
+
 	err = ns.Mounter.Mount(ns.StorageBackend.PathForVolume(volumeID), targetPath, "", []string{"bind", "ro"})
 	if err != nil {
 		return nil, fmt.Errorf("error mounting volume to pod %w", err)
@@ -102,12 +177,57 @@ func (ns *NodeServer) NodePublishVolume(
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+// writeVolume writes the content of a new volume, in the background if a
+// Preparer is configured. It reports whether the content is ready.
+func (ns *NodeServer) writeVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (bool, error) {
+	if ns.Preparer == nil {
+		_, err := ns.StorageBackend.WriteVolume(ctx, req.GetVolumeId(), req.GetVolumeContext(), req.GetSecrets())
+
+		return err == nil, err
+	}
+
+	return ns.Preparer.Run(req.GetVolumeId(), ns.PublishTimeout, func(ctx context.Context) error {
+		_, err := ns.StorageBackend.WriteVolume(ctx, req.GetVolumeId(), req.GetVolumeContext(), req.GetSecrets())
+
+		return err
+	})
+}
+
 // NodeUnpublishVolume implements the csi.NodeServer interface.
-// Unpublishes volume.
+// Unpublishes volume. Hooks cannot stop this, their failures are only logged.
 func (ns *NodeServer) NodeUnpublishVolume(
-	_ context.Context,
+	ctx context.Context,
+	req *csi.NodeUnpublishVolumeRequest,
+) (*csi.NodeUnpublishVolumeResponse, error) {
+	if ns.Hooks == nil {
+		return ns.unpublishVolume(ctx, req)
+	}
+
+	// the request has no attributes, the backend may still know them.
+	var attributes map[string]string
+	if reader, ok := ns.StorageBackend.(storage.AttributeReader); ok {
+		attributes, _ = reader.VolumeAttributes(req.GetVolumeId())
+	}
+
+	event := hook.NewEvent(hook.PreUnpublish, req.GetVolumeId(), req.GetTargetPath(), attributes)
+
+	_ = ns.Hooks.Run(ctx, event)
+
+	resp, err := ns.unpublishVolume(ctx, req)
+
+	ns.runPostHook(ctx, event, hook.PostUnpublish, err)
+
+	return resp, err
+}
+
+func (ns *NodeServer) unpublishVolume(
+	ctx context.Context,
 	req *csi.NodeUnpublishVolumeRequest,
 ) (*csi.NodeUnpublishVolumeResponse, error) {
+	if ns.Preparer != nil {
+		ns.Preparer.Cancel(ctx, req.GetVolumeId())
+	}
+
 	// check to see if volume is mounted
 	isMounted, err := ns.Mounter.IsMountPoint(req.GetTargetPath())
 	if err != nil {
@@ -121,7 +241,7 @@ func (ns *NodeServer) NodeUnpublishVolume(
 		}
 	}
 
-	err = ns.StorageBackend.RemoveVolume(req.GetVolumeId())
+	err = ns.releaseVolume(req.GetVolumeId())
 	if err != nil {
 		return nil, fmt.Errorf("failed to remove directories: %w", err)
 	}
@@ -129,15 +249,77 @@ func (ns *NodeServer) NodeUnpublishVolume(
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
+// releaseVolume removes an unpublished volume, unless the storage backend
+// retains its data for a republish.
+func (ns *NodeServer) releaseVolume(id string) error {
+	if retainer, ok := ns.StorageBackend.(storage.Retainer); ok {
+		_, err := retainer.ReleaseVolume(id)
+
+		return err
+	}
+
+	return ns.StorageBackend.RemoveVolume(id)
+}
+
+// volumeRetained reports whether the storage backend retains the data of
+// volume id.
+func (ns *NodeServer) volumeRetained(id string) bool {
+	retainer, ok := ns.StorageBackend.(storage.Retainer)
+
+	return ok && retainer.VolumeRetained(id)
+}
+
+// runPostHook runs the hooks of phase after the call of event finished with
+// err.
+func (ns *NodeServer) runPostHook(ctx context.Context, event *hook.Event, phase string, err error) {
+	post := *event
+	post.Phase = phase
+
+	if err != nil {
+		post.Error = err.Error()
+	}
+
+	_ = ns.Hooks.Run(ctx, &post)
+}
+
 // NodeGetVolumeStats implements the csi.NodeServer interface.
-// Not implemented.
+// Reports the size of the volume content and whether it is healthy, if the
+// storage backend supports it.
 func (ns *NodeServer) NodeGetVolumeStats(
 	_ context.Context,
-	_ *csi.NodeGetVolumeStatsRequest,
+	req *csi.NodeGetVolumeStatsRequest,
 ) (*csi.NodeGetVolumeStatsResponse, error) {
-	return nil, fmt.Errorf("failed NodeGetVolumeStats: %w",
-		status.Error(codes.Unimplemented, "NodeGetVolumeStats not implemented"),
-	)
+	reporter, ok := ns.StorageBackend.(storage.StatusReporter)
+	if !ok {
+		return nil, fmt.Errorf("failed NodeGetVolumeStats: %w",
+			status.Error(codes.Unimplemented, "NodeGetVolumeStats not implemented"),
+		)
+	}
+
+	volumeStatus, err := reporter.VolumeStatus(req.GetVolumeId())
+	if err != nil {
+		if errors.Is(err, storage.ErrVolumeNotFound) {
+			return nil, fmt.Errorf("failed NodeGetVolumeStats: %w",
+				status.Error(codes.NotFound, err.Error()),
+			)
+		}
+
+		return nil, fmt.Errorf("failed to get volume status: %w", err)
+	}
+
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: []*csi.VolumeUsage{
+			{
+				//nolint:nosnakecase // library code.
+				Unit: csi.VolumeUsage_BYTES,
+				Used: volumeStatus.UsedBytes,
+			},
+		},
+		VolumeCondition: &csi.VolumeCondition{
+			Abnormal: volumeStatus.Abnormal,
+			Message:  volumeStatus.Message,
+		},
+	}, nil
 }
 
 // NodeExpandVolume implements the csi.NodeServer interface.
@@ -159,25 +341,78 @@ func (ns *NodeServer) NodeGetCapabilities(
 ) (*csi.NodeGetCapabilitiesResponse, error) {
 	return &csi.NodeGetCapabilitiesResponse{
 		Capabilities: []*csi.NodeServiceCapability{
-			{
-				//nolint:nosnakecase // library code.
-				Type: &csi.NodeServiceCapability_Rpc{
-					Rpc: &csi.NodeServiceCapability_RPC{
-						Type: csi.NodeServiceCapability_RPC_UNKNOWN,
-					},
-				},
-			},
+			nodeCapability(csi.NodeServiceCapability_RPC_GET_VOLUME_STATS),
+			nodeCapability(csi.NodeServiceCapability_RPC_VOLUME_CONDITION),
 		},
 	}, nil
 }
 
+//nolint:nosnakecase // library code.
+func nodeCapability(capability csi.NodeServiceCapability_RPC_Type) *csi.NodeServiceCapability {
+	return &csi.NodeServiceCapability{
+		Type: &csi.NodeServiceCapability_Rpc{
+			Rpc: &csi.NodeServiceCapability_RPC{
+				Type: capability,
+			},
+		},
+	}
+}
+
 // NodeGetInfo implements the csi.NodeServer interface.
-// Returns node name.
+// Returns node name, topology and volume limit.
 func (ns *NodeServer) NodeGetInfo(
 	_ context.Context,
 	_ *csi.NodeGetInfoRequest,
 ) (*csi.NodeGetInfoResponse, error) {
-	return &csi.NodeGetInfoResponse{
-		NodeId: ns.NodeID,
-	}, nil
+	resp := &csi.NodeGetInfoResponse{
+		NodeId:            ns.NodeID,
+		MaxVolumesPerNode: ns.MaxVolumesPerNode,
+	}
+
+	if len(ns.Topology) > 0 {
+		resp.AccessibleTopology = &csi.Topology{Segments: ns.Topology}
+	}
+
+	return resp, nil
+}
+
+// writeVolumeError maps storage backend errors to gRPC status codes.
+func writeVolumeError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrInvalidAttribute):
+		return fmt.Errorf("failed to write volume: %w",
+			status.Error(codes.InvalidArgument, err.Error()),
+		)
+	case errors.Is(err, storage.ErrNotConfigured):
+		return fmt.Errorf("failed to write volume: %w",
+			status.Error(codes.FailedPrecondition, err.Error()),
+		)
+	case errors.Is(err, storage.ErrUntrusted):
+		return fmt.Errorf("failed to write volume: %w",
+			status.Error(codes.PermissionDenied, err.Error()),
+		)
+	case errors.Is(err, storage.ErrUnavailable):
+		return fmt.Errorf("failed to write volume: %w",
+			status.Error(codes.Unavailable, err.Error()),
+		)
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		return fmt.Errorf("failed to write volume: %w",
+			status.Error(codes.ResourceExhausted, err.Error()),
+		)
+	}
+
+	return fmt.Errorf("unexpected error writing to storage backend: %w", err)
+}
+
+// hookError maps pre-publish hook errors to gRPC status codes.
+func hookError(err error) error {
+	if errors.Is(err, hook.ErrVetoed) {
+		return fmt.Errorf("failed to publish volume: %w",
+			status.Error(codes.FailedPrecondition, err.Error()),
+		)
+	}
+
+	return fmt.Errorf("failed to run hooks: %w",
+		status.Error(codes.Unavailable, err.Error()),
+	)
 }
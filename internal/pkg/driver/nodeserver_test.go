@@ -3,14 +3,26 @@ package driver_test
 import (
 	"context"
 	"csi-driver/internal/pkg/driver"
+	"csi-driver/internal/pkg/hook"
+	"csi-driver/internal/pkg/policy"
+	"csi-driver/internal/pkg/prepare"
 	"csi-driver/internal/pkg/storage"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/mount-utils"
 )
 
@@ -106,6 +118,25 @@ func TestNodeServer_NodeGetInfo(t *testing.T) {
 	}
 }
 
+func TestNodeServer_NodeGetInfo_Topology(t *testing.T) {
+	t.Parallel()
+
+	nodeServer := &driver.NodeServer{
+		NodeID:            "node-1",
+		Topology:          map[string]string{"topology.kubernetes.io/zone": "zone-a"},
+		MaxVolumesPerNode: 10,
+	}
+
+	resp, err := nodeServer.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error for gRPC method: %v", err)
+	}
+
+	if resp.GetMaxVolumesPerNode() != 10 || resp.GetAccessibleTopology().GetSegments()["topology.kubernetes.io/zone"] != "zone-a" {
+		t.Errorf("NodeServer.NodeGetInfo() = %v, want topology and volume limit", resp)
+	}
+}
+
 func TestNodeServer_NodeGetCapabilities(t *testing.T) {
 	t.Parallel()
 
@@ -207,6 +238,385 @@ func TestNodeServer_NodePublishVolume(t *testing.T) {
 	}
 }
 
+func TestNodeServer_NodePublishVolume_Republish(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		backend *storage.MockStorage
+		wantErr bool
+	}{
+		{
+			name:    "republish refreshes content",
+			backend: &storage.MockStorage{},
+		},
+		{
+			name:    "failed republish keeps mount",
+			backend: &storage.MockStorage{ShouldErr: true},
+			wantErr: true,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			targetPath := t.TempDir()
+			mounter := mount.NewFakeMounter([]mount.MountPoint{{Path: targetPath}})
+
+			nodeServer := &driver.NodeServer{
+				Logger:         zaptest.NewLogger(t),
+				Mounter:        mounter,
+				StorageBackend: testCase.backend,
+			}
+
+			_, err := nodeServer.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "x1b3n4",
+				TargetPath: targetPath,
+			})
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("NodeServer.NodePublishVolume() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+
+			isMountPoint, err := mounter.IsMountPoint(targetPath)
+			if err != nil || !isMountPoint {
+				t.Errorf("target path is no longer mounted: %v", err)
+			}
+		})
+	}
+}
+
+func TestNodeServer_NodePublishVolume_RetainedFailure(t *testing.T) {
+	t.Parallel()
+
+	backend, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithMaxRetention(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("failed to create storage backend: %v", err)
+	}
+
+	nodeServer := &driver.NodeServer{
+		Logger:         zaptest.NewLogger(t),
+		Mounter:        mount.NewFakeMounter([]mount.MountPoint{}),
+		StorageBackend: backend,
+	}
+
+	vCtx := map[string]string{
+		"csi-driver.mattslater.io/filename":         "config",
+		"csi-driver.mattslater.io/data":             "retained",
+		"csi-driver.mattslater.io/retention-period": "10m",
+		"csi.storage.k8s.io/pod.namespace":          "default",
+		"csi.storage.k8s.io/serviceAccount.name":    "app",
+	}
+
+	publish := func(vCtx map[string]string) error {
+		_, err := nodeServer.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:      "x1b3n4",
+			TargetPath:    filepath.Join(t.TempDir(), "target"),
+			VolumeContext: vCtx,
+		})
+
+		return err
+	}
+
+	err = publish(vCtx)
+	if err != nil {
+		t.Fatalf("NodeServer.NodePublishVolume() error = %v", err)
+	}
+
+	_, err = nodeServer.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "x1b3n4",
+		TargetPath: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("NodeServer.NodeUnpublishVolume() error = %v", err)
+	}
+
+	broken := map[string]string{"csi-driver.mattslater.io/expires-after": "soon"}
+	for key, value := range vCtx {
+		broken[key] = value
+	}
+
+	err = publish(broken)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("NodeServer.NodePublishVolume() error = %v, want %v", err, codes.InvalidArgument)
+	}
+
+	if !backend.VolumeRetained("x1b3n4") {
+		t.Fatal("failed republish did not keep the volume data retained")
+	}
+
+	data, err := os.ReadFile(filepath.Join(backend.PathForVolume("x1b3n4"), "config"))
+	if err != nil || string(data) != "retained" {
+		t.Errorf("retained data = %q, %v, want it kept", data, err)
+	}
+}
+
+func TestNodeServer_NodePublishVolume_ErrorCodes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		err      error
+		wantCode codes.Code
+	}{
+		{
+			name:     "invalid attribute",
+			err:      storage.ErrInvalidAttribute,
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "not configured",
+			err:      storage.ErrNotConfigured,
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name:     "untrusted content",
+			err:      storage.ErrUntrusted,
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "content unavailable",
+			err:      storage.ErrUnavailable,
+			wantCode: codes.Unavailable,
+		},
+		{
+			name:     "quota exceeded",
+			err:      storage.ErrQuotaExceeded,
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name:     "unexpected error",
+			err:      os.ErrPermission,
+			wantCode: codes.Unknown,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			nodeServer := &driver.NodeServer{
+				Logger:         zaptest.NewLogger(t),
+				Mounter:        mount.NewFakeMounter([]mount.MountPoint{}),
+				StorageBackend: &storage.MockStorage{Err: testCase.err},
+			}
+
+			_, err := nodeServer.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "x1b3n4",
+				TargetPath: filepath.Join(t.TempDir(), "target"),
+			})
+			if got := status.Code(err); got != testCase.wantCode {
+				t.Errorf("NodeServer.NodePublishVolume() code = %v, want %v", got, testCase.wantCode)
+			}
+		})
+	}
+}
+
+func TestNodeServer_NodePublishVolume_Hooks(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event hook.Event
+
+		_ = json.NewDecoder(r.Body).Decode(&event)
+
+		switch event.Pod.Namespace {
+		case "denied":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("not in this namespace"))
+		case "broken":
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	hooks, err := hook.New(zaptest.NewLogger(t), &hook.Config{Hooks: []hook.Spec{
+		{Name: "admission", Phases: []string{hook.PrePublish}, URL: server.URL},
+	}}, t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create hooks: %v", err)
+	}
+
+	tests := []struct {
+		namespace string
+		wantCode  codes.Code
+	}{
+		{namespace: "allowed", wantCode: codes.OK},
+		{namespace: "denied", wantCode: codes.FailedPrecondition},
+		{namespace: "broken", wantCode: codes.Unavailable},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.namespace, func(t *testing.T) {
+			t.Parallel()
+
+			mounter := mount.NewFakeMounter([]mount.MountPoint{})
+			nodeServer := &driver.NodeServer{
+				Logger:         zaptest.NewLogger(t),
+				Mounter:        mounter,
+				StorageBackend: &storage.MockStorage{Path: t.TempDir()},
+				Hooks:          hooks,
+			}
+
+			_, err := nodeServer.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:      "x1b3n4",
+				TargetPath:    filepath.Join(t.TempDir(), "target"),
+				VolumeContext: map[string]string{"csi.storage.k8s.io/pod.namespace": testCase.namespace},
+			})
+			if got := status.Code(err); got != testCase.wantCode {
+				t.Fatalf("NodeServer.NodePublishVolume() code = %v, want %v", got, testCase.wantCode)
+			}
+
+			if mounted := len(mounter.MountPoints) > 0; mounted != (testCase.wantCode == codes.OK) {
+				t.Errorf("NodeServer.NodePublishVolume() mounted = %v with code %v", mounted, testCase.wantCode)
+			}
+		})
+	}
+}
+
+func TestNodeServer_NodePublishVolume_Policy(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "policy.json")
+
+	err := os.WriteFile(path, []byte(`{"rules": [{"name": "inline only", "sources": ["inline"]}]}`), 0o644)
+	if err != nil {
+		t.Fatalf("failed to write policy: %v", err)
+	}
+
+	store, err := policy.NewStore(zaptest.NewLogger(t), path)
+	if err != nil {
+		t.Fatalf("failed to load policy: %v", err)
+	}
+
+	nodeServer := &driver.NodeServer{
+		Logger:         zaptest.NewLogger(t),
+		Mounter:        mount.NewFakeMounter([]mount.MountPoint{}),
+		StorageBackend: &storage.MockStorage{Path: t.TempDir()},
+		Policy:         store,
+	}
+
+	_, err = nodeServer.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:      "x1b3n4",
+		TargetPath:    filepath.Join(t.TempDir(), "target"),
+		VolumeContext: map[string]string{"csi-driver.mattslater.io/source": "url"},
+	})
+	if got := status.Code(err); got != codes.PermissionDenied {
+		t.Fatalf("NodeServer.NodePublishVolume() code = %v, want %v", got, codes.PermissionDenied)
+	}
+
+	if !strings.Contains(err.Error(), "source url is not allowed") {
+		t.Errorf("NodeServer.NodePublishVolume() error = %v, want the reason", err)
+	}
+}
+
+// slowStorage blocks WriteVolume until release is closed.
+type slowStorage struct {
+	storage.MockStorage
+	release chan struct{}
+}
+
+func (ss *slowStorage) WriteVolume(
+	ctx context.Context,
+	_ string,
+	_ map[string]string,
+	_ map[string]string,
+) (bool, error) {
+	select {
+	case <-ss.release:
+		return true, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+func TestNodeServer_NodePublishVolume_Prepare(t *testing.T) {
+	t.Parallel()
+
+	backend := &slowStorage{release: make(chan struct{})}
+	nodeServer := &driver.NodeServer{
+		Logger:         zaptest.NewLogger(t),
+		Mounter:        mount.NewFakeMounter([]mount.MountPoint{}),
+		StorageBackend: backend,
+		Preparer:       prepare.NewPool(zaptest.NewLogger(t), 1, time.Minute),
+		PublishTimeout: 10 * time.Millisecond,
+	}
+
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:   "x1b3n4",
+		TargetPath: filepath.Join(t.TempDir(), "target"),
+	}
+
+	_, err := nodeServer.NodePublishVolume(context.Background(), req)
+	if got := status.Code(err); got != codes.Aborted {
+		t.Fatalf("NodeServer.NodePublishVolume() code = %v, want %v", got, codes.Aborted)
+	}
+
+	close(backend.release)
+
+	_, err = nodeServer.NodePublishVolume(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error on retry: %v", err)
+	}
+}
+
+// statusStorage reports the status of a single volume.
+type statusStorage struct {
+	storage.MockStorage
+	id     string
+	status *storage.VolumeStatus
+}
+
+func (ss *statusStorage) VolumeStatus(id string) (*storage.VolumeStatus, error) {
+	if id != ss.id {
+		return nil, storage.ErrVolumeNotFound
+	}
+
+	return ss.status, nil
+}
+
+func TestNodeServer_NodeGetVolumeStats_Condition(t *testing.T) {
+	t.Parallel()
+
+	nodeServer := &driver.NodeServer{
+		StorageBackend: &statusStorage{
+			id:     "x1b3n4",
+			status: &storage.VolumeStatus{Abnormal: true, Message: "refresh failing", UsedBytes: 42},
+		},
+	}
+
+	resp, err := nodeServer.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "x1b3n4"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	condition := resp.GetVolumeCondition()
+	if !condition.GetAbnormal() || condition.GetMessage() != "refresh failing" {
+		t.Errorf("NodeServer.NodeGetVolumeStats() condition = %v, want abnormal", condition)
+	}
+
+	if len(resp.GetUsage()) != 1 || resp.GetUsage()[0].GetUsed() != 42 {
+		t.Errorf("NodeServer.NodeGetVolumeStats() usage = %v, want 42 bytes used", resp.GetUsage())
+	}
+
+	_, err = nodeServer.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: "missing"})
+	if got := status.Code(err); got != codes.NotFound {
+		t.Errorf("NodeServer.NodeGetVolumeStats() code = %v, want %v", got, codes.NotFound)
+	}
+}
+
 func TestNodeServer_NodeUnpublishVolume(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,155 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// restrictedNetworks are reachable from the node only, e.g. the endpoints of
+// the driver itself or cloud metadata services, which listen on link-local,
+// shared (100.64.0.0/10) and unique local IPv6 addresses such as
+// fd00:ec2::254. They are never fetched from unless a CIDR within them is
+// allowed explicitly.
+var restrictedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+// Allowlist names the hosts a Fetcher may download from.
+type Allowlist struct {
+	hosts    []string
+	networks []*net.IPNet
+}
+
+// ParseAllowlist parses entries that are each a host name such as
+// example.com, a wildcard such as *.example.com matching every subdomain, or a
+// CIDR such as 10.0.0.0/8 matching every host resolving into it.
+func ParseAllowlist(entries []string) (*Allowlist, error) {
+	allowlist := &Allowlist{}
+
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+
+		switch {
+		case entry == "":
+		case strings.Contains(entry, "/"):
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed network %q: %w", entry, err)
+			}
+
+			allowlist.networks = append(allowlist.networks, network)
+		default:
+			allowlist.hosts = append(allowlist.hosts, entry)
+		}
+	}
+
+	return allowlist, nil
+}
+
+// hostAllowed reports whether host is allowed by name.
+func (a *Allowlist) hostAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, pattern := range a.hosts {
+		suffix, wildcard := strings.CutPrefix(pattern, "*")
+		if host == pattern || (wildcard && strings.HasSuffix(host, suffix) && len(host) > len(suffix)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkAddress decides whether the resolved address of host may be dialed.
+// Hosts allowed by name may resolve to any address but the restricted ones,
+// other hosts have to resolve into an allowed network.
+func (a *Allowlist) checkAddress(host string, address string) error {
+	ipString, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrForbidden, err)
+	}
+
+	ip := net.ParseIP(ipString)
+	if ip == nil {
+		return fmt.Errorf("%w: %q is not an IP address", ErrForbidden, ipString)
+	}
+
+	for _, restricted := range restrictedNetworks {
+		if restricted.Contains(ip) && !a.networkAllowed(ip, restricted) {
+			return fmt.Errorf("%w: %s resolves to restricted address %s", ErrForbidden, host, ip)
+		}
+	}
+
+	if !a.hostAllowed(host) && !a.networkAllowed(ip, nil) {
+		return fmt.Errorf("%w: %s (%s)", ErrForbidden, host, ip)
+	}
+
+	return nil
+}
+
+// networkAllowed reports whether ip is in an allowed network that lies
+// within within, if set.
+func (a *Allowlist) networkAllowed(ip net.IP, within *net.IPNet) bool {
+	for _, network := range a.networks {
+		if !network.Contains(ip) {
+			continue
+		}
+
+		if within == nil {
+			return true
+		}
+
+		ones, _ := network.Mask.Size()
+		minOnes, _ := within.Mask.Size()
+
+		if within.Contains(network.IP) && ones >= minOnes {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dialContext dials like dialer but only connects to addresses the allowlist
+// permits. The check runs on the resolved address of every connection, so it
+// also covers redirects and names that resolve differently over time.
+func (a *Allowlist) dialContext(dialer *net.Dialer) func(ctx context.Context, network string, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrForbidden, err)
+		}
+
+		checked := *dialer
+		checked.Control = func(_ string, address string, _ syscall.RawConn) error {
+			return a.checkAddress(host, address)
+		}
+
+		return checked.DialContext(ctx, network, addr)
+	}
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks
+}
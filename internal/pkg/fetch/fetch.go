@@ -0,0 +1,249 @@
+// Package fetch downloads volume content over HTTP(S).
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// dialTimeout and dialKeepAlive match http.DefaultTransport.
+	dialTimeout   = 30 * time.Second
+	dialKeepAlive = 30 * time.Second
+)
+
+var (
+	// ErrUnavailable is returned when the content could not be downloaded,
+	// possibly only for now.
+	ErrUnavailable = errors.New("content unavailable")
+	// ErrTooLarge is returned when the content exceeds the size limit.
+	ErrTooLarge = errors.New("content too large")
+	// ErrDigestMismatch is returned when the content does not match its pinned
+	// digest.
+	ErrDigestMismatch = errors.New("content digest mismatch")
+	// ErrInvalidRequest is returned for requests that can never succeed.
+	ErrInvalidRequest = errors.New("invalid fetch request")
+	// ErrForbidden is returned when the content is on a host the Fetcher may
+	// not download from.
+	ErrForbidden = errors.New("host not allowed")
+
+	// errRetryable marks failures that may go away on another attempt.
+	errRetryable = errors.New("retryable")
+)
+
+// Request describes content to download.
+type Request struct {
+	URL string
+	// SHA256 is the optional hex encoded digest the content must match.
+	SHA256 string
+	// CABundle optionally replaces the system roots for HTTPS.
+	CABundle []byte
+	// MaxSize and Timeout may only lower the limits of the Fetcher.
+	MaxSize int64
+	Timeout time.Duration
+}
+
+// Fetcher downloads content with retries.
+type Fetcher struct {
+	logger  *zap.Logger
+	allowed *Allowlist
+	maxSize int64
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+}
+
+// NewFetcher returns a Fetcher that downloads at most maxSize bytes from the
+// hosts in allowed, gives each attempt timeout and retries failed attempts up
+// to retries times with exponential backoff starting at backoff.
+func NewFetcher(
+	logger *zap.Logger,
+	allowed *Allowlist,
+	maxSize int64,
+	timeout time.Duration,
+	retries int,
+	backoff time.Duration,
+) *Fetcher {
+	if allowed == nil {
+		allowed = &Allowlist{}
+	}
+
+	return &Fetcher{
+		logger:  logger,
+		allowed: allowed,
+		maxSize: maxSize,
+		timeout: timeout,
+		retries: retries,
+		backoff: backoff,
+	}
+}
+
+// Fetch downloads the content described by req.
+func (f *Fetcher) Fetch(ctx context.Context, req Request) ([]byte, error) {
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("%w: %q is not an HTTP(S) URL", ErrInvalidRequest, req.URL)
+	}
+
+	var want []byte
+
+	if req.SHA256 != "" {
+		want, err = hex.DecodeString(strings.TrimPrefix(strings.ToLower(req.SHA256), "sha256:"))
+		if err != nil || len(want) != sha256.Size {
+			return nil, fmt.Errorf("%w: malformed sha256 digest", ErrInvalidRequest)
+		}
+	}
+
+	client, err := f.client(req.CABundle)
+	if err != nil {
+		return nil, err
+	}
+
+	defer client.CloseIdleConnections()
+
+	maxSize := f.maxSize
+	if req.MaxSize > 0 && req.MaxSize < maxSize {
+		maxSize = req.MaxSize
+	}
+
+	timeout := f.timeout
+	if req.Timeout > 0 && req.Timeout < timeout {
+		timeout = req.Timeout
+	}
+
+	backoff := f.backoff
+
+	for attempt := 0; ; attempt++ {
+		data, err := f.attempt(ctx, client, target.String(), maxSize, timeout)
+		if err == nil {
+			return verifyDigest(data, want)
+		}
+
+		if !errors.Is(err, errRetryable) || attempt >= f.retries {
+			return nil, err
+		}
+
+		f.logger.Warn("failed to fetch content, retrying",
+			zap.String("host", target.Host),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", ErrUnavailable, ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+func (f *Fetcher) attempt(
+	ctx context.Context,
+	client *http.Client,
+	target string,
+	maxSize int64,
+	timeout time.Duration,
+) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+
+	resp, err := client.Do(req)
+	if errors.Is(err, ErrForbidden) {
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w: %w", ErrUnavailable, errRetryable, err)
+	}
+
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError:
+		return nil, fmt.Errorf("%w: %w: status %d", ErrUnavailable, errRetryable, resp.StatusCode)
+	default:
+		return nil, fmt.Errorf("%w: status %d", ErrUnavailable, resp.StatusCode)
+	}
+
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes exceed the limit of %d", ErrTooLarge, resp.ContentLength, maxSize)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w: %w", ErrUnavailable, errRetryable, err)
+	}
+
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: content exceeds the limit of %d bytes", ErrTooLarge, maxSize)
+	}
+
+	return data, nil
+}
+
+func (f *Fetcher) client(caBundle []byte) (*http.Client, error) {
+	transport := &http.Transport{}
+	if defaultTransport, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport = defaultTransport.Clone()
+	}
+
+	// a proxy would make the connections the allowlist checks go to the
+	// proxy instead of the host.
+	transport.Proxy = nil
+	// every Fetch gets its own transport, so connections are not kept for
+	// reuse.
+	transport.DisableKeepAlives = true
+	transport.DialContext = f.allowed.dialContext(&net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: dialKeepAlive,
+	})
+
+	if len(caBundle) > 0 {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("%w: CA bundle contains no certificates", ErrInvalidRequest)
+		}
+
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:    roots,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+func verifyDigest(data []byte, want []byte) ([]byte, error) {
+	if want == nil {
+		return data, nil
+	}
+
+	got := sha256.Sum256(data)
+	if string(got[:]) != string(want) {
+		return nil, fmt.Errorf("%w: got sha256:%x", ErrDigestMismatch, got)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,251 @@
+package fetch_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"csi-driver/internal/pkg/fetch"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+)
+
+// allowLoopback allows the test servers, which listen on the loopback
+// interface.
+func allowLoopback(t *testing.T) *fetch.Allowlist {
+	t.Helper()
+
+	allowed, err := fetch.ParseAllowlist([]string{"127.0.0.1/32"})
+	if err != nil {
+		t.Fatalf("failed to parse allowlist: %v", err)
+	}
+
+	return allowed
+}
+
+func TestFetcher_Fetch(t *testing.T) {
+	t.Parallel()
+
+	content := "reference data"
+	digest := sha256.Sum256([]byte(content))
+
+	var flaky atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/content", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(content))
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("x", 1024)))
+	})
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, _ *http.Request) {
+		if flaky.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		_, _ = w.Write([]byte(content))
+	})
+	mux.HandleFunc("/down", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		name    string
+		req     fetch.Request
+		want    string
+		wantErr error
+	}{
+		{
+			name: "success",
+			req:  fetch.Request{URL: server.URL + "/content"},
+			want: content,
+		},
+		{
+			name: "pinned digest",
+			req:  fetch.Request{URL: server.URL + "/content", SHA256: "sha256:" + hex.EncodeToString(digest[:])},
+			want: content,
+		},
+		{
+			name:    "digest mismatch",
+			req:     fetch.Request{URL: server.URL + "/content", SHA256: strings.Repeat("0", 64)},
+			wantErr: fetch.ErrDigestMismatch,
+		},
+		{
+			name:    "too large",
+			req:     fetch.Request{URL: server.URL + "/large", MaxSize: 512},
+			wantErr: fetch.ErrTooLarge,
+		},
+		{
+			name: "retried until available",
+			req:  fetch.Request{URL: server.URL + "/flaky"},
+			want: content,
+		},
+		{
+			name:    "retries exhausted",
+			req:     fetch.Request{URL: server.URL + "/down"},
+			wantErr: fetch.ErrUnavailable,
+		},
+		{
+			name:    "not found",
+			req:     fetch.Request{URL: server.URL + "/missing"},
+			wantErr: fetch.ErrUnavailable,
+		},
+		{
+			name:    "unsupported scheme",
+			req:     fetch.Request{URL: "file:///etc/passwd"},
+			wantErr: fetch.ErrInvalidRequest,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			fetcher := fetch.NewFetcher(zaptest.NewLogger(t), allowLoopback(t), 1<<20, time.Second, 3, time.Millisecond)
+
+			got, err := fetcher.Fetch(context.Background(), testCase.req)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("Fetch() error = %v, want %v", err, testCase.wantErr)
+			}
+
+			if string(got) != testCase.want {
+				t.Errorf("Fetch() = %q, want %q", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestFetcher_FetchCABundle(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("tls content"))
+	}))
+	defer server.Close()
+
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	fetcher := fetch.NewFetcher(zaptest.NewLogger(t), allowLoopback(t), 1<<20, time.Second, 0, time.Millisecond)
+
+	_, err := fetcher.Fetch(context.Background(), fetch.Request{URL: server.URL})
+	if !errors.Is(err, fetch.ErrUnavailable) {
+		t.Errorf("Fetch() without CA bundle error = %v, want %v", err, fetch.ErrUnavailable)
+	}
+
+	got, err := fetcher.Fetch(context.Background(), fetch.Request{URL: server.URL, CABundle: caBundle})
+	if err != nil {
+		t.Fatalf("Fetch() with CA bundle error = %v", err)
+	}
+
+	if string(got) != "tls content" {
+		t.Errorf("Fetch() = %q, want %q", got, "tls content")
+	}
+}
+
+func TestFetcher_FetchAllowlist(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/content", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("content"))
+	})
+	mux.HandleFunc("/metadata", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to split server address: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		allowed []string
+		url     string
+		wantErr error
+	}{
+		{
+			name:    "allowed network",
+			allowed: []string{"127.0.0.1/32"},
+			url:     server.URL + "/content",
+		},
+		{
+			name:    "nothing allowed",
+			url:     server.URL + "/content",
+			wantErr: fetch.ErrForbidden,
+		},
+		{
+			name:    "other host",
+			allowed: []string{"example.com", "*.example.com"},
+			url:     server.URL + "/content",
+			wantErr: fetch.ErrForbidden,
+		},
+		{
+			name:    "allowed name resolving to loopback",
+			allowed: []string{"localhost"},
+			url:     "http://localhost:" + port + "/content",
+			wantErr: fetch.ErrForbidden,
+		},
+		{
+			name:    "every network",
+			allowed: []string{"0.0.0.0/0", "::/0"},
+			url:     server.URL + "/content",
+			wantErr: fetch.ErrForbidden,
+		},
+		{
+			name:    "shared address",
+			allowed: []string{"0.0.0.0/0"},
+			url:     "http://100.100.100.200/latest/meta-data/",
+			wantErr: fetch.ErrForbidden,
+		},
+		{
+			name:    "unique local address",
+			allowed: []string{"::/0"},
+			url:     "http://[fd00:ec2::254]/latest/meta-data/",
+			wantErr: fetch.ErrForbidden,
+		},
+		{
+			name:    "redirect to link-local address",
+			allowed: []string{"127.0.0.1/32", "0.0.0.0/0"},
+			url:     server.URL + "/metadata",
+			wantErr: fetch.ErrForbidden,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			allowed, err := fetch.ParseAllowlist(testCase.allowed)
+			if err != nil {
+				t.Fatalf("failed to parse allowlist: %v", err)
+			}
+
+			fetcher := fetch.NewFetcher(zaptest.NewLogger(t), allowed, 1<<20, time.Second, 0, time.Millisecond)
+
+			_, err = fetcher.Fetch(context.Background(), fetch.Request{URL: testCase.url})
+			if !errors.Is(err, testCase.wantErr) {
+				t.Errorf("Fetch() error = %v, want %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
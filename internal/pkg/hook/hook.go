@@ -0,0 +1,390 @@
+// Package hook runs the lifecycle hooks the node administrator configured on
+// the driver before and after volumes are published and unpublished. Hooks are
+// executables, run like the commands of the command source, or HTTP endpoints
+// on the node itself. Both receive the Event as JSON, on stdin or as the body
+// of a POST request.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"csi-driver/internal/pkg/command"
+	"csi-driver/internal/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// PrePublish hooks run before a volume is published and can veto it.
+	PrePublish = "pre-publish"
+	// PostPublish hooks run once publishing succeeded or failed.
+	PostPublish = "post-publish"
+	// PreUnpublish hooks run before a volume is unpublished.
+	PreUnpublish = "pre-unpublish"
+	// PostUnpublish hooks run once unpublishing succeeded or failed.
+	PostUnpublish = "post-unpublish"
+
+	defaultTimeout = 10 * time.Second
+	maxMessage     = 1 << 10
+	// maxOutputSize caps what exec hooks may write to stdout, which is
+	// discarded.
+	maxOutputSize = 64 << 10
+
+	podInfoPrefix           = "csi.storage.k8s.io/"
+	serviceAccountTokensKey = podInfoPrefix + "serviceAccount.tokens"
+)
+
+var (
+	// ErrInvalidConfig is returned for unusable hook configurations.
+	ErrInvalidConfig = errors.New("invalid hook config")
+	// ErrVetoed is returned when a pre-publish hook rejects a volume.
+	ErrVetoed = errors.New("vetoed by hook")
+	// ErrFailed is returned when a pre-publish hook cannot give an answer.
+	ErrFailed = errors.New("hook failed")
+)
+
+// Spec configures a single hook. Exactly one of Command and URL is set.
+type Spec struct {
+	Name string `json:"name"`
+	// Phases lists the phases the hook runs in.
+	Phases []string `json:"phases"`
+	// Command runs an executable with the event on stdin. A non-zero exit
+	// status vetoes, with the message taken from stderr.
+	Command *command.Spec `json:"command,omitempty"`
+	// URL receives the event in a POST request and must point at a loopback
+	// address. 4xx responses veto, with the message taken from the body.
+	URL string `json:"url,omitempty"`
+	// Timeout bounds HTTP hooks and defaults to 10s. Exec hooks use the
+	// timeout of their command.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Config is the hook configuration file of the driver.
+type Config struct {
+	Hooks []Spec `json:"hooks"`
+}
+
+// PodInfo describes the pod a volume belongs to, as far as kubelet passes it
+// on.
+type PodInfo struct {
+	Name           string `json:"name,omitempty"`
+	Namespace      string `json:"namespace,omitempty"`
+	UID            string `json:"uid,omitempty"`
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+}
+
+// Event is what hooks receive.
+type Event struct {
+	Phase      string            `json:"phase"`
+	VolumeID   string            `json:"volumeId"`
+	TargetPath string            `json:"targetPath"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Pod        PodInfo           `json:"pod"`
+	// Error is set for post hooks of failed calls.
+	Error string `json:"error,omitempty"`
+}
+
+// NewEvent returns the event of phase for a volume. Service account tokens
+// are removed from attributes.
+func NewEvent(phase string, volumeID string, targetPath string, attributes map[string]string) *Event {
+	event := &Event{
+		Phase:      phase,
+		VolumeID:   volumeID,
+		TargetPath: targetPath,
+		Attributes: make(map[string]string, len(attributes)),
+		Pod: PodInfo{
+			Name:           attributes[podInfoPrefix+"pod.name"],
+			Namespace:      attributes[podInfoPrefix+"pod.namespace"],
+			UID:            attributes[podInfoPrefix+"pod.uid"],
+			ServiceAccount: attributes[podInfoPrefix+"serviceAccount.name"],
+		},
+	}
+
+	for key, value := range attributes {
+		if key != serviceAccountTokensKey {
+			event.Attributes[key] = value
+		}
+	}
+
+	return event
+}
+
+type hook struct {
+	Spec
+	phases  map[string]bool
+	url     *url.URL
+	timeout time.Duration
+}
+
+// Runner runs the configured hooks.
+type Runner struct {
+	audit    *zap.Logger
+	hooks    []*hook
+	commands *command.Runner
+	client   *http.Client
+}
+
+// Load reads the hook configuration at path. Exec hooks get a scratch
+// directory below workDir.
+func Load(audit *zap.Logger, path string, workDir string) (*Runner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hook config: %w", err)
+	}
+
+	var config Config
+
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	return New(audit, &config, workDir)
+}
+
+// New returns a Runner for config. Every hook outcome is logged to audit.
+func New(audit *zap.Logger, config *Config, workDir string) (*Runner, error) {
+	runner := &Runner{
+		audit: audit,
+		// hooks are local, proxies from the environment must not apply.
+		client: &http.Client{Transport: &http.Transport{Proxy: nil}},
+	}
+	names := make(map[string]bool, len(config.Hooks))
+	commands := make(map[string]command.Spec)
+
+	for _, spec := range config.Hooks {
+		if names[spec.Name] {
+			return nil, fmt.Errorf("%w: duplicate hook %q", ErrInvalidConfig, spec.Name)
+		}
+
+		names[spec.Name] = true
+
+		hook, err := newHook(spec)
+		if err != nil {
+			return nil, fmt.Errorf("hook %s: %w", spec.Name, err)
+		}
+
+		if hook.Command != nil {
+			commands[hook.Name] = *hook.Command
+		}
+
+		runner.hooks = append(runner.hooks, hook)
+	}
+
+	if len(commands) > 0 {
+		var err error
+
+		runner.commands, err = command.New(audit, &command.Config{Commands: commands}, workDir)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	return runner, nil
+}
+
+func newHook(spec Spec) (*hook, error) {
+	if spec.Name == "" {
+		return nil, fmt.Errorf("%w: hooks need a name", ErrInvalidConfig)
+	}
+
+	if (spec.Command == nil) == (spec.URL == "") {
+		return nil, fmt.Errorf("%w: set either command or url", ErrInvalidConfig)
+	}
+
+	hook := &hook{Spec: spec, phases: make(map[string]bool), timeout: defaultTimeout}
+
+	for _, phase := range spec.Phases {
+		switch phase {
+		case PrePublish, PostPublish, PreUnpublish, PostUnpublish:
+			hook.phases[phase] = true
+		default:
+			return nil, fmt.Errorf("%w: unknown phase %q", ErrInvalidConfig, phase)
+		}
+	}
+
+	if spec.Command != nil && spec.Command.Output != "" && spec.Command.Output != command.OutputStdout {
+		return nil, fmt.Errorf("%w: hooks have no output dir", ErrInvalidConfig)
+	}
+
+	if spec.Command != nil && spec.Command.MaxOutputSize == 0 {
+		limited := *spec.Command
+		limited.MaxOutputSize = maxOutputSize
+		hook.Command = &limited
+	}
+
+	if spec.URL != "" {
+		var err error
+
+		hook.url, err = localURL(spec.URL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if spec.Timeout != "" {
+		timeout, err := time.ParseDuration(spec.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("%w: invalid timeout %q", ErrInvalidConfig, spec.Timeout)
+		}
+
+		hook.timeout = timeout
+	}
+
+	return hook, nil
+}
+
+// localURL parses an http URL and checks that it points at the node itself,
+// as events carry the attributes of volumes.
+func localURL(value string) (*url.URL, error) {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Scheme != "http" {
+		return nil, fmt.Errorf("%w: url %q must be an http URL", ErrInvalidConfig, value)
+	}
+
+	host := parsed.Hostname()
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("%w: url %q must point at a loopback address", ErrInvalidConfig, value)
+	}
+
+	return parsed, nil
+}
+
+// Run runs the hooks of the phase of event in order. Pre-publish hooks stop
+// at the first veto or failure, which Run returns wrapping ErrVetoed or
+// ErrFailed. The other phases cannot stop anything, so all their hooks run
+// and failures are only logged.
+func (r *Runner) Run(ctx context.Context, event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal hook event: %w", err)
+	}
+
+	for _, hook := range r.hooks {
+		if !hook.phases[event.Phase] {
+			continue
+		}
+
+		start := time.Now()
+		err := r.run(ctx, hook, event.VolumeID, body)
+
+		r.record(hook, event, err, time.Since(start))
+
+		if err != nil && event.Phase == PrePublish {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Runner) run(ctx context.Context, hook *hook, volumeID string, body []byte) error {
+	if hook.url != nil {
+		return r.post(ctx, hook, body)
+	}
+
+	_, err := r.commands.Run(ctx, hook.Name, &command.Request{VolumeID: volumeID, Stdin: body})
+
+	var exitErr *command.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("%w %s: %s", ErrVetoed, hook.Name, message([]byte(exitErr.Stderr)))
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrFailed, err)
+	}
+
+	return nil
+}
+
+func (r *Runner) post(ctx context.Context, hook *hook, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, hook.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.url.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w %s: %w", ErrFailed, hook.Name, err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w %s: %w", ErrFailed, hook.Name, err)
+	}
+	defer resp.Body.Close()
+
+	answer, _ := io.ReadAll(io.LimitReader(resp.Body, maxMessage))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return fmt.Errorf("%w %s: %s", ErrVetoed, hook.Name, message(answer))
+	}
+
+	return fmt.Errorf("%w %s: status %d", ErrFailed, hook.Name, resp.StatusCode)
+}
+
+// record adds the outcome of a hook to the audit trail.
+func (r *Runner) record(hook *hook, event *Event, err error, duration time.Duration) {
+	result := metrics.ResultSucceeded
+
+	switch {
+	case errors.Is(err, ErrVetoed):
+		result = metrics.ResultVetoed
+	case err != nil:
+		result = metrics.ResultFailed
+	}
+
+	metrics.Hooks.Add(result, 1)
+
+	fields := []zap.Field{
+		zap.String("hook", hook.Name),
+		zap.String("phase", event.Phase),
+		zap.String("volume_id", event.VolumeID),
+		zap.String("target_path", event.TargetPath),
+		zap.String("pod_namespace", event.Pod.Namespace),
+		zap.String("pod_name", event.Pod.Name),
+		zap.String("result", result),
+		zap.Duration("duration", duration),
+	}
+
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+
+	r.audit.Info("hook finished", fields...)
+}
+
+// message trims what a hook said to a single short line of valid UTF-8, as
+// it ends up in gRPC status messages.
+func message(data []byte) string {
+	text := strings.Join(strings.Fields(strings.ToValidUTF8(string(data), "\uFFFD")), " ")
+	if len(text) > maxMessage {
+		cut := maxMessage
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+
+		text = text[:cut]
+	}
+
+	if text == "" {
+		return "no reason given"
+	}
+
+	return text
+}
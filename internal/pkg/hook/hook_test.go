@@ -0,0 +1,190 @@
+package hook_test
+
+import (
+	"context"
+	"csi-driver/internal/pkg/command"
+	"csi-driver/internal/pkg/hook"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"go.uber.org/zap/zaptest"
+)
+
+// newScript writes an executable shell script the unprivileged command user
+// can reach, as the tests may run as root.
+func newScript(t *testing.T, body string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hook.sh")
+
+	err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755)
+	for _, allowed := range []string{dir, filepath.Dir(dir)} {
+		if err == nil {
+			err = os.Chmod(allowed, 0o755)
+		}
+	}
+
+	if err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	return path
+}
+
+func TestNewEvent(t *testing.T) {
+	t.Parallel()
+
+	event := hook.NewEvent(hook.PrePublish, "vol", "/target", map[string]string{
+		"csi.storage.k8s.io/pod.name":              "app-0",
+		"csi.storage.k8s.io/pod.namespace":         "team-a",
+		"csi.storage.k8s.io/serviceAccount.name":   "app",
+		"csi.storage.k8s.io/serviceAccount.tokens": `{"":{"token":"secret"}}`,
+	})
+
+	if _, ok := event.Attributes["csi.storage.k8s.io/serviceAccount.tokens"]; ok {
+		t.Error("NewEvent() kept the service account tokens")
+	}
+
+	want := hook.PodInfo{Name: "app-0", Namespace: "team-a", ServiceAccount: "app"}
+	if event.Pod != want {
+		t.Errorf("NewEvent() pod = %+v, want %+v", event.Pod, want)
+	}
+}
+
+func TestRunner_Run(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu       sync.Mutex
+		received []hook.Event
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event hook.Event
+
+		_ = json.NewDecoder(r.Body).Decode(&event)
+
+		mu.Lock()
+		received = append(received, event)
+		mu.Unlock()
+
+		switch event.Attributes["decision"] {
+		case "deny":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("namespace team-b\nmay not mount this"))
+		case "deny-long":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("x" + strings.Repeat("é", 1000)))
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	runner, err := hook.New(zaptest.NewLogger(t), &hook.Config{Hooks: []hook.Spec{
+		{
+			Name:   "exec",
+			Phases: []string{hook.PrePublish, hook.PostUnpublish},
+			Command: &command.Spec{Path: newScript(t, `event=$(cat)
+case "$event" in *'"volumeId":"vol"'*) ;; *) exit 2 ;; esac
+case "$event" in *deny-exec*) echo "denied by exec" >&2; exit 1 ;; esac`,
+			)},
+		},
+		{
+			Name:   "http",
+			Phases: []string{hook.PrePublish, hook.PostPublish, hook.PreUnpublish},
+			URL:    server.URL,
+		},
+	}}, t.TempDir())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		phase       string
+		decision    string
+		wantErr     error
+		wantMessage string
+	}{
+		{name: "allowed", phase: hook.PrePublish, decision: "allow"},
+		{name: "vetoed by exec", phase: hook.PrePublish, decision: "deny-exec", wantErr: hook.ErrVetoed, wantMessage: "denied by exec"},
+		{
+			name: "vetoed by http", phase: hook.PrePublish, decision: "deny",
+			wantErr: hook.ErrVetoed, wantMessage: "namespace team-b may not mount this",
+		},
+		{name: "vetoed at length", phase: hook.PrePublish, decision: "deny-long", wantErr: hook.ErrVetoed, wantMessage: "xé"},
+		{name: "hook failure", phase: hook.PrePublish, decision: "broken", wantErr: hook.ErrFailed},
+		{name: "post hooks cannot veto", phase: hook.PostPublish, decision: "deny"},
+		{name: "unpublish hooks cannot veto", phase: hook.PreUnpublish, decision: "broken"},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := runner.Run(context.Background(), hook.NewEvent(testCase.phase, "vol", "/target", map[string]string{
+				"decision": testCase.decision,
+			}))
+
+			if !errors.Is(err, testCase.wantErr) || err == nil && testCase.wantErr != nil {
+				t.Fatalf("Run() error = %v, want %v", err, testCase.wantErr)
+			}
+
+			if testCase.wantMessage != "" && !strings.Contains(err.Error(), testCase.wantMessage) {
+				t.Errorf("Run() error = %v, want it to say %q", err, testCase.wantMessage)
+			}
+
+			if err != nil && !utf8.ValidString(err.Error()) {
+				t.Errorf("Run() error = %q, want valid UTF-8", err)
+			}
+		})
+	}
+
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, event := range received {
+			if event.VolumeID != "vol" || event.TargetPath != "/target" {
+				t.Errorf("http hook received %+v", event)
+			}
+		}
+	})
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	script := newScript(t, "true")
+
+	tests := map[string][]hook.Spec{
+		"no name":        {{URL: "http://127.0.0.1/hook"}},
+		"no target":      {{Name: "a"}},
+		"both targets":   {{Name: "a", URL: "http://127.0.0.1/hook", Command: &command.Spec{Path: script}}},
+		"remote url":     {{Name: "a", URL: "http://hooks.example.com/hook"}},
+		"https":          {{Name: "a", URL: "https://127.0.0.1/hook"}},
+		"unknown phase":  {{Name: "a", URL: "http://[::1]/hook", Phases: []string{"pre-stage"}}},
+		"output dir":     {{Name: "a", Command: &command.Spec{Path: script, Output: command.OutputDir}}},
+		"duplicate name": {{Name: "a", URL: "http://localhost/a"}, {Name: "a", URL: "http://localhost/b"}},
+		"bad command":    {{Name: "a", Command: &command.Spec{Path: "hook.sh"}}},
+	}
+
+	for name, hooks := range tests {
+		_, err := hook.New(zaptest.NewLogger(t), &hook.Config{Hooks: hooks}, t.TempDir())
+		if !errors.Is(err, hook.ErrInvalidConfig) {
+			t.Errorf("New() with %s error = %v, want %v", name, err, hook.ErrInvalidConfig)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Package integrity periodically verifies that the content of volumes is
+// still what the driver wrote, so changes made on the node behind the
+// driver's back are noticed.
+package integrity
+
+import (
+	"context"
+	"time"
+
+	"csi-driver/internal/pkg/metrics"
+	"csi-driver/internal/pkg/storage"
+
+	"go.uber.org/zap"
+)
+
+// Target is a storage backend whose volumes can be verified.
+type Target interface {
+	// VolumeIDs returns the IDs of the volumes on the node.
+	VolumeIDs() ([]string, error)
+	// VerifyVolume compares the content of a volume with what was written
+	// and repairs it if the backend is configured to.
+	VerifyVolume(ctx context.Context, id string) (*storage.Drift, error)
+}
+
+// Run verifies every volume of target every interval until ctx is done.
+func Run(ctx context.Context, logger *zap.Logger, target Target, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		verifyAll(ctx, logger, target)
+	}
+}
+
+func verifyAll(ctx context.Context, logger *zap.Logger, target Target) {
+	ids, err := target.VolumeIDs()
+	if err != nil {
+		logger.Error("failed to list volumes to verify", zap.Error(err))
+
+		return
+	}
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+
+		drift, err := target.VerifyVolume(ctx, id)
+
+		switch {
+		case err != nil && drift == nil:
+			metrics.IntegrityChecks.Add(metrics.ResultFailed, 1)
+			logger.Error("failed to verify volume", zap.String("volume_id", id), zap.Error(err))
+		case drift.Repaired:
+			metrics.IntegrityChecks.Add(metrics.ResultRepaired, 1)
+			logger.Warn("volume content drifted and was repaired",
+				zap.String("volume_id", id),
+				zap.Stringer("drift", drift),
+			)
+		case drift.Detected():
+			metrics.IntegrityChecks.Add(metrics.ResultDrifted, 1)
+			logger.Warn("volume content drifted",
+				zap.String("volume_id", id),
+				zap.Stringer("drift", drift),
+				zap.Error(err),
+			)
+		default:
+			metrics.IntegrityChecks.Add(metrics.ResultSucceeded, 1)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package integrity_test
+
+import (
+	"context"
+	"csi-driver/internal/pkg/integrity"
+	"csi-driver/internal/pkg/storage"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+)
+
+var errVerify = errors.New("verify failed")
+
+type fakeTarget struct {
+	mu       sync.Mutex
+	verified []string
+	done     chan struct{}
+}
+
+func (ft *fakeTarget) VolumeIDs() ([]string, error) {
+	return []string{"failing", "drifted", "vol"}, nil
+}
+
+func (ft *fakeTarget) VerifyVolume(_ context.Context, id string) (*storage.Drift, error) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	ft.verified = append(ft.verified, id)
+	if len(ft.verified) == 3 {
+		close(ft.done)
+	}
+
+	switch id {
+	case "failing":
+		return nil, errVerify
+	case "drifted":
+		return &storage.Drift{Modified: []string{"file"}}, nil
+	default:
+		return &storage.Drift{}, nil
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	target := &fakeTarget{done: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		integrity.Run(ctx, zaptest.NewLogger(t), target, 10*time.Millisecond)
+	}()
+
+	select {
+	case <-target.done:
+	case <-time.After(time.Second):
+		t.Fatal("volumes were not verified")
+	}
+
+	cancel()
+	<-stopped
+
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	// failing and drifted volumes do not hold up the others.
+	if len(target.verified) < 3 || target.verified[2] != "vol" {
+		t.Errorf("verified %v, want [failing drifted vol]", target.verified)
+	}
+}
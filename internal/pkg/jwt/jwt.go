@@ -0,0 +1,296 @@
+// Package jwt contains a minimal JSON Web Token issuer for pod identities.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	algEdDSA = "EdDSA"
+	algES256 = "ES256"
+
+	p256CoordinateSize = 32
+	tokenSegments      = 3
+)
+
+var (
+	errKeyType   = errors.New("unsupported signing key type")
+	errMalformed = errors.New("malformed token")
+	errSignature = errors.New("invalid token signature")
+	errExpired   = errors.New("token expired")
+)
+
+// Claims are the claims of an identity token.
+type Claims struct {
+	Issuer         string   `json:"iss"`
+	Subject        string   `json:"sub"`
+	Audience       []string `json:"aud,omitempty"`
+	IssuedAt       int64    `json:"iat"`
+	NotBefore      int64    `json:"nbf"`
+	Expiry         int64    `json:"exp"`
+	Namespace      string   `json:"namespace,omitempty"`
+	ServiceAccount string   `json:"service_account,omitempty"`
+	PodName        string   `json:"pod_name,omitempty"`
+	PodUID         string   `json:"pod_uid,omitempty"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// JWK is the public part of a signing key as a JSON Web Key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y,omitempty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// Issuer signs identity tokens with a key held by the driver.
+type Issuer struct {
+	name      string
+	key       crypto.Signer
+	algorithm string
+	jwk       JWK
+	jwks      []byte
+}
+
+// NewIssuer returns an Issuer that signs with key, which must be an ed25519
+// or ECDSA P-256 private key. name is used as the iss claim.
+func NewIssuer(name string, key crypto.Signer) (*Issuer, error) {
+	issuer := &Issuer{
+		name: name,
+		key:  key,
+	}
+
+	switch public := key.Public().(type) {
+	case ed25519.PublicKey:
+		issuer.algorithm = algEdDSA
+		issuer.jwk = JWK{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       encode(public),
+		}
+	case *ecdsa.PublicKey:
+		if public.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%w: only P-256 ECDSA keys are supported", errKeyType)
+		}
+
+		ecdhKey, err := public.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert ECDSA key: %w", err)
+		}
+
+		// uncompressed point: 0x04 || X || Y.
+		point := ecdhKey.Bytes()
+		issuer.algorithm = algES256
+		issuer.jwk = JWK{
+			KeyType: "EC",
+			Curve:   "P-256",
+			X:       encode(point[1 : 1+p256CoordinateSize]),
+			Y:       encode(point[1+p256CoordinateSize:]),
+		}
+	default:
+		return nil, fmt.Errorf("%w: %T", errKeyType, public)
+	}
+
+	issuer.jwk.Use = "sig"
+	issuer.jwk.Algorithm = issuer.algorithm
+	issuer.jwk.KeyID = thumbprint(issuer.jwk)
+
+	jwks, err := json.Marshal(map[string][]JWK{"keys": {issuer.jwk}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JWKS: %w", err)
+	}
+
+	issuer.jwks = jwks
+
+	return issuer, nil
+}
+
+// GenerateIssuer returns an Issuer with a fresh ed25519 key.
+func GenerateIssuer(name string) (*Issuer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	return NewIssuer(name, key)
+}
+
+// JWKS returns the JSON Web Key Set that verifies tokens of the Issuer.
+func (i *Issuer) JWKS() []byte {
+	return i.jwks
+}
+
+// ServeHTTP serves the JWKS.
+func (i *Issuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	_, _ = w.Write(i.jwks)
+}
+
+// Issue signs claims valid from now for duration. Issuer and time claims are
+// set by the Issuer.
+func (i *Issuer) Issue(claims Claims, duration time.Duration) ([]byte, error) {
+	now := time.Now()
+	claims.Issuer = i.name
+	claims.IssuedAt = now.Unix()
+	claims.NotBefore = now.Unix()
+	claims.Expiry = now.Add(duration).Unix()
+
+	headerJSON, err := json.Marshal(header{Algorithm: i.algorithm, Type: "JWT", KeyID: i.jwk.KeyID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal header: %w", err)
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal claims: %w", err)
+	}
+
+	signingInput := encode(headerJSON) + "." + encode(claimsJSON)
+
+	signature, err := i.sign([]byte(signingInput))
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(signingInput + "." + encode(signature)), nil
+}
+
+// Verify checks that token was signed by the Issuer and has not expired and
+// returns its claims.
+func (i *Issuer) Verify(token []byte) (*Claims, error) {
+	segments := strings.Split(string(token), ".")
+	if len(segments) != tokenSegments {
+		return nil, errMalformed
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(segments[0])
+	if err != nil {
+		return nil, errMalformed
+	}
+
+	var tokenHeader header
+
+	err = json.Unmarshal(headerJSON, &tokenHeader)
+	if err != nil || tokenHeader.KeyID != i.jwk.KeyID || tokenHeader.Algorithm != i.algorithm {
+		return nil, errSignature
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return nil, errMalformed
+	}
+
+	if !i.verify([]byte(segments[0]+"."+segments[1]), signature) {
+		return nil, errSignature
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(segments[1])
+	if err != nil {
+		return nil, errMalformed
+	}
+
+	var claims Claims
+
+	err = json.Unmarshal(claimsJSON, &claims)
+	if err != nil {
+		return nil, errMalformed
+	}
+
+	if time.Now().Unix() >= claims.Expiry {
+		return nil, errExpired
+	}
+
+	return &claims, nil
+}
+
+func (i *Issuer) sign(input []byte) ([]byte, error) {
+	if i.algorithm == algEdDSA {
+		signature, err := i.key.Sign(rand.Reader, input, crypto.Hash(0))
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign token: %w", err)
+		}
+
+		return signature, nil
+	}
+
+	digest := sha256.Sum256(input)
+
+	//nolint:forcetypeassert // checked in NewIssuer.
+	r, s, err := ecdsa.Sign(rand.Reader, i.key.(*ecdsa.PrivateKey), digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	// JWS uses the fixed size r || s encoding instead of ASN.1.
+	signature := make([]byte, 2*p256CoordinateSize)
+	r.FillBytes(signature[:p256CoordinateSize])
+	s.FillBytes(signature[p256CoordinateSize:])
+
+	return signature, nil
+}
+
+func (i *Issuer) verify(input []byte, signature []byte) bool {
+	switch public := i.key.Public().(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(public, input, signature)
+	case *ecdsa.PublicKey:
+		if len(signature) != 2*p256CoordinateSize {
+			return false
+		}
+
+		digest := sha256.Sum256(input)
+		r := new(big.Int).SetBytes(signature[:p256CoordinateSize])
+		s := new(big.Int).SetBytes(signature[p256CoordinateSize:])
+
+		return ecdsa.Verify(public, digest[:], r, s)
+	default:
+		return false
+	}
+}
+
+// thumbprint computes the RFC 7638 thumbprint of jwk, which is used as its
+// key ID.
+func thumbprint(jwk JWK) string {
+	var canonical string
+
+	if jwk.KeyType == "OKP" {
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, jwk.Curve, jwk.KeyType, jwk.X)
+	} else {
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, jwk.Curve, jwk.KeyType, jwk.X, jwk.Y)
+	}
+
+	digest := sha256.Sum256([]byte(canonical))
+
+	return encode(digest[:])
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,162 @@
+package jwt_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"csi-driver/internal/pkg/jwt"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIssuer_IssueVerify(t *testing.T) {
+	t.Parallel()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %v", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		key     crypto.Signer
+		wantAlg string
+	}{
+		{
+			name:    "ed25519",
+			key:     edKey,
+			wantAlg: "EdDSA",
+		},
+		{
+			name:    "ecdsa p-256",
+			key:     ecKey,
+			wantAlg: "ES256",
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			issuer, err := jwt.NewIssuer("test-issuer", testCase.key)
+			if err != nil {
+				t.Fatalf("unexpected error creating issuer: %v", err)
+			}
+
+			token, err := issuer.Issue(jwt.Claims{
+				Subject:  "system:serviceaccount:default:app",
+				Audience: []string{"vault"},
+			}, time.Hour)
+			if err != nil {
+				t.Fatalf("unexpected error issuing token: %v", err)
+			}
+
+			claims, err := issuer.Verify(token)
+			if err != nil {
+				t.Fatalf("unexpected error verifying token: %v", err)
+			}
+
+			if claims.Issuer != "test-issuer" || claims.Subject != "system:serviceaccount:default:app" {
+				t.Errorf("unexpected claims: %+v", claims)
+			}
+
+			var jwks struct {
+				Keys []jwt.JWK `json:"keys"`
+			}
+
+			err = json.Unmarshal(issuer.JWKS(), &jwks)
+			if err != nil {
+				t.Fatalf("failed to decode JWKS: %v", err)
+			}
+
+			if len(jwks.Keys) != 1 || jwks.Keys[0].Algorithm != testCase.wantAlg || jwks.Keys[0].KeyID == "" {
+				t.Errorf("unexpected JWKS: %s", issuer.JWKS())
+			}
+
+			// flipping a claim invalidates the signature.
+			segments := strings.Split(string(token), ".")
+			segments[1] = strings.ToUpper(segments[1])
+
+			if _, err := issuer.Verify([]byte(strings.Join(segments, "."))); err == nil {
+				t.Error("unexpected nil error verifying tampered token")
+			}
+		})
+	}
+}
+
+func TestIssuer_VerifyOtherKey(t *testing.T) {
+	t.Parallel()
+
+	issuer, err := jwt.GenerateIssuer("test-issuer")
+	if err != nil {
+		t.Fatalf("unexpected error creating issuer: %v", err)
+	}
+
+	other, err := jwt.GenerateIssuer("test-issuer")
+	if err != nil {
+		t.Fatalf("unexpected error creating issuer: %v", err)
+	}
+
+	token, err := other.Issue(jwt.Claims{Subject: "test"}, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error issuing token: %v", err)
+	}
+
+	if _, err := issuer.Verify(token); err == nil {
+		t.Error("unexpected nil error verifying token of another issuer")
+	}
+}
+
+func TestIssuer_VerifyExpired(t *testing.T) {
+	t.Parallel()
+
+	issuer, err := jwt.GenerateIssuer("test-issuer")
+	if err != nil {
+		t.Fatalf("unexpected error creating issuer: %v", err)
+	}
+
+	token, err := issuer.Issue(jwt.Claims{Subject: "test"}, -time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error issuing token: %v", err)
+	}
+
+	if _, err := issuer.Verify(token); err == nil {
+		t.Error("unexpected nil error verifying expired token")
+	}
+}
+
+func TestIssuer_ServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	issuer, err := jwt.GenerateIssuer("test-issuer")
+	if err != nil {
+		t.Fatalf("unexpected error creating issuer: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	issuer.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	if recorder.Code != http.StatusOK || recorder.Body.String() != string(issuer.JWKS()) {
+		t.Errorf("unexpected response: %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	issuer.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/.well-known/jwks.json", nil))
+
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status for POST: %d", recorder.Code)
+	}
+}
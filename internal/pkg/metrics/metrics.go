@@ -0,0 +1,60 @@
+// Package metrics contains the metrics exported by the driver. They are
+// published with expvar and served as JSON on /debug/vars.
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+)
+
+const (
+	// ResultSucceeded counts work that finished successfully.
+	ResultSucceeded = "succeeded"
+	// ResultFailed counts work that finished with an error.
+	ResultFailed = "failed"
+	// ResultCanceled counts work that was canceled before it finished.
+	ResultCanceled = "canceled"
+	// ResultHit counts lookups that were served from the cache.
+	ResultHit = "hit"
+	// ResultMiss counts lookups that had to produce the content.
+	ResultMiss = "miss"
+	// ResultVetoed counts hooks that rejected a volume.
+	ResultVetoed = "vetoed"
+	// ResultDrifted counts volumes whose content no longer matched what was
+	// written.
+	ResultDrifted = "drifted"
+	// ResultRepaired counts volumes whose drifted content was restored.
+	ResultRepaired = "repaired"
+)
+
+var (
+	// PreparationsInProgress is the number of volumes whose content is being
+	// prepared.
+	PreparationsInProgress = expvar.NewInt("preparations_in_progress")
+	// Preparations counts finished content preparations by result.
+	Preparations = expvar.NewMap("preparations_total")
+	// PreparationSeconds is the total time spent preparing content.
+	PreparationSeconds = expvar.NewFloat("preparation_seconds_total")
+	// CacheLookups counts node content cache lookups by result.
+	CacheLookups = expvar.NewMap("cache_lookups_total")
+	// BundleRefreshes counts bundle updates pushed into volumes by result.
+	BundleRefreshes = expvar.NewMap("bundle_refreshes_total")
+	// Refreshes counts scheduled volume content refreshes by result.
+	Refreshes = expvar.NewMap("refreshes_total")
+	// Expirations counts volumes whose content was wiped at its deadline.
+	Expirations = expvar.NewInt("expirations_total")
+	// Hooks counts lifecycle hook runs by result.
+	Hooks = expvar.NewMap("hooks_total")
+	// IntegrityChecks counts volume content verifications by result.
+	IntegrityChecks = expvar.NewMap("integrity_checks_total")
+	// RetainedReused counts republished volumes that reused retained data.
+	RetainedReused = expvar.NewInt("retained_reused_total")
+	// RetainedSwept counts retained volume data removed once its retention
+	// period ended.
+	RetainedSwept = expvar.NewInt("retained_swept_total")
+)
+
+// Handler serves all metrics.
+func Handler() http.Handler {
+	return expvar.Handler()
+}
@@ -0,0 +1,29 @@
+package metrics_test
+
+import (
+	"csi-driver/internal/pkg/metrics"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+
+	var vars map[string]json.RawMessage
+
+	err := json.Unmarshal(recorder.Body.Bytes(), &vars)
+	if err != nil {
+		t.Fatalf("failed to decode metrics: %v", err)
+	}
+
+	for _, name := range []string{"preparations_in_progress", "preparations_total", "preparation_seconds_total"} {
+		if _, ok := vars[name]; !ok {
+			t.Errorf("missing metric %s", name)
+		}
+	}
+}
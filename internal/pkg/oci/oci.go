@@ -0,0 +1,529 @@
+// Package oci unpacks images stored on the node as OCI image layouts, either
+// as a directory or as an oci-archive tarball.
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	mediaTypeImageIndex         = "application/vnd.oci.image.index.v1+json"
+	mediaTypeImageManifest      = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// layerMediaTypePrefix and dockerLayerMediaTypePrefix prefix the media
+	// types of tar layers, with an optional +gzip or +zstd suffix.
+	layerMediaTypePrefix       = "application/vnd.oci.image.layer."
+	dockerLayerMediaTypePrefix = "application/vnd.docker.image.rootfs."
+
+	// AnnotationRefName names a manifest in the index of a layout.
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+	// AnnotationTitle names the file a non-tar artifact layer is written to.
+	AnnotationTitle = "org.opencontainers.image.title"
+
+	indexFile  = "index.json"
+	layoutFile = "oci-layout"
+
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+
+	digestAlgorithm  = "sha256"
+	maxManifestSize  = 4 << 20
+	maxIndexDepth    = 2
+	maxSymlinkHops   = 40
+	dirPerms         = 0o755
+	defaultFilePerms = 0o644
+)
+
+var (
+	// ErrNotFound is returned when the layout or the reference does not exist.
+	ErrNotFound = errors.New("not found in image layout")
+	// ErrInvalidLayout is returned for malformed layouts, indexes and manifests.
+	ErrInvalidLayout = errors.New("invalid image layout")
+	// ErrUnsupported is returned for content this package cannot unpack, e.g.
+	// zstd compressed layers.
+	ErrUnsupported = errors.New("unsupported image content")
+	// ErrDigestMismatch is returned when a blob does not match its digest.
+	ErrDigestMismatch = errors.New("blob digest mismatch")
+	// ErrTooLarge is returned when the unpacked image exceeds the size limit.
+	ErrTooLarge = errors.New("image too large")
+)
+
+// Descriptor references a blob in the layout.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+// Platform is the platform an image in an index is built for.
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+type index struct {
+	Manifests []Descriptor `json:"manifests"`
+}
+
+type manifest struct {
+	Layers []Descriptor `json:"layers"`
+}
+
+type imageLayout struct {
+	Version string `json:"imageLayoutVersion"`
+}
+
+// Image is a resolved image manifest.
+type Image struct {
+	// Digest is the digest of the manifest and identifies the unpacked
+	// content.
+	Digest string
+	// Manifest is the raw manifest, e.g. for verifying signatures over it.
+	Manifest []byte
+
+	layers []Descriptor
+}
+
+// blobSource opens files of a layout by their slash separated name.
+type blobSource interface {
+	open(name string) (io.ReadCloser, error)
+}
+
+// Layout is an OCI image layout.
+type Layout struct {
+	source blobSource
+}
+
+// Open opens the layout at path, which is either a directory or an
+// oci-archive tarball, optionally gzip compressed.
+func Open(path string) (*Layout, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: layout %q", ErrNotFound, path)
+		}
+
+		return nil, fmt.Errorf("failed to open layout: %w", err)
+	}
+
+	layout := &Layout{source: dirSource(path)}
+	if !info.IsDir() {
+		layout.source = archiveSource(path)
+	}
+
+	data, err := layout.readFile(layoutFile, maxManifestSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var version imageLayout
+
+	err = json.Unmarshal(data, &version)
+	if err != nil || version.Version == "" {
+		return nil, fmt.Errorf("%w: malformed %s", ErrInvalidLayout, layoutFile)
+	}
+
+	return layout, nil
+}
+
+// Resolve finds the image manifest for reference, which is either a name from
+// the org.opencontainers.image.ref.name annotation or a digest. An empty
+// reference selects the only manifest of the layout. Image indexes resolve to
+// the manifest for the platform of the node.
+func (l *Layout) Resolve(reference string) (*Image, error) {
+	data, err := l.readFile(indexFile, maxManifestSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var root index
+
+	err = json.Unmarshal(data, &root)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed %s: %w", ErrInvalidLayout, indexFile, err)
+	}
+
+	desc, err := findManifest(root.Manifests, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.image(desc, 0)
+}
+
+func findManifest(manifests []Descriptor, reference string) (Descriptor, error) {
+	if reference == "" {
+		if len(manifests) != 1 {
+			return Descriptor{}, fmt.Errorf("%w: layout has %d manifests, a reference is required",
+				ErrInvalidLayout, len(manifests))
+		}
+
+		return manifests[0], nil
+	}
+
+	for _, desc := range manifests {
+		if desc.Digest == reference || desc.Annotations[AnnotationRefName] == reference {
+			return desc, nil
+		}
+	}
+
+	return Descriptor{}, fmt.Errorf("%w: reference %q", ErrNotFound, reference)
+}
+
+func (l *Layout) image(desc Descriptor, depth int) (*Image, error) {
+	switch desc.MediaType {
+	case mediaTypeImageIndex, mediaTypeDockerManifestList:
+		if depth >= maxIndexDepth {
+			return nil, fmt.Errorf("%w: image indexes nested too deep", ErrInvalidLayout)
+		}
+
+		data, err := l.readBlob(desc, maxManifestSize)
+		if err != nil {
+			return nil, err
+		}
+
+		var nested index
+
+		err = json.Unmarshal(data, &nested)
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed image index: %w", ErrInvalidLayout, err)
+		}
+
+		platformDesc, err := findPlatform(nested.Manifests)
+		if err != nil {
+			return nil, err
+		}
+
+		return l.image(platformDesc, depth+1)
+	case mediaTypeImageManifest, mediaTypeDockerManifest:
+		data, err := l.readBlob(desc, maxManifestSize)
+		if err != nil {
+			return nil, err
+		}
+
+		var parsed manifest
+
+		err = json.Unmarshal(data, &parsed)
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed manifest: %w", ErrInvalidLayout, err)
+		}
+
+		return &Image{Digest: desc.Digest, Manifest: data, layers: parsed.Layers}, nil
+	default:
+		return nil, fmt.Errorf("%w: media type %q", ErrUnsupported, desc.MediaType)
+	}
+}
+
+// findPlatform picks the manifest for the platform of the node, or the only
+// manifest of an index that does not declare platforms.
+func findPlatform(manifests []Descriptor) (Descriptor, error) {
+	for _, desc := range manifests {
+		if desc.Platform != nil && desc.Platform.OS == runtime.GOOS && desc.Platform.Architecture == runtime.GOARCH {
+			return desc, nil
+		}
+	}
+
+	if len(manifests) == 1 && manifests[0].Platform == nil {
+		return manifests[0], nil
+	}
+
+	return Descriptor{}, fmt.Errorf("%w: no manifest for %s/%s", ErrNotFound, runtime.GOOS, runtime.GOARCH)
+}
+
+// Unpack applies the layers of img to the empty directory dest, verifying the
+// digest of every layer. At most maxSize bytes are written. Only directories
+// and regular files are created: symlinks to files are replaced by a copy of
+// their target and other entries are skipped.
+func (l *Layout) Unpack(ctx context.Context, img *Image, dest string, maxSize int64) error {
+	unpack := &unpacker{
+		dest:      dest,
+		remaining: maxSize,
+		links:     make(map[string]string),
+	}
+
+	for _, layer := range img.layers {
+		err := ctx.Err()
+		if err != nil {
+			return fmt.Errorf("unpacking canceled: %w", err)
+		}
+
+		err = l.applyLayer(unpack, layer)
+		if err != nil {
+			return err
+		}
+	}
+
+	return unpack.resolveLinks()
+}
+
+func (l *Layout) applyLayer(unpack *unpacker, layer Descriptor) error {
+	blob, err := l.openBlob(layer)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	switch {
+	case strings.HasSuffix(layer.MediaType, "+zstd") || strings.HasSuffix(layer.MediaType, ".zstd"):
+		return fmt.Errorf("%w: layer media type %q", ErrUnsupported, layer.MediaType)
+	case strings.HasPrefix(layer.MediaType, layerMediaTypePrefix),
+		strings.HasPrefix(layer.MediaType, dockerLayerMediaTypePrefix):
+		var reader io.Reader = blob
+
+		if strings.HasSuffix(layer.MediaType, "gzip") {
+			gzipReader, err := gzip.NewReader(blob)
+			if err != nil {
+				return fmt.Errorf("%w: layer %s is not gzip compressed: %w", ErrInvalidLayout, layer.Digest, err)
+			}
+			defer gzipReader.Close()
+
+			reader = gzipReader
+		}
+
+		err = unpack.applyTar(tar.NewReader(reader))
+	case layer.Annotations[AnnotationTitle] != "":
+		// artifacts, e.g. pushed with oras, store plain files as layers.
+		name, ok := cleanName(layer.Annotations[AnnotationTitle])
+		if !ok {
+			return fmt.Errorf("%w: invalid layer title %q", ErrInvalidLayout, layer.Annotations[AnnotationTitle])
+		}
+
+		err = unpack.writeFile(name, defaultFilePerms, blob)
+	default:
+		return fmt.Errorf("%w: layer media type %q", ErrUnsupported, layer.MediaType)
+	}
+
+	if err != nil {
+		// a corrupted blob usually fails to parse, report it as such.
+		verifyErr := blob.verify()
+		if errors.Is(verifyErr, ErrDigestMismatch) {
+			return verifyErr
+		}
+
+		return err
+	}
+
+	return blob.verify()
+}
+
+// readFile reads a file of the layout that is not addressed by digest.
+func (l *Layout) readFile(name string, limit int64) ([]byte, error) {
+	file, err := l.source.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: %s is too large", ErrInvalidLayout, name)
+	}
+
+	return data, nil
+}
+
+// readBlob reads a small blob such as a manifest and verifies its digest.
+func (l *Layout) readBlob(desc Descriptor, limit int64) ([]byte, error) {
+	if desc.Size > limit {
+		return nil, fmt.Errorf("%w: blob %s is too large", ErrInvalidLayout, desc.Digest)
+	}
+
+	blob, err := l.openBlob(desc)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", desc.Digest, err)
+	}
+
+	err = blob.verify()
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (l *Layout) openBlob(desc Descriptor) (*verifiedBlob, error) {
+	algorithm, encoded, _ := strings.Cut(desc.Digest, ":")
+	if algorithm != digestAlgorithm {
+		return nil, fmt.Errorf("%w: digest %q", ErrUnsupported, desc.Digest)
+	}
+
+	want, err := hex.DecodeString(encoded)
+	if err != nil || len(want) != sha256.Size || encoded != strings.ToLower(encoded) {
+		return nil, fmt.Errorf("%w: malformed digest %q", ErrInvalidLayout, desc.Digest)
+	}
+
+	file, err := l.source.open(path.Join("blobs", algorithm, encoded))
+	if err != nil {
+		return nil, err
+	}
+
+	return &verifiedBlob{
+		reader: io.LimitReader(file, desc.Size+1),
+		closer: file,
+		hasher: sha256.New(),
+		desc:   desc,
+		want:   want,
+	}, nil
+}
+
+// verifiedBlob hashes and counts a blob while it is read. Reads are limited
+// to one byte past the expected size, which is enough to detect a mismatch.
+type verifiedBlob struct {
+	reader io.Reader
+	closer io.Closer
+	hasher hash.Hash
+	read   int64
+	desc   Descriptor
+	want   []byte
+}
+
+func (b *verifiedBlob) Read(p []byte) (int, error) {
+	n, err := b.reader.Read(p)
+	b.hasher.Write(p[:n])
+	b.read += int64(n)
+
+	return n, err
+}
+
+func (b *verifiedBlob) Close() error {
+	return b.closer.Close()
+}
+
+// verify reads the rest of the blob and checks its size and digest.
+func (b *verifiedBlob) verify() error {
+	_, err := io.Copy(io.Discard, b)
+	if err != nil {
+		return fmt.Errorf("failed to read blob %s: %w", b.desc.Digest, err)
+	}
+
+	if b.read != b.desc.Size || !bytes.Equal(b.hasher.Sum(nil), b.want) {
+		return fmt.Errorf("%w: %s", ErrDigestMismatch, b.desc.Digest)
+	}
+
+	return nil
+}
+
+// cleanName turns a tar entry name into a slash separated path relative to the
+// root of the image. Names cannot escape the root.
+func cleanName(name string) (string, bool) {
+	cleaned := path.Clean("/" + name)
+	if cleaned == "/" {
+		return "", false
+	}
+
+	return cleaned[1:], true
+}
+
+type dirSource string
+
+func (d dirSource) open(name string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(string(d), filepath.FromSlash(name)))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+
+	return file, nil
+}
+
+// archiveSource reads files from an oci-archive by scanning the tarball for
+// them, which avoids extracting the archive to disk first.
+type archiveSource string
+
+func (a archiveSource) open(name string) (io.ReadCloser, error) {
+	file, err := os.Open(string(a))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	reader, err := decompress(file)
+	if err != nil {
+		file.Close()
+
+		return nil, err
+	}
+
+	archive := tar.NewReader(reader)
+
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			file.Close()
+
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+
+		if err != nil {
+			file.Close()
+
+			return nil, fmt.Errorf("%w: malformed archive: %w", ErrInvalidLayout, err)
+		}
+
+		entry, ok := cleanName(header.Name)
+		if ok && entry == name && header.Typeflag == tar.TypeReg {
+			return struct {
+				io.Reader
+				io.Closer
+			}{archive, file}, nil
+		}
+	}
+}
+
+// decompress transparently handles gzip compressed archives.
+func decompress(file *os.File) (io.Reader, error) {
+	var magic [2]byte
+
+	_, err := io.ReadFull(file, magic[:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed archive: %w", ErrInvalidLayout, err)
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewind archive: %w", err)
+	}
+
+	if magic != [2]byte{0x1f, 0x8b} {
+		return file, nil
+	}
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed archive: %w", ErrInvalidLayout, err)
+	}
+
+	return reader, nil
+}
@@ -0,0 +1,362 @@
+package oci_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"csi-driver/internal/pkg/oci"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type entry struct {
+	name     string
+	typeflag byte
+	data     string
+	linkname string
+}
+
+// writeBlob stores data in the layout at dir and returns its descriptor.
+func writeBlob(t *testing.T, dir string, mediaType string, data []byte) oci.Descriptor {
+	t.Helper()
+
+	sum := sha256.Sum256(data)
+	encoded := hex.EncodeToString(sum[:])
+
+	err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0o755)
+	if err != nil {
+		t.Fatalf("failed to create blobs dir: %v", err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "blobs", "sha256", encoded), data, 0o644)
+	if err != nil {
+		t.Fatalf("failed to write blob: %v", err)
+	}
+
+	return oci.Descriptor{MediaType: mediaType, Digest: "sha256:" + encoded, Size: int64(len(data))}
+}
+
+func layer(t *testing.T, entries []entry, compress bool) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	archive := tar.NewWriter(&buf)
+
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: 0o644, Linkname: e.linkname}
+		if e.typeflag == tar.TypeDir {
+			header.Mode = 0o755
+		}
+
+		if e.typeflag == tar.TypeReg {
+			header.Size = int64(len(e.data))
+		}
+
+		err := archive.WriteHeader(header)
+		if err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+
+		_, err = archive.Write([]byte(e.data))
+		if err != nil {
+			t.Fatalf("failed to write tar entry: %v", err)
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		t.Fatalf("failed to close tar: %v", err)
+	}
+
+	if !compress {
+		return buf.Bytes()
+	}
+
+	var compressed bytes.Buffer
+
+	writer := gzip.NewWriter(&compressed)
+	_, _ = writer.Write(buf.Bytes())
+	_ = writer.Close()
+
+	return compressed.Bytes()
+}
+
+// buildLayout creates an empty image layout.
+func buildLayout(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0o644)
+	if err != nil {
+		t.Fatalf("failed to write oci-layout: %v", err)
+	}
+
+	return dir
+}
+
+// writeManifest writes a manifest for layers and an index tagging it v1.
+func writeManifest(t *testing.T, dir string, layers []oci.Descriptor) oci.Descriptor {
+	t.Helper()
+
+	config := writeBlob(t, dir, "application/vnd.oci.image.config.v1+json", []byte("{}"))
+
+	manifest, err := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config":        config,
+		"layers":        layers,
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal manifest: %v", err)
+	}
+
+	desc := writeBlob(t, dir, "application/vnd.oci.image.manifest.v1+json", manifest)
+	desc.Annotations = map[string]string{oci.AnnotationRefName: "v1"}
+
+	index, err := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"manifests":     []oci.Descriptor{desc},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal index: %v", err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "index.json"), index, 0o644)
+	if err != nil {
+		t.Fatalf("failed to write index: %v", err)
+	}
+
+	return desc
+}
+
+func testLayout(t *testing.T) string {
+	t.Helper()
+
+	dir := buildLayout(t)
+	lower := writeBlob(t, dir, "application/vnd.oci.image.layer.v1.tar", layer(t, []entry{
+		{name: "a/", typeflag: tar.TypeDir},
+		{name: "a/x", typeflag: tar.TypeReg, data: "old"},
+		{name: "a/y", typeflag: tar.TypeReg, data: "removed"},
+		{name: "b", typeflag: tar.TypeReg, data: "kept"},
+		{name: "c", typeflag: tar.TypeSymlink, linkname: "a/x"},
+		{name: "d/z", typeflag: tar.TypeReg, data: "hidden"},
+		{name: "e", typeflag: tar.TypeLink, linkname: "b"},
+		{name: "../../escaped", typeflag: tar.TypeReg, data: "contained"},
+	}, false))
+	upper := writeBlob(t, dir, "application/vnd.oci.image.layer.v1.tar+gzip", layer(t, []entry{
+		{name: "a/x", typeflag: tar.TypeReg, data: "new"},
+		{name: "a/.wh.y", typeflag: tar.TypeReg},
+		{name: "d/new", typeflag: tar.TypeReg, data: "added"},
+		{name: "d/.wh..wh..opq", typeflag: tar.TypeReg},
+	}, true))
+	artifact := writeBlob(t, dir, "text/plain", []byte("artifact"))
+	artifact.Annotations = map[string]string{oci.AnnotationTitle: "notes.txt"}
+
+	writeManifest(t, dir, []oci.Descriptor{lower, upper, artifact})
+
+	return dir
+}
+
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+
+	tree := make(map[string]string)
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		rel, _ := filepath.Rel(root, path)
+		tree[filepath.ToSlash(rel)] = string(data)
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to read tree: %v", err)
+	}
+
+	return tree
+}
+
+func TestLayout_Unpack(t *testing.T) {
+	t.Parallel()
+
+	layoutDir := testLayout(t)
+
+	layout, err := oci.Open(layoutDir)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	img, err := layout.Resolve("v1")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	dest := t.TempDir()
+
+	err = layout.Unpack(context.Background(), img, dest, 1<<20)
+	if err != nil {
+		t.Fatalf("Unpack() error = %v", err)
+	}
+
+	want := map[string]string{
+		"a/x":       "new",
+		"b":         "kept",
+		"c":         "new",
+		"d/new":     "added",
+		"e":         "kept",
+		"escaped":   "contained",
+		"notes.txt": "artifact",
+	}
+
+	got := readTree(t, dest)
+	if len(got) != len(want) {
+		t.Errorf("Unpack() wrote %v, want %v", got, want)
+	}
+
+	for name, data := range want {
+		if got[name] != data {
+			t.Errorf("%s = %q, want %q", name, got[name], data)
+		}
+	}
+}
+
+func TestLayout_Archive(t *testing.T) {
+	t.Parallel()
+
+	layoutDir := testLayout(t)
+	archivePath := filepath.Join(t.TempDir(), "image.tar")
+
+	var buf bytes.Buffer
+
+	archive := tar.NewWriter(&buf)
+
+	err := filepath.WalkDir(layoutDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(layoutDir, path)
+
+		err = archive.WriteHeader(&tar.Header{Name: rel, Mode: 0o644, Size: int64(len(data))})
+		if err != nil {
+			return err
+		}
+
+		_, err = archive.Write(data)
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to archive layout: %v", err)
+	}
+
+	_ = archive.Close()
+
+	err = os.WriteFile(archivePath, buf.Bytes(), 0o644)
+	if err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+
+	layout, err := oci.Open(archivePath)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	img, err := layout.Resolve("")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	dest := t.TempDir()
+
+	err = layout.Unpack(context.Background(), img, dest, 1<<20)
+	if err != nil {
+		t.Fatalf("Unpack() error = %v", err)
+	}
+
+	if got := readTree(t, dest)["a/x"]; got != "new" {
+		t.Errorf("a/x = %q, want %q", got, "new")
+	}
+}
+
+func TestLayout_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		reference string
+		corrupt   bool
+		maxSize   int64
+		wantErr   error
+	}{
+		{
+			name:      "unknown reference",
+			reference: "v2",
+			maxSize:   1 << 20,
+			wantErr:   oci.ErrNotFound,
+		},
+		{
+			name:      "corrupted layer",
+			reference: "v1",
+			corrupt:   true,
+			maxSize:   1 << 20,
+			wantErr:   oci.ErrDigestMismatch,
+		},
+		{
+			name:      "too large",
+			reference: "v1",
+			maxSize:   4,
+			wantErr:   oci.ErrTooLarge,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := buildLayout(t)
+			data := layer(t, []entry{{name: "file", typeflag: tar.TypeReg, data: "content"}}, false)
+			desc := writeBlob(t, dir, "application/vnd.oci.image.layer.v1.tar", data)
+			writeManifest(t, dir, []oci.Descriptor{desc})
+
+			if testCase.corrupt {
+				data[len(data)-1] ^= 0xff
+				_ = os.WriteFile(filepath.Join(dir, "blobs", "sha256", desc.Digest[len("sha256:"):]), data, 0o644)
+			}
+
+			layout, err := oci.Open(dir)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+
+			img, err := layout.Resolve(testCase.reference)
+			if err == nil {
+				err = layout.Unpack(context.Background(), img, t.TempDir(), testCase.maxSize)
+			}
+
+			if !errors.Is(err, testCase.wantErr) {
+				t.Errorf("error = %v, want %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package oci
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// unpacker applies layers to a directory. Symlinks are tracked in links
+// rather than created on disk, so nothing written while unpacking can be
+// redirected outside of dest.
+type unpacker struct {
+	dest      string
+	remaining int64
+	// links maps symlinks to their target, both relative to the image root.
+	links map[string]string
+}
+
+// applyTar applies a single tar layer. Whiteouts only remove content of lower
+// layers, so entries added by this layer are remembered in added.
+func (u *unpacker) applyTar(archive *tar.Reader) error {
+	added := make(map[string]bool)
+
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w: malformed layer: %w", ErrInvalidLayout, err)
+		}
+
+		name, ok := cleanName(header.Name)
+		if !ok {
+			continue
+		}
+
+		dir, base := path.Dir(name), path.Base(name)
+
+		switch {
+		case base == whiteoutOpaque:
+			err = u.removeChildren(dir, added)
+		case strings.HasPrefix(base, whiteoutPrefix):
+			err = u.remove(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+		default:
+			for parent := name; parent != "."; parent = path.Dir(parent) {
+				added[parent] = true
+			}
+
+			err = u.applyEntry(name, header, archive)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (u *unpacker) applyEntry(name string, header *tar.Header, content io.Reader) error {
+	perms := fs.FileMode(header.Mode) & fs.ModePerm
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		delete(u.links, name)
+
+		err := u.ensureDir(name)
+		if err != nil {
+			return err
+		}
+
+		return chmod(u.path(name), perms|0o700)
+	case tar.TypeReg:
+		return u.writeFile(name, perms, content)
+	case tar.TypeLink:
+		target, ok := cleanName(header.Linkname)
+		if !ok {
+			return fmt.Errorf("%w: invalid hard link %q", ErrInvalidLayout, header.Name)
+		}
+
+		return u.copyFile(name, target)
+	case tar.TypeSymlink:
+		err := u.remove(name)
+		if err != nil {
+			return err
+		}
+
+		target := header.Linkname
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(name), target)
+		}
+
+		// a target that resolves to the root itself can never be a file.
+		resolved, _ := cleanName(target)
+		u.links[name] = resolved
+
+		return nil
+	default:
+		// devices, fifos and the like make no sense in a volume.
+		return nil
+	}
+}
+
+// writeFile writes content to name, replacing whatever was there before.
+func (u *unpacker) writeFile(name string, perms fs.FileMode, content io.Reader) error {
+	err := u.remove(name)
+	if err != nil {
+		return err
+	}
+
+	err = u.ensureDir(path.Dir(name))
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(u.path(name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, perms)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	defer file.Close()
+
+	written, err := io.Copy(file, io.LimitReader(content, u.remaining+1))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+
+	if written > u.remaining {
+		return ErrTooLarge
+	}
+
+	u.remaining -= written
+
+	return chmod(u.path(name), perms)
+}
+
+// copyFile writes a copy of the regular file target to name.
+func (u *unpacker) copyFile(name string, target string) error {
+	source, err := os.Open(u.path(target))
+	if err != nil {
+		return fmt.Errorf("%w: link %q to missing file %q", ErrInvalidLayout, name, target)
+	}
+	defer source.Close()
+
+	info, err := source.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: link %q to non regular file %q", ErrInvalidLayout, name, target)
+	}
+
+	return u.writeFile(name, info.Mode().Perm(), source)
+}
+
+// ensureDir creates dir and its parents, replacing files that are in the way.
+func (u *unpacker) ensureDir(dir string) error {
+	if dir == "." {
+		return nil
+	}
+
+	current := ""
+
+	for _, element := range strings.Split(dir, "/") {
+		current = path.Join(current, element)
+		delete(u.links, current)
+
+		info, err := os.Lstat(u.path(current))
+
+		switch {
+		case err == nil && info.IsDir():
+			continue
+		case err == nil:
+			err = os.Remove(u.path(current))
+			if err != nil {
+				return fmt.Errorf("failed to replace %s: %w", current, err)
+			}
+		case !errors.Is(err, fs.ErrNotExist):
+			return fmt.Errorf("failed to inspect %s: %w", current, err)
+		}
+
+		err = os.Mkdir(u.path(current), dirPerms)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", current, err)
+		}
+	}
+
+	return nil
+}
+
+// remove deletes name and everything below it.
+func (u *unpacker) remove(name string) error {
+	for link := range u.links {
+		if link == name || strings.HasPrefix(link, name+"/") {
+			delete(u.links, link)
+		}
+	}
+
+	err := os.RemoveAll(u.path(name))
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// removeChildren implements opaque whiteouts by deleting everything in dir
+// that was not added by the current layer.
+func (u *unpacker) removeChildren(dir string, added map[string]bool) error {
+	for link := range u.links {
+		if path.Dir(link) == dir && !added[link] {
+			delete(u.links, link)
+		}
+	}
+
+	entries, err := os.ReadDir(u.path(dir))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		child := path.Join(dir, entry.Name())
+		if added[child] {
+			continue
+		}
+
+		err := u.remove(child)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveLinks replaces symlinks to regular files with a copy of the file.
+// Symlinks to directories and dangling symlinks are dropped.
+func (u *unpacker) resolveLinks() error {
+	names := make([]string, 0, len(u.links))
+	for name := range u.links {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		target, ok := u.links[name], true
+
+		for hops := 0; ok && hops < maxSymlinkHops; hops++ {
+			var next string
+
+			next, ok = u.links[target]
+			if ok {
+				target = next
+			}
+		}
+
+		if ok || target == "" {
+			continue
+		}
+
+		info, err := os.Lstat(u.path(target))
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		err = u.copyFile(name, target)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (u *unpacker) path(name string) string {
+	return filepath.Join(u.dest, filepath.FromSlash(name))
+}
+
+// chmod sets perms explicitly since files are created subject to the umask.
+func chmod(name string, perms fs.FileMode) error {
+	err := os.Chmod(name, perms)
+	if err != nil {
+		return fmt.Errorf("failed to set mode of %s: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,395 @@
+// Package policy decides which volume attributes pods may use. The policy is
+// a JSON file on the node that is polled for changes, so it can be updated
+// without restarting the driver.
+//
+// Rules are tried in order and the first rule whose match selects the pod and
+// the request decides. Requests no rule matches are denied unless the policy
+// sets "default": "allow".
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultAllow admits requests that no rule matches.
+	DefaultAllow = "allow"
+	// DefaultDeny denies requests that no rule matches.
+	DefaultDeny = "deny"
+
+	attributePrefix = "csi-driver.mattslater.io/"
+	podInfoPrefix   = "csi.storage.k8s.io/"
+
+	sourceAttribute         = attributePrefix + "source"
+	filenameAttribute       = attributePrefix + "filename"
+	bundleAttribute         = attributePrefix + "bundle"
+	dataSignatureAttribute  = attributePrefix + "data-signature"
+	valueAttributePrefix    = attributePrefix + "value."
+	namespaceAttribute      = podInfoPrefix + "pod.namespace"
+	serviceAccountAttribute = podInfoPrefix + "serviceAccount.name"
+	// sourceInline is what requests without a source attribute use.
+	sourceInline = "inline"
+)
+
+var (
+	// ErrInvalidPolicy is returned for policy files that cannot be used.
+	ErrInvalidPolicy = errors.New("invalid policy")
+	// ErrDenied is returned for requests the policy does not admit.
+	ErrDenied = errors.New("denied by policy")
+
+	// dataAttributes are the attributes that carry volume content inline and
+	// count towards MaxDataSize, along with every value.<key> attribute.
+	dataAttributes = []string{
+		attributePrefix + "data",
+		attributePrefix + "encrypted-data",
+		attributePrefix + "values",
+	}
+)
+
+// Match selects the requests a rule applies to. Namespaces, service accounts
+// and attribute values are shell patterns as understood by path.Match, empty
+// fields match everything.
+type Match struct {
+	Namespaces      []string          `json:"namespaces,omitempty"`
+	ServiceAccounts []string          `json:"serviceAccounts,omitempty"`
+	Attributes      map[string]string `json:"attributes,omitempty"`
+}
+
+// Rule restricts the requests it matches. Empty fields restrict nothing.
+type Rule struct {
+	Name  string `json:"name"`
+	Match Match  `json:"match"`
+	// Sources lists the allowed values of the source attribute, inline for
+	// requests without one.
+	Sources []string `json:"sources,omitempty"`
+	// Filenames lists patterns the path of every file in the volume has to
+	// match, not just the filename attribute but also projected secrets and
+	// tokens, rendered and generated files and the content of bundles. The
+	// rendered files are checked by the storage backend, see AdmitFiles.
+	Filenames []string `json:"filenames,omitempty"`
+	// MaxDataSize bounds the size in bytes of the data, encrypted-data, values
+	// and value.<key> attributes together.
+	MaxDataSize int64 `json:"maxDataSize,omitempty"`
+	// Bundles lists the bundles that may be requested.
+	Bundles []string `json:"bundles,omitempty"`
+	// RequireSignature requires content signed by a trusted key of the
+	// driver, as REQUIRE_SIGNATURES does for every volume. The storage backend
+	// verifies the data-signature attribute and refuses content that cannot
+	// be signed, see SignatureRequired.
+	RequireSignature bool `json:"requireSignature,omitempty"`
+	// Deny denies every matching request.
+	Deny bool `json:"deny,omitempty"`
+}
+
+// Policy is the content of a policy file.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+	// Default is DefaultAllow or DefaultDeny, the default.
+	Default string `json:"default,omitempty"`
+}
+
+// Parse parses and validates a policy file.
+func Parse(data []byte) (*Policy, error) {
+	policy := &Policy{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(policy)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPolicy, err)
+	}
+
+	switch policy.Default {
+	case "":
+		policy.Default = DefaultDeny
+	case DefaultAllow, DefaultDeny:
+	default:
+		return nil, fmt.Errorf("%w: unknown default %q", ErrInvalidPolicy, policy.Default)
+	}
+
+	for i, rule := range policy.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("%w: rule %d has no name", ErrInvalidPolicy, i)
+		}
+
+		patterns := append(append(append([]string{}, rule.Match.Namespaces...), rule.Match.ServiceAccounts...),
+			rule.Filenames...)
+		for _, pattern := range rule.Match.Attributes {
+			patterns = append(patterns, pattern)
+		}
+
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("%w: rule %s: bad pattern %q", ErrInvalidPolicy, rule.Name, pattern)
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+// Admit checks the attributes of a publish request against the policy. The
+// error of denied requests wraps ErrDenied and names the rule and the
+// violation.
+func (p *Policy) Admit(attributes map[string]string) error {
+	rule := p.rule(attributes)
+	if rule != nil {
+		err := rule.check(attributes)
+		if err != nil {
+			return fmt.Errorf("%w: rule %s: %w", ErrDenied, rule.Name, err)
+		}
+
+		return nil
+	}
+
+	if p.Default == DefaultAllow {
+		return nil
+	}
+
+	return fmt.Errorf("%w: no rule admits namespace %q and service account %q",
+		ErrDenied, attributes[namespaceAttribute], attributes[serviceAccountAttribute],
+	)
+}
+
+// AdmitFiles checks the paths of the files rendered for a volume with
+// attributes against the filenames of the rule that admitted them.
+func (p *Policy) AdmitFiles(attributes map[string]string, paths []string) error {
+	rule := p.rule(attributes)
+	if rule == nil {
+		return nil
+	}
+
+	for _, file := range paths {
+		if !matchesAny(rule.Filenames, file) {
+			return fmt.Errorf("%w: rule %s: file %s does not match %s",
+				ErrDenied, rule.Name, file, strings.Join(rule.Filenames, ", "),
+			)
+		}
+	}
+
+	return nil
+}
+
+// SignatureRequired reports whether the rule that admitted attributes
+// requires signed content.
+func (p *Policy) SignatureRequired(attributes map[string]string) bool {
+	rule := p.rule(attributes)
+
+	return rule != nil && rule.RequireSignature
+}
+
+// rule returns the first rule matching attributes, nil if none does.
+func (p *Policy) rule(attributes map[string]string) *Rule {
+	for i := range p.Rules {
+		if p.Rules[i].matches(attributes) {
+			return &p.Rules[i]
+		}
+	}
+
+	return nil
+}
+
+func (r *Rule) matches(attributes map[string]string) bool {
+	if !matchesAny(r.Match.Namespaces, attributes[namespaceAttribute]) ||
+		!matchesAny(r.Match.ServiceAccounts, attributes[serviceAccountAttribute]) {
+		return false
+	}
+
+	for key, pattern := range r.Match.Attributes {
+		if ok, _ := path.Match(pattern, attributes[key]); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// check returns the first violation of rule by attributes. Errors are plain
+// sentences as they end up in pod events.
+func (r *Rule) check(attributes map[string]string) error {
+	if r.Deny {
+		return errors.New("volumes are not allowed")
+	}
+
+	source := attributes[sourceAttribute]
+	if source == "" {
+		source = sourceInline
+	}
+
+	if len(r.Sources) > 0 && !contains(r.Sources, source) {
+		return fmt.Errorf("source %s is not allowed, use one of %s", source, strings.Join(r.Sources, ", "))
+	}
+
+	if filename, ok := attributes[filenameAttribute]; ok && !matchesAny(r.Filenames, filename) {
+		return fmt.Errorf("filename %s does not match %s", filename, strings.Join(r.Filenames, ", "))
+	}
+
+	if r.MaxDataSize > 0 {
+		var size int64
+		for _, key := range dataAttributes {
+			size += int64(len(attributes[key]))
+		}
+
+		for key, value := range attributes {
+			if strings.HasPrefix(key, valueAttributePrefix) {
+				size += int64(len(value))
+			}
+		}
+
+		if size > r.MaxDataSize {
+			return fmt.Errorf("%d bytes of inline data exceed the limit of %d bytes", size, r.MaxDataSize)
+		}
+	}
+
+	if len(r.Bundles) > 0 {
+		for _, entry := range strings.Split(attributes[bundleAttribute], ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(entry), "=")
+			if name != "" && !contains(r.Bundles, name) {
+				return fmt.Errorf("bundle %s is not allowed, use one of %s", name, strings.Join(r.Bundles, ", "))
+			}
+		}
+	}
+
+	if r.RequireSignature && attributes[dataSignatureAttribute] == "" {
+		return errors.New("content has to be signed with a data-signature")
+	}
+
+	return nil
+}
+
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Store holds the current policy of a policy file.
+type Store struct {
+	logger *zap.Logger
+	path   string
+
+	mu      sync.RWMutex
+	policy  *Policy
+	content []byte
+}
+
+// NewStore returns a Store for the policy file at path and loads it.
+func NewStore(logger *zap.Logger, path string) (*Store, error) {
+	store := &Store{logger: logger, path: path}
+
+	_, err := store.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Admit checks attributes against the current policy.
+func (s *Store) Admit(attributes map[string]string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.policy.Admit(attributes)
+}
+
+// AdmitFiles checks the paths of the files of a volume against the current
+// policy.
+func (s *Store) AdmitFiles(attributes map[string]string, paths []string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.policy.AdmitFiles(attributes, paths)
+}
+
+// SignatureRequired reports whether the current policy requires signed
+// content for attributes.
+func (s *Store) SignatureRequired(attributes map[string]string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.policy.SignatureRequired(attributes)
+}
+
+// Reload reads the policy file again and reports whether it changed. A file
+// that cannot be used leaves the current policy in place.
+func (s *Store) Reload() (bool, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read policy: %w", err)
+	}
+
+	s.mu.RLock()
+	unchanged := s.policy != nil && bytes.Equal(data, s.content)
+	s.mu.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	policy, err := Parse(data)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.policy, s.content = policy, data
+	s.mu.Unlock()
+
+	return true, nil
+}
+
+// Watch reloads the policy every interval until ctx is done.
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := s.Reload()
+		if err != nil {
+			s.logger.Error("failed to reload policy, keeping the current one", zap.Error(err))
+
+			continue
+		}
+
+		if changed {
+			s.logger.Info("policy changed")
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package policy_test
+
+import (
+	"csi-driver/internal/pkg/policy"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+)
+
+const document = `{
+	"rules": [
+		{"name": "frozen", "match": {"namespaces": ["frozen"]}, "deny": true},
+		{
+			"name": "teams",
+			"match": {"namespaces": ["team-*"], "serviceAccounts": ["app", "worker"]},
+			"sources": ["inline", "bundle"],
+			"filenames": ["*.conf"],
+			"maxDataSize": 16,
+			"bundles": ["ca", "flags"]
+		},
+		{
+			"name": "signed urls",
+			"match": {"attributes": {"csi-driver.mattslater.io/source": "url"}},
+			"requireSignature": true
+		}
+	]
+}`
+
+func attributes(namespace string, serviceAccount string, extra ...string) map[string]string {
+	attrs := map[string]string{
+		"csi.storage.k8s.io/pod.namespace":       namespace,
+		"csi.storage.k8s.io/serviceAccount.name": serviceAccount,
+	}
+
+	for i := 0; i+1 < len(extra); i += 2 {
+		attrs["csi-driver.mattslater.io/"+extra[i]] = extra[i+1]
+	}
+
+	return attrs
+}
+
+func TestPolicy_Admit(t *testing.T) {
+	t.Parallel()
+
+	rules, err := policy.Parse([]byte(document))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		attributes map[string]string
+		wantReason string
+	}{
+		{
+			name:       "allowed inline",
+			attributes: attributes("team-a", "app", "filename", "app.conf", "data", "a=b"),
+		},
+		{
+			name:       "allowed bundles",
+			attributes: attributes("team-b", "worker", "bundle", "ca=certs,flags"),
+		},
+		{
+			name:       "denied namespace",
+			attributes: attributes("frozen", "app"),
+			wantReason: "rule frozen: volumes are not allowed",
+		},
+		{
+			name:       "source",
+			attributes: attributes("team-a", "app", "source", "url"),
+			wantReason: "rule teams: source url is not allowed, use one of inline, bundle",
+		},
+		{
+			name:       "filename",
+			attributes: attributes("team-a", "app", "filename", "app.sh"),
+			wantReason: "filename app.sh does not match *.conf",
+		},
+		{
+			name:       "data size",
+			attributes: attributes("team-a", "app", "data", strings.Repeat("x", 17)),
+			wantReason: "17 bytes of inline data exceed the limit of 16 bytes",
+		},
+		{
+			name: "data size spread across values",
+			attributes: attributes("team-a", "app",
+				"value.a", strings.Repeat("x", 8), "value.b", strings.Repeat("x", 8), "values", `{}`),
+			wantReason: "18 bytes of inline data exceed the limit of 16 bytes",
+		},
+		{
+			name:       "bundle",
+			attributes: attributes("team-a", "app", "bundle", "ca, secrets=s"),
+			wantReason: "bundle secrets is not allowed",
+		},
+		{
+			name:       "unsigned url",
+			attributes: attributes("other", "app", "source", "url"),
+			wantReason: "rule signed urls: content has to be signed",
+		},
+		{
+			name:       "signed url",
+			attributes: attributes("other", "app", "source", "url", "data-signature", "c2ln"),
+		},
+		{
+			name:       "unmatched service account",
+			attributes: attributes("team-a", "default"),
+			wantReason: `no rule admits namespace "team-a" and service account "default"`,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := rules.Admit(testCase.attributes)
+			if testCase.wantReason == "" {
+				if err != nil {
+					t.Errorf("Admit() error = %v", err)
+				}
+
+				return
+			}
+
+			if !errors.Is(err, policy.ErrDenied) || !strings.Contains(err.Error(), testCase.wantReason) {
+				t.Errorf("Admit() error = %v, want denial saying %q", err, testCase.wantReason)
+			}
+		})
+	}
+}
+
+func TestPolicy_AdmitFiles(t *testing.T) {
+	t.Parallel()
+
+	rules, err := policy.Parse([]byte(document))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		attributes map[string]string
+		paths      []string
+		wantReason string
+	}{
+		{
+			name:       "matching files",
+			attributes: attributes("team-a", "app"),
+			paths:      []string{"app.conf", "secret.conf"},
+		},
+		{
+			name:       "file beside the filename",
+			attributes: attributes("team-a", "app", "filename", "app.conf"),
+			paths:      []string{"app.conf", "password"},
+			wantReason: "rule teams: file password does not match *.conf",
+		},
+		{
+			name:       "bundle dir",
+			attributes: attributes("team-a", "app", "bundle", "ca=certs"),
+			paths:      []string{"certs/ca.conf"},
+			wantReason: "file certs/ca.conf does not match *.conf",
+		},
+		{
+			name:       "rule without filenames",
+			attributes: attributes("other", "app", "source", "url"),
+			paths:      []string{"anything"},
+		},
+		{
+			name:       "unmatched",
+			attributes: attributes("team-a", "default"),
+			paths:      []string{"anything"},
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := rules.AdmitFiles(testCase.attributes, testCase.paths)
+			if testCase.wantReason == "" {
+				if err != nil {
+					t.Errorf("AdmitFiles() error = %v", err)
+				}
+
+				return
+			}
+
+			if !errors.Is(err, policy.ErrDenied) || !strings.Contains(err.Error(), testCase.wantReason) {
+				t.Errorf("AdmitFiles() error = %v, want denial saying %q", err, testCase.wantReason)
+			}
+		})
+	}
+}
+
+func TestPolicy_SignatureRequired(t *testing.T) {
+	t.Parallel()
+
+	rules, err := policy.Parse([]byte(document))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if !rules.SignatureRequired(attributes("other", "app", "source", "url")) {
+		t.Error("SignatureRequired() = false for a rule requiring signatures")
+	}
+
+	if rules.SignatureRequired(attributes("team-a", "app", "source", "url")) {
+		t.Error("SignatureRequired() = true for a rule not requiring signatures")
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	t.Parallel()
+
+	for _, document := range []string{
+		`{"rules": [{"match": {}}]}`,
+		`{"rules": [], "default": "maybe"}`,
+		`{"rules": [{"name": "a", "sources": ["inline"], "maxSize": 1}]}`,
+		`{"rules": [{"name": "a", "match": {"namespaces": ["[team"]}}]}`,
+	} {
+		_, err := policy.Parse([]byte(document))
+		if !errors.Is(err, policy.ErrInvalidPolicy) {
+			t.Errorf("Parse(%s) error = %v, want %v", document, err, policy.ErrInvalidPolicy)
+		}
+	}
+}
+
+func TestStore_Reload(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "policy.json")
+	writePolicy := func(document string) {
+		err := os.WriteFile(path, []byte(document), 0o644)
+		if err != nil {
+			t.Fatalf("failed to write policy: %v", err)
+		}
+	}
+
+	writePolicy(`{"default": "allow"}`)
+
+	store, err := policy.NewStore(zaptest.NewLogger(t), path)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	if err := store.Admit(attributes("team-a", "app")); err != nil {
+		t.Errorf("Admit() error = %v", err)
+	}
+
+	writePolicy(`{"rules": [{"name": "closed", "deny": true}]}`)
+
+	changed, err := store.Reload()
+	if err != nil || !changed {
+		t.Fatalf("Reload() = %v, %v, want a change", changed, err)
+	}
+
+	if err := store.Admit(attributes("team-a", "app")); !errors.Is(err, policy.ErrDenied) {
+		t.Errorf("Admit() error = %v, want %v", err, policy.ErrDenied)
+	}
+
+	writePolicy(`{"rules": [`)
+
+	_, err = store.Reload()
+	if !errors.Is(err, policy.ErrInvalidPolicy) {
+		t.Errorf("Reload() error = %v, want %v", err, policy.ErrInvalidPolicy)
+	}
+
+	if err := store.Admit(attributes("team-a", "app")); !errors.Is(err, policy.ErrDenied) {
+		t.Errorf("Admit() after a broken update error = %v, want the last policy to apply", err)
+	}
+}
@@ -0,0 +1,172 @@
+// Package prepare runs volume content preparation in the background so that
+// slow sources do not block NodePublishVolume.
+package prepare
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"csi-driver/internal/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// Func prepares the content of a volume. It should return once ctx is done.
+type Func func(ctx context.Context) error
+
+type job struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Pool runs at most a fixed number of preparations at once, keyed by volume
+// ID.
+type Pool struct {
+	logger  *zap.Logger
+	workers chan struct{}
+	keep    time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// NewPool returns a Pool running up to workers preparations concurrently.
+// The result of a finished preparation is kept for keep, so it is forgotten
+// even if nobody asks for it again, e.g. because the pod was deleted.
+func NewPool(logger *zap.Logger, workers int, keep time.Duration) *Pool {
+	return &Pool{
+		logger:  logger,
+		workers: make(chan struct{}, workers),
+		keep:    keep,
+		jobs:    make(map[string]*job),
+	}
+}
+
+// Run starts prepare for key unless a preparation for key is already known
+// and waits up to wait for it to finish. It reports whether the preparation
+// finished and, if so, its error. A finished preparation is forgotten once its
+// result has been returned or was kept for long enough, so the next call for
+// key starts over.
+func (p *Pool) Run(key string, wait time.Duration, prepare Func) (bool, error) {
+	p.mu.Lock()
+
+	current, ok := p.jobs[key]
+	if !ok {
+		current = p.start(key, prepare)
+	}
+
+	p.mu.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-current.done:
+	case <-timer.C:
+		return false, nil
+	}
+
+	p.forget(key, current)
+
+	return true, current.err
+}
+
+// forget drops current unless key was started over in the meantime.
+func (p *Pool) forget(key string, current *job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.jobs[key] == current {
+		delete(p.jobs, key)
+	}
+}
+
+// Cancel stops the preparation for key, if any, and waits until it has
+// returned or ctx is done.
+func (p *Pool) Cancel(ctx context.Context, key string) {
+	p.mu.Lock()
+
+	current, ok := p.jobs[key]
+	if ok {
+		delete(p.jobs, key)
+	}
+
+	p.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	current.cancel()
+
+	select {
+	case <-current.done:
+	case <-ctx.Done():
+	}
+}
+
+// start must be called with p.mu held.
+func (p *Pool) start(key string, prepare Func) *job {
+	ctx, cancel := context.WithCancel(context.Background())
+	current := &job{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	p.jobs[key] = current
+
+	go func() {
+		defer time.AfterFunc(p.keep, func() { p.forget(key, current) })
+		defer close(current.done)
+		defer cancel()
+
+		select {
+		case p.workers <- struct{}{}:
+		case <-ctx.Done():
+			current.err = ctx.Err()
+			metrics.Preparations.Add(metrics.ResultCanceled, 1)
+
+			return
+		}
+
+		defer func() { <-p.workers }()
+
+		metrics.PreparationsInProgress.Add(1)
+		defer metrics.PreparationsInProgress.Add(-1)
+
+		p.logger.Info("preparing volume content", zap.String("volume_id", key))
+
+		started := time.Now()
+		current.err = prepare(ctx)
+		elapsed := time.Since(started)
+
+		metrics.PreparationSeconds.Add(elapsed.Seconds())
+
+		switch {
+		case current.err == nil:
+			metrics.Preparations.Add(metrics.ResultSucceeded, 1)
+			p.logger.Info("prepared volume content",
+				zap.String("volume_id", key),
+				zap.Duration("duration", elapsed),
+			)
+		case errors.Is(current.err, context.Canceled):
+			metrics.Preparations.Add(metrics.ResultCanceled, 1)
+			p.logger.Info("canceled volume content preparation",
+				zap.String("volume_id", key),
+				zap.Duration("duration", elapsed),
+			)
+		default:
+			metrics.Preparations.Add(metrics.ResultFailed, 1)
+			p.logger.Error("failed to prepare volume content",
+				zap.String("volume_id", key),
+				zap.Duration("duration", elapsed),
+				zap.Error(current.err),
+			)
+		}
+	}()
+
+	return current
+}
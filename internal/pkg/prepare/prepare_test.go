@@ -0,0 +1,148 @@
+package prepare_test
+
+import (
+	"context"
+	"csi-driver/internal/pkg/prepare"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+)
+
+var errPrepare = errors.New("prepare failed")
+
+func TestPool_Run(t *testing.T) {
+	t.Parallel()
+
+	pool := prepare.NewPool(zaptest.NewLogger(t), 1, time.Minute)
+
+	done, err := pool.Run("fast", time.Second, func(context.Context) error { return nil })
+	if !done || err != nil {
+		t.Errorf("Run() = %v, %v, want true, nil", done, err)
+	}
+
+	done, err = pool.Run("failing", time.Second, func(context.Context) error { return errPrepare })
+	if !done || !errors.Is(err, errPrepare) {
+		t.Errorf("Run() = %v, %v, want true, %v", done, err, errPrepare)
+	}
+
+	// a failed preparation is forgotten so the next call starts over.
+	done, err = pool.Run("failing", time.Second, func(context.Context) error { return nil })
+	if !done || err != nil {
+		t.Errorf("Run() after failure = %v, %v, want true, nil", done, err)
+	}
+}
+
+func TestPool_RunPending(t *testing.T) {
+	t.Parallel()
+
+	pool := prepare.NewPool(zaptest.NewLogger(t), 1, time.Minute)
+	release := make(chan struct{})
+
+	var calls atomic.Int32
+
+	slow := func(context.Context) error {
+		calls.Add(1)
+		<-release
+
+		return nil
+	}
+
+	done, err := pool.Run("slow", 10*time.Millisecond, slow)
+	if done || err != nil {
+		t.Fatalf("Run() = %v, %v, want false, nil", done, err)
+	}
+
+	// retries while in progress do not start another preparation.
+	done, _ = pool.Run("slow", 10*time.Millisecond, slow)
+	if done {
+		t.Fatal("Run() finished before the preparation was released")
+	}
+
+	close(release)
+
+	done, err = pool.Run("slow", time.Second, slow)
+	if !done || err != nil {
+		t.Errorf("Run() = %v, %v, want true, nil", done, err)
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("preparation ran %d times, want 1", calls.Load())
+	}
+}
+
+func TestPool_Cancel(t *testing.T) {
+	t.Parallel()
+
+	pool := prepare.NewPool(zaptest.NewLogger(t), 1, time.Minute)
+	canceled := make(chan struct{})
+
+	done, _ := pool.Run("slow", 10*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		close(canceled)
+
+		return ctx.Err()
+	})
+	if done {
+		t.Fatal("Run() finished before the preparation was canceled")
+	}
+
+	// queued behind the slow preparation since there is a single worker.
+	done, _ = pool.Run("queued", 10*time.Millisecond, func(context.Context) error { return nil })
+	if done {
+		t.Fatal("queued preparation ran while the worker was busy")
+	}
+
+	pool.Cancel(context.Background(), "slow")
+
+	select {
+	case <-canceled:
+	default:
+		t.Fatal("Cancel() returned before the preparation stopped")
+	}
+
+	done, err := pool.Run("queued", time.Second, func(context.Context) error { return nil })
+	if !done || err != nil {
+		t.Errorf("Run() = %v, %v, want true, nil", done, err)
+	}
+}
+
+func TestPool_RunForgetsUnclaimedResults(t *testing.T) {
+	t.Parallel()
+
+	pool := prepare.NewPool(zaptest.NewLogger(t), 1, 10*time.Millisecond)
+	release := make(chan struct{})
+
+	var calls atomic.Int32
+
+	slow := func(context.Context) error {
+		calls.Add(1)
+		<-release
+
+		return errPrepare
+	}
+
+	done, _ := pool.Run("abandoned", time.Millisecond, slow)
+	if done {
+		t.Fatal("Run() finished before the preparation was released")
+	}
+
+	// nobody asks for the result, e.g. because the pod was deleted.
+	close(release)
+	time.Sleep(100 * time.Millisecond)
+
+	done, err := pool.Run("abandoned", time.Second, func(context.Context) error {
+		calls.Add(1)
+
+		return nil
+	})
+	if !done || err != nil {
+		t.Errorf("Run() = %v, %v, want true, nil", done, err)
+	}
+
+	if calls.Load() != 2 {
+		t.Errorf("preparation ran %d times, want 2", calls.Load())
+	}
+}
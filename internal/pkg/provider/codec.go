@@ -0,0 +1,31 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// jsonCodec encodes gRPC messages as JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	return data, nil
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	return nil
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
@@ -0,0 +1,100 @@
+// Package conformance checks that a provider implements the content provider
+// protocol the way the driver expects. Provider authors run it against their
+// provider from a test, similar to testing/fstest.TestFS.
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+
+	"csi-driver/internal/pkg/provider"
+)
+
+// concurrentMounts is the number of Mount calls made at once, since the driver
+// prepares several volumes concurrently.
+const concurrentMounts = 4
+
+// Test calls the provider behind client with req and reports every deviation
+// from the protocol it finds. req should be a request the provider accepts.
+func Test(ctx context.Context, client *provider.Client, req *provider.MountRequest) error {
+	var errs []error
+
+	version, err := client.Version(ctx)
+
+	switch {
+	case err != nil:
+		errs = append(errs, fmt.Errorf("Version() failed: %w", err))
+	case version.Version != provider.APIVersion:
+		errs = append(errs, fmt.Errorf("Version() = %q, want %q", version.Version, provider.APIVersion))
+	case version.RuntimeName == "":
+		errs = append(errs, errors.New("Version() returned no runtime name"))
+	}
+
+	resp, err := client.Mount(ctx, req)
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("Mount() failed: %w", err))...)
+	}
+
+	errs = append(errs, checkFiles(resp.Files)...)
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		fail []error
+	)
+
+	for i := 0; i < concurrentMounts; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := client.Mount(ctx, req)
+			if err != nil {
+				mu.Lock()
+				fail = append(fail, fmt.Errorf("concurrent Mount() failed: %w", err))
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(append(errs, fail...)...)
+}
+
+// checkFiles validates the files of a Mount response the same way the driver
+// does before writing them.
+func checkFiles(files []provider.File) []error {
+	var errs []error
+
+	seen := make(map[string]bool, len(files))
+
+	for _, file := range files {
+		cleaned := path.Clean(file.Path)
+
+		switch {
+		case file.Path == "" || path.IsAbs(file.Path):
+			errs = append(errs, fmt.Errorf("file path %q is not relative", file.Path))
+		case cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../"):
+			errs = append(errs, fmt.Errorf("file path %q escapes the volume", file.Path))
+		case strings.HasPrefix(cleaned, ".."):
+			errs = append(errs, fmt.Errorf("file path %q uses a name reserved by the driver", file.Path))
+		case seen[cleaned]:
+			errs = append(errs, fmt.Errorf("file path %q returned more than once", file.Path))
+		}
+
+		if fs.FileMode(file.Mode) & ^fs.ModePerm != 0 {
+			errs = append(errs, fmt.Errorf("file %q has invalid mode %o", file.Path, file.Mode))
+		}
+
+		seen[cleaned] = true
+	}
+
+	return errs
+}
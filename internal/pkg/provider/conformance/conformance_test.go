@@ -0,0 +1,66 @@
+package conformance_test
+
+import (
+	"context"
+	"csi-driver/internal/pkg/provider"
+	"csi-driver/internal/pkg/provider/conformance"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// badProvider breaks the protocol in every way the suite checks.
+type badProvider struct{}
+
+func (badProvider) Version(context.Context, *provider.VersionRequest) (*provider.VersionResponse, error) {
+	return &provider.VersionResponse{Version: "v0"}, nil
+}
+
+func (badProvider) Mount(context.Context, *provider.MountRequest) (*provider.MountResponse, error) {
+	return &provider.MountResponse{Files: []provider.File{
+		{Path: "/etc/passwd"},
+		{Path: "../escape"},
+		{Path: "..data"},
+		{Path: "dup"},
+		{Path: "dup"},
+		{Path: "setuid", Mode: 0o4755},
+	}}, nil
+}
+
+func TestTest(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "bad.sock")
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	server := provider.NewGRPCServer(badProvider{})
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	t.Cleanup(server.Stop)
+
+	client, err := provider.NewClient(socketPath)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	t.Cleanup(func() { _ = client.Close() })
+
+	err = conformance.Test(context.Background(), client, &provider.MountRequest{VolumeID: "vol"})
+	if err == nil {
+		t.Fatal("Test() accepted a broken provider")
+	}
+
+	for _, want := range []string{"Version()", "/etc/passwd", "../escape", "..data", "dup", "setuid"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Test() error does not mention %q: %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Package podinfo is the reference content provider. It projects the pod
+// information kubelet passes in the volume context into files, much like the
+// downward API, and the secrets of the volume below secrets/.
+package podinfo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"csi-driver/internal/pkg/provider"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// RuntimeName identifies the provider in VersionResponse.
+	RuntimeName = "podinfo"
+
+	podInfoPrefix = "csi.storage.k8s.io/"
+	podKeyPrefix  = podInfoPrefix + "pod."
+	secretsDir    = "secrets/"
+	secretPerms   = 0o400
+)
+
+// Provider implements provider.Server.
+type Provider struct {
+	// RuntimeVersion is reported in VersionResponse.
+	RuntimeVersion string
+}
+
+// Version implements provider.Server.
+func (p *Provider) Version(_ context.Context, req *provider.VersionRequest) (*provider.VersionResponse, error) {
+	return &provider.VersionResponse{
+		Version:        provider.APIVersion,
+		RuntimeName:    RuntimeName,
+		RuntimeVersion: p.RuntimeVersion,
+	}, nil
+}
+
+// Mount returns a file pod/<key> for every csi.storage.k8s.io/pod.<key> and
+// serviceAccount.name attribute and a file secrets/<key> for every secret.
+func (p *Provider) Mount(_ context.Context, req *provider.MountRequest) (*provider.MountResponse, error) {
+	files := []provider.File{}
+
+	for key, value := range req.Attributes {
+		switch {
+		case strings.HasPrefix(key, podKeyPrefix):
+			files = append(files, provider.File{Path: "pod/" + strings.TrimPrefix(key, podKeyPrefix), Contents: []byte(value)})
+		case key == podInfoPrefix+"serviceAccount.name":
+			files = append(files, provider.File{Path: "pod/serviceAccountName", Contents: []byte(value)})
+		}
+	}
+
+	for key, value := range req.Secrets {
+		if key == "" || strings.ContainsAny(key, `/\`) || strings.HasPrefix(key, ".") {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("secret key %q is not a file name", key))
+		}
+
+		files = append(files, provider.File{Path: secretsDir + key, Mode: secretPerms, Contents: []byte(value)})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	return &provider.MountResponse{Files: files}, nil
+}
@@ -0,0 +1,52 @@
+package podinfo_test
+
+import (
+	"context"
+	"csi-driver/internal/pkg/provider"
+	"csi-driver/internal/pkg/provider/conformance"
+	"csi-driver/internal/pkg/provider/podinfo"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "podinfo.sock")
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	server := provider.NewGRPCServer(&podinfo.Provider{RuntimeVersion: "test"})
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	t.Cleanup(server.Stop)
+
+	client, err := provider.NewClient(socketPath)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	t.Cleanup(func() { _ = client.Close() })
+
+	err = conformance.Test(context.Background(), client, &provider.MountRequest{
+		VolumeID: "vol",
+		Attributes: map[string]string{
+			"csi.storage.k8s.io/pod.name":             "web-0",
+			"csi.storage.k8s.io/pod.namespace":        "default",
+			"csi.storage.k8s.io/serviceAccount.name":  "web",
+			"csi-driver.mattslater.io/provider":       "podinfo",
+			"csi-driver.mattslater.io/unrelated-attr": "ignored",
+		},
+		Secrets: map[string]string{"password": "hunter2"},
+	})
+	if err != nil {
+		t.Errorf("provider is not conformant: %v", err)
+	}
+}
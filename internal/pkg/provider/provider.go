@@ -0,0 +1,186 @@
+// Package provider implements the content provider protocol. A provider is a
+// separate process on the node that serves a small gRPC service on a unix
+// socket. The driver sends it the volume attributes, pod information and
+// secrets and gets the files of the volume back, which it then writes, swaps
+// in atomically and refreshes like any other content.
+//
+// Messages are encoded as JSON, so providers can be written without protobuf
+// code generation.
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	// ServiceName is the fully qualified name of the gRPC service.
+	ServiceName = "csidriver.provider.v1.Provider"
+	// APIVersion is the version of the protocol sent in VersionRequest.
+	APIVersion = "v1"
+
+	versionMethod = "/" + ServiceName + "/Version"
+	mountMethod   = "/" + ServiceName + "/Mount"
+)
+
+// VersionRequest is sent by the driver to check that it speaks the protocol
+// version of the provider.
+type VersionRequest struct {
+	Version string `json:"version"`
+}
+
+// VersionResponse identifies the provider.
+type VersionResponse struct {
+	Version        string `json:"version"`
+	RuntimeName    string `json:"runtimeName"`
+	RuntimeVersion string `json:"runtimeVersion"`
+}
+
+// MountRequest asks a provider for the files of a volume. Attributes is the
+// complete volume context, including the pod information and service account
+// tokens kubelet adds under csi.storage.k8s.io/.
+type MountRequest struct {
+	VolumeID   string            `json:"volumeId"`
+	Attributes map[string]string `json:"attributes"`
+	Secrets    map[string]string `json:"secrets,omitempty"`
+}
+
+// File is a file of a volume. Path is relative to the volume root and a zero
+// Mode selects the default mode of the driver.
+type File struct {
+	Path     string `json:"path"`
+	Mode     uint32 `json:"mode,omitempty"`
+	Contents []byte `json:"contents"`
+}
+
+// MountResponse holds the files of a volume.
+type MountResponse struct {
+	Files []File `json:"files"`
+}
+
+// Server is implemented by providers. Errors should carry a gRPC status,
+// e.g. InvalidArgument for bad attributes or Unavailable for content that
+// cannot be fetched right now.
+type Server interface {
+	Version(ctx context.Context, req *VersionRequest) (*VersionResponse, error)
+	Mount(ctx context.Context, req *MountRequest) (*MountResponse, error)
+}
+
+// NewGRPCServer returns a gRPC server serving impl.
+func NewGRPCServer(impl Server, opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(append(opts, grpc.ForceServerCodec(jsonCodec{}))...)
+	server.RegisterService(&serviceDesc, impl)
+
+	return server
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Version", Handler: versionHandler},
+		{MethodName: "Mount", Handler: mountHandler},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "provider.go",
+}
+
+func versionHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	req := &VersionRequest{}
+
+	err := dec(req)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Server).Version(ctx, req.(*VersionRequest)) //nolint:forcetypeassert // registered for Server.
+	}
+
+	if interceptor == nil {
+		return handler(ctx, req)
+	}
+
+	return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: versionMethod}, handler)
+}
+
+func mountHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	req := &MountRequest{}
+
+	err := dec(req)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Server).Mount(ctx, req.(*MountRequest)) //nolint:forcetypeassert // registered for Server.
+	}
+
+	if interceptor == nil {
+		return handler(ctx, req)
+	}
+
+	return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: mountMethod}, handler)
+}
+
+// Client calls a provider.
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// NewClient returns a client for the provider listening on socketPath. The
+// connection is established lazily on the first call.
+func NewClient(socketPath string) (*Client, error) {
+	conn, err := grpc.Dial(
+		"unix://"+socketPath,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to provider: %w", err)
+	}
+
+	return &Client{conn: conn}, nil
+}
+
+// Version calls the Version method of the provider.
+func (c *Client) Version(ctx context.Context) (*VersionResponse, error) {
+	resp := &VersionResponse{}
+
+	err := c.conn.Invoke(ctx, versionMethod, &VersionRequest{Version: APIVersion}, resp)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // keep the gRPC status.
+	}
+
+	return resp, nil
+}
+
+// Mount calls the Mount method of the provider.
+func (c *Client) Mount(ctx context.Context, req *MountRequest) (*MountResponse, error) {
+	resp := &MountResponse{}
+
+	err := c.conn.Invoke(ctx, mountMethod, req, resp)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // keep the gRPC status.
+	}
+
+	return resp, nil
+}
+
+// Close closes the connection to the provider.
+func (c *Client) Close() error {
+	return c.conn.Close() //nolint:wrapcheck // nothing to add.
+}
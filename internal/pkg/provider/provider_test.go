@@ -0,0 +1,115 @@
+package provider_test
+
+import (
+	"context"
+	"csi-driver/internal/pkg/provider"
+	"csi-driver/internal/pkg/provider/podinfo"
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// staleProvider speaks another protocol version.
+type staleProvider struct {
+	podinfo.Provider
+}
+
+func (staleProvider) Version(context.Context, *provider.VersionRequest) (*provider.VersionResponse, error) {
+	return &provider.VersionResponse{Version: "v0", RuntimeName: "stale"}, nil
+}
+
+func serve(t *testing.T, socketPath string, impl provider.Server) {
+	t.Helper()
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	server := provider.NewGRPCServer(impl)
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	t.Cleanup(server.Stop)
+}
+
+func TestRegistry_Mount(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	serve(t, filepath.Join(dir, "podinfo.sock"), &podinfo.Provider{})
+	serve(t, filepath.Join(dir, "stale.sock"), &staleProvider{})
+
+	registry := provider.NewRegistry(dir)
+	t.Cleanup(func() { _ = registry.Close() })
+
+	req := &provider.MountRequest{
+		VolumeID:   "vol",
+		Attributes: map[string]string{"csi.storage.k8s.io/pod.name": "web-0"},
+		Secrets:    map[string]string{"password": "hunter2"},
+	}
+
+	tests := []struct {
+		name     string
+		provider string
+		want     []provider.File
+		wantErr  error
+		wantCode codes.Code
+	}{
+		{
+			name:     "mount",
+			provider: "podinfo",
+			want: []provider.File{
+				{Path: "pod/name", Contents: []byte("web-0")},
+				{Path: "secrets/password", Mode: 0o400, Contents: []byte("hunter2")},
+			},
+		},
+		{
+			name:     "name escapes providers dir",
+			provider: "../podinfo",
+			wantErr:  provider.ErrUnknownProvider,
+		},
+		{
+			name:     "incompatible version",
+			provider: "stale",
+			wantErr:  provider.ErrIncompatible,
+		},
+		{
+			name:     "not running",
+			provider: "missing",
+			wantCode: codes.Unavailable,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp, err := registry.Mount(context.Background(), testCase.provider, req)
+
+			switch {
+			case testCase.wantErr != nil:
+				if !errors.Is(err, testCase.wantErr) {
+					t.Errorf("Registry.Mount() error = %v, want %v", err, testCase.wantErr)
+				}
+			case testCase.wantCode != codes.OK:
+				if got := status.Code(err); got != testCase.wantCode {
+					t.Errorf("Registry.Mount() code = %v, want %v", got, testCase.wantCode)
+				}
+			case err != nil:
+				t.Errorf("Registry.Mount() error = %v", err)
+			case !reflect.DeepEqual(resp.Files, testCase.want):
+				t.Errorf("Registry.Mount() = %+v, want %+v", resp.Files, testCase.want)
+			}
+		})
+	}
+}
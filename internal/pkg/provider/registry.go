@@ -0,0 +1,110 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const socketSuffix = ".sock"
+
+var (
+	// ErrUnknownProvider is returned for provider names that cannot name a
+	// socket in the providers dir.
+	ErrUnknownProvider = errors.New("unknown provider")
+	// ErrIncompatible is returned for providers speaking another protocol
+	// version.
+	ErrIncompatible = errors.New("incompatible provider")
+)
+
+// Registry calls providers by name. A provider named foo listens on foo.sock
+// in the providers dir, which only the node administrator can write to, so
+// volumes can choose a provider but not an arbitrary socket.
+type Registry struct {
+	dir string
+
+	mu      sync.Mutex
+	clients map[string]*Client
+	// verified records the providers whose version has been checked.
+	verified map[string]bool
+}
+
+// NewRegistry returns a Registry for the sockets in dir.
+func NewRegistry(dir string) *Registry {
+	return &Registry{
+		dir:      dir,
+		clients:  make(map[string]*Client),
+		verified: make(map[string]bool),
+	}
+}
+
+// Mount calls the Mount method of the provider called name. The protocol
+// version of a provider is checked before it is first used.
+func (r *Registry) Mount(ctx context.Context, name string, req *MountRequest) (*MountResponse, error) {
+	client, err := r.client(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	verified := r.verified[name]
+	r.mu.Unlock()
+
+	if !verified {
+		version, err := client.Version(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if version.Version != APIVersion {
+			return nil, fmt.Errorf("%w: %s speaks %q, want %q", ErrIncompatible, name, version.Version, APIVersion)
+		}
+
+		r.mu.Lock()
+		r.verified[name] = true
+		r.mu.Unlock()
+	}
+
+	return client.Mount(ctx, req)
+}
+
+func (r *Registry) client(name string) (*Client, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if client, ok := r.clients[name]; ok {
+		return client, nil
+	}
+
+	client, err := NewClient(filepath.Join(r.dir, name+socketSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	r.clients[name] = client
+
+	return client, nil
+}
+
+// Close closes the connections to all providers.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []error
+
+	for name, client := range r.clients {
+		errs = append(errs, client.Close())
+		delete(r.clients, name)
+		delete(r.verified, name)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,115 @@
+// Package refresh schedules the refresh of volume content that changes while
+// a pod runs, such as certificates, identity tokens and downloaded content.
+package refresh
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"csi-driver/internal/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// jitter delays a refresh by up to a tenth of the time until it is due,
+	// but never more than maxJitter, so volumes created together do not
+	// refresh in lockstep.
+	jitterDivisor = 10
+	maxJitter     = 5 * time.Minute
+
+	minBackoff = 10 * time.Second
+	maxBackoff = 10 * time.Minute
+)
+
+// Target is a storage backend whose volumes can be refreshed.
+type Target interface {
+	// DueVolumes returns the IDs of the volumes that are due for a refresh at
+	// now.
+	DueVolumes(now time.Time) ([]string, error)
+	// RefreshVolume rewrites the content of a volume and schedules its next
+	// refresh, also when it fails.
+	RefreshVolume(ctx context.Context, id string) error
+}
+
+// Run refreshes the volumes of target that are due every interval until ctx
+// is done.
+func Run(ctx context.Context, logger *zap.Logger, target Target, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		refreshDue(ctx, logger, target)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func refreshDue(ctx context.Context, logger *zap.Logger, target Target) {
+	ids, err := target.DueVolumes(time.Now())
+	if err != nil {
+		logger.Error("failed to find volumes due for refresh", zap.Error(err))
+
+		return
+	}
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+
+		err := target.RefreshVolume(ctx, id)
+		if err != nil {
+			metrics.Refreshes.Add(metrics.ResultFailed, 1)
+			logger.Error("failed to refresh volume",
+				zap.String("volume_id", id),
+				zap.Error(err),
+			)
+
+			continue
+		}
+
+		metrics.Refreshes.Add(metrics.ResultSucceeded, 1)
+		logger.Debug("refreshed volume", zap.String("volume_id", id))
+	}
+}
+
+// Delay returns when a refresh that is due at should run, given that it is now.
+// A zero at means no refresh is needed and is returned as is.
+func Delay(at time.Time, now time.Time) time.Time {
+	if at.IsZero() {
+		return at
+	}
+
+	return at.Add(jitter(at.Sub(now) / jitterDivisor))
+}
+
+// Backoff returns how long to wait before retrying a refresh that failed
+// failures times in a row.
+func Backoff(failures int) time.Duration {
+	backoff := minBackoff
+
+	for i := 1; i < failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	backoff = min(backoff, maxBackoff)
+
+	return backoff + jitter(backoff/jitterDivisor)
+}
+
+// jitter returns a random duration below limit, capped at maxJitter.
+func jitter(limit time.Duration) time.Duration {
+	limit = min(limit, maxJitter)
+	if limit <= 0 {
+		return 0
+	}
+
+	//nolint:gosec // jitter needs no cryptographic randomness.
+	return time.Duration(rand.Int63n(int64(limit)))
+}
@@ -0,0 +1,126 @@
+package refresh_test
+
+import (
+	"context"
+	"csi-driver/internal/pkg/refresh"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+)
+
+var errRefresh = errors.New("refresh failed")
+
+type fakeTarget struct {
+	mu        sync.Mutex
+	due       []string
+	refreshed []string
+	done      chan struct{}
+}
+
+func (ft *fakeTarget) DueVolumes(time.Time) ([]string, error) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	due := ft.due
+	ft.due = nil
+
+	return due, nil
+}
+
+func (ft *fakeTarget) RefreshVolume(_ context.Context, id string) error {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	ft.refreshed = append(ft.refreshed, id)
+	if len(ft.refreshed) == 2 {
+		close(ft.done)
+	}
+
+	if id == "failing" {
+		return errRefresh
+	}
+
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	target := &fakeTarget{due: []string{"failing", "vol"}, done: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		refresh.Run(ctx, zaptest.NewLogger(t), target, time.Hour)
+	}()
+
+	select {
+	case <-target.done:
+	case <-time.After(time.Second):
+		t.Fatal("due volumes were not refreshed")
+	}
+
+	cancel()
+	<-stopped
+
+	// a failing volume does not hold up the others.
+	if len(target.refreshed) != 2 || target.refreshed[1] != "vol" {
+		t.Errorf("refreshed %v, want [failing vol]", target.refreshed)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		maxDelay time.Duration
+	}{
+		{name: "never", at: time.Time{}},
+		{name: "overdue", at: now.Add(-time.Minute)},
+		{name: "soon", at: now.Add(time.Minute), maxDelay: 6 * time.Second},
+		{name: "later", at: now.Add(24 * time.Hour), maxDelay: 5 * time.Minute},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := refresh.Delay(testCase.at, now)
+			if got.Before(testCase.at) || got.Sub(testCase.at) > testCase.maxDelay {
+				t.Errorf("Delay() = %v, want up to %v after %v", got, testCase.maxDelay, testCase.at)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		failures int
+		min      time.Duration
+	}{
+		{failures: 1, min: 10 * time.Second},
+		{failures: 2, min: 20 * time.Second},
+		{failures: 5, min: 160 * time.Second},
+		{failures: 100, min: 10 * time.Minute},
+	}
+
+	for _, testCase := range tests {
+		got := refresh.Backoff(testCase.failures)
+		if got < testCase.min || got > testCase.min+testCase.min/10 {
+			t.Errorf("Backoff(%d) = %v, want %v plus up to 10%%", testCase.failures, got, testCase.min)
+		}
+	}
+}
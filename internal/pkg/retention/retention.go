@@ -0,0 +1,45 @@
+// Package retention sweeps the data that unpublished volumes retain for a
+// republish once their retention period has ended.
+package retention
+
+import (
+	"context"
+	"time"
+
+	"csi-driver/internal/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// Target is a storage backend that retains the data of unpublished volumes.
+type Target interface {
+	// SweepRetained removes the retained data whose retention period ended
+	// before now and returns the IDs of those volumes.
+	SweepRetained(now time.Time) ([]string, error)
+}
+
+// Run sweeps the retained data of target every interval until ctx is done.
+// The first sweep runs right away, so data retained before a restart of the
+// driver does not outlive its retention period by much.
+func Run(ctx context.Context, logger *zap.Logger, target Target, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		swept, err := target.SweepRetained(time.Now())
+		if err != nil {
+			logger.Error("failed to sweep retained volume data", zap.Error(err))
+		}
+
+		if len(swept) > 0 {
+			metrics.RetainedSwept.Add(int64(len(swept)))
+			logger.Info("swept retained volume data", zap.Strings("volume_ids", swept))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package retention_test
+
+import (
+	"context"
+	"csi-driver/internal/pkg/retention"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+)
+
+var errSweep = errors.New("sweep failed")
+
+type fakeTarget struct {
+	sweeps chan time.Time
+}
+
+func (ft *fakeTarget) SweepRetained(now time.Time) ([]string, error) {
+	ft.sweeps <- now
+
+	return []string{"vol"}, errSweep
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	target := &fakeTarget{sweeps: make(chan time.Time, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		retention.Run(ctx, zaptest.NewLogger(t), target, time.Hour)
+	}()
+
+	// the first sweep does not wait for the interval.
+	select {
+	case <-target.sweeps:
+	case <-time.After(time.Second):
+		t.Fatal("retained data was not swept")
+	}
+
+	cancel()
+	<-stopped
+}
@@ -0,0 +1,226 @@
+// Package seal encrypts volume content to the public key of a driver so it
+// can be stored in plain text volume attributes.
+//
+// A sealed payload is the prefix "sealed:v1:" followed by the base64 encoding
+// of an ephemeral X25519 public key, a nonce and the AES-256-GCM ciphertext.
+// The AES key is derived with HKDF-SHA256 from the X25519 shared secret. The
+// namespace and, optionally, the service account a payload is sealed for are
+// authenticated as additional data, so the payload only opens in volumes of
+// pods running there.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Prefix marks sealed payloads.
+	Prefix = "sealed:v1:"
+
+	keySize   = 32
+	nonceSize = 12
+	info      = "csi-driver.mattslater.io sealed v1"
+)
+
+var (
+	errNoPEM      = errors.New("no PEM block found")
+	errKeyType    = errors.New("key is not an X25519 key")
+	errMalformed  = errors.New("malformed sealed payload")
+	errDecryption = errors.New("failed to decrypt sealed payload")
+	errNoScope    = errors.New("sealed payloads need a namespace")
+)
+
+// Scope names the pods a payload is sealed for.
+type Scope struct {
+	Namespace string
+	// ServiceAccount limits the payload to pods running as that service
+	// account. If empty, every pod of the namespace can open it.
+	ServiceAccount string
+}
+
+func (s Scope) additionalData() []byte {
+	return []byte(info + "\x00" + s.Namespace + "\x00" + s.ServiceAccount)
+}
+
+// Seal encrypts plaintext to recipient for the pods of scope.
+func Seal(recipient *ecdh.PublicKey, plaintext []byte, scope Scope) (string, error) {
+	if scope.Namespace == "" {
+		return "", errNoScope
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+
+	aead, err := newAEAD(ephemeral, recipient, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, nonceSize)
+
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", fmt.Errorf("failed to read random data: %w", err)
+	}
+
+	payload := append(ephemeral.PublicKey().Bytes(), nonce...)
+	payload = aead.Seal(payload, nonce, plaintext, scope.additionalData())
+
+	return Prefix + base64.StdEncoding.EncodeToString(payload), nil
+}
+
+// Opener decrypts payloads sealed to its public key.
+type Opener struct {
+	key *ecdh.PrivateKey
+}
+
+// NewOpener returns an Opener for key.
+func NewOpener(key *ecdh.PrivateKey) *Opener {
+	return &Opener{key: key}
+}
+
+// PublicKey returns the key payloads have to be sealed to.
+func (o *Opener) PublicKey() *ecdh.PublicKey {
+	return o.key.PublicKey()
+}
+
+// Open decrypts a sealed payload for a pod of scope. It opens payloads sealed
+// for the namespace and service account of the pod as well as those sealed
+// for its whole namespace. Errors never include the payload.
+func (o *Opener) Open(sealed string, scope Scope) ([]byte, error) {
+	if scope.Namespace == "" {
+		return nil, errNoScope
+	}
+
+	encoded, found := strings.CutPrefix(strings.TrimSpace(sealed), Prefix)
+	if !found {
+		return nil, errMalformed
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(payload) < keySize+nonceSize {
+		return nil, errMalformed
+	}
+
+	ephemeral, err := ecdh.X25519().NewPublicKey(payload[:keySize])
+	if err != nil {
+		return nil, errMalformed
+	}
+
+	aead, err := newAEAD(o.key, ephemeral, ephemeral, o.key.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, ciphertext := payload[keySize:keySize+nonceSize], payload[keySize+nonceSize:]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, scope.additionalData())
+	if err != nil && scope.ServiceAccount != "" {
+		plaintext, err = aead.Open(nil, nonce, ciphertext, Scope{Namespace: scope.Namespace}.additionalData())
+	}
+
+	if err != nil {
+		return nil, errDecryption
+	}
+
+	return plaintext, nil
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS#8 X25519 private key as created by
+// "openssl genpkey -algorithm X25519".
+func ParsePrivateKey(keyPEM []byte) (*ecdh.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode private key: %w", errNoPEM)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	private, ok := key.(*ecdh.PrivateKey)
+	if !ok || private.Curve() != ecdh.X25519() {
+		return nil, errKeyType
+	}
+
+	return private, nil
+}
+
+// ParsePublicKey parses a PEM encoded PKIX X25519 public key.
+func ParsePublicKey(keyPEM []byte) (*ecdh.PublicKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode public key: %w", errNoPEM)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	public, ok := key.(*ecdh.PublicKey)
+	if !ok || public.Curve() != ecdh.X25519() {
+		return nil, errKeyType
+	}
+
+	return public, nil
+}
+
+// MarshalPublicKey PEM encodes key in PKIX form.
+func MarshalPublicKey(key *ecdh.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// newAEAD derives the AES-GCM cipher shared by private and peer. The salt
+// binds the key to both the ephemeral and the recipient public key.
+func newAEAD(private *ecdh.PrivateKey, peer *ecdh.PublicKey, ephemeral *ecdh.PublicKey, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute shared secret: %w", err)
+	}
+
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+
+	block, err := aes.NewCipher(hkdf(shared, salt, []byte(info), keySize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AEAD: %w", err)
+	}
+
+	return aead, nil
+}
+
+// hkdf implements RFC 5869 HKDF-SHA256 for outputs of up to one hash length.
+func hkdf(secret []byte, salt []byte, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+
+	return expand.Sum(nil)[:length]
+}
@@ -0,0 +1,172 @@
+package seal_test
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/x509"
+	"csi-driver/internal/pkg/seal"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func generateKey(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	return key
+}
+
+func TestSealOpen(t *testing.T) {
+	t.Parallel()
+
+	key := generateKey(t)
+	opener := seal.NewOpener(key)
+
+	scope := seal.Scope{Namespace: "team", ServiceAccount: "app"}
+
+	sealed, err := seal.Seal(opener.PublicKey(), []byte("top secret"), scope)
+	if err != nil {
+		t.Fatalf("unexpected error sealing: %v", err)
+	}
+
+	if !strings.HasPrefix(sealed, seal.Prefix) || strings.Contains(sealed, "top secret") {
+		t.Fatalf("unexpected sealed payload: %s", sealed)
+	}
+
+	plaintext, err := opener.Open(sealed, scope)
+	if err != nil {
+		t.Fatalf("unexpected error opening: %v", err)
+	}
+
+	if string(plaintext) != "top secret" {
+		t.Errorf("Open() = %q, want %q", plaintext, "top secret")
+	}
+
+	// payloads sealed for a namespace open for every service account there.
+	sealed, err = seal.Seal(opener.PublicKey(), []byte("top secret"), seal.Scope{Namespace: "team"})
+	if err != nil {
+		t.Fatalf("unexpected error sealing: %v", err)
+	}
+
+	plaintext, err = opener.Open(sealed, seal.Scope{Namespace: "team", ServiceAccount: "other"})
+	if err != nil || string(plaintext) != "top secret" {
+		t.Errorf("Open() = %q, %v, want %q", plaintext, err, "top secret")
+	}
+}
+
+func TestOpener_Open(t *testing.T) {
+	t.Parallel()
+
+	opener := seal.NewOpener(generateKey(t))
+
+	scope := seal.Scope{Namespace: "team", ServiceAccount: "app"}
+
+	sealedToOther, err := seal.Seal(generateKey(t).PublicKey(), []byte("top secret"), scope)
+	if err != nil {
+		t.Fatalf("unexpected error sealing: %v", err)
+	}
+
+	sealed, err := seal.Seal(opener.PublicKey(), []byte("top secret"), scope)
+	if err != nil {
+		t.Fatalf("unexpected error sealing: %v", err)
+	}
+
+	tampered := []byte(sealed)
+	tampered[len(tampered)-5] ^= 1
+
+	tests := []struct {
+		name   string
+		sealed string
+		scope  seal.Scope
+	}{
+		{
+			name:   "sealed to another key",
+			sealed: sealedToOther,
+			scope:  scope,
+		},
+		{
+			name:   "tampered",
+			sealed: string(tampered),
+			scope:  scope,
+		},
+		{
+			name:   "missing prefix",
+			sealed: strings.TrimPrefix(sealed, seal.Prefix),
+			scope:  scope,
+		},
+		{
+			name:   "truncated",
+			sealed: seal.Prefix + "AAAA",
+			scope:  scope,
+		},
+		{
+			name:   "other namespace",
+			sealed: sealed,
+			scope:  seal.Scope{Namespace: "other", ServiceAccount: "app"},
+		},
+		{
+			name:   "other service account",
+			sealed: sealed,
+			scope:  seal.Scope{Namespace: "team", ServiceAccount: "admin"},
+		},
+		{
+			name:   "no pod info",
+			sealed: sealed,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := opener.Open(testCase.sealed, testCase.scope); err == nil {
+				t.Error("unexpected nil error")
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	t.Parallel()
+
+	key := generateKey(t)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	parsed, err := seal.ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("unexpected error parsing private key: %v", err)
+	}
+
+	if !parsed.Equal(key) {
+		t.Error("parsed private key differs")
+	}
+
+	publicPEM, err := seal.MarshalPublicKey(key.PublicKey())
+	if err != nil {
+		t.Fatalf("unexpected error marshaling public key: %v", err)
+	}
+
+	public, err := seal.ParsePublicKey(publicPEM)
+	if err != nil {
+		t.Fatalf("unexpected error parsing public key: %v", err)
+	}
+
+	if !public.Equal(key.PublicKey()) {
+		t.Error("parsed public key differs")
+	}
+
+	if _, err := seal.ParsePrivateKey([]byte("not pem")); err == nil {
+		t.Error("unexpected nil error parsing garbage")
+	}
+}
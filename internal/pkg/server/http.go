@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const readHeaderTimeout = 10 * time.Second
+
+// HTTPServer serves the node local HTTP endpoints of the driver.
+type HTTPServer struct {
+	server   *http.Server
+	logger   *zap.Logger
+	listener net.Listener
+}
+
+// NewHTTPServer returns an HTTPServer serving handler on listener.
+func NewHTTPServer(listener net.Listener, handler http.Handler, logger *zap.Logger) *HTTPServer {
+	return &HTTPServer{
+		server: &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: readHeaderTimeout,
+			ErrorLog:          zap.NewStdLog(logger),
+		},
+		logger:   logger,
+		listener: listener,
+	}
+}
+
+// Run runs the HTTPServer.
+func (hs *HTTPServer) Run() error {
+	err := hs.server.Serve(hs.listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to start HTTP server: %w", err)
+	}
+
+	return nil
+}
+
+// Shutdown stops the HTTPServer once in-flight requests are done or ctx
+// expires.
+func (hs *HTTPServer) Shutdown(ctx context.Context) error {
+	err := hs.server.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to shut down HTTP server: %w", err)
+	}
+
+	return nil
+}
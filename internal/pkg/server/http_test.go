@@ -0,0 +1,57 @@
+package server_test
+
+import (
+	"context"
+	"csi-driver/internal/pkg/server"
+	"io"
+	"net/http"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+	"golang.org/x/net/nettest"
+)
+
+func TestHTTPServer_RunShutdown(t *testing.T) {
+	t.Parallel()
+
+	listener, err := nettest.NewLocalListener("tcp")
+	if err != nil {
+		t.Fatalf("failed to create test listener: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("pong"))
+	})
+
+	httpServer := server.NewHTTPServer(listener, mux, zaptest.NewLogger(t))
+
+	done := make(chan error)
+
+	go func() {
+		done <- httpServer.Run()
+	}()
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://"+listener.Addr().String()+"/ping", nil)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "pong" {
+		t.Errorf("unexpected body: %q", body)
+	}
+
+	err = httpServer.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error shutting down: %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error from Run: %v", err)
+	}
+}
@@ -10,8 +10,14 @@ import (
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
 )
 
+// serviceAccountTokensKey carries service account tokens in the volume
+// context, which protosanitizer does not know about.
+const serviceAccountTokensKey = "csi.storage.k8s.io/serviceAccount.tokens"
+
 // ExtendedGRPCServer add a logger and listener to GRPCServeer.
 type ExtendedGRPCServer struct {
 	server   *grpc.Server
@@ -23,6 +29,7 @@ type ExtendedGRPCServer struct {
 func NewExtendedGRPCServer(
 	listener net.Listener,
 	identityServer csi.IdentityServer,
+	controllerServer csi.ControllerServer,
 	nodeServer csi.NodeServer,
 	logger *zap.Logger,
 ) *ExtendedGRPCServer {
@@ -36,6 +43,10 @@ func NewExtendedGRPCServer(
 		csi.RegisterIdentityServer(server, identityServer)
 	}
 
+	if controllerServer != nil {
+		csi.RegisterControllerServer(server, controllerServer)
+	}
+
 	if nodeServer != nil {
 		csi.RegisterNodeServer(server, nodeServer)
 	}
@@ -76,7 +87,7 @@ func loggingInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
 	) (interface{}, error) {
 		logger.Info("request received",
 			zap.String("rpc_method", info.FullMethod),
-			zap.Any("request", protosanitizer.StripSecrets(req)),
+			zap.Any("request", sanitize(req)),
 		)
 
 		resp, err := handler(ctx, req)
@@ -91,3 +102,22 @@ func loggingInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
 		return resp, err
 	}
 }
+
+// sanitize strips secrets and service account tokens from a message so it can
+// be logged.
+func sanitize(msg interface{}) fmt.Stringer {
+	publishReq, ok := msg.(*csi.NodePublishVolumeRequest)
+	if ok {
+		if _, found := publishReq.GetVolumeContext()[serviceAccountTokensKey]; found {
+			// csi messages implement the v1 API, which Clone only takes
+			// through an adapter.
+			clone := proto.Clone(protoadapt.MessageV2Of(publishReq))
+			stripped, _ := protoadapt.MessageV1Of(clone).(*csi.NodePublishVolumeRequest)
+			delete(stripped.GetVolumeContext(), serviceAccountTokensKey)
+
+			msg = stripped
+		}
+	}
+
+	return protosanitizer.StripSecrets(msg)
+}
@@ -21,6 +21,7 @@ func TestNewExtendedGRPCServer(t *testing.T) {
 	server := server.NewExtendedGRPCServer(
 		listener,
 		&driver.IdentityServer{},
+		&driver.ControllerServer{},
 		&driver.NodeServer{},
 		zaptest.NewLogger(t),
 	)
@@ -41,6 +42,7 @@ func TestExtendedGRPCSercer_GracefulStop(t *testing.T) {
 	server := server.NewExtendedGRPCServer(
 		listener,
 		&driver.IdentityServer{},
+		&driver.ControllerServer{},
 		&driver.NodeServer{},
 		zaptest.NewLogger(t),
 	)
@@ -0,0 +1,87 @@
+// Package signature verifies detached signatures of volume content.
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	errNoKeys     = errors.New("no public keys found")
+	errKeyType    = errors.New("unsupported public key type")
+	errUnverified = errors.New("signature does not match any trusted key")
+)
+
+// Verifier checks signatures against a set of trusted public keys.
+type Verifier struct {
+	keys []crypto.PublicKey
+}
+
+// LoadVerifier reads trusted public keys from a PEM file.
+func LoadVerifier(path string) (*Verifier, error) {
+	keysPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trusted keys: %w", err)
+	}
+
+	return NewVerifier(keysPEM)
+}
+
+// NewVerifier parses every PUBLIC KEY block of keysPEM. Only ed25519 and
+// ECDSA keys are accepted.
+func NewVerifier(keysPEM []byte) (*Verifier, error) {
+	var keys []crypto.PublicKey
+
+	for block, rest := pem.Decode(keysPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trusted key: %w", err)
+		}
+
+		switch key.(type) {
+		case ed25519.PublicKey, *ecdsa.PublicKey:
+			keys = append(keys, key)
+		default:
+			return nil, fmt.Errorf("%w: %T", errKeyType, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errNoKeys
+	}
+
+	return &Verifier{keys: keys}, nil
+}
+
+// Verify checks that signature was made over data by one of the trusted keys.
+// ed25519 signatures sign data directly, ECDSA signatures are ASN.1 encoded and
+// sign its SHA-256 digest, as produced by "openssl dgst -sha256 -sign".
+func (v *Verifier) Verify(data []byte, signature []byte) error {
+	digest := sha256.Sum256(data)
+
+	for _, key := range v.keys {
+		switch public := key.(type) {
+		case ed25519.PublicKey:
+			if ed25519.Verify(public, data, signature) {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(public, digest[:], signature) {
+				return nil
+			}
+		}
+	}
+
+	return errUnverified
+}
@@ -0,0 +1,134 @@
+package signature_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"csi-driver/internal/pkg/signature"
+	"encoding/pem"
+	"testing"
+)
+
+func publicPEM(t *testing.T, key any) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal public key: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	t.Parallel()
+
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %v", err)
+	}
+
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %v", err)
+	}
+
+	_, untrusted, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %v", err)
+	}
+
+	data := []byte("feature-flags: all")
+	digest := sha256.Sum256(data)
+
+	ecSignature, err := ecdsa.SignASN1(rand.Reader, ecPrivate, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+
+	verifier, err := signature.NewVerifier(append(publicPEM(t, edPublic), publicPEM(t, &ecPrivate.PublicKey)...))
+	if err != nil {
+		t.Fatalf("unexpected error creating verifier: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		signature []byte
+		wantErr   bool
+	}{
+		{
+			name:      "ed25519",
+			data:      data,
+			signature: ed25519.Sign(edPrivate, data),
+		},
+		{
+			name:      "ecdsa",
+			data:      data,
+			signature: ecSignature,
+		},
+		{
+			name:      "untrusted key",
+			data:      data,
+			signature: ed25519.Sign(untrusted, data),
+			wantErr:   true,
+		},
+		{
+			name:      "modified data",
+			data:      []byte("feature-flags: none"),
+			signature: ed25519.Sign(edPrivate, data),
+			wantErr:   true,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := verifier.Verify(testCase.data, testCase.signature)
+			if (err != nil) != testCase.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		keysPEM []byte
+	}{
+		{
+			name: "no keys",
+		},
+		{
+			name:    "rsa key",
+			keysPEM: publicPEM(t, &rsaKey.PublicKey),
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := signature.NewVerifier(testCase.keysPEM); err == nil {
+				t.Error("unexpected nil error")
+			}
+		})
+	}
+}
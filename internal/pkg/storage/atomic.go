@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// dataDirName is the symlink inside a volume's data path that points at
+	// the directory holding the current version of the content.
+	dataDirName    = "..data"
+	newDataDirName = "..data_tmp"
+	versionPrefix  = ".."
+	versionLayout  = "2006_01_02_15_04_05."
+)
+
+// writeAtomic replaces the content of datapath with files. Files are written
+// into a fresh version directory, the ..data symlink is swapped to it with a
+// rename and every top level entry is a symlink through ..data, so readers
+// either see the old or the new content but never a mix of both. This is the
+// same layout kubelet uses for configMap and secret volumes.
+func (f *Filesystem) writeAtomic(datapath string, files []File) (bool, error) {
+	current, err := os.Readlink(filepath.Join(datapath, dataDirName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("failed to read data dir link: %w", err)
+	}
+
+	if current != "" && contentEqual(filepath.Join(datapath, current), files) {
+		return false, nil
+	}
+
+	versionDir, err := os.MkdirTemp(datapath, versionPrefix+time.Now().UTC().Format(versionLayout))
+	if err != nil {
+		return false, fmt.Errorf("failed to create version dir: %w", err)
+	}
+
+	for _, file := range files {
+		err := f.writeFile(versionDir, file)
+		if err != nil {
+			_ = os.RemoveAll(versionDir)
+
+			return false, err
+		}
+	}
+
+	err = swapDataLink(datapath, filepath.Base(versionDir))
+	if err != nil {
+		return false, err
+	}
+
+	err = linkTopLevel(datapath, files)
+	if err != nil {
+		return false, err
+	}
+
+	if current != "" {
+		f.retireDir(datapath, current)
+	}
+
+	return true, nil
+}
+
+// swapDataLink points the ..data symlink of datapath at the version dir
+// name. The new link is renamed over the old one, so the switch is atomic.
+func swapDataLink(datapath string, name string) error {
+	tmpLink := filepath.Join(datapath, newDataDirName)
+
+	err := os.Remove(tmpLink)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale data dir link: %w", err)
+	}
+
+	err = os.Symlink(name, tmpLink)
+	if err != nil {
+		return fmt.Errorf("failed to create data dir link: %w", err)
+	}
+
+	err = os.Rename(tmpLink, filepath.Join(datapath, dataDirName))
+	if err != nil {
+		return fmt.Errorf("failed to swap data dir link: %w", err)
+	}
+
+	return nil
+}
+
+// linkTopLevel makes sure every top level entry of files is reachable through
+// the ..data symlink and removes entries that are no longer part of the volume.
+func linkTopLevel(datapath string, files []File) error {
+	wanted := make(map[string]struct{}, len(files))
+
+	for _, file := range files {
+		top, _, _ := strings.Cut(filepath.ToSlash(filepath.Clean(file.Path)), "/")
+		wanted[top] = struct{}{}
+	}
+
+	entries, err := os.ReadDir(datapath)
+	if err != nil {
+		return fmt.Errorf("failed to read data dir: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, versionPrefix) {
+			continue
+		}
+
+		_, keep := wanted[name]
+		if keep && entry.Type()&fs.ModeSymlink != 0 {
+			delete(wanted, name)
+
+			continue
+		}
+
+		// anything else is either stale or a plain file left by an older
+		// driver version that has to make way for a symlink.
+		err := os.RemoveAll(filepath.Join(datapath, name))
+		if err != nil {
+			return fmt.Errorf("failed to remove stale volume entry: %w", err)
+		}
+	}
+
+	for name := range wanted {
+		err := os.Symlink(filepath.Join(dataDirName, name), filepath.Join(datapath, name))
+		if err != nil {
+			return fmt.Errorf("failed to link volume entry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// contentEqual reports whether dir holds exactly files.
+func contentEqual(dir string, files []File) bool {
+	count := 0
+
+	err := filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			count++
+		}
+
+		return err
+	})
+	if err != nil || count != len(files) {
+		return false
+	}
+
+	for _, file := range files {
+		path := filepath.Join(dir, file.Path)
+
+		info, err := os.Stat(path)
+		if err != nil || info.Mode().Perm() != file.Mode.Perm() {
+			return false
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil || !bytes.Equal(data, file.Data) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	attributePrefix = "csi-driver.mattslater.io/"
+
+	filenameAttribute = attributePrefix + "filename"
+	dataAttribute     = attributePrefix + "data"
+	// encryptedDataAttribute is the sealed counterpart of dataAttribute.
+	encryptedDataAttribute = attributePrefix + "encrypted-data"
+	// dataSignatureAttribute is a base64 signature over the volume content and
+	// where it goes: the filename attribute and its data, the oci-subpath
+	// attribute and the image manifest, or the render attribute and the
+	// structured values. See signedMessage.
+	dataSignatureAttribute = attributePrefix + "data-signature"
+
+	// sourceAttribute selects where the content of the file named by
+	// filenameAttribute comes from.
+	sourceAttribute      = attributePrefix + "source"
+	urlAttribute         = attributePrefix + "url"
+	urlSHA256Attribute   = attributePrefix + "url-sha256"
+	urlCABundleAttribute = attributePrefix + "url-ca-bundle"
+	urlMaxSizeAttribute  = attributePrefix + "url-max-size"
+	urlTimeoutAttribute  = attributePrefix + "url-timeout"
+	// urlRefreshIntervalAttribute makes downloaded content be fetched again
+	// periodically instead of being kept for the lifetime of the volume.
+	urlRefreshIntervalAttribute = attributePrefix + "url-refresh-interval"
+	// ociLayoutAttribute is relative to the OCI layouts dir of the driver.
+	ociLayoutAttribute    = attributePrefix + "oci-layout"
+	ociReferenceAttribute = attributePrefix + "oci-reference"
+	ociSubpathAttribute   = attributePrefix + "oci-subpath"
+	// providerAttribute names the content provider of the provider source.
+	providerAttribute = attributePrefix + "provider"
+	// commandAttribute names the driver configured command of the command
+	// source.
+	commandAttribute = attributePrefix + "command"
+	// the synthetic-* attributes describe the dataset of the synthetic
+	// source.
+	syntheticFilesAttribute    = attributePrefix + "synthetic-files"
+	syntheticSizeAttribute     = attributePrefix + "synthetic-size"
+	syntheticFanoutAttribute   = attributePrefix + "synthetic-fanout"
+	syntheticDepthAttribute    = attributePrefix + "synthetic-depth"
+	syntheticContentAttribute  = attributePrefix + "synthetic-content"
+	syntheticSeedAttribute     = attributePrefix + "synthetic-seed"
+	syntheticManifestAttribute = attributePrefix + "synthetic-manifest"
+
+	secretsAttribute = attributePrefix + "secrets"
+	tokensAttribute  = attributePrefix + "service-account-tokens"
+
+	certificateAttribute         = attributePrefix + "certificate"
+	certificateDNSNamesAttribute = attributePrefix + "certificate-dns-names"
+	certificateURIsAttribute     = attributePrefix + "certificate-uris"
+	certificateDurationAttribute = attributePrefix + "certificate-duration"
+
+	// expiresAfterAttribute wipes the volume content once the duration has
+	// passed since the volume was first published, leaving only the file
+	// named by expiryMarkerAttribute, if any.
+	expiresAfterAttribute = attributePrefix + "expires-after"
+	expiryMarkerAttribute = attributePrefix + "expiry-marker"
+	// retentionPeriodAttribute keeps the data of a volume for that long after
+	// it was unpublished, so republishing the same volume ID reuses it.
+	retentionPeriodAttribute = attributePrefix + "retention-period"
+
+	// valuesAttribute is a JSON object that renderAttribute renders into
+	// config files. Single values can also be given as value.<key>
+	// attributes.
+	valuesAttribute      = attributePrefix + "values"
+	valueAttributePrefix = attributePrefix + "value."
+	renderAttribute      = attributePrefix + "render"
+
+	generateAttribute = attributePrefix + "generate"
+	bundleAttribute   = attributePrefix + "bundle"
+
+	identityTokenAttribute          = attributePrefix + "identity-token"
+	identityTokenAudiencesAttribute = attributePrefix + "identity-token-audiences"
+	identityTokenDurationAttribute  = attributePrefix + "identity-token-duration"
+	identityJWKSAttribute           = attributePrefix + "identity-jwks"
+
+	// podInfoPrefix prefixes the pod information kubelet adds to the volume
+	// context when podInfoOnMount is enabled.
+	podInfoPrefix = "csi.storage.k8s.io/"
+
+	// serviceAccountTokensKey is set by kubelet when the CSIDriver object
+	// requests service account tokens.
+	serviceAccountTokensKey = "csi.storage.k8s.io/serviceAccount.tokens"
+
+	fileModeBase = 8
+	fileModeBits = 32
+)
+
+// validateRelativePath makes sure a path taken from volume attributes stays
+// inside the volume it is written to.
+func validateRelativePath(path string) error {
+	if path == "" {
+		return fmt.Errorf("%w: empty path", ErrInvalidAttribute)
+	}
+
+	if filepath.IsAbs(path) {
+		return fmt.Errorf("%w: path %q must be relative", ErrInvalidAttribute, path)
+	}
+
+	cleaned := filepath.Clean(path)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return fmt.Errorf("%w: path %q escapes the volume", ErrInvalidAttribute, path)
+	}
+
+	// names starting with .. are reserved for the atomic writer.
+	if strings.HasPrefix(cleaned, versionPrefix) {
+		return fmt.Errorf("%w: path %q uses a reserved name", ErrInvalidAttribute, path)
+	}
+
+	return nil
+}
+
+// parseFileEntry parses a key[=path][:mode] entry as used by the attributes
+// that project keyed values into files. The mode suffix is only recognized
+// when it is numeric, so keys such as URL audiences may contain colons.
+func parseFileEntry(entry string, defaultMode fs.FileMode) (string, string, fs.FileMode, error) {
+	mode := defaultMode
+
+	if idx := strings.LastIndex(entry, ":"); idx != -1 && isDigits(entry[idx+1:]) {
+		parsed, err := strconv.ParseUint(entry[idx+1:], fileModeBase, fileModeBits)
+		if err != nil || parsed > uint64(fs.ModePerm) {
+			return "", "", 0, fmt.Errorf("%w: invalid mode in entry %q", ErrInvalidAttribute, entry)
+		}
+
+		mode = fs.FileMode(parsed)
+		entry = entry[:idx]
+	}
+
+	key, path, found := strings.Cut(entry, "=")
+	if !found {
+		path = key
+	}
+
+	if key == "" {
+		return "", "", 0, fmt.Errorf("%w: empty key in entry %q", ErrInvalidAttribute, entry)
+	}
+
+	err := validateRelativePath(path)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	return key, path, mode, nil
+}
+
+// splitEntries splits a comma separated attribute value into trimmed,
+// non-empty entries.
+func splitEntries(value string) []string {
+	var entries []string
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// expandPodInfo replaces ${key} placeholders in value with the pod
+// information kubelet passes as csi.storage.k8s.io/<key>, e.g.
+// ${pod.name}.${pod.namespace}.svc.
+func expandPodInfo(value string, vCtx map[string]string) (string, error) {
+	var missing []string
+
+	expanded := os.Expand(value, func(key string) string {
+		info, ok := vCtx[podInfoPrefix+key]
+		if !ok || info == "" {
+			missing = append(missing, key)
+		}
+
+		return info
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%w: unknown pod info %q in %q", ErrInvalidAttribute, missing, value)
+	}
+
+	return expanded, nil
+}
+
+func isDigits(value string) bool {
+	if value == "" {
+		return false
+	}
+
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"csi-driver/internal/pkg/bundle"
+	"csi-driver/internal/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// bundleRef records which version of a bundle a volume holds and where its
+// files went, so they can be swapped for a newer version later.
+type bundleRef struct {
+	Version string   `json:"version"`
+	Paths   []string `json:"paths"`
+}
+
+// bundlePin keeps a bundle of a volume at the version it was rolled back to.
+// The pin holds while the bundle source still offers the version Replaced it
+// was rolled back from, the files of the bundle are carried over from the
+// current content until then.
+type bundlePin struct {
+	bundleRef
+	Dir      string `json:"dir"`
+	Replaced string `json:"replaced"`
+}
+
+// bundleFiles places the bundles named by the bundle attribute, a comma
+// separated list of name[=dir] entries, into the volume. Without a dir the
+// files of a bundle go to the volume root. Pinned bundles keep their files,
+// see bundlePin, and the pins that still hold are returned.
+func (f *Filesystem) bundleFiles(content *render) ([]File, map[string]bundleRef, map[string]bundlePin, error) {
+	value, ok := content.vCtx[bundleAttribute]
+	if !ok {
+		return nil, nil, nil, nil
+	}
+
+	if f.bundles == nil {
+		return nil, nil, nil, fmt.Errorf("%w: no bundles dir", ErrNotConfigured)
+	}
+
+	var (
+		files []File
+		pins  map[string]bundlePin
+	)
+
+	refs := make(map[string]bundleRef)
+
+	for _, entry := range splitEntries(value) {
+		name, dir, err := parseBundleEntry(entry)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		current, err := f.bundles.Get(name)
+		if err != nil {
+			if errors.Is(err, bundle.ErrNotFound) {
+				// the bundle may not have been synced to the node yet.
+				return nil, nil, nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+			}
+
+			return nil, nil, nil, fmt.Errorf("failed to get bundle: %w", err)
+		}
+
+		if pin, ok := content.pins[name]; ok && pin.Replaced == current.Version && pin.Dir == dir {
+			pinned, err := carryFiles(content.current, pin.Paths)
+			if err == nil {
+				if pins == nil {
+					pins = make(map[string]bundlePin)
+				}
+
+				files = append(files, pinned...)
+				refs[name] = pin.bundleRef
+				pins[name] = pin
+
+				continue
+			}
+
+			f.logger.Warn("failed to keep rolled back bundle, using the current version",
+				zap.String("volume_id", content.id),
+				zap.String("bundle", name),
+				zap.Error(err),
+			)
+		}
+
+		placed := placeBundle(current, dir)
+		files = append(files, placed...)
+		refs[name] = bundleRef{Version: current.Version, Paths: filePaths(placed)}
+	}
+
+	return files, refs, pins, nil
+}
+
+func parseBundleEntry(entry string) (string, string, error) {
+	name, dir, found := strings.Cut(entry, "=")
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", "", fmt.Errorf("%w: invalid bundle entry %q", ErrInvalidAttribute, entry)
+	}
+
+	if !found {
+		return name, "", nil
+	}
+
+	err := validateRelativePath(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	return name, dir, nil
+}
+
+// bundleDir returns the dir the bundle called name is placed in according to
+// vCtx.
+func bundleDir(vCtx map[string]string, name string) (string, bool) {
+	for _, entry := range splitEntries(vCtx[bundleAttribute]) {
+		entryName, dir, err := parseBundleEntry(entry)
+		if err == nil && entryName == name {
+			return dir, true
+		}
+	}
+
+	return "", false
+}
+
+func placeBundle(current *bundle.Bundle, dir string) []File {
+	files := make([]File, 0, len(current.Files))
+
+	for _, file := range current.Files {
+		files = append(files, File{
+			Path: filepath.Join(dir, filepath.FromSlash(file.Path)),
+			Mode: file.Mode,
+			Data: file.Data,
+		})
+	}
+
+	return files
+}
+
+func filePaths(files []File) []string {
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		paths = append(paths, filepath.Clean(file.Path))
+	}
+
+	return paths
+}
+
+// RefreshBundle pushes the given version of a bundle into every volume that
+// holds another version of it. Only the files of the bundle are replaced, the
+// rest of the content is carried over as is, and the swap is atomic.
+func (f *Filesystem) RefreshBundle(ctx context.Context, current *bundle.Bundle) error {
+	ids, err := f.VolumeIDs()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return fmt.Errorf("bundle refresh canceled: %w", ctx.Err())
+		}
+
+		refreshed, err := f.refreshVolumeBundle(id, current)
+
+		switch {
+		case err != nil:
+			metrics.BundleRefreshes.Add(metrics.ResultFailed, 1)
+			f.logger.Error("failed to refresh bundle",
+				zap.String("volume_id", id),
+				zap.String("bundle", current.Name),
+				zap.Error(err),
+			)
+
+			errs = append(errs, fmt.Errorf("volume %s: %w", id, err))
+		case refreshed:
+			metrics.BundleRefreshes.Add(metrics.ResultSucceeded, 1)
+			f.logger.Info("refreshed bundle",
+				zap.String("volume_id", id),
+				zap.String("bundle", current.Name),
+				zap.String("version", current.Version),
+			)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (f *Filesystem) refreshVolumeBundle(id string, current *bundle.Bundle) (bool, error) {
+	unlock := f.lockVolume(id)
+	defer unlock()
+
+	meta, err := f.readMetadata(id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	pin, pinned := meta.Pins[current.Name]
+	if pinned && pin.Replaced == current.Version {
+		return false, nil
+	}
+
+	// the bundle changed since it was rolled back, if it was.
+	delete(meta.Pins, current.Name)
+
+	ref, ok := meta.Bundles[current.Name]
+	if !ok || ref.Version == current.Version {
+		if pinned {
+			return false, f.writeMetadata(id, meta)
+		}
+
+		return false, nil
+	}
+
+	dir, ok := bundleDir(meta.Attributes, current.Name)
+	if !ok {
+		return false, nil
+	}
+
+	datapath := f.PathForVolume(id)
+
+	currentDir := f.currentDir(datapath)
+	if currentDir == "" {
+		return false, nil
+	}
+
+	existing, err := treeFiles(currentDir)
+	if err != nil {
+		return false, err
+	}
+
+	stale := make(map[string]bool, len(ref.Paths))
+	for _, path := range ref.Paths {
+		stale[path] = true
+	}
+
+	files := make([]File, 0, len(existing))
+
+	for _, file := range existing {
+		if !stale[filepath.Clean(file.Path)] {
+			files = append(files, file)
+		}
+	}
+
+	placed := placeBundle(current, dir)
+	files = append(files, placed...)
+
+	err = checkDuplicates(files)
+	if err != nil {
+		return false, err
+	}
+
+	retired := retire(meta, currentDir)
+
+	changed, err := f.writeAtomic(datapath, files)
+	if err != nil {
+		return false, err
+	}
+
+	meta.Bundles[current.Name] = bundleRef{Version: current.Version, Paths: filePaths(placed)}
+	meta.Size = contentSize(files)
+	meta.Manifest = newManifest(files)
+	f.recordUsage(id, meta.Size)
+
+	if changed {
+		f.recordVersion(id, meta, retired, versionBundle+" "+current.Name+" "+current.Version, time.Now())
+	}
+
+	return true, f.writeMetadata(id, meta)
+}
+
+// BundleVersions returns the version of every bundle each volume holds, keyed
+// by volume ID and bundle name.
+func (f *Filesystem) BundleVersions() (map[string]map[string]string, error) {
+	ids, err := f.VolumeIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[string]map[string]string)
+
+	for _, id := range ids {
+		meta, err := f.readMetadata(id)
+		if err != nil || len(meta.Bundles) == 0 {
+			continue
+		}
+
+		versions[id] = make(map[string]string, len(meta.Bundles))
+		for name, ref := range meta.Bundles {
+			versions[id][name] = ref.Version
+		}
+	}
+
+	return versions, nil
+}
+
+// VolumeIDs lists the volumes on the node.
+func (f *Filesystem) VolumeIDs() ([]string, error) {
+	entries, err := os.ReadDir(f.baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+
+	ids := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ids = append(ids, entry.Name())
+		}
+	}
+
+	return ids, nil
+}
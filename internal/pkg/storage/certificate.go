@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	certificateFile = "tls.crt"
+	keyFile         = "tls.key"
+	caFile          = "ca.crt"
+
+	defaultCertificateDuration = 24 * time.Hour
+	defaultCertificateDNSName  = "${pod.name}.${pod.namespace}.svc"
+	// certificates are renewed once less than a third of their lifetime is
+	// left.
+	renewalDivisor = 3
+)
+
+// certificateFiles issues a certificate signed by the driver CA when the
+// certificate attribute is set. An existing certificate is kept until it is
+// due for renewal, so republishing only rotates it when needed.
+func (f *Filesystem) certificateFiles(current string, vCtx map[string]string) ([]File, error) {
+	enabled, _ := strconv.ParseBool(vCtx[certificateAttribute])
+	if !enabled {
+		return nil, nil
+	}
+
+	if f.issuer == nil {
+		return nil, fmt.Errorf("%w: no certificate authority", ErrNotConfigured)
+	}
+
+	dnsNames, uris, err := certificateNames(vCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.checkCertificateNames(vCtx, dnsNames, uris)
+	if err != nil {
+		return nil, err
+	}
+
+	duration := defaultCertificateDuration
+
+	if value := vCtx[certificateDurationAttribute]; value != "" {
+		duration, err = time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("%w: invalid certificate duration %q", ErrInvalidAttribute, value)
+		}
+	}
+
+	caPEM := f.issuer.CertificatePEM()
+
+	certPEM, keyPEM, ok := reusableCertificate(current, caPEM, dnsNames, uris)
+	if !ok {
+		certPEM, keyPEM, err = f.issuer.Issue(dnsNames, uris, duration)
+		if err != nil {
+			return nil, fmt.Errorf("failed to issue certificate: %w", err)
+		}
+	}
+
+	return []File{
+		{Path: certificateFile, Mode: defaultFilePerms, Data: certPEM},
+		{Path: keyFile, Mode: defaultSecretPerms, Data: keyPEM},
+		{Path: caFile, Mode: defaultFilePerms, Data: caPEM},
+	}, nil
+}
+
+func certificateNames(vCtx map[string]string) ([]string, []*url.URL, error) {
+	names := vCtx[certificateDNSNamesAttribute]
+	if names == "" {
+		names = defaultCertificateDNSName
+	}
+
+	var dnsNames []string
+
+	for _, entry := range splitEntries(names) {
+		name, err := expandPodInfo(entry, vCtx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		dnsNames = append(dnsNames, name)
+	}
+
+	var uris []*url.URL
+
+	for _, entry := range splitEntries(vCtx[certificateURIsAttribute]) {
+		expanded, err := expandPodInfo(entry, vCtx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		uri, err := url.Parse(expanded)
+		if err != nil || uri.Scheme == "" {
+			return nil, nil, fmt.Errorf("%w: invalid certificate URI %q", ErrInvalidAttribute, expanded)
+		}
+
+		uris = append(uris, uri)
+	}
+
+	return dnsNames, uris, nil
+}
+
+// checkCertificateNames makes sure a pod only gets certificates for names of
+// its own namespace: its pod name, DNS names below <namespace>.svc or
+// <namespace>.svc.cluster.local and the SPIFFE ID of its service account.
+// Other names must match a pattern the driver allows.
+func (f *Filesystem) checkCertificateNames(vCtx map[string]string, dnsNames []string, uris []*url.URL) error {
+	namespace := volumeNamespace(vCtx)
+	podName := vCtx[podInfoPrefix+"pod.name"]
+	serviceAccount := volumeServiceAccount(vCtx)
+
+	for _, name := range dnsNames {
+		ownName := name == podName ||
+			strings.HasSuffix(name, "."+namespace+".svc") ||
+			strings.HasSuffix(name, "."+namespace+".svc.cluster.local")
+
+		if (namespace == "" || !ownName) && !f.certificateNameAllowed(name) {
+			return fmt.Errorf("%w: certificate DNS name %q is outside namespace %q", ErrUntrusted, name, namespace)
+		}
+	}
+
+	for _, uri := range uris {
+		ownID := uri.Scheme == "spiffe" && uri.Path == "/ns/"+namespace+"/sa/"+serviceAccount
+
+		if (namespace == "" || serviceAccount == "" || !ownID) && !f.certificateNameAllowed(uri.String()) {
+			return fmt.Errorf("%w: certificate URI %q is not the identity of service account %q in namespace %q",
+				ErrUntrusted, uri, serviceAccount, namespace,
+			)
+		}
+	}
+
+	return nil
+}
+
+func (f *Filesystem) certificateNameAllowed(name string) bool {
+	for _, pattern := range f.certificateNames {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reusableCertificate returns the certificate and key in dir if they were
+// issued by the current CA for the same names and are not due for renewal.
+func reusableCertificate(dir string, caPEM []byte, dnsNames []string, uris []*url.URL) ([]byte, []byte, bool) {
+	if dir == "" {
+		return nil, nil, false
+	}
+
+	currentCA, err := os.ReadFile(filepath.Join(dir, caFile))
+	if err != nil || !bytes.Equal(currentCA, caPEM) {
+		return nil, nil, false
+	}
+
+	certPEM, err := os.ReadFile(filepath.Join(dir, certificateFile))
+	if err != nil {
+		return nil, nil, false
+	}
+
+	keyPEM, err := os.ReadFile(filepath.Join(dir, keyFile))
+	if err != nil {
+		return nil, nil, false
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, false
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	if !slices.Equal(cert.DNSNames, dnsNames) || !urisEqual(cert.URIs, uris) {
+		return nil, nil, false
+	}
+
+	if time.Now().After(renewalTime(cert)) {
+		return nil, nil, false
+	}
+
+	return certPEM, keyPEM, true
+}
+
+// renewalTime returns the point in time after which cert should be replaced.
+func renewalTime(cert *x509.Certificate) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+
+	return cert.NotAfter.Add(-lifetime / renewalDivisor)
+}
+
+func urisEqual(a []*url.URL, b []*url.URL) bool {
+	return slices.EqualFunc(a, b, func(x *url.URL, y *url.URL) bool {
+		return x.String() == y.String()
+	})
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"csi-driver/internal/pkg/command"
+)
+
+// Exit codes of sysexits.h that commands can use to select the gRPC code
+// publishing fails with. Any other status is reported as an internal error.
+const (
+	exitUsage    = 64
+	exitDataErr  = 65
+	exitTempFail = 75
+	exitNoPerm   = 77
+	exitConfig   = 78
+)
+
+// commandFiles runs the command named by the command attribute. Commands that
+// write to stdout produce the file named by the filename attribute, commands
+// with an output dir produce the files they wrote there. Commands are
+// configured on the driver by the node administrator and are trusted like the
+// driver itself.
+func (f *Filesystem) commandFiles(
+	ctx context.Context,
+	id string,
+	filename string,
+	vCtx map[string]string,
+) ([]File, error) {
+	if f.commands == nil {
+		return nil, fmt.Errorf("%w: no commands", ErrNotConfigured)
+	}
+
+	name := vCtx[commandAttribute]
+	if name == "" {
+		return nil, fmt.Errorf("%w: source %q needs a command", ErrInvalidAttribute, sourceCommand)
+	}
+
+	output, err := f.commands.Run(ctx, name, &command.Request{
+		VolumeID:   id,
+		Attributes: metadataAttributes(vCtx),
+	})
+	if err != nil {
+		return nil, commandError(err)
+	}
+
+	if !output.Dir {
+		if filename == "" {
+			return nil, fmt.Errorf("%w: command %s writes to stdout and needs a filename", ErrInvalidAttribute, name)
+		}
+
+		err := validateRelativePath(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		return []File{{Path: filename, Mode: defaultFilePerms, Data: output.Stdout}}, nil
+	}
+
+	if filename != "" {
+		return nil, fmt.Errorf("%w: command %s writes an output dir and takes no filename", ErrInvalidAttribute, name)
+	}
+
+	files := make([]File, 0, len(output.Files))
+	for _, file := range output.Files {
+		files = append(files, File{Path: file.Path, Mode: file.Mode, Data: file.Data})
+	}
+
+	return checkFiles("command "+name, files)
+}
+
+func commandError(err error) error {
+	var exitErr *command.ExitError
+
+	switch {
+	case errors.Is(err, command.ErrUnknownCommand), errors.Is(err, command.ErrTooLarge):
+		return fmt.Errorf("%w: %w", ErrInvalidAttribute, err)
+	case errors.Is(err, command.ErrTimeout):
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	case errors.As(err, &exitErr):
+		switch exitErr.Code {
+		case exitUsage, exitDataErr:
+			return fmt.Errorf("%w: %w", ErrInvalidAttribute, err)
+		case exitTempFail:
+			return fmt.Errorf("%w: %w", ErrUnavailable, err)
+		case exitNoPerm:
+			return fmt.Errorf("%w: %w", ErrUntrusted, err)
+		case exitConfig:
+			return fmt.Errorf("%w: %w", ErrNotConfigured, err)
+		}
+	}
+
+	return err
+}
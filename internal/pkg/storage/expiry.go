@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// expiryDeadline returns when the content of a volume expires according to
+// the expires-after attribute, or zero if it does not. The deadline is set
+// once, when the volume is first published, and kept in the metadata so
+// neither republishing nor restarting the driver extends it.
+func expiryDeadline(vCtx map[string]string, previous *volumeMetadata, now time.Time) (time.Time, error) {
+	value, ok := vCtx[expiresAfterAttribute]
+	if !ok {
+		return time.Time{}, nil
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		return time.Time{}, fmt.Errorf("%w: invalid expires-after %q", ErrInvalidAttribute, value)
+	}
+
+	if marker := vCtx[expiryMarkerAttribute]; marker != "" {
+		err := validateRelativePath(marker)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	if !previous.Expires.IsZero() {
+		return previous.Expires, nil
+	}
+
+	return now.Add(ttl), nil
+}
+
+// expiredFiles is what remains of a volume once its content expired: nothing
+// but the marker file, if the expiry-marker attribute names one.
+func expiredFiles(vCtx map[string]string, expires time.Time) []File {
+	marker := vCtx[expiryMarkerAttribute]
+	if marker == "" {
+		return nil
+	}
+
+	return []File{{
+		Path: marker,
+		Mode: defaultFilePerms,
+		Data: []byte("expired at " + expires.UTC().Format(time.RFC3339) + "\n"),
+	}}
+}
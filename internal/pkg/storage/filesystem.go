@@ -1,11 +1,15 @@
 package storage
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 	"k8s.io/mount-utils"
@@ -16,10 +20,57 @@ type Filesystem struct {
 	storage fs.FS
 	mounter mount.Interface
 	baseDir string
+	issuer  CertificateIssuer
+	// certificateNames are patterns of certificate names pods may request
+	// beyond those of their own namespace.
+	certificateNames []string
+
+	tokenIssuer TokenIssuer
+	unsealer    Unsealer
+
+	verifier           SignatureVerifier
+	signaturesRequired bool
+	filePolicy         FilePolicy
+
+	fetcher   ContentFetcher
+	cache     ContentCache
+	providers ContentProviders
+
+	contentProviders map[string]ContentProvider
+
+	ociLayoutsDir string
+	ociMaxSize    int64
+
+	bundles BundleSource
+
+	maxVolumeSize int64
+
+	commands CommandRunner
+
+	quotas     *Quotas
+	maxVolumes int
+	// keepVersions is how many replaced versions of its content every volume
+	// keeps for rollback.
+	keepVersions int
+	// maxRetention bounds how long the data of unpublished volumes may be
+	// kept, zero disables retaining it.
+	maxRetention time.Duration
+	// repairDrift restores volume content that no longer matches its
+	// manifest.
+	repairDrift bool
+	// usage tracks the content size and namespace of every volume.
+	usageMu sync.Mutex
+	usage   map[string]volumeUsage
+
+	// locks serializes writes to a volume, e.g. a republish and a bundle
+	// update arriving at the same time.
+	locksMu sync.Mutex
+	locks   map[string]*volumeLock
 }
 
 const (
-	rwePerms = 0o700
+	rwePerms         = 0o700
+	defaultFilePerms = 0o644
 )
 
 func NewFilesystem(
@@ -27,12 +78,25 @@ func NewFilesystem(
 	baseDir string,
 	rootFS fs.FS,
 	mounter mount.Interface,
+	opts ...Option,
 ) (*Filesystem, error) {
 	filesystem := &Filesystem{
 		logger:  logger,
 		storage: rootFS,
 		mounter: mounter,
 		baseDir: baseDir,
+		locks:   make(map[string]*volumeLock),
+		usage:   make(map[string]volumeUsage),
+	}
+
+	for _, opt := range opts {
+		opt(filesystem)
+	}
+
+	for source := range filesystem.contentProviders {
+		if isBuiltinSource(source) {
+			return nil, fmt.Errorf("%w: source %q is built in", ErrInvalidSource, source)
+		}
 	}
 
 	isMount, err := filesystem.mounter.IsMountPoint(filesystem.baseDir)
@@ -58,41 +122,423 @@ func NewFilesystem(
 		)
 	}
 
+	err = filesystem.loadUsage()
+	if err != nil {
+		return nil, err
+	}
+
 	return filesystem, nil
 }
 
-func (f *Filesystem) WriteVolume(id string, vCtx map[string]string) (bool, error) {
-	datapath := filepath.Join(f.baseDir, id, "data")
+// WriteVolume writes the content requested by vCtx into the volume and
+// reports whether the volume was newly created. Writing an existing volume
+// refreshes its content in place, which is what republishing relies on.
+func (f *Filesystem) WriteVolume(
+	ctx context.Context,
+	id string,
+	vCtx map[string]string,
+	secrets map[string]string,
+) (bool, error) {
+	unlock := f.lockVolume(id)
+	defer unlock()
+
+	datapath := f.PathForVolume(id)
+
+	_, err := f.retentionPeriod(vCtx)
+	if err != nil {
+		return false, err
+	}
+
+	previous, err := f.readMetadata(id)
+	if err != nil {
+		previous = &volumeMetadata{}
+	}
+
+	reclaimed := false
+
+	if !previous.RetainedUntil.IsZero() {
+		previous, reclaimed, err = f.reclaimRetained(id, previous, vCtx)
+		if err != nil {
+			return false, err
+		}
+	}
+
 	// check if volume already exists
-	err := os.MkdirAll(datapath, rwePerms)
+	_, err = os.Stat(datapath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("unexpected error checking data dir: %w", err)
+	}
+
+	created := err != nil
+
+	now := time.Now()
+
+	expires, err := expiryDeadline(vCtx, previous, now)
+	if err != nil {
+		return false, err
+	}
+
+	current := f.currentDir(datapath)
+	drifted := f.republishDrift(id, current, previous)
+
+	// reclaimed data that was rendered from the same attributes is still
+	// what they ask for, unless parts of it came with the publish request,
+	// such as secrets and service account tokens.
+	if reclaimed && current != "" && !drifted && len(previous.Published) == 0 &&
+		(expires.IsZero() || now.Before(expires)) &&
+		maps.Equal(previous.Attributes, metadataAttributes(vCtx)) {
+		return false, f.resumeRetained(id, previous)
+	}
+
+	content := &render{
+		id:       id,
+		current:  current,
+		previous: previous.Attributes,
+		vCtx:     vCtx,
+		secrets:  secrets,
+		expires:  expires,
+		pins:     previous.Pins,
+	}
+
+	err = f.volumeFiles(ctx, content)
+	if err != nil {
+		return false, err
+	}
+
+	size := contentSize(content.files)
+
+	undo, err := f.reserveUsage(id, volumeNamespace(vCtx), size)
 	if err != nil {
-		if !errors.Is(err, os.ErrExist) {
-			return false, fmt.Errorf("unexpected error creating data dir: %w", err)
+		return false, err
+	}
+
+	err = os.MkdirAll(datapath, rwePerms)
+	if err != nil {
+		undo()
+
+		return false, fmt.Errorf("unexpected error creating data dir: %w", err)
+	}
+
+	retired := retire(previous, current)
+	if drifted {
+		// drifted content is not worth rolling back to.
+		retired.Name = ""
+	}
+
+	changed, err := f.writeAtomic(datapath, content.files)
+	if err != nil {
+		undo()
+
+		return false, err
+	}
+
+	meta := &volumeMetadata{
+		Attributes: metadataAttributes(vCtx),
+		Bundles:    content.bundles,
+		Pins:       content.pins,
+		Published:  content.published,
+		Refresh:    previous.Refresh,
+		Expires:    expires,
+		Size:       size,
+		Manifest:   newManifest(content.files),
+		Integrity:  integrityState{Checked: now, Repaired: previous.Integrity.Repaired},
+		Version:    previous.Version,
+		History:    previous.History,
+	}
+	f.refreshSucceeded(meta, content, now)
+
+	if changed {
+		source := versionRepublish
+		if created {
+			source = versionPublish
 		}
-		// if yes, return false
-		return false, nil
+
+		f.recordVersion(id, meta, retired, source, now)
+	}
+
+	err = f.writeMetadata(id, meta)
+	if err != nil {
+		// usage follows the metadata, which still describes the previous
+		// content.
+		undo()
+
+		return false, err
+	}
+
+	switch {
+	case created:
+		f.logger.Info("wrote volume datapath and file successfully")
+	case changed:
+		f.logger.Info("refreshed volume content", zap.String("volume_id", id))
 	}
 
-	// if no, create volume and files in it, return true.
+	return created, nil
+}
+
+// render holds what the content of a volume is rendered from and the result.
+type render struct {
+	id string
+	// current is the directory holding the content that is being replaced, or
+	// empty for new volumes, so content that has to stay stable can be
+	// carried over.
+	current string
+	// previous holds the attributes current was rendered from.
+	previous map[string]string
+	vCtx     map[string]string
+	secrets  map[string]string
+	// refresh is set when rendering without a publish request. The files
+	// listed in published need secrets or service account tokens and are
+	// carried over from current then.
+	refresh bool
+	// refetch downloads content again instead of carrying it over.
+	refetch bool
+	// expires is when the content is wiped, zero if never.
+	expires time.Time
+
+	// pins holds the bundle pins of the volume, see bundlePin. Rendering
+	// leaves the pins that still hold.
+	pins map[string]bundlePin
+
+	files     []File
+	bundles   map[string]bundleRef
+	published []string
+	// fetched is set when content was downloaded.
+	fetched bool
+	// expired is set when the content was wiped.
+	expired bool
+}
+
+// volumeFiles collects every file requested by the volume attributes, along
+// with the bundles placed into the volume.
+func (f *Filesystem) volumeFiles(ctx context.Context, content *render) error {
+	err := f.checkSignable(content.vCtx)
+	if err != nil {
+		return err
+	}
+
+	if !content.expires.IsZero() && !time.Now().Before(content.expires) {
+		content.files = expiredFiles(content.vCtx, content.expires)
+		content.expired = true
+
+		return f.admitFiles(content)
+	}
+
+	published, err := f.publishedFiles(ctx, content)
+	if err != nil {
+		return err
+	}
+
+	content.files = append(content.files, published...)
+	content.published = filePaths(published)
+
+	if !f.publishedSource(content.vCtx) {
+		primary, err := f.sourceFiles(ctx, content)
+		if err != nil {
+			return err
+		}
+
+		content.files = append(content.files, primary...)
+	}
+
+	certificates, err := f.certificateFiles(content.current, content.vCtx)
+	if err != nil {
+		return err
+	}
+
+	content.files = append(content.files, certificates...)
+
+	rendered, err := f.structuredFiles(content.vCtx)
+	if err != nil {
+		return err
+	}
+
+	content.files = append(content.files, rendered...)
+
+	generated, err := generatedFiles(content.current, content.vCtx)
+	if err != nil {
+		return err
+	}
+
+	content.files = append(content.files, generated...)
+
+	identity, err := f.identityFiles(content.current, content.vCtx)
+	if err != nil {
+		return err
+	}
+
+	content.files = append(content.files, identity...)
+
+	bundled, bundles, pins, err := f.bundleFiles(content)
+	if err != nil {
+		return err
+	}
+
+	content.files = append(content.files, bundled...)
+	content.bundles = bundles
+	content.pins = pins
+
+	err = checkDuplicates(content.files)
+	if err != nil {
+		return err
+	}
+
+	err = f.admitFiles(content)
+	if err != nil {
+		return err
+	}
+
+	return f.checkSize(content.files)
+}
+
+// admitFiles checks the rendered files against the file policy, if any.
+func (f *Filesystem) admitFiles(content *render) error {
+	if f.filePolicy == nil {
+		return nil
+	}
+
+	err := f.filePolicy.AdmitFiles(content.vCtx, filePaths(content.files))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUntrusted, err)
+	}
+
+	return nil
+}
+
+// checkSize makes sure files fit into the volume size limit.
+func (f *Filesystem) checkSize(files []File) error {
+	if f.maxVolumeSize == 0 {
+		return nil
+	}
+
+	size := contentSize(files)
+	if size > f.maxVolumeSize {
+		return fmt.Errorf("%w: content of %d bytes exceeds the volume size limit of %d bytes",
+			ErrInvalidAttribute, size, f.maxVolumeSize,
+		)
+	}
+
+	return nil
+}
+
+// publishedFiles returns the files that can only be produced with the
+// secrets and service account tokens of a publish request. On refresh they
+// are carried over from the current content instead.
+func (f *Filesystem) publishedFiles(ctx context.Context, content *render) ([]File, error) {
+	if content.refresh {
+		return carryFiles(content.current, content.published)
+	}
+
+	var files []File
+
+	if f.publishedSource(content.vCtx) {
+		primary, err := f.sourceFiles(ctx, content)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, primary...)
+	}
+
+	projected, err := secretFiles(content.vCtx, content.secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	files = append(files, projected...)
+
+	tokens, err := tokenFiles(content.vCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(files, tokens...), nil
+}
+
+// publishedSource reports whether the source of vCtx is handed the secrets of
+// the publish request.
+func (f *Filesystem) publishedSource(vCtx map[string]string) bool {
+	source := vCtx[sourceAttribute]
+	if _, ok := f.contentProviders[source]; ok {
+		return true
+	}
+
+	return source == sourceProvider
+}
+
+// carryFiles reads the files at paths below dir.
+func carryFiles(dir string, paths []string) ([]File, error) {
+	files := make([]File, 0, len(paths))
+
+	for _, path := range paths {
+		info, err := os.Stat(filepath.Join(dir, path))
+		if err != nil {
+			return nil, fmt.Errorf("%w: published content is gone, it is restored on the next publish", ErrUnavailable)
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read published content: %w", err)
+		}
+
+		files = append(files, File{Path: path, Mode: info.Mode().Perm(), Data: data})
+	}
+
+	return files, nil
+}
+
+// checkDuplicates makes sure no path is requested twice.
+func checkDuplicates(files []File) error {
+	seen := make(map[string]struct{}, len(files))
+
+	for _, file := range files {
+		path := filepath.Clean(file.Path)
+		if _, ok := seen[path]; ok {
+			return fmt.Errorf("%w: path %q requested more than once", ErrInvalidAttribute, file.Path)
+		}
+
+		seen[path] = struct{}{}
+	}
+
+	return nil
+}
+
+// currentDir returns the directory holding the current content of the volume
+// at datapath, or an empty string if there is none.
+func (f *Filesystem) currentDir(datapath string) string {
+	current, err := os.Readlink(filepath.Join(datapath, dataDirName))
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(datapath, current)
+}
+
+// writeFile writes a single file below dir. Only the path is logged since the
+// content may be sensitive.
+func (f *Filesystem) writeFile(dir string, file File) error {
+	path := filepath.Join(dir, file.Path)
+
 	f.logger.Info("creating file",
-		zap.String("path", filepath.Join(datapath, vCtx["csi-driver.mattslater.io/filename"])),
+		zap.String("path", path),
 	)
 
-	file, err := os.Create(filepath.Join(datapath, vCtx["csi-driver.mattslater.io/filename"]))
+	err := os.MkdirAll(filepath.Dir(path), rwePerms)
 	if err != nil {
-		return false, fmt.Errorf("failed to create file: %w", err)
+		return fmt.Errorf("failed to create file directories: %w", err)
 	}
 
-	defer file.Close()
-
-	_, err = file.WriteString(vCtx["csi-driver.mattslater.io/data"])
+	err = os.WriteFile(path, file.Data, file.Mode)
 	if err != nil {
-		return false, fmt.Errorf("failed to write data to file: %w", err)
+		return fmt.Errorf("failed to write data to file: %w", err)
 	}
 
-	f.logger.Info("wrote volume datapath and file successfully")
+	// WriteFile is subject to the umask, so set the requested mode explicitly.
+	err = os.Chmod(path, file.Mode)
+	if err != nil {
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
 
-	return true, nil
+	return nil
 }
 
 func (f *Filesystem) PathForVolume(id string) string {
@@ -115,10 +561,20 @@ func (f *Filesystem) ListVolumes() ([]string, error) {
 }
 
 func (f *Filesystem) RemoveVolume(id string) error {
+	unlock := f.lockVolume(id)
+	defer unlock()
+
+	return f.removeVolume(id)
+}
+
+// removeVolume removes volume id, which has to be locked.
+func (f *Filesystem) removeVolume(id string) error {
 	err := os.RemoveAll(filepath.Join(f.baseDir, id))
 	if err != nil {
 		return fmt.Errorf("failed to remove volume: %w", err)
 	}
 
+	f.releaseUsage(id)
+
 	return nil
 }
@@ -1,12 +1,41 @@
 package storage_test
 
 import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"csi-driver/internal/pkg/bundle"
+	"csi-driver/internal/pkg/ca"
+	"csi-driver/internal/pkg/cache"
+	"csi-driver/internal/pkg/command"
+	"csi-driver/internal/pkg/fetch"
+	"csi-driver/internal/pkg/jwt"
+	"csi-driver/internal/pkg/policy"
+	"csi-driver/internal/pkg/provider"
+	"csi-driver/internal/pkg/provider/podinfo"
+	"csi-driver/internal/pkg/seal"
+	"csi-driver/internal/pkg/signature"
 	"csi-driver/internal/pkg/storage"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"go.uber.org/zap/zaptest"
 	"k8s.io/mount-utils"
@@ -59,16 +88,18 @@ func TestFilesystem_WriteVolume(t *testing.T) {
 	}
 
 	type args struct {
-		id   string
-		vCtx map[string]string
+		id      string
+		vCtx    map[string]string
+		secrets map[string]string
 	}
 
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    bool
-		wantErr bool
+		name      string
+		fields    fields
+		args      args
+		want      bool
+		wantFiles map[string]fs.FileMode
+		wantErr   bool
 	}{
 		{
 			name: "success write volume",
@@ -85,6 +116,125 @@ func TestFilesystem_WriteVolume(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "success write secrets",
+			fields: fields{
+				storage: fstest.MapFS{},
+				mounter: mount.NewFakeMounter([]mount.MountPoint{}),
+			},
+			args: args{
+				id: "test-id",
+				vCtx: map[string]string{
+					"csi-driver.mattslater.io/secrets": "username=db/user:0440, password",
+				},
+				secrets: map[string]string{
+					"username": "admin",
+					"password": "hunter2",
+				},
+			},
+			want: true,
+			wantFiles: map[string]fs.FileMode{
+				"db/user":  0o440,
+				"password": 0o400,
+			},
+		},
+		{
+			name: "missing secret key",
+			fields: fields{
+				storage: fstest.MapFS{},
+				mounter: mount.NewFakeMounter([]mount.MountPoint{}),
+			},
+			args: args{
+				id: "test-id",
+				vCtx: map[string]string{
+					"csi-driver.mattslater.io/secrets": "token",
+				},
+				secrets: map[string]string{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "secret path escapes volume",
+			fields: fields{
+				storage: fstest.MapFS{},
+				mounter: mount.NewFakeMounter([]mount.MountPoint{}),
+			},
+			args: args{
+				id: "test-id",
+				vCtx: map[string]string{
+					"csi-driver.mattslater.io/secrets": "token=../../token",
+				},
+				secrets: map[string]string{
+					"token": "abc",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "success write service account tokens",
+			fields: fields{
+				storage: fstest.MapFS{},
+				mounter: mount.NewFakeMounter([]mount.MountPoint{}),
+			},
+			args: args{
+				id: "test-id",
+				vCtx: map[string]string{
+					"csi-driver.mattslater.io/service-account-tokens": "https://vault.example.com=tokens/vault:0440",
+					"csi.storage.k8s.io/serviceAccount.tokens": `{"https://vault.example.com":` +
+						`{"token":"abc","expirationTimestamp":"2030-01-01T00:00:00Z"}}`,
+				},
+			},
+			want: true,
+			wantFiles: map[string]fs.FileMode{
+				"tokens/vault": 0o440,
+			},
+		},
+		{
+			name: "missing service account token",
+			fields: fields{
+				storage: fstest.MapFS{},
+				mounter: mount.NewFakeMounter([]mount.MountPoint{}),
+			},
+			args: args{
+				id: "test-id",
+				vCtx: map[string]string{
+					"csi-driver.mattslater.io/service-account-tokens": "vault",
+					"csi.storage.k8s.io/serviceAccount.tokens":        `{}`,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "reserved path",
+			fields: fields{
+				storage: fstest.MapFS{},
+				mounter: mount.NewFakeMounter([]mount.MountPoint{}),
+			},
+			args: args{
+				id: "test-id",
+				vCtx: map[string]string{
+					"csi-driver.mattslater.io/filename": "..data",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid secret mode",
+			fields: fields{
+				storage: fstest.MapFS{},
+				mounter: mount.NewFakeMounter([]mount.MountPoint{}),
+			},
+			args: args{
+				id: "test-id",
+				vCtx: map[string]string{
+					"csi-driver.mattslater.io/secrets": "token:0999",
+				},
+				secrets: map[string]string{
+					"token": "abc",
+				},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, testCase := range tests {
@@ -110,7 +260,7 @@ func TestFilesystem_WriteVolume(t *testing.T) {
 				t.Fatalf("failed to create filesystem: %v", err)
 			}
 
-			got, err := f.WriteVolume(testCase.args.id, testCase.args.vCtx)
+			got, err := f.WriteVolume(context.Background(), testCase.args.id, testCase.args.vCtx, testCase.args.secrets)
 			if (err != nil) != testCase.wantErr {
 				t.Errorf("Filesystem.WriteVolume() error = %v, wantErr %v", err, testCase.wantErr)
 
@@ -120,10 +270,2236 @@ func TestFilesystem_WriteVolume(t *testing.T) {
 			if got != testCase.want {
 				t.Errorf("Filesystem.WriteVolume() = %v, want %v", got, testCase.want)
 			}
+
+			for path, mode := range testCase.wantFiles {
+				info, err := os.Stat(filepath.Join(f.PathForVolume(testCase.args.id), path))
+				if err != nil {
+					t.Fatalf("failed to stat %s: %v", path, err)
+				}
+
+				if info.Mode().Perm() != mode {
+					t.Errorf("file %s has mode %v, want %v", path, info.Mode().Perm(), mode)
+				}
+			}
+		})
+	}
+}
+
+func TestFilesystem_WriteVolume_Refresh(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := os.MkdirTemp("", "refresh-volume*")
+	if err != nil {
+		t.Fatalf("failed to create a temp dir: %v", err)
+	}
+
+	defer func() {
+		err := os.RemoveAll(tmpDir)
+		if err != nil {
+			t.Fatalf("failed to remove temp dir: %v", err)
+		}
+	}()
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		tmpDir,
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	vCtx := func(token string) map[string]string {
+		return map[string]string{
+			"csi-driver.mattslater.io/filename":               "static.txt",
+			"csi-driver.mattslater.io/data":                   "static",
+			"csi-driver.mattslater.io/service-account-tokens": "api=token",
+			"csi.storage.k8s.io/serviceAccount.tokens":        `{"api":{"token":"` + token + `"}}`,
+		}
+	}
+
+	created, err := fileSystem.WriteVolume(context.Background(), "vol", vCtx("first"), nil)
+	if err != nil || !created {
+		t.Fatalf("WriteVolume() = %v, %v, want true, nil", created, err)
+	}
+
+	created, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx("second"), nil)
+	if err != nil || created {
+		t.Fatalf("WriteVolume() = %v, %v, want false, nil", created, err)
+	}
+
+	datapath := fileSystem.PathForVolume("vol")
+
+	token, err := os.ReadFile(filepath.Join(datapath, "token"))
+	if err != nil {
+		t.Fatalf("failed to read token: %v", err)
+	}
+
+	if string(token) != "second" {
+		t.Errorf("token = %q, want %q", token, "second")
+	}
+
+	target, err := os.Readlink(filepath.Join(datapath, "token"))
+	if err != nil {
+		t.Fatalf("token is not a symlink: %v", err)
+	}
+
+	if target != filepath.Join("..data", "token") {
+		t.Errorf("token links to %q, want it to go through ..data", target)
+	}
+
+	entries, err := os.ReadDir(datapath)
+	if err != nil {
+		t.Fatalf("failed to read data dir: %v", err)
+	}
+
+	// ..data, one version dir, static.txt and token.
+	if len(entries) != 4 {
+		t.Errorf("data dir has %d entries, want 4: %v", len(entries), entries)
+	}
+}
+
+func TestFilesystem_WriteVolume_Certificate(t *testing.T) {
+	t.Parallel()
+
+	certificateAuthority, err := ca.Generate("test-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("failed to generate CA: %v", err)
+	}
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithCertificateIssuer(certificateAuthority),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	vCtx := map[string]string{
+		"csi-driver.mattslater.io/certificate":      "true",
+		"csi-driver.mattslater.io/certificate-uris": "spiffe://cluster.local/ns/${pod.namespace}/sa/${serviceAccount.name}",
+		"csi.storage.k8s.io/pod.name":               "app-0",
+		"csi.storage.k8s.io/pod.namespace":          "default",
+		"csi.storage.k8s.io/serviceAccount.name":    "app",
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx, nil)
+	if err != nil {
+		t.Fatalf("unexpected error writing volume: %v", err)
+	}
+
+	certPath := filepath.Join(fileSystem.PathForVolume("vol"), "tls.crt")
+
+	first, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatalf("failed to read certificate: %v", err)
+	}
+
+	block, _ := pem.Decode(first)
+	if block == nil {
+		t.Fatal("certificate is not PEM encoded")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "app-0.default.svc" {
+		t.Errorf("unexpected DNS names: %v", cert.DNSNames)
+	}
+
+	if len(cert.URIs) != 1 || cert.URIs[0].String() != "spiffe://cluster.local/ns/default/sa/app" {
+		t.Errorf("unexpected URIs: %v", cert.URIs)
+	}
+
+	for _, name := range []string{"tls.key", "ca.crt"} {
+		if _, err := os.Stat(filepath.Join(fileSystem.PathForVolume("vol"), name)); err != nil {
+			t.Errorf("missing %s: %v", name, err)
+		}
+	}
+
+	// republishing keeps a certificate that is not due for renewal.
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx, nil)
+	if err != nil {
+		t.Fatalf("unexpected error rewriting volume: %v", err)
+	}
+
+	second, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatalf("failed to read certificate: %v", err)
+	}
+
+	if string(first) != string(second) {
+		t.Error("certificate was reissued before renewal was due")
+	}
+}
+
+func TestFilesystem_WriteVolume_CertificateNames(t *testing.T) {
+	t.Parallel()
+
+	certificateAuthority, err := ca.Generate("test-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("failed to generate CA: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		dnsNames string
+		uris     string
+		allowed  []string
+		wantErr  error
+	}{
+		{
+			name:     "own namespace",
+			dnsNames: "app-0,web.team.svc,web.team.svc.cluster.local",
+			uris:     "spiffe://cluster.local/ns/team/sa/app",
+		},
+		{
+			name:     "other namespace",
+			dnsNames: "web.team.svc,api.kube-system.svc",
+			wantErr:  storage.ErrUntrusted,
+		},
+		{
+			name:     "cluster service",
+			dnsNames: "kubernetes.default.svc",
+			wantErr:  storage.ErrUntrusted,
+		},
+		{
+			name:     "other service account",
+			dnsNames: "app-0",
+			uris:     "spiffe://cluster.local/ns/team/sa/admin",
+			wantErr:  storage.ErrUntrusted,
+		},
+		{
+			name:     "allowed by the driver",
+			dnsNames: "app.example.com",
+			uris:     "https://example.com/app",
+			allowed:  []string{"*.example.com", "https://example.com/*"},
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			fileSystem, err := storage.NewFilesystem(
+				zaptest.NewLogger(t),
+				t.TempDir(),
+				fstest.MapFS{},
+				mount.NewFakeMounter([]mount.MountPoint{}),
+				storage.WithCertificateIssuer(certificateAuthority),
+				storage.WithAllowedCertificateNames(testCase.allowed),
+			)
+			if err != nil {
+				t.Fatalf("failed to create filesystem: %v", err)
+			}
+
+			_, err = fileSystem.WriteVolume(context.Background(), "vol", map[string]string{
+				"csi-driver.mattslater.io/certificate":           "true",
+				"csi-driver.mattslater.io/certificate-dns-names": testCase.dnsNames,
+				"csi-driver.mattslater.io/certificate-uris":      testCase.uris,
+				"csi.storage.k8s.io/pod.name":                    "app-0",
+				"csi.storage.k8s.io/pod.namespace":               "team",
+				"csi.storage.k8s.io/serviceAccount.name":         "app",
+			}, nil)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Errorf("WriteVolume() error = %v, want %v", err, testCase.wantErr)
+			}
 		})
 	}
 }
 
+func TestFilesystem_WriteVolume_CertificateNotConfigured(t *testing.T) {
+	t.Parallel()
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", map[string]string{
+		"csi-driver.mattslater.io/certificate": "true",
+	}, nil)
+	if !errors.Is(err, storage.ErrNotConfigured) {
+		t.Errorf("WriteVolume() error = %v, want %v", err, storage.ErrNotConfigured)
+	}
+}
+
+func TestFilesystem_WriteVolume_Generated(t *testing.T) {
+	t.Parallel()
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	vCtx := map[string]string{
+		"csi-driver.mattslater.io/generate": "password=password:12:digits,token=hex:8,id=uuid,ssh=ssh-ed25519",
+	}
+
+	read := func() map[string]string {
+		content := map[string]string{}
+
+		for _, path := range []string{"password", "token", "id", "ssh/id_ed25519", "ssh/id_ed25519.pub", "ssh/authorized_keys"} {
+			data, err := os.ReadFile(filepath.Join(fileSystem.PathForVolume("vol"), path))
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", path, err)
+			}
+
+			content[path] = string(data)
+		}
+
+		return content
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx, nil)
+	if err != nil {
+		t.Fatalf("unexpected error writing volume: %v", err)
+	}
+
+	first := read()
+
+	if len(first["password"]) != 12 || len(first["token"]) != 16 {
+		t.Errorf("unexpected generated lengths: %d, %d", len(first["password"]), len(first["token"]))
+	}
+
+	// values stay stable for the lifetime of the volume ID.
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx, nil)
+	if err != nil {
+		t.Fatalf("unexpected error rewriting volume: %v", err)
+	}
+
+	if second := read(); !reflect.DeepEqual(first, second) {
+		t.Error("generated values changed on rewrite")
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "other", map[string]string{
+		"csi-driver.mattslater.io/generate": "password=password:12:emoji",
+	}, nil)
+	if !errors.Is(err, storage.ErrInvalidAttribute) {
+		t.Errorf("WriteVolume() error = %v, want %v", err, storage.ErrInvalidAttribute)
+	}
+}
+
+func TestFilesystem_WriteVolume_Structured(t *testing.T) {
+	t.Parallel()
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", map[string]string{
+		"csi-driver.mattslater.io/values":          `{"db": {"host": "db.local"}}`,
+		"csi-driver.mattslater.io/value.LOG_LEVEL": "debug",
+		"csi-driver.mattslater.io/render":          "json,env=app/app.env:0600",
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error writing volume: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"config.json": "{\n  \"LOG_LEVEL\": \"debug\",\n  \"db\": {\n    \"host\": \"db.local\"\n  }\n}\n",
+		"app/app.env": "LOG_LEVEL=\"debug\"\ndb_host=\"db.local\"\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(fileSystem.PathForVolume("vol"), name))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+		}
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "invalid", map[string]string{
+		"csi-driver.mattslater.io/values": `{"servers": ["a", "b"]}`,
+		"csi-driver.mattslater.io/render": "properties",
+	}, nil)
+	if !errors.Is(err, storage.ErrInvalidAttribute) || !strings.Contains(err.Error(), `"servers"`) {
+		t.Errorf("WriteVolume() error = %v, want %v naming the key", err, storage.ErrInvalidAttribute)
+	}
+}
+
+func TestFilesystem_WriteVolume_Synthetic(t *testing.T) {
+	t.Parallel()
+
+	vCtx := map[string]string{
+		"csi-driver.mattslater.io/source":           "synthetic",
+		"csi-driver.mattslater.io/synthetic-files":  "8",
+		"csi-driver.mattslater.io/synthetic-size":   "1Ki-4Ki",
+		"csi-driver.mattslater.io/synthetic-fanout": "4",
+		"csi-driver.mattslater.io/synthetic-seed":   "7",
+	}
+
+	unlimited, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	_, err = unlimited.WriteVolume(context.Background(), "vol", vCtx, nil)
+	if !errors.Is(err, storage.ErrNotConfigured) {
+		t.Errorf("WriteVolume() error = %v, want %v", err, storage.ErrNotConfigured)
+	}
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithMaxVolumeSize(64<<10),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx, nil)
+	if err != nil {
+		t.Fatalf("unexpected error writing volume: %v", err)
+	}
+
+	manifest, err := os.ReadFile(filepath.Join(fileSystem.PathForVolume("vol"), "SHA256SUMS"))
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(manifest)), "\n")
+	if len(lines) != 8 {
+		t.Fatalf("manifest has %d entries, want 8", len(lines))
+	}
+
+	for _, line := range lines {
+		sum, path, _ := strings.Cut(line, "  ")
+
+		data, err := os.ReadFile(filepath.Join(fileSystem.PathForVolume("vol"), path))
+		if err != nil {
+			t.Errorf("failed to read %s: %v", path, err)
+
+			continue
+		}
+
+		if digest := sha256.Sum256(data); hex.EncodeToString(digest[:]) != sum {
+			t.Errorf("checksum of %s does not match the manifest", path)
+		}
+	}
+
+	// republishing keeps the dataset, another seed generates a new one.
+	for _, step := range []struct {
+		seed    string
+		changed bool
+	}{
+		{seed: "7", changed: false},
+		{seed: "8", changed: true},
+	} {
+		vCtx["csi-driver.mattslater.io/synthetic-seed"] = step.seed
+
+		_, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx, nil)
+		if err != nil {
+			t.Fatalf("unexpected error republishing volume: %v", err)
+		}
+
+		republished, err := os.ReadFile(filepath.Join(fileSystem.PathForVolume("vol"), "SHA256SUMS"))
+		if err != nil || (string(republished) != string(manifest)) != step.changed {
+			t.Errorf("manifest changed = %v with seed %s, want %v: %v",
+				string(republished) != string(manifest), step.seed, step.changed, err)
+		}
+	}
+
+	vCtx["csi-driver.mattslater.io/synthetic-files"] = "100"
+
+	_, err = fileSystem.WriteVolume(context.Background(), "large", vCtx, nil)
+	if !errors.Is(err, storage.ErrInvalidAttribute) {
+		t.Errorf("WriteVolume() error = %v, want %v", err, storage.ErrInvalidAttribute)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "inline", map[string]string{
+		"csi-driver.mattslater.io/filename": "big.txt",
+		"csi-driver.mattslater.io/data":     strings.Repeat("x", 65<<10),
+	}, nil)
+	if !errors.Is(err, storage.ErrInvalidAttribute) {
+		t.Errorf("WriteVolume() error = %v, want the volume size limit to apply", err)
+	}
+}
+
+// fakeCommands answers commands with canned outputs and errors.
+type fakeCommands struct {
+	outputs map[string]*command.Output
+	errs    map[string]error
+	runs    atomic.Int32
+}
+
+func (c *fakeCommands) Run(_ context.Context, name string, req *command.Request) (*command.Output, error) {
+	c.runs.Add(1)
+
+	if _, ok := req.Attributes["csi.storage.k8s.io/serviceAccount.tokens"]; ok {
+		return nil, fmt.Errorf("command %s got the service account tokens", name)
+	}
+
+	if err, ok := c.errs[name]; ok {
+		return nil, err
+	}
+
+	output, ok := c.outputs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", command.ErrUnknownCommand, name)
+	}
+
+	return output, nil
+}
+
+func TestFilesystem_WriteVolume_Command(t *testing.T) {
+	t.Parallel()
+
+	commands := &fakeCommands{
+		outputs: map[string]*command.Output{
+			"stdout": {Stdout: []byte("generated")},
+			"dir": {Dir: true, Files: []command.File{
+				{Path: "conf/app.conf", Mode: 0o600, Data: []byte("a=b")},
+			}},
+			"escape": {Dir: true, Files: []command.File{{Path: "../escape", Mode: 0o644}}},
+		},
+		errs: map[string]error{
+			"usage":    &command.ExitError{Command: "usage", Code: 64},
+			"tempfail": &command.ExitError{Command: "tempfail", Code: 75},
+			"noperm":   &command.ExitError{Command: "noperm", Code: 77},
+			"crash":    &command.ExitError{Command: "crash", Code: 1},
+			"slow":     command.ErrTimeout,
+		},
+	}
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithCommands(commands),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		command  string
+		filename string
+		want     map[string]string
+		wantErr  error
+	}{
+		{name: "stdout", command: "stdout", filename: "out.txt", want: map[string]string{"out.txt": "generated"}},
+		{name: "dir", command: "dir", want: map[string]string{"conf/app.conf": "a=b"}},
+		{name: "stdout without filename", command: "stdout", wantErr: storage.ErrInvalidAttribute},
+		{name: "dir with filename", command: "dir", filename: "out.txt", wantErr: storage.ErrInvalidAttribute},
+		{name: "escaping output", command: "escape", wantErr: storage.ErrInvalidAttribute},
+		{name: "unknown command", command: "missing", wantErr: storage.ErrInvalidAttribute},
+		{name: "usage", command: "usage", wantErr: storage.ErrInvalidAttribute},
+		{name: "temporary failure", command: "tempfail", wantErr: storage.ErrUnavailable},
+		{name: "no permission", command: "noperm", wantErr: storage.ErrUntrusted},
+		{name: "timeout", command: "slow", wantErr: storage.ErrUnavailable},
+		{name: "crash", command: "crash", wantErr: &command.ExitError{}},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			id := strings.ReplaceAll(testCase.name, " ", "-")
+			vCtx := map[string]string{
+				"csi-driver.mattslater.io/source":          "command",
+				"csi-driver.mattslater.io/command":         testCase.command,
+				"csi.storage.k8s.io/serviceAccount.tokens": "{}",
+			}
+
+			if testCase.filename != "" {
+				vCtx["csi-driver.mattslater.io/filename"] = testCase.filename
+			}
+
+			_, err := fileSystem.WriteVolume(context.Background(), id, vCtx, nil)
+
+			var exitErr *command.ExitError
+
+			switch {
+			case testCase.wantErr == nil:
+				if err != nil {
+					t.Fatalf("unexpected error writing volume: %v", err)
+				}
+			case errors.As(testCase.wantErr, &exitErr):
+				if !errors.As(err, &exitErr) || errors.Is(err, storage.ErrInvalidAttribute) {
+					t.Errorf("WriteVolume() error = %v, want a plain exit error", err)
+				}
+
+				return
+			default:
+				if !errors.Is(err, testCase.wantErr) {
+					t.Errorf("WriteVolume() error = %v, want %v", err, testCase.wantErr)
+				}
+
+				return
+			}
+
+			for path, want := range testCase.want {
+				data, err := os.ReadFile(filepath.Join(fileSystem.PathForVolume(id), path))
+				if err != nil || string(data) != want {
+					t.Errorf("%s = %q, %v, want %q", path, data, err, want)
+				}
+			}
+		})
+	}
+
+	unconfigured, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	_, err = unconfigured.WriteVolume(context.Background(), "vol", map[string]string{
+		"csi-driver.mattslater.io/source":  "command",
+		"csi-driver.mattslater.io/command": "dir",
+	}, nil)
+	if !errors.Is(err, storage.ErrNotConfigured) {
+		t.Errorf("WriteVolume() error = %v, want %v", err, storage.ErrNotConfigured)
+	}
+}
+
+func TestFilesystem_WriteVolume_IdentityToken(t *testing.T) {
+	t.Parallel()
+
+	issuer, err := jwt.GenerateIssuer("test-issuer")
+	if err != nil {
+		t.Fatalf("failed to create issuer: %v", err)
+	}
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithTokenIssuer(issuer),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	vCtx := map[string]string{
+		"csi-driver.mattslater.io/identity-token":           "identity/token",
+		"csi-driver.mattslater.io/identity-token-audiences": "vault,api",
+		"csi-driver.mattslater.io/identity-jwks":            "identity/jwks.json",
+		"csi.storage.k8s.io/pod.namespace":                  "default",
+		"csi.storage.k8s.io/serviceAccount.name":            "app",
+		"csi.storage.k8s.io/pod.name":                       "app-1",
+		"csi.storage.k8s.io/pod.uid":                        "uid-1",
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx, nil)
+	if err != nil {
+		t.Fatalf("unexpected error writing volume: %v", err)
+	}
+
+	tokenPath := filepath.Join(fileSystem.PathForVolume("vol"), "identity/token")
+
+	token, err := os.ReadFile(tokenPath)
+	if err != nil {
+		t.Fatalf("failed to read token: %v", err)
+	}
+
+	claims, err := issuer.Verify(token)
+	if err != nil {
+		t.Fatalf("failed to verify token: %v", err)
+	}
+
+	if claims.Subject != "system:serviceaccount:default:app" || !reflect.DeepEqual(claims.Audience, []string{"vault", "api"}) {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	jwks, err := os.ReadFile(filepath.Join(fileSystem.PathForVolume("vol"), "identity/jwks.json"))
+	if err != nil || string(jwks) != string(issuer.JWKS()) {
+		t.Errorf("unexpected JWKS file: %s, %v", jwks, err)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx, nil)
+	if err != nil {
+		t.Fatalf("unexpected error rewriting volume: %v", err)
+	}
+
+	again, err := os.ReadFile(tokenPath)
+	if err != nil || string(again) != string(token) {
+		t.Error("token was reissued before renewal was due")
+	}
+
+	// a pod reusing the volume ID gets a token of its own.
+	vCtx["csi.storage.k8s.io/pod.uid"] = "uid-2"
+
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx, nil)
+	if err != nil {
+		t.Fatalf("unexpected error rewriting volume for another pod: %v", err)
+	}
+
+	token, err = os.ReadFile(tokenPath)
+	if err != nil {
+		t.Fatalf("failed to read token: %v", err)
+	}
+
+	claims, err = issuer.Verify(token)
+	if err != nil || claims.PodUID != "uid-2" {
+		t.Errorf("token for another pod has claims %+v, %v, want pod UID uid-2", claims, err)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "no-pod-info", map[string]string{
+		"csi-driver.mattslater.io/identity-token": "token",
+	}, nil)
+	if !errors.Is(err, storage.ErrInvalidAttribute) {
+		t.Errorf("WriteVolume() error = %v, want %v", err, storage.ErrInvalidAttribute)
+	}
+}
+
+func TestFilesystem_WriteVolume_EncryptedData(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	sealed, err := seal.Seal(key.PublicKey(), []byte("top secret"), seal.Scope{Namespace: "team"})
+	if err != nil {
+		t.Fatalf("failed to seal data: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    []storage.Option
+		vCtx    map[string]string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "decrypts encrypted data",
+			opts: []storage.Option{storage.WithUnsealer(seal.NewOpener(key))},
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/filename":       "secret.txt",
+				"csi-driver.mattslater.io/encrypted-data": sealed,
+				"csi.storage.k8s.io/pod.namespace":        "team",
+			},
+			want: "top secret",
+		},
+		{
+			name: "sealed for another namespace",
+			opts: []storage.Option{storage.WithUnsealer(seal.NewOpener(key))},
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/filename":       "secret.txt",
+				"csi-driver.mattslater.io/encrypted-data": sealed,
+				"csi.storage.k8s.io/pod.namespace":        "other",
+			},
+			wantErr: storage.ErrInvalidAttribute,
+		},
+		{
+			name: "data and encrypted data",
+			opts: []storage.Option{storage.WithUnsealer(seal.NewOpener(key))},
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/filename":       "secret.txt",
+				"csi-driver.mattslater.io/data":           "plain",
+				"csi-driver.mattslater.io/encrypted-data": sealed,
+			},
+			wantErr: storage.ErrInvalidAttribute,
+		},
+		{
+			name: "corrupted encrypted data",
+			opts: []storage.Option{storage.WithUnsealer(seal.NewOpener(key))},
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/filename":       "secret.txt",
+				"csi-driver.mattslater.io/encrypted-data": sealed[:len(sealed)-8],
+				"csi.storage.k8s.io/pod.namespace":        "team",
+			},
+			wantErr: storage.ErrInvalidAttribute,
+		},
+		{
+			name: "no sealing key",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/filename":       "secret.txt",
+				"csi-driver.mattslater.io/encrypted-data": sealed,
+			},
+			wantErr: storage.ErrNotConfigured,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			fileSystem, err := storage.NewFilesystem(
+				zaptest.NewLogger(t),
+				t.TempDir(),
+				fstest.MapFS{},
+				mount.NewFakeMounter([]mount.MountPoint{}),
+				testCase.opts...,
+			)
+			if err != nil {
+				t.Fatalf("failed to create filesystem: %v", err)
+			}
+
+			_, err = fileSystem.WriteVolume(context.Background(), "vol", testCase.vCtx, nil)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("WriteVolume() error = %v, want %v", err, testCase.wantErr)
+			}
+
+			if testCase.wantErr != nil {
+				return
+			}
+
+			data, err := os.ReadFile(filepath.Join(fileSystem.PathForVolume("vol"), "secret.txt"))
+			if err != nil || string(data) != testCase.want {
+				t.Errorf("secret.txt = %q, %v, want %q", data, err, testCase.want)
+			}
+		})
+	}
+}
+
+func TestFilesystem_WriteVolume_Signature(t *testing.T) {
+	t.Parallel()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	verifier, err := signature.NewVerifier(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+
+	// signatures cover the length prefixed path and the content.
+	signed := base64.StdEncoding.EncodeToString(ed25519.Sign(private, []byte("6:configtrusted")))
+	// values are signed in their canonical form, placed at the render
+	// attribute.
+	signedValues := base64.StdEncoding.EncodeToString(
+		ed25519.Sign(private, []byte(`10:properties{"host":"db.local","level":"debug"}`)),
+	)
+
+	tests := []struct {
+		name     string
+		required bool
+		vCtx     map[string]string
+		wantErr  error
+	}{
+		{
+			name: "valid signature",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/filename":       "config",
+				"csi-driver.mattslater.io/data":           "trusted",
+				"csi-driver.mattslater.io/data-signature": signed,
+			},
+		},
+		{
+			name: "signature over other data",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/filename":       "config",
+				"csi-driver.mattslater.io/data":           "injected",
+				"csi-driver.mattslater.io/data-signature": signed,
+			},
+			wantErr: storage.ErrUntrusted,
+		},
+		{
+			name: "signature for another filename",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/filename":       "other",
+				"csi-driver.mattslater.io/data":           "trusted",
+				"csi-driver.mattslater.io/data-signature": signed,
+			},
+			wantErr: storage.ErrUntrusted,
+		},
+		{
+			name: "unsigned content allowed",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/filename": "config",
+				"csi-driver.mattslater.io/data":     "anything",
+			},
+		},
+		{
+			name:     "unsigned content required",
+			required: true,
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/filename": "config",
+				"csi-driver.mattslater.io/data":     "anything",
+			},
+			wantErr: storage.ErrUntrusted,
+		},
+		{
+			name:     "unsignable source required",
+			required: true,
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/source":         "synthetic",
+				"csi-driver.mattslater.io/synthetic-size": "1024",
+			},
+			wantErr: storage.ErrUntrusted,
+		},
+		{
+			name:     "signed file with generated values required",
+			required: true,
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/filename":       "config",
+				"csi-driver.mattslater.io/data":           "trusted",
+				"csi-driver.mattslater.io/data-signature": signed,
+				"csi-driver.mattslater.io/generate":       "password",
+			},
+			wantErr: storage.ErrUntrusted,
+		},
+		{
+			name:     "signed values",
+			required: true,
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/values":         `{"host": "db.local"}`,
+				"csi-driver.mattslater.io/value.level":    "debug",
+				"csi-driver.mattslater.io/render":         "properties",
+				"csi-driver.mattslater.io/data-signature": signedValues,
+			},
+		},
+		{
+			name:     "signed values with injected value",
+			required: true,
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/values":         `{"host": "db.local"}`,
+				"csi-driver.mattslater.io/value.level":    "debug",
+				"csi-driver.mattslater.io/value.evil":     "injected",
+				"csi-driver.mattslater.io/render":         "properties",
+				"csi-driver.mattslater.io/data-signature": signedValues,
+			},
+			wantErr: storage.ErrUntrusted,
+		},
+		{
+			name:     "unsigned values required",
+			required: true,
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/value.evil": "injected",
+				"csi-driver.mattslater.io/render":     "properties",
+			},
+			wantErr: storage.ErrUntrusted,
+		},
+		{
+			name:     "signed file with values required",
+			required: true,
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/filename":       "config",
+				"csi-driver.mattslater.io/data":           "trusted",
+				"csi-driver.mattslater.io/data-signature": signed,
+				"csi-driver.mattslater.io/value.evil":     "injected",
+				"csi-driver.mattslater.io/render":         "properties",
+			},
+			wantErr: storage.ErrUntrusted,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			fileSystem, err := storage.NewFilesystem(
+				zaptest.NewLogger(t),
+				t.TempDir(),
+				fstest.MapFS{},
+				mount.NewFakeMounter([]mount.MountPoint{}),
+				storage.WithSignatureVerifier(verifier, testCase.required),
+			)
+			if err != nil {
+				t.Fatalf("failed to create filesystem: %v", err)
+			}
+
+			_, err = fileSystem.WriteVolume(context.Background(), "vol", testCase.vCtx, nil)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Errorf("WriteVolume() error = %v, want %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilesystem_WriteVolume_FilePolicy(t *testing.T) {
+	t.Parallel()
+
+	rules, err := policy.Parse([]byte(`{
+		"rules": [
+			{"name": "signed", "match": {"namespaces": ["signed"]}, "requireSignature": true},
+			{"name": "configs", "filenames": ["*.conf"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		vCtx    map[string]string
+		wantErr error
+	}{
+		{
+			name: "matching files",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/filename":      "app.conf",
+				"csi-driver.mattslater.io/data":          "a=b",
+				"csi-driver.mattslater.io/values":        `{"a": "b"}`,
+				"csi-driver.mattslater.io/render":        "properties=values.conf",
+				"csi-driver.mattslater.io/expires-after": "1h",
+			},
+		},
+		{
+			name: "generated file",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/filename": "app.conf",
+				"csi-driver.mattslater.io/data":     "a=b",
+				"csi-driver.mattslater.io/generate": "password=password",
+			},
+			wantErr: policy.ErrDenied,
+		},
+		{
+			name: "rendered file",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/values": `{"a": "b"}`,
+				"csi-driver.mattslater.io/render": "properties=values.sh",
+			},
+			wantErr: policy.ErrDenied,
+		},
+		{
+			name: "unsigned content",
+			vCtx: map[string]string{
+				"csi.storage.k8s.io/pod.namespace":  "signed",
+				"csi-driver.mattslater.io/filename": "app.conf",
+				"csi-driver.mattslater.io/data":     "a=b",
+			},
+			wantErr: storage.ErrUntrusted,
+		},
+		{
+			name: "unsignable content",
+			vCtx: map[string]string{
+				"csi.storage.k8s.io/pod.namespace":  "signed",
+				"csi-driver.mattslater.io/generate": "password=password",
+			},
+			wantErr: storage.ErrUntrusted,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			fileSystem, err := storage.NewFilesystem(
+				zaptest.NewLogger(t),
+				t.TempDir(),
+				fstest.MapFS{},
+				mount.NewFakeMounter([]mount.MountPoint{}),
+				storage.WithFilePolicy(rules),
+			)
+			if err != nil {
+				t.Fatalf("failed to create filesystem: %v", err)
+			}
+
+			_, err = fileSystem.WriteVolume(context.Background(), "vol", testCase.vCtx, nil)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Errorf("WriteVolume() error = %v, want %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilesystem_WriteVolume_URL(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+
+		_, _ = w.Write([]byte("downloaded"))
+	}))
+	defer server.Close()
+
+	allowed, err := fetch.ParseAllowlist([]string{"127.0.0.1/32"})
+	if err != nil {
+		t.Fatalf("failed to parse allowlist: %v", err)
+	}
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithFetcher(fetch.NewFetcher(zaptest.NewLogger(t), allowed, 1<<20, time.Second, 0, time.Millisecond)),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	vCtx := map[string]string{
+		"csi-driver.mattslater.io/filename": "data.txt",
+		"csi-driver.mattslater.io/source":   "url",
+		"csi-driver.mattslater.io/url":      server.URL,
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx, nil)
+		if err != nil {
+			t.Fatalf("unexpected error writing volume: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(fileSystem.PathForVolume("vol"), "data.txt"))
+	if err != nil || string(data) != "downloaded" {
+		t.Errorf("data.txt = %q, %v, want %q", data, err, "downloaded")
+	}
+
+	// republishing keeps the downloaded content.
+	if requests.Load() != 1 {
+		t.Errorf("content was downloaded %d times, want 1", requests.Load())
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "unreachable", map[string]string{
+		"csi-driver.mattslater.io/filename":   "data.txt",
+		"csi-driver.mattslater.io/source":     "url",
+		"csi-driver.mattslater.io/url":        server.URL + "/../../../unreachable",
+		"csi-driver.mattslater.io/url-sha256": strings.Repeat("0", 64),
+	}, nil)
+	if !errors.Is(err, storage.ErrUntrusted) {
+		t.Errorf("WriteVolume() error = %v, want %v", err, storage.ErrUntrusted)
+	}
+}
+
+// writeArtifactLayout writes an image layout below dir holding a single file
+// as an artifact layer.
+func writeArtifactLayout(t *testing.T, dir string, name string, content string) {
+	t.Helper()
+
+	writeBlob := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		encoded := hex.EncodeToString(sum[:])
+
+		err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0o755)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, "blobs", "sha256", encoded), data, 0o644)
+		}
+
+		if err != nil {
+			t.Fatalf("failed to write blob: %v", err)
+		}
+
+		return "sha256:" + encoded
+	}
+
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"layers":[{"mediaType":"text/plain",`+
+		`"digest":%q,"size":%d,"annotations":{"org.opencontainers.image.title":%q}}]}`,
+		writeBlob([]byte(content)), len(content), name))
+
+	index := fmt.Sprintf(`{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json",`+
+		`"digest":%q,"size":%d,"annotations":{"org.opencontainers.image.ref.name":"v1"}}]}`,
+		writeBlob(manifest), len(manifest))
+
+	err := os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0o644)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "index.json"), []byte(index), 0o644)
+	}
+
+	if err != nil {
+		t.Fatalf("failed to write layout: %v", err)
+	}
+}
+
+func TestFilesystem_WriteVolume_OCI(t *testing.T) {
+	t.Parallel()
+
+	layoutsDir := t.TempDir()
+	writeArtifactLayout(t, filepath.Join(layoutsDir, "reference-data"), "data/table.csv", "a,b")
+
+	contentCache, err := cache.New(zaptest.NewLogger(t), t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithContentCache(contentCache),
+		storage.WithOCILayouts(layoutsDir, 1<<20),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		vCtx    map[string]string
+		want    map[string]string
+		wantErr error
+	}{
+		{
+			name: "whole image",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/source":        "oci",
+				"csi-driver.mattslater.io/oci-layout":    "reference-data",
+				"csi-driver.mattslater.io/oci-reference": "v1",
+			},
+			want: map[string]string{"data/table.csv": "a,b"},
+		},
+		{
+			name: "subpath",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/source":        "oci",
+				"csi-driver.mattslater.io/oci-layout":    "reference-data",
+				"csi-driver.mattslater.io/oci-reference": "v1",
+				"csi-driver.mattslater.io/oci-subpath":   "data",
+			},
+			want: map[string]string{"table.csv": "a,b"},
+		},
+		{
+			name: "missing layout",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/source":     "oci",
+				"csi-driver.mattslater.io/oci-layout": "missing",
+			},
+			wantErr: storage.ErrUnavailable,
+		},
+		{
+			name: "layout outside layouts dir",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/source":     "oci",
+				"csi-driver.mattslater.io/oci-layout": "../reference-data",
+			},
+			wantErr: storage.ErrInvalidAttribute,
+		},
+		{
+			name: "missing subpath",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/source":        "oci",
+				"csi-driver.mattslater.io/oci-layout":    "reference-data",
+				"csi-driver.mattslater.io/oci-reference": "v1",
+				"csi-driver.mattslater.io/oci-subpath":   "other",
+			},
+			wantErr: storage.ErrInvalidAttribute,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			id := strings.ReplaceAll(testCase.name, " ", "-")
+
+			_, err := fileSystem.WriteVolume(context.Background(), id, testCase.vCtx, nil)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("WriteVolume() error = %v, want %v", err, testCase.wantErr)
+			}
+
+			for name, want := range testCase.want {
+				data, err := os.ReadFile(filepath.Join(fileSystem.PathForVolume(id), name))
+				if err != nil || string(data) != want {
+					t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFilesystem_WriteVolume_Provider(t *testing.T) {
+	t.Parallel()
+
+	providersDir := t.TempDir()
+
+	listener, err := net.Listen("unix", filepath.Join(providersDir, "podinfo.sock"))
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	server := provider.NewGRPCServer(&podinfo.Provider{})
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	t.Cleanup(server.Stop)
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithProviders(provider.NewRegistry(providersDir)),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		vCtx    map[string]string
+		secrets map[string]string
+		want    map[string]fs.FileMode
+		wantErr error
+	}{
+		{
+			name: "provider files",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/source":   "provider",
+				"csi-driver.mattslater.io/provider": "podinfo",
+				"csi.storage.k8s.io/pod.name":       "web-0",
+			},
+			secrets: map[string]string{"password": "hunter2"},
+			want: map[string]fs.FileMode{
+				"pod/name":         0o644,
+				"secrets/password": 0o400,
+			},
+		},
+		{
+			name: "provider rejects request",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/source":   "provider",
+				"csi-driver.mattslater.io/provider": "podinfo",
+			},
+			secrets: map[string]string{"../password": "hunter2"},
+			wantErr: storage.ErrInvalidAttribute,
+		},
+		{
+			name: "provider not running",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/source":   "provider",
+				"csi-driver.mattslater.io/provider": "missing",
+			},
+			wantErr: storage.ErrUnavailable,
+		},
+		{
+			name: "missing provider name",
+			vCtx: map[string]string{
+				"csi-driver.mattslater.io/source": "provider",
+			},
+			wantErr: storage.ErrInvalidAttribute,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			id := strings.ReplaceAll(testCase.name, " ", "-")
+
+			_, err := fileSystem.WriteVolume(context.Background(), id, testCase.vCtx, testCase.secrets)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("WriteVolume() error = %v, want %v", err, testCase.wantErr)
+			}
+
+			for name, mode := range testCase.want {
+				info, err := os.Stat(filepath.Join(fileSystem.PathForVolume(id), name))
+				if err != nil {
+					t.Errorf("failed to stat %s: %v", name, err)
+
+					continue
+				}
+
+				if info.Mode().Perm() != mode {
+					t.Errorf("%s mode = %v, want %v", name, info.Mode().Perm(), mode)
+				}
+			}
+		})
+	}
+}
+
+func TestFilesystem_RefreshBundle(t *testing.T) {
+	t.Parallel()
+
+	bundlesDir := t.TempDir()
+	bundleFile := filepath.Join(bundlesDir, "ca", "ca.crt")
+
+	err := os.MkdirAll(filepath.Dir(bundleFile), 0o755)
+	if err == nil {
+		err = os.WriteFile(bundleFile, []byte("v1"), 0o644)
+	}
+
+	if err != nil {
+		t.Fatalf("failed to write bundle: %v", err)
+	}
+
+	store, err := bundle.NewStore(zaptest.NewLogger(t), bundlesDir)
+	if err != nil {
+		t.Fatalf("failed to create bundle store: %v", err)
+	}
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithBundles(store),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", map[string]string{
+		"csi-driver.mattslater.io/filename": "data.txt",
+		"csi-driver.mattslater.io/data":     "inline",
+		"csi-driver.mattslater.io/bundle":   "ca=certs",
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error writing volume: %v", err)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "missing", map[string]string{
+		"csi-driver.mattslater.io/bundle": "missing",
+	}, nil)
+	if !errors.Is(err, storage.ErrUnavailable) {
+		t.Errorf("WriteVolume() error = %v, want %v", err, storage.ErrUnavailable)
+	}
+
+	err = os.WriteFile(bundleFile, []byte("v2"), 0o644)
+	if err != nil {
+		t.Fatalf("failed to update bundle: %v", err)
+	}
+
+	changed, err := store.Reload()
+	if err != nil || len(changed) != 1 {
+		t.Fatalf("Reload() = %v, %v, want the changed bundle", changed, err)
+	}
+
+	err = fileSystem.RefreshBundle(context.Background(), changed[0])
+	if err != nil {
+		t.Fatalf("RefreshBundle() error = %v", err)
+	}
+
+	for name, want := range map[string]string{"certs/ca.crt": "v2", "data.txt": "inline"} {
+		data, err := os.ReadFile(filepath.Join(fileSystem.PathForVolume("vol"), name))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+		}
+	}
+
+	versions, err := fileSystem.BundleVersions()
+	if err != nil || versions["vol"]["ca"] != changed[0].Version {
+		t.Errorf("BundleVersions() = %v, %v, want vol on %s", versions, err, changed[0].Version)
+	}
+}
+
+func TestFilesystem_RefreshVolume(t *testing.T) {
+	t.Parallel()
+
+	certificateAuthority, err := ca.Generate("test-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("failed to generate CA: %v", err)
+	}
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithCertificateIssuer(certificateAuthority),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	// the certificate is due for renewal right away, the secret can only be
+	// carried over.
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", map[string]string{
+		"csi-driver.mattslater.io/certificate":          "true",
+		"csi-driver.mattslater.io/certificate-duration": "1s",
+		"csi-driver.mattslater.io/secrets":              "password",
+		"csi.storage.k8s.io/pod.name":                   "app-0",
+		"csi.storage.k8s.io/pod.namespace":              "default",
+	}, map[string]string{"password": "hunter2"})
+	if err != nil {
+		t.Fatalf("unexpected error writing volume: %v", err)
+	}
+
+	datapath := fileSystem.PathForVolume("vol")
+
+	first, err := os.ReadFile(filepath.Join(datapath, "tls.crt"))
+	if err != nil {
+		t.Fatalf("failed to read certificate: %v", err)
+	}
+
+	due, err := fileSystem.DueVolumes(time.Now())
+	if err != nil || !reflect.DeepEqual(due, []string{"vol"}) {
+		t.Fatalf("DueVolumes() = %v, %v, want [vol]", due, err)
+	}
+
+	err = fileSystem.RefreshVolume(context.Background(), "vol")
+	if err != nil {
+		t.Fatalf("RefreshVolume() error = %v", err)
+	}
+
+	second, err := os.ReadFile(filepath.Join(datapath, "tls.crt"))
+	if err != nil || string(first) == string(second) {
+		t.Errorf("certificate was not renewed: %v", err)
+	}
+
+	password, err := os.ReadFile(filepath.Join(datapath, "password"))
+	if err != nil || string(password) != "hunter2" {
+		t.Errorf("password = %q, %v, want it carried over", password, err)
+	}
+
+	volumeStatus, err := fileSystem.VolumeStatus("vol")
+	if err != nil || volumeStatus.Abnormal || volumeStatus.UsedBytes == 0 {
+		t.Errorf("VolumeStatus() = %+v, %v, want a healthy volume", volumeStatus, err)
+	}
+
+	// losing published content makes refreshing fail until the next publish.
+	err = os.Remove(filepath.Join(datapath, "..data", "password"))
+	if err != nil {
+		t.Fatalf("failed to remove password: %v", err)
+	}
+
+	err = fileSystem.RefreshVolume(context.Background(), "vol")
+	if !errors.Is(err, storage.ErrUnavailable) {
+		t.Errorf("RefreshVolume() error = %v, want %v", err, storage.ErrUnavailable)
+	}
+
+	volumeStatus, err = fileSystem.VolumeStatus("vol")
+	if err != nil || !volumeStatus.Abnormal || !strings.Contains(volumeStatus.Message, "failed 1 times") {
+		t.Errorf("VolumeStatus() = %+v, %v, want an abnormal volume", volumeStatus, err)
+	}
+
+	due, err = fileSystem.DueVolumes(time.Now())
+	if err != nil || len(due) != 0 {
+		t.Errorf("DueVolumes() = %v, %v, want the retry to back off", due, err)
+	}
+
+	_, err = fileSystem.VolumeStatus("missing")
+	if !errors.Is(err, storage.ErrVolumeNotFound) {
+		t.Errorf("VolumeStatus() error = %v, want %v", err, storage.ErrVolumeNotFound)
+	}
+}
+
+func TestFilesystem_WriteVolume_Expiry(t *testing.T) {
+	t.Parallel()
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "invalid", map[string]string{
+		"csi-driver.mattslater.io/expires-after": "-1h",
+	}, nil)
+	if !errors.Is(err, storage.ErrInvalidAttribute) {
+		t.Errorf("WriteVolume() error = %v, want %v", err, storage.ErrInvalidAttribute)
+	}
+
+	vCtx := map[string]string{
+		"csi-driver.mattslater.io/secrets":       "password",
+		"csi-driver.mattslater.io/expires-after": "50ms",
+		"csi-driver.mattslater.io/expiry-marker": "EXPIRED",
+	}
+	secrets := map[string]string{"password": "hunter2"}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx, secrets)
+	if err != nil {
+		t.Fatalf("unexpected error writing volume: %v", err)
+	}
+
+	datapath := fileSystem.PathForVolume("vol")
+
+	if _, err := os.Stat(filepath.Join(datapath, "password")); err != nil {
+		t.Fatalf("password missing before expiry: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	due, err := fileSystem.DueVolumes(time.Now())
+	if err != nil || !reflect.DeepEqual(due, []string{"vol"}) {
+		t.Fatalf("DueVolumes() = %v, %v, want [vol]", due, err)
+	}
+
+	err = fileSystem.RefreshVolume(context.Background(), "vol")
+	if err != nil {
+		t.Fatalf("RefreshVolume() error = %v", err)
+	}
+
+	// republishing must not bring the content back.
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx, secrets)
+	if err != nil {
+		t.Fatalf("unexpected error rewriting volume: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(datapath, "..data"))
+	if err != nil || len(entries) != 1 || entries[0].Name() != "EXPIRED" {
+		t.Errorf("content after expiry = %v, %v, want only the marker", entries, err)
+	}
+
+	volumeStatus, err := fileSystem.VolumeStatus("vol")
+	if err != nil || !volumeStatus.Abnormal || !strings.HasPrefix(volumeStatus.Message, "content expired") {
+		t.Errorf("VolumeStatus() = %+v, %v, want expired", volumeStatus, err)
+	}
+
+	due, err = fileSystem.DueVolumes(time.Now())
+	if err != nil || len(due) != 0 {
+		t.Errorf("DueVolumes() = %v, %v, want nothing left to refresh", due, err)
+	}
+}
+
+func TestFilesystem_VerifyVolume(t *testing.T) {
+	t.Parallel()
+
+	for _, repair := range []bool{false, true} {
+		repair := repair
+
+		t.Run(fmt.Sprintf("repair %v", repair), func(t *testing.T) {
+			t.Parallel()
+
+			var opts []storage.Option
+			if repair {
+				opts = append(opts, storage.WithDriftRepair())
+			}
+
+			fileSystem, err := storage.NewFilesystem(
+				zaptest.NewLogger(t),
+				t.TempDir(),
+				fstest.MapFS{},
+				mount.NewFakeMounter([]mount.MountPoint{}),
+				opts...,
+			)
+			if err != nil {
+				t.Fatalf("failed to create filesystem: %v", err)
+			}
+
+			vCtx := map[string]string{
+				"csi-driver.mattslater.io/filename": "conf/app.conf",
+				"csi-driver.mattslater.io/data":     "a=b",
+			}
+
+			_, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx, nil)
+			if err != nil {
+				t.Fatalf("unexpected error writing volume: %v", err)
+			}
+
+			drift, err := fileSystem.VerifyVolume(context.Background(), "vol")
+			if err != nil || drift.Detected() {
+				t.Fatalf("VerifyVolume() = %v, %v, want intact content", drift, err)
+			}
+
+			content := filepath.Join(fileSystem.PathForVolume("vol"), "..data")
+			for path, data := range map[string]string{"conf/app.conf": "a=evil", "extra": "x"} {
+				err := os.WriteFile(filepath.Join(content, path), []byte(data), 0o644)
+				if err != nil {
+					t.Fatalf("failed to tamper with volume: %v", err)
+				}
+			}
+
+			drift, err = fileSystem.VerifyVolume(context.Background(), "vol")
+			if err != nil || drift.String() != "modified: conf/app.conf; added: extra" || drift.Repaired != repair {
+				t.Fatalf("VerifyVolume() = %v (repaired %v), %v, want drift", drift, drift.Repaired, err)
+			}
+
+			volumeStatus, err := fileSystem.VolumeStatus("vol")
+			if err != nil || volumeStatus.Abnormal == repair {
+				t.Errorf("VolumeStatus() = %+v, %v, want abnormal %v", volumeStatus, err, !repair)
+			}
+
+			if !repair {
+				// republishing restores the content either way.
+				_, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx, nil)
+				if err != nil {
+					t.Fatalf("unexpected error republishing volume: %v", err)
+				}
+			}
+
+			data, err := os.ReadFile(filepath.Join(content, "conf/app.conf"))
+			if err != nil || string(data) != "a=b" {
+				t.Errorf("content = %q, %v, want it restored", data, err)
+			}
+
+			if _, err := os.Stat(filepath.Join(content, "extra")); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("added file is still there: %v", err)
+			}
+
+			drift, err = fileSystem.VerifyVolume(context.Background(), "vol")
+			if err != nil || drift.Detected() {
+				t.Errorf("VerifyVolume() after restoring = %v, %v, want intact content", drift, err)
+			}
+		})
+	}
+}
+
+func TestFilesystem_RollbackVolume(t *testing.T) {
+	t.Parallel()
+
+	baseDir := t.TempDir()
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		baseDir,
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithVersionHistory(2),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	for _, data := range []string{"a", "b", "c", "d"} {
+		_, err := fileSystem.WriteVolume(context.Background(), "vol", map[string]string{
+			"csi-driver.mattslater.io/filename": "file",
+			"csi-driver.mattslater.io/data":     data,
+		}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error writing volume: %v", err)
+		}
+	}
+
+	readContent := func() string {
+		data, err := os.ReadFile(filepath.Join(fileSystem.PathForVolume("vol"), "file"))
+		if err != nil {
+			t.Fatalf("failed to read volume: %v", err)
+		}
+
+		return string(data)
+	}
+
+	versions, err := fileSystem.VolumeVersions("vol")
+	if err != nil || len(versions) != 3 || !versions[0].Current || versions[1].Source != "republish" {
+		t.Fatalf("VolumeVersions() = %+v, %v, want the current and 2 kept versions", versions, err)
+	}
+
+	kept, err := os.ReadDir(filepath.Join(baseDir, "vol", "history"))
+	if err != nil || len(kept) != 2 {
+		t.Errorf("history dir = %v, %v, want 2 versions", kept, err)
+	}
+
+	restored, err := fileSystem.RollbackVolume("vol", "")
+	if err != nil || restored.Digest != versions[1].Digest || readContent() != "c" {
+		t.Fatalf("RollbackVolume() = %+v, %v, want the previous version", restored, err)
+	}
+
+	// the replaced version is kept, so the rollback can be undone.
+	_, err = fileSystem.RollbackVolume("vol", versions[0].Name)
+	if err != nil || readContent() != "d" {
+		t.Errorf("RollbackVolume(%s) error = %v, want the rolled back version", versions[0].Name, err)
+	}
+
+	_, err = fileSystem.RollbackVolume("vol", "..missing")
+	if !errors.Is(err, storage.ErrVersionNotFound) {
+		t.Errorf("RollbackVolume() error = %v, want %v", err, storage.ErrVersionNotFound)
+	}
+
+	drift, err := fileSystem.VerifyVolume(context.Background(), "vol")
+	if err != nil || drift.Detected() {
+		t.Errorf("VerifyVolume() after rollback = %v, %v, want intact content", drift, err)
+	}
+}
+
+func TestFilesystem_RollbackBundle(t *testing.T) {
+	t.Parallel()
+
+	bundlesDir := t.TempDir()
+	bundleFile := filepath.Join(bundlesDir, "ca", "ca.crt")
+
+	err := os.MkdirAll(filepath.Dir(bundleFile), 0o755)
+	if err == nil {
+		err = os.WriteFile(bundleFile, []byte("v1"), 0o644)
+	}
+
+	if err != nil {
+		t.Fatalf("failed to write bundle: %v", err)
+	}
+
+	store, err := bundle.NewStore(zaptest.NewLogger(t), bundlesDir)
+	if err != nil {
+		t.Fatalf("failed to create bundle store: %v", err)
+	}
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithBundles(store),
+		storage.WithVersionHistory(1),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	for _, id := range []string{"vol-1", "vol-2"} {
+		_, err = fileSystem.WriteVolume(context.Background(), id, map[string]string{
+			"csi-driver.mattslater.io/bundle": "ca=certs",
+		}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error writing volume: %v", err)
+		}
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "other", map[string]string{
+		"csi-driver.mattslater.io/filename": "file",
+		"csi-driver.mattslater.io/data":     "data",
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error writing volume: %v", err)
+	}
+
+	previous := store.List()[0].Version
+
+	err = os.WriteFile(bundleFile, []byte("v2"), 0o644)
+	if err != nil {
+		t.Fatalf("failed to update bundle: %v", err)
+	}
+
+	changed, err := store.Reload()
+	if err == nil {
+		err = fileSystem.RefreshBundle(context.Background(), changed[0])
+	}
+
+	if err != nil {
+		t.Fatalf("failed to update bundle: %v", err)
+	}
+
+	rolledBack, err := fileSystem.RollbackBundle("ca", "")
+	if err != nil || !reflect.DeepEqual(rolledBack, []string{"vol-1", "vol-2"}) {
+		t.Fatalf("RollbackBundle() = %v, %v, want both volumes", rolledBack, err)
+	}
+
+	for _, id := range rolledBack {
+		data, err := os.ReadFile(filepath.Join(fileSystem.PathForVolume(id), "certs", "ca.crt"))
+		if err != nil || string(data) != "v1" {
+			t.Errorf("%s certs/ca.crt = %q, %v, want v1", id, data, err)
+		}
+	}
+
+	versions, err := fileSystem.BundleVersions()
+	if err != nil || versions["vol-1"]["ca"] != previous {
+		t.Errorf("BundleVersions() = %v, %v, want vol-1 on %s", versions, err, previous)
+	}
+
+	_, err = fileSystem.RollbackBundle("ca", "unknown")
+	if !errors.Is(err, storage.ErrVersionNotFound) {
+		t.Errorf("RollbackBundle() error = %v, want %v", err, storage.ErrVersionNotFound)
+	}
+
+	readBundle := func(id string) string {
+		t.Helper()
+
+		data, err := os.ReadFile(filepath.Join(fileSystem.PathForVolume(id), "certs", "ca.crt"))
+		if err != nil {
+			t.Fatalf("failed to read %s certs/ca.crt: %v", id, err)
+		}
+
+		return string(data)
+	}
+
+	// the rollback holds while the bundle source still offers v2.
+	_, err = fileSystem.WriteVolume(context.Background(), "vol-1", map[string]string{
+		"csi-driver.mattslater.io/bundle": "ca=certs",
+	}, nil)
+	if err == nil {
+		err = fileSystem.RefreshVolume(context.Background(), "vol-1")
+	}
+
+	if err == nil {
+		err = fileSystem.RefreshBundle(context.Background(), changed[0])
+	}
+
+	if err != nil {
+		t.Fatalf("failed to republish volume: %v", err)
+	}
+
+	if got := readBundle("vol-1"); got != "v1" {
+		t.Errorf("vol-1 certs/ca.crt after republish = %q, want v1", got)
+	}
+
+	// rolling forward lifts the pin.
+	_, err = fileSystem.RollbackBundle("ca", changed[0].Version)
+	if err != nil {
+		t.Fatalf("RollbackBundle(%s) error = %v", changed[0].Version, err)
+	}
+
+	if got := readBundle("vol-2"); got != "v2" {
+		t.Errorf("vol-2 certs/ca.crt after rolling forward = %q, want v2", got)
+	}
+
+	_, err = fileSystem.RollbackBundle("ca", previous)
+	if err == nil {
+		err = os.WriteFile(bundleFile, []byte("v3"), 0o644)
+	}
+
+	if err != nil {
+		t.Fatalf("failed to roll back bundle: %v", err)
+	}
+
+	// a new version of the bundle replaces the rolled back one.
+	changed, err = store.Reload()
+	if err == nil {
+		err = fileSystem.RefreshBundle(context.Background(), changed[0])
+	}
+
+	if err == nil {
+		_, err = fileSystem.WriteVolume(context.Background(), "vol-1", map[string]string{
+			"csi-driver.mattslater.io/bundle": "ca=certs",
+		}, nil)
+	}
+
+	if err != nil {
+		t.Fatalf("failed to update bundle: %v", err)
+	}
+
+	for _, id := range rolledBack {
+		if got := readBundle(id); got != "v3" {
+			t.Errorf("%s certs/ca.crt after update = %q, want v3", id, got)
+		}
+	}
+}
+
+func TestFilesystem_ReleaseVolume(t *testing.T) {
+	t.Parallel()
+
+	newFilesystem := func(opts ...storage.Option) *storage.Filesystem {
+		fileSystem, err := storage.NewFilesystem(
+			zaptest.NewLogger(t),
+			t.TempDir(),
+			fstest.MapFS{},
+			mount.NewFakeMounter([]mount.MountPoint{}),
+			opts...,
+		)
+		if err != nil {
+			t.Fatalf("failed to create filesystem: %v", err)
+		}
+
+		return fileSystem
+	}
+
+	volume := func(namespace string, retention string) map[string]string {
+		return map[string]string{
+			"csi-driver.mattslater.io/filename":         "file",
+			"csi-driver.mattslater.io/data":             "data",
+			"csi-driver.mattslater.io/retention-period": retention,
+			"csi.storage.k8s.io/pod.namespace":          namespace,
+			"csi.storage.k8s.io/serviceAccount.name":    "app",
+		}
+	}
+
+	_, err := newFilesystem().WriteVolume(context.Background(), "vol", volume("team-a", "10m"), nil)
+	if !errors.Is(err, storage.ErrNotConfigured) {
+		t.Errorf("WriteVolume() without retention error = %v, want %v", err, storage.ErrNotConfigured)
+	}
+
+	fileSystem := newFilesystem(storage.WithMaxRetention(time.Hour))
+
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", volume("team-a", "2h"), nil)
+	if !errors.Is(err, storage.ErrInvalidAttribute) {
+		t.Errorf("WriteVolume() beyond the retention limit error = %v, want %v", err, storage.ErrInvalidAttribute)
+	}
+
+	publish := func(namespace string) (bool, string) {
+		created, err := fileSystem.WriteVolume(context.Background(), "vol", volume(namespace, "10m"), nil)
+		if err != nil {
+			t.Fatalf("unexpected error writing volume: %v", err)
+		}
+
+		version, _ := os.Readlink(filepath.Join(fileSystem.PathForVolume("vol"), "..data"))
+
+		return created, version
+	}
+
+	release := func() {
+		retained, err := fileSystem.ReleaseVolume("vol")
+		if err != nil || !retained {
+			t.Fatalf("ReleaseVolume() = %v, %v, want the data retained", retained, err)
+		}
+	}
+
+	_, version := publish("team-a")
+	release()
+
+	if usage := fileSystem.NamespaceUsage(); len(usage) != 0 {
+		t.Errorf("NamespaceUsage() = %v, want retained data not to count", usage)
+	}
+
+	if created, reused := publish("team-a"); created || reused != version {
+		t.Errorf("republishing created %v with version %s, want %s reused", created, reused, version)
+	}
+
+	release()
+
+	if created, _ := publish("team-b"); !created {
+		t.Error("another namespace reused retained data")
+	}
+
+	release()
+
+	swept, err := fileSystem.SweepRetained(time.Now())
+	if err != nil || len(swept) != 0 {
+		t.Errorf("SweepRetained() = %v, %v, want nothing swept yet", swept, err)
+	}
+
+	swept, err = fileSystem.SweepRetained(time.Now().Add(time.Hour))
+	if err != nil || !reflect.DeepEqual(swept, []string{"vol"}) {
+		t.Errorf("SweepRetained() = %v, %v, want the volume swept", swept, err)
+	}
+
+	if _, err := os.Stat(fileSystem.PathForVolume("vol")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("swept volume still exists: %v", err)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "plain", map[string]string{
+		"csi-driver.mattslater.io/filename": "file",
+		"csi-driver.mattslater.io/data":     "data",
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error writing volume: %v", err)
+	}
+
+	retained, err := fileSystem.ReleaseVolume("plain")
+	if err != nil || retained {
+		t.Errorf("ReleaseVolume() = %v, %v, want the volume removed", retained, err)
+	}
+}
+
+func TestFilesystem_WriteVolume_RetainedReuse(t *testing.T) {
+	t.Parallel()
+
+	commands := &fakeCommands{
+		outputs: map[string]*command.Output{"generate": {Stdout: []byte("generated")}},
+	}
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithCommands(commands),
+		storage.WithMaxRetention(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	publish := func(retention string) {
+		_, err := fileSystem.WriteVolume(context.Background(), "vol", map[string]string{
+			"csi-driver.mattslater.io/source":           "command",
+			"csi-driver.mattslater.io/command":          "generate",
+			"csi-driver.mattslater.io/filename":         "out.txt",
+			"csi-driver.mattslater.io/retention-period": retention,
+			"csi.storage.k8s.io/pod.namespace":          "team",
+		}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error writing volume: %v", err)
+		}
+
+		retained, err := fileSystem.ReleaseVolume("vol")
+		if err != nil || !retained {
+			t.Fatalf("ReleaseVolume() = %v, %v, want the data retained", retained, err)
+		}
+	}
+
+	publish("10m")
+	publish("10m")
+
+	if runs := commands.runs.Load(); runs != 1 {
+		t.Errorf("command ran %d times, want the retained data reused without rendering", runs)
+	}
+
+	publish("20m")
+
+	if runs := commands.runs.Load(); runs != 2 {
+		t.Errorf("command ran %d times, want changed attributes rendered again", runs)
+	}
+}
+
+func TestFilesystem_WriteVolume_Quota(t *testing.T) {
+	t.Parallel()
+
+	baseDir := t.TempDir()
+	newFilesystem := func() *storage.Filesystem {
+		fileSystem, err := storage.NewFilesystem(
+			zaptest.NewLogger(t),
+			baseDir,
+			fstest.MapFS{},
+			mount.NewFakeMounter([]mount.MountPoint{}),
+			storage.WithQuotas(&storage.Quotas{
+				Default:    storage.Quota{Volumes: 2},
+				Namespaces: map[string]storage.Quota{"small": {Bytes: 8}},
+			}),
+		)
+		if err != nil {
+			t.Fatalf("failed to create filesystem: %v", err)
+		}
+
+		return fileSystem
+	}
+	inline := func(namespace string, data string) map[string]string {
+		return map[string]string{
+			"csi.storage.k8s.io/pod.namespace":  namespace,
+			"csi-driver.mattslater.io/filename": "file",
+			"csi-driver.mattslater.io/data":     data,
+		}
+	}
+
+	fileSystem := newFilesystem()
+
+	for _, id := range []string{"a1", "a2"} {
+		_, err := fileSystem.WriteVolume(context.Background(), id, inline("team-a", "data"), nil)
+		if err != nil {
+			t.Fatalf("unexpected error writing volume %s: %v", id, err)
+		}
+	}
+
+	_, err := fileSystem.WriteVolume(context.Background(), "a3", inline("team-a", "data"), nil)
+	if !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Errorf("WriteVolume() beyond the volume quota error = %v, want %v", err, storage.ErrQuotaExceeded)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "a2", inline("team-a", "republished"), nil)
+	if err != nil {
+		t.Errorf("unexpected error republishing volume: %v", err)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "s1", inline("small", "123456789"), nil)
+	if !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Errorf("WriteVolume() beyond the byte quota error = %v, want %v", err, storage.ErrQuotaExceeded)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "s1", inline("small", "12345678"), nil)
+	if err != nil {
+		t.Errorf("unexpected error writing volume: %v", err)
+	}
+
+	want := map[string]storage.Usage{"team-a": {Bytes: 15, Volumes: 2}, "small": {Bytes: 8, Volumes: 1}}
+
+	// usage survives a restart of the driver.
+	restarted := newFilesystem()
+	if got := restarted.NamespaceUsage(); !reflect.DeepEqual(got, want) {
+		t.Errorf("NamespaceUsage() after restart = %v, want %v", got, want)
+	}
+
+	err = restarted.RemoveVolume("a1")
+	if err != nil {
+		t.Fatalf("unexpected error removing volume: %v", err)
+	}
+
+	_, err = restarted.WriteVolume(context.Background(), "a3", inline("team-a", "data"), nil)
+	if err != nil {
+		t.Errorf("unexpected error writing volume after freeing quota: %v", err)
+	}
+}
+
+func TestFilesystem_WriteVolume_QuotaMetadataFailure(t *testing.T) {
+	t.Parallel()
+
+	baseDir := t.TempDir()
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		baseDir,
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithQuotas(&storage.Quotas{Default: storage.Quota{Volumes: 1}}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	// a directory in place of the temporary metadata file fails writing it.
+	err = os.MkdirAll(filepath.Join(baseDir, "broken", "meta.json.tmp"), 0o700)
+	if err != nil {
+		t.Fatalf("failed to block metadata: %v", err)
+	}
+
+	vCtx := map[string]string{
+		"csi.storage.k8s.io/pod.namespace":  "team-a",
+		"csi-driver.mattslater.io/filename": "file",
+		"csi-driver.mattslater.io/data":     "data",
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "broken", vCtx, nil)
+	if err == nil {
+		t.Fatal("WriteVolume() succeeded without metadata")
+	}
+
+	if usage := fileSystem.NamespaceUsage(); len(usage) != 0 {
+		t.Errorf("NamespaceUsage() = %v after a failed write, want none", usage)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "vol", vCtx, nil)
+	if err != nil {
+		t.Errorf("unexpected error writing volume: %v", err)
+	}
+}
+
+func TestFilesystem_WriteVolume_MaxVolumes(t *testing.T) {
+	t.Parallel()
+
+	fileSystem, err := storage.NewFilesystem(
+		zaptest.NewLogger(t),
+		t.TempDir(),
+		fstest.MapFS{},
+		mount.NewFakeMounter([]mount.MountPoint{}),
+		storage.WithMaxVolumes(1),
+	)
+	if err != nil {
+		t.Fatalf("failed to create filesystem: %v", err)
+	}
+
+	vCtx := map[string]string{
+		"csi-driver.mattslater.io/filename": "file",
+		"csi-driver.mattslater.io/data":     "data",
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "first", vCtx, nil)
+	if err != nil {
+		t.Fatalf("unexpected error writing volume: %v", err)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "first", vCtx, nil)
+	if err != nil {
+		t.Errorf("unexpected error republishing volume: %v", err)
+	}
+
+	_, err = fileSystem.WriteVolume(context.Background(), "second", vCtx, nil)
+	if !errors.Is(err, storage.ErrQuotaExceeded) {
+		t.Errorf("WriteVolume() beyond the volume limit error = %v, want %v", err, storage.ErrQuotaExceeded)
+	}
+}
+
 func TestFilesystem_ListVolumes(t *testing.T) {
 	t.Parallel()
 
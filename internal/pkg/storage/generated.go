@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"csi-driver/internal/pkg/credential"
+)
+
+const (
+	defaultPasswordLength = 32
+	defaultPasswordChars  = "alphanumeric"
+	defaultHexBytes       = 32
+	maxGeneratedLength    = 4096
+	maxRSABits            = 4096
+
+	authorizedKeysFile = "authorized_keys"
+)
+
+// generatedFiles generates the credentials requested by the generate
+// attribute. It is a comma separated list of path=kind[:arg...] entries:
+//
+//	password[:length[:alphabet]]  random password, e.g. db=password:24:symbols
+//	hex[:bytes]                   random hex encoded token
+//	uuid                          random version 4 UUID
+//	ssh-ed25519, ssh-rsa[:bits]   SSH key pair written below path
+//
+// Credentials are generated once per volume ID and carried over from current
+// on every later write.
+func generatedFiles(current string, vCtx map[string]string) ([]File, error) {
+	var files []File
+
+	for _, entry := range splitEntries(vCtx[generateAttribute]) {
+		path, spec, found := strings.Cut(entry, "=")
+		if !found || spec == "" {
+			return nil, fmt.Errorf("%w: generate entry %q must be path=kind", ErrInvalidAttribute, entry)
+		}
+
+		err := validateRelativePath(path)
+		if err != nil {
+			return nil, err
+		}
+
+		kind, args, _ := strings.Cut(spec, ":")
+
+		layout, err := credentialLayout(path, kind)
+		if err != nil {
+			return nil, err
+		}
+
+		if reused, ok := reuseFiles(current, layout); ok {
+			files = append(files, reused...)
+
+			continue
+		}
+
+		generated, err := generateCredential(layout, kind, args)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, generated...)
+	}
+
+	return files, nil
+}
+
+// credentialLayout returns the files a generator of kind writes below path,
+// without any data.
+func credentialLayout(path string, kind string) ([]File, error) {
+	switch kind {
+	case "password", "hex":
+		return []File{{Path: path, Mode: defaultSecretPerms}}, nil
+	case "uuid":
+		return []File{{Path: path, Mode: defaultFilePerms}}, nil
+	case credential.SSHKeyTypeEd25519, credential.SSHKeyTypeRSA:
+		keyName := "id_" + strings.TrimPrefix(kind, "ssh-")
+
+		return []File{
+			{Path: filepath.Join(path, keyName), Mode: defaultSecretPerms},
+			{Path: filepath.Join(path, keyName+".pub"), Mode: defaultFilePerms},
+			{Path: filepath.Join(path, authorizedKeysFile), Mode: defaultFilePerms},
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown generator %q", ErrInvalidAttribute, kind)
+	}
+}
+
+// generateCredential fills the files of layout with a fresh credential.
+func generateCredential(layout []File, kind string, args string) ([]File, error) {
+	switch kind {
+	case "password":
+		lengthArg, alphabetName, _ := strings.Cut(args, ":")
+
+		length, err := intArgument(lengthArg, defaultPasswordLength, maxGeneratedLength)
+		if err != nil {
+			return nil, err
+		}
+
+		if alphabetName == "" {
+			alphabetName = defaultPasswordChars
+		}
+
+		alphabet, ok := credential.Alphabets[alphabetName]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown password alphabet %q", ErrInvalidAttribute, alphabetName)
+		}
+
+		password, err := credential.Password(length, alphabet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate password: %w", err)
+		}
+
+		layout[0].Data = []byte(password)
+	case "hex":
+		size, err := intArgument(args, defaultHexBytes, maxGeneratedLength)
+		if err != nil {
+			return nil, err
+		}
+
+		token, err := credential.Hex(size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate token: %w", err)
+		}
+
+		layout[0].Data = []byte(token)
+	case "uuid":
+		uuid, err := credential.UUID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate uuid: %w", err)
+		}
+
+		layout[0].Data = []byte(uuid)
+	default:
+		bits, err := intArgument(args, credential.MinRSABits, maxRSABits)
+		if err != nil {
+			return nil, err
+		}
+
+		keyPair, err := credential.GenerateSSHKeyPair(kind, bits, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ssh key pair: %w", err)
+		}
+
+		layout[0].Data = keyPair.PrivateKey
+		layout[1].Data = keyPair.AuthorizedKey
+		layout[2].Data = keyPair.AuthorizedKey
+	}
+
+	return layout, nil
+}
+
+// reuseFiles returns files with their data replaced by the content already
+// present in dir, as long as every one of them exists there.
+func reuseFiles(dir string, files []File) ([]File, bool) {
+	if dir == "" {
+		return nil, false
+	}
+
+	reused := make([]File, 0, len(files))
+
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(dir, file.Path))
+		if err != nil {
+			return nil, false
+		}
+
+		file.Data = data
+		reused = append(reused, file)
+	}
+
+	return reused, true
+}
+
+func intArgument(value string, defaultValue int, max int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 || parsed > max {
+		return 0, fmt.Errorf("%w: %q must be a number between 1 and %d", ErrInvalidAttribute, value, max)
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,447 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// historyDirName holds the versions a volume keeps for rollback, next to
+	// its data dir so pods cannot see them. They are not part of the usage of
+	// the volume.
+	historyDirName = "history"
+
+	// versions record what wrote them.
+	versionPublish   = "publish"
+	versionRepublish = "republish"
+	versionRefresh   = "refresh"
+	versionRepair    = "repair"
+	versionBundle    = "bundle"
+)
+
+// ErrVersionNotFound is returned when a volume keeps no matching version.
+var ErrVersionNotFound = errors.New("version not found")
+
+// contentVersion describes a version of the content of a volume.
+type contentVersion struct {
+	// Name is the name of the directory holding the version.
+	Name    string    `json:"name"`
+	Written time.Time `json:"written"`
+	// Source says what wrote the version, e.g. a publish request or an update
+	// of a bundle.
+	Source string `json:"source"`
+	// Digest is the digest of the manifest of the version.
+	Digest string `json:"digest"`
+}
+
+// retiredVersion is a version that was replaced and is kept for rollback,
+// along with what the metadata said about it.
+type retiredVersion struct {
+	contentVersion
+	Manifest  map[string]fileDigest `json:"manifest,omitempty"`
+	Bundles   map[string]bundleRef  `json:"bundles,omitempty"`
+	Published []string              `json:"published,omitempty"`
+	Size      int64                 `json:"size"`
+}
+
+// Version describes a version of the content of a volume.
+type Version struct {
+	Name    string            `json:"name"`
+	Written time.Time         `json:"written"`
+	Source  string            `json:"source"`
+	Digest  string            `json:"digest"`
+	Bundles map[string]string `json:"bundles,omitempty"`
+	Current bool              `json:"current"`
+}
+
+func (f *Filesystem) historyDir(id string) string {
+	return filepath.Join(f.baseDir, id, historyDirName)
+}
+
+// manifestDigest returns a digest over every path, digest and mode of
+// manifest.
+func manifestDigest(manifest map[string]fileDigest) string {
+	paths := make([]string, 0, len(manifest))
+	for path := range manifest {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	hash := sha256.New()
+	for _, path := range paths {
+		fmt.Fprintf(hash, "%s\x00%s\x00%o\n", path, manifest[path].SHA256, manifest[path].Mode)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// retire returns the history entry of the content in dir as described by
+// meta, with an empty name if there is no content. It copies what callers may
+// change in place.
+func retire(meta *volumeMetadata, dir string) retiredVersion {
+	retired := retiredVersion{
+		contentVersion: meta.Version,
+		Manifest:       meta.Manifest,
+		Bundles:        maps.Clone(meta.Bundles),
+		Published:      meta.Published,
+		Size:           meta.Size,
+	}
+	retired.Name = ""
+
+	if dir != "" {
+		retired.Name = filepath.Base(dir)
+	}
+
+	return retired
+}
+
+// retireDir moves the version dir name out of datapath into the history of
+// the volume, or removes it if no history is kept.
+func (f *Filesystem) retireDir(datapath string, name string) {
+	path := filepath.Join(datapath, name)
+	historyDir := filepath.Join(filepath.Dir(datapath), historyDirName)
+
+	var err error
+
+	if f.keepVersions > 0 {
+		err = os.MkdirAll(historyDir, rwePerms)
+		if err == nil {
+			err = os.Rename(path, filepath.Join(historyDir, name))
+		}
+	} else {
+		err = os.RemoveAll(path)
+	}
+
+	if err != nil {
+		f.logger.Warn("failed to retire previous volume content",
+			zap.String("path", path),
+			zap.Error(err),
+		)
+	}
+}
+
+// recordVersion makes the content that was just written for source the
+// current version in meta. The version it replaced, described by retired,
+// moves into the history unless it drifted, as it no longer matches its
+// manifest then. Expired content takes its history with it.
+func (f *Filesystem) recordVersion(
+	id string,
+	meta *volumeMetadata,
+	retired retiredVersion,
+	source string,
+	now time.Time,
+) {
+	meta.Version = contentVersion{
+		Name:    filepath.Base(f.currentDir(f.PathForVolume(id))),
+		Written: now,
+		Source:  source,
+		Digest:  manifestDigest(meta.Manifest),
+	}
+
+	if f.keepVersions > 0 && retired.Name != "" && source != versionRepair && !meta.Expired {
+		meta.History = append([]retiredVersion{retired}, meta.History...)
+	}
+
+	if meta.Expired {
+		meta.History = nil
+	}
+
+	f.pruneHistory(id, meta)
+}
+
+// pruneHistory cuts the history of meta down to the configured number of
+// versions and removes version dirs that are no longer part of it.
+func (f *Filesystem) pruneHistory(id string, meta *volumeMetadata) {
+	if len(meta.History) > f.keepVersions {
+		meta.History = meta.History[:f.keepVersions]
+	}
+
+	kept := make(map[string]bool, len(meta.History))
+	for _, version := range meta.History {
+		kept[version.Name] = true
+	}
+
+	entries, err := os.ReadDir(f.historyDir(id))
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if kept[entry.Name()] {
+			continue
+		}
+
+		err := os.RemoveAll(filepath.Join(f.historyDir(id), entry.Name()))
+		if err != nil {
+			f.logger.Warn("failed to remove old volume content",
+				zap.String("volume_id", id),
+				zap.String("version", entry.Name()),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// VolumeVersions returns the current version of the content of volume id and
+// the versions kept for rollback, newest first.
+func (f *Filesystem) VolumeVersions(id string) ([]Version, error) {
+	meta, err := f.readMetadata(id)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrVolumeNotFound, id)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []Version{newVersion(meta.Version, meta.Bundles, true)}
+	for _, retired := range meta.History {
+		versions = append(versions, newVersion(retired.contentVersion, retired.Bundles, false))
+	}
+
+	return versions, nil
+}
+
+func newVersion(version contentVersion, bundles map[string]bundleRef, current bool) Version {
+	exported := Version{
+		Name:    version.Name,
+		Written: version.Written,
+		Source:  version.Source,
+		Digest:  version.Digest,
+		Current: current,
+	}
+
+	if len(bundles) > 0 {
+		exported.Bundles = make(map[string]string, len(bundles))
+		for name, ref := range bundles {
+			exported.Bundles[name] = ref.Version
+		}
+	}
+
+	return exported
+}
+
+// RollbackVolume switches volume id back to the kept version called name, or
+// to the version before the current one if name is empty. The switch is an
+// atomic swap of the ..data symlink and the replaced content is kept as the
+// newest version of the history, so a rollback can be undone. Bundles stay at
+// the restored version as described at RollbackBundle. Other content is
+// rendered from the volume attributes again on the next republish or
+// refresh.
+func (f *Filesystem) RollbackVolume(id string, name string) (*Version, error) {
+	unlock := f.lockVolume(id)
+	defer unlock()
+
+	meta, err := f.readMetadata(id)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrVolumeNotFound, id)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i, retired := range meta.History {
+		if name == "" || retired.Name == name {
+			return f.rollback(id, meta, i)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: volume %s keeps no version %q", ErrVersionNotFound, id, name)
+}
+
+// RollbackBundle rolls every volume holding bundle name back to the newest
+// kept version that holds the bundle at version, or at the version before the
+// current one if version is empty. It returns the volumes that were rolled
+// back. The bundle is pinned in every volume, so republishing and refreshing
+// keep the restored version until the bundle source offers another version
+// than the one that was rolled back, or until an operator rolls forward to
+// it again.
+func (f *Filesystem) RollbackBundle(name string, version string) ([]string, error) {
+	ids, err := f.VolumeIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		rolledBack []string
+		errs       []error
+	)
+
+	for _, id := range ids {
+		ok, err := f.rollbackVolumeBundle(id, name, version)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("volume %s: %w", id, err))
+		}
+
+		if ok {
+			rolledBack = append(rolledBack, id)
+		}
+	}
+
+	return rolledBack, errors.Join(errs...)
+}
+
+func (f *Filesystem) rollbackVolumeBundle(id string, name string, version string) (bool, error) {
+	unlock := f.lockVolume(id)
+	defer unlock()
+
+	meta, err := f.readMetadata(id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	current, ok := meta.Bundles[name]
+	if !ok || current.Version == version {
+		return false, nil
+	}
+
+	for i, retired := range meta.History {
+		ref, ok := retired.Bundles[name]
+		if !ok || ref.Version == current.Version || version != "" && ref.Version != version {
+			continue
+		}
+
+		_, err := f.rollback(id, meta, i)
+
+		return err == nil, err
+	}
+
+	return false, fmt.Errorf("%w: no kept version holds bundle %s at %q", ErrVersionNotFound, name, version)
+}
+
+// rollback makes the history entry at index i of meta the current content of
+// volume id.
+func (f *Filesystem) rollback(id string, meta *volumeMetadata, i int) (*Version, error) {
+	target := meta.History[i]
+	datapath := f.PathForVolume(id)
+
+	current := f.currentDir(datapath)
+	if current == "" {
+		return nil, fmt.Errorf("%w: %s", ErrVolumeNotFound, id)
+	}
+
+	undo, err := f.reserveUsage(id, volumeNamespace(meta.Attributes), target.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(filepath.Join(f.historyDir(id), target.Name), filepath.Join(datapath, target.Name))
+	if err != nil {
+		undo()
+
+		return nil, fmt.Errorf("failed to restore version: %w", err)
+	}
+
+	// putBack returns the target to the history when the switch fails.
+	putBack := func() error {
+		return os.Rename(filepath.Join(datapath, target.Name), filepath.Join(f.historyDir(id), target.Name))
+	}
+
+	files, err := treeFiles(filepath.Join(datapath, target.Name))
+	if err == nil {
+		err = swapDataLink(datapath, target.Name)
+	}
+
+	if err != nil {
+		undo()
+
+		return nil, errors.Join(err, putBack())
+	}
+
+	err = linkTopLevel(datapath, files)
+	if err != nil {
+		undo()
+
+		return nil, errors.Join(err, restoreCurrent(datapath, current), putBack())
+	}
+
+	retired := retire(meta, current)
+	f.retireDir(datapath, retired.Name)
+
+	meta.Pins = pinBundles(meta, target)
+	meta.History = append([]retiredVersion{retired}, append(meta.History[:i:i], meta.History[i+1:]...)...)
+	meta.Version = target.contentVersion
+	meta.Manifest = target.Manifest
+	meta.Bundles = target.Bundles
+	meta.Published = target.Published
+	meta.Size = target.Size
+	meta.Integrity.Drift = ""
+	f.pruneHistory(id, meta)
+
+	err = f.writeMetadata(id, meta)
+	if err != nil {
+		// usage follows the metadata, which still describes the replaced
+		// content.
+		undo()
+
+		return nil, err
+	}
+
+	f.logger.Info("rolled back volume content",
+		zap.String("volume_id", id),
+		zap.String("version", target.Name),
+		zap.String("digest", target.Digest),
+	)
+
+	version := newVersion(meta.Version, meta.Bundles, true)
+
+	return &version, nil
+}
+
+// restoreCurrent points datapath back at the content in current after
+// switching to another version failed.
+func restoreCurrent(datapath string, current string) error {
+	err := swapDataLink(datapath, filepath.Base(current))
+	if err != nil {
+		return err
+	}
+
+	files, err := treeFiles(current)
+	if err != nil {
+		return err
+	}
+
+	return linkTopLevel(datapath, files)
+}
+
+// pinBundles returns the pins of meta after target was restored. Every bundle
+// target holds at another version than the bundle source offers is pinned to
+// it.
+func pinBundles(meta *volumeMetadata, target retiredVersion) map[string]bundlePin {
+	pins := make(map[string]bundlePin)
+
+	for name, ref := range target.Bundles {
+		offered := meta.Bundles[name].Version
+		if pin, ok := meta.Pins[name]; ok {
+			offered = pin.Replaced
+		}
+
+		dir, ok := bundleDir(meta.Attributes, name)
+		if !ok || ref.Version == offered {
+			continue
+		}
+
+		pins[name] = bundlePin{bundleRef: ref, Dir: dir, Replaced: offered}
+	}
+
+	if len(pins) == 0 {
+		return nil
+	}
+
+	return pins
+}
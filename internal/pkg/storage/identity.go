@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"csi-driver/internal/pkg/jwt"
+)
+
+const (
+	defaultIdentityTokenDuration = time.Hour
+	maxIdentityTokenDuration     = 24 * time.Hour
+)
+
+// identityFiles mints a signed identity token for the pod when the
+// identity-token attribute names a file for it, and writes the JWKS that
+// verifies it when identity-jwks does. Tokens are reissued once they are due
+// for renewal or the signing key changed.
+func (f *Filesystem) identityFiles(current string, vCtx map[string]string) ([]File, error) {
+	tokenSpec := vCtx[identityTokenAttribute]
+	jwksSpec := vCtx[identityJWKSAttribute]
+
+	if tokenSpec == "" && jwksSpec == "" {
+		return nil, nil
+	}
+
+	if f.tokenIssuer == nil {
+		return nil, fmt.Errorf("%w: no identity token issuer", ErrNotConfigured)
+	}
+
+	var files []File
+
+	if jwksSpec != "" {
+		_, path, mode, err := parseFileEntry(jwksSpec, defaultFilePerms)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, File{Path: path, Mode: mode, Data: f.tokenIssuer.JWKS()})
+	}
+
+	if tokenSpec == "" {
+		return files, nil
+	}
+
+	_, path, mode, err := parseFileEntry(tokenSpec, defaultSecretPerms)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, duration, err := identityClaims(vCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, ok := f.reusableToken(current, path, claims)
+	if !ok {
+		token, err = f.tokenIssuer.Issue(claims, duration)
+		if err != nil {
+			return nil, fmt.Errorf("failed to issue identity token: %w", err)
+		}
+	}
+
+	return append(files, File{Path: path, Mode: mode, Data: token}), nil
+}
+
+// identityClaims builds the claims for the pod. The subject follows the
+// Kubernetes service account naming, system:serviceaccount:<ns>:<name>.
+func identityClaims(vCtx map[string]string) (jwt.Claims, time.Duration, error) {
+	namespace := vCtx[podInfoPrefix+"pod.namespace"]
+	serviceAccount := vCtx[podInfoPrefix+"serviceAccount.name"]
+
+	if namespace == "" || serviceAccount == "" {
+		return jwt.Claims{}, 0, fmt.Errorf("%w: identity tokens need pod info, check podInfoOnMount of the CSIDriver",
+			ErrInvalidAttribute,
+		)
+	}
+
+	duration := defaultIdentityTokenDuration
+
+	if value := vCtx[identityTokenDurationAttribute]; value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 || parsed > maxIdentityTokenDuration {
+			return jwt.Claims{}, 0, fmt.Errorf("%w: identity token duration %q must be between 0 and %s",
+				ErrInvalidAttribute, value, maxIdentityTokenDuration,
+			)
+		}
+
+		duration = parsed
+	}
+
+	return jwt.Claims{
+		Subject:        "system:serviceaccount:" + namespace + ":" + serviceAccount,
+		Audience:       splitEntries(vCtx[identityTokenAudiencesAttribute]),
+		Namespace:      namespace,
+		ServiceAccount: serviceAccount,
+		PodName:        vCtx[podInfoPrefix+"pod.name"],
+		PodUID:         vCtx[podInfoPrefix+"pod.uid"],
+	}, duration, nil
+}
+
+// reusableToken returns the token in dir if it is still valid for claims, was
+// issued to the same pod and is not due for renewal. A volume ID can outlive
+// its pod, e.g. when its data is retained, and the token must not follow it.
+func (f *Filesystem) reusableToken(dir string, path string, claims jwt.Claims) ([]byte, bool) {
+	if dir == "" {
+		return nil, false
+	}
+
+	token, err := os.ReadFile(filepath.Join(dir, path))
+	if err != nil {
+		return nil, false
+	}
+
+	existing, err := f.tokenIssuer.Verify(token)
+	if err != nil {
+		return nil, false
+	}
+
+	if existing.Subject != claims.Subject || !slices.Equal(existing.Audience, claims.Audience) ||
+		existing.PodName != claims.PodName || existing.PodUID != claims.PodUID {
+		return nil, false
+	}
+
+	lifetime := existing.Expiry - existing.IssuedAt
+	if time.Now().Unix() >= existing.Expiry-lifetime/renewalDivisor {
+		return nil, false
+	}
+
+	return token, true
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fileDigest is what the manifest of a volume records about a file.
+type fileDigest struct {
+	SHA256 string      `json:"sha256"`
+	Mode   fs.FileMode `json:"mode"`
+}
+
+// integrityState tracks how the last verification of a volume went.
+type integrityState struct {
+	Checked time.Time `json:"checked"`
+	// Drift describes how the content differed from its manifest, it is empty
+	// while the content is intact.
+	Drift    string    `json:"drift,omitempty"`
+	Repaired time.Time `json:"repaired"`
+}
+
+// Drift describes how the content of a volume differs from what the driver
+// wrote. Paths are relative to the volume.
+type Drift struct {
+	Modified []string
+	Missing  []string
+	Added    []string
+	// Repaired is set when the content was restored.
+	Repaired bool
+}
+
+// Detected reports whether the content differed from what was written.
+func (d *Drift) Detected() bool {
+	return len(d.Modified)+len(d.Missing)+len(d.Added) > 0
+}
+
+func (d *Drift) String() string {
+	var parts []string
+
+	for _, kind := range []struct {
+		name  string
+		paths []string
+	}{
+		{"modified", d.Modified},
+		{"missing", d.Missing},
+		{"added", d.Added},
+	} {
+		if len(kind.paths) > 0 {
+			parts = append(parts, kind.name+": "+strings.Join(kind.paths, ", "))
+		}
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// newManifest returns the digest of every file of a volume.
+func newManifest(files []File) map[string]fileDigest {
+	manifest := make(map[string]fileDigest, len(files))
+
+	for _, file := range files {
+		manifest[filepath.ToSlash(filepath.Clean(file.Path))] = digestOf(file.Data, file.Mode.Perm())
+	}
+
+	return manifest
+}
+
+func digestOf(data []byte, mode fs.FileMode) fileDigest {
+	sum := sha256.Sum256(data)
+
+	return fileDigest{SHA256: hex.EncodeToString(sum[:]), Mode: mode}
+}
+
+// checkManifest compares the content in dir with manifest.
+func checkManifest(dir string, manifest map[string]fileDigest) (*Drift, error) {
+	drift := &Drift{}
+	seen := make(map[string]bool, len(manifest))
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return fmt.Errorf("failed to resolve volume path: %w", err)
+		}
+
+		rel = filepath.ToSlash(rel)
+
+		want, ok := manifest[rel]
+		if !ok {
+			drift.Added = append(drift.Added, rel)
+
+			return nil
+		}
+
+		seen[rel] = true
+
+		// anything but a regular file, e.g. a symlink, was not written by the
+		// driver.
+		if !entry.Type().IsRegular() {
+			drift.Modified = append(drift.Modified, rel)
+
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("failed to stat volume file: %w", err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read volume file: %w", err)
+		}
+
+		if digestOf(data, info.Mode().Perm()) != want {
+			drift.Modified = append(drift.Modified, rel)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify volume content: %w", err)
+	}
+
+	for path := range manifest {
+		if !seen[path] {
+			drift.Missing = append(drift.Missing, path)
+		}
+	}
+
+	sort.Strings(drift.Missing)
+
+	return drift, nil
+}
+
+// dropDrifted removes the modified and added files of drift from dir, so
+// re-rendering the volume produces them again instead of carrying them over.
+func dropDrifted(dir string, drift *Drift) error {
+	for _, path := range append(append([]string{}, drift.Modified...), drift.Added...) {
+		err := os.Remove(filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove drifted file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// republishDrift checks the content a republish replaces against its
+// manifest and reports whether it drifted. Drifted files are dropped, so the
+// republish writes them again rather than carrying them over, e.g. downloaded
+// content or certificates.
+func (f *Filesystem) republishDrift(id string, current string, previous *volumeMetadata) bool {
+	if current == "" || previous.Manifest == nil {
+		return false
+	}
+
+	drift, err := checkManifest(current, previous.Manifest)
+	if err == nil && drift.Detected() {
+		f.logger.Warn("volume content drifted, restoring it on republish",
+			zap.String("volume_id", id),
+			zap.Stringer("drift", drift),
+		)
+
+		err = dropDrifted(current, drift)
+	}
+
+	if err != nil {
+		f.logger.Warn("failed to verify volume content on republish", zap.String("volume_id", id), zap.Error(err))
+
+		return false
+	}
+
+	return drift.Detected()
+}
+
+// VerifyVolume compares the content of volume id with the manifest recorded
+// when it was written and keeps the outcome for VolumeStatus. With drift
+// repair enabled, drifted content is restored by rendering it again from the
+// attributes of the volume. Content that needs a publish request, such as
+// projected secrets, cannot be restored that way and is left for the next
+// republish. Volumes written before manifests were recorded are reported as
+// intact.
+func (f *Filesystem) VerifyVolume(ctx context.Context, id string) (*Drift, error) {
+	unlock := f.lockVolume(id)
+	defer unlock()
+
+	meta, err := f.readMetadata(id)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrVolumeNotFound, id)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	current := f.currentDir(f.PathForVolume(id))
+	if current == "" || meta.Manifest == nil {
+		return &Drift{}, nil
+	}
+
+	drift, err := checkManifest(current, meta.Manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	meta.Integrity.Checked = now
+	meta.Integrity.Drift = ""
+
+	if drift.Detected() {
+		meta.Integrity.Drift = drift.String()
+	}
+
+	if !drift.Detected() || !f.repairDrift || touchesPublished(drift, meta.Published) {
+		return drift, f.writeMetadata(id, meta)
+	}
+
+	err = dropDrifted(current, drift)
+	if err != nil {
+		return drift, errors.Join(err, f.writeMetadata(id, meta))
+	}
+
+	meta.Integrity.Drift = ""
+	meta.Integrity.Repaired = now
+
+	err = f.rerender(ctx, id, meta, versionRepair, now)
+	if err != nil {
+		return drift, fmt.Errorf("failed to repair volume content: %w", err)
+	}
+
+	drift.Repaired = true
+
+	return drift, nil
+}
+
+// touchesPublished reports whether drift concerns files that only a publish
+// request can produce.
+func touchesPublished(drift *Drift, published []string) bool {
+	for _, path := range published {
+		path = filepath.ToSlash(filepath.Clean(path))
+
+		for _, drifted := range append(append([]string{}, drift.Modified...), drift.Missing...) {
+			if drifted == path {
+				return true
+			}
+		}
+	}
+
+	return false
+}
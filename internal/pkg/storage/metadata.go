@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// metadataFile is kept next to the data dir of a volume, so it is not
+	// visible inside the mounted volume.
+	metadataFile  = "meta.json"
+	metadataPerms = 0o600
+)
+
+// volumeMetadata lets the backend update a volume later without a publish
+// request. It never holds secrets or service account tokens.
+type volumeMetadata struct {
+	Attributes map[string]string    `json:"attributes"`
+	Bundles    map[string]bundleRef `json:"bundles,omitempty"`
+	// Pins holds the bundles that were rolled back, keyed by name, so they
+	// stay at the restored version until the bundle changes again.
+	Pins map[string]bundlePin `json:"pins,omitempty"`
+	// Published lists the files that need a publish request to be produced.
+	Published []string     `json:"published,omitempty"`
+	Refresh   refreshState `json:"refresh"`
+	// Expires is when the content is wiped, Expired is set once it was.
+	Expires time.Time `json:"expires"`
+	Expired bool      `json:"expired,omitempty"`
+	// Size is the size of the content in bytes, which counts towards the
+	// quota of the namespace of the volume.
+	Size int64 `json:"size"`
+	// Manifest holds the digest of every file as written, keyed by path, and
+	// Integrity how verifying the content against it went.
+	Manifest  map[string]fileDigest `json:"manifest,omitempty"`
+	Integrity integrityState        `json:"integrity"`
+	// Version describes the current content and History the versions it
+	// replaced that are kept for rollback, newest first.
+	Version contentVersion   `json:"version"`
+	History []retiredVersion `json:"history,omitempty"`
+	// RetainedUntil is when the data of an unpublished volume is removed. It
+	// is zero while the volume is published.
+	RetainedUntil time.Time `json:"retainedUntil"`
+}
+
+// metadataAttributes returns the attributes of vCtx that may be persisted.
+func metadataAttributes(vCtx map[string]string) map[string]string {
+	attributes := make(map[string]string, len(vCtx))
+
+	for key, value := range vCtx {
+		if key != serviceAccountTokensKey {
+			attributes[key] = value
+		}
+	}
+
+	return attributes
+}
+
+func (f *Filesystem) metadataPath(id string) string {
+	return filepath.Join(f.baseDir, id, metadataFile)
+}
+
+// readMetadata returns the metadata of volume id. Volumes written by older
+// versions of the driver have none, which is reported as os.ErrNotExist.
+func (f *Filesystem) readMetadata(id string) (*volumeMetadata, error) {
+	data, err := os.ReadFile(f.metadataPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read volume metadata: %w", err)
+	}
+
+	meta := &volumeMetadata{}
+
+	err = json.Unmarshal(data, meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse volume metadata: %w", err)
+	}
+
+	return meta, nil
+}
+
+// VolumeAttributes returns the attributes volume id was published with,
+// without service account tokens.
+func (f *Filesystem) VolumeAttributes(id string) (map[string]string, error) {
+	meta, err := f.readMetadata(id)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrVolumeNotFound, id)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return meta.Attributes, nil
+}
+
+// writeMetadata replaces the metadata of volume id atomically.
+func (f *Filesystem) writeMetadata(id string, meta *volumeMetadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to marshal volume metadata: %w", err)
+	}
+
+	tmp := f.metadataPath(id) + ".tmp"
+
+	err = os.WriteFile(tmp, data, metadataPerms)
+	if err != nil {
+		return fmt.Errorf("failed to write volume metadata: %w", err)
+	}
+
+	err = os.Rename(tmp, f.metadataPath(id))
+	if err != nil {
+		return fmt.Errorf("failed to replace volume metadata: %w", err)
+	}
+
+	return nil
+}
+
+type volumeLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockVolume serializes changes to volume id and returns the unlock function.
+func (f *Filesystem) lockVolume(id string) func() {
+	f.locksMu.Lock()
+
+	lock, ok := f.locks[id]
+	if !ok {
+		lock = &volumeLock{}
+		f.locks[id] = lock
+	}
+
+	lock.refs++
+	f.locksMu.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		f.locksMu.Lock()
+		defer f.locksMu.Unlock()
+
+		lock.refs--
+		if lock.refs == 0 {
+			delete(f.locks, id)
+		}
+	}
+}
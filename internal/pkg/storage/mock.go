@@ -1,18 +1,30 @@
 package storage
 
 import (
+	"context"
 	"errors"
 )
 
 type MockStorage struct {
 	ShouldErr bool
-	Path      string
-	Volumes   []string
+	// Err is returned by WriteVolume instead of the generic mock error.
+	Err     error
+	Path    string
+	Volumes []string
 }
 
 var errMock = errors.New("mock error")
 
-func (ms *MockStorage) WriteVolume(_ string, _ map[string]string) (bool, error) {
+func (ms *MockStorage) WriteVolume(
+	_ context.Context,
+	_ string,
+	_ map[string]string,
+	_ map[string]string,
+) (bool, error) {
+	if ms.Err != nil {
+		return false, ms.Err
+	}
+
 	if ms.ShouldErr {
 		return false, errMock
 	}
@@ -1,6 +1,7 @@
 package storage_test
 
 import (
+	"context"
 	"csi-driver/internal/pkg/storage"
 	"reflect"
 	"testing"
@@ -178,8 +179,9 @@ func TestMockStorage_WriteVolume(t *testing.T) {
 	}
 
 	type args struct {
-		id   string
-		vCtx map[string]string
+		id      string
+		vCtx    map[string]string
+		secrets map[string]string
 	}
 
 	tests := []struct {
@@ -220,7 +222,7 @@ func TestMockStorage_WriteVolume(t *testing.T) {
 				Volumes:   testCase.fields.Volumes,
 			}
 
-			got, err := mockStorage.WriteVolume(testCase.args.id, testCase.args.vCtx)
+			got, err := mockStorage.WriteVolume(context.Background(), testCase.args.id, testCase.args.vCtx, testCase.args.secrets)
 			if (err != nil) != testCase.wantErr {
 				t.Errorf("MockStorage.WriteVolume() error = %v, wantErr %v", err, testCase.wantErr)
 
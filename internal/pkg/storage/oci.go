@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"csi-driver/internal/pkg/oci"
+)
+
+// ociFiles returns the content of the image selected by the oci attributes,
+// optionally limited to a subpath of it. Images are unpacked once into the
+// content cache, keyed by their manifest digest, and reused from there. A
+// data-signature is verified against the image manifest, which pins the
+// digests of all layers.
+func (f *Filesystem) ociFiles(ctx context.Context, vCtx map[string]string) ([]File, error) {
+	if f.ociLayoutsDir == "" || f.cache == nil {
+		return nil, fmt.Errorf("%w: no OCI layouts dir", ErrNotConfigured)
+	}
+
+	layoutPath := vCtx[ociLayoutAttribute]
+
+	err := validateRelativePath(layoutPath)
+	if err != nil {
+		return nil, err
+	}
+
+	subpath := vCtx[ociSubpathAttribute]
+	if subpath != "" {
+		err := validateRelativePath(subpath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	layout, err := oci.Open(filepath.Join(f.ociLayoutsDir, layoutPath))
+	if err != nil {
+		return nil, ociError(err)
+	}
+
+	img, err := layout.Resolve(vCtx[ociReferenceAttribute])
+	if err != nil {
+		return nil, ociError(err)
+	}
+
+	err = f.verifyContent(subpath, img.Manifest, vCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	key := "oci-" + strings.ReplaceAll(img.Digest, ":", "-")
+
+	dir, err := f.cache.Get(ctx, key, func(ctx context.Context, dir string) error {
+		return layout.Unpack(ctx, img, dir, f.ociMaxSize)
+	})
+	if err != nil {
+		return nil, ociError(err)
+	}
+
+	return treeFiles(filepath.Join(dir, subpath))
+}
+
+// treeFiles reads the regular files below root. If root is a file itself it is
+// returned under its base name.
+func treeFiles(root string) ([]File, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: subpath %q not found in image", ErrInvalidAttribute, filepath.Base(root))
+		}
+
+		return nil, fmt.Errorf("failed to read image content: %w", err)
+	}
+
+	if info.Mode().IsRegular() {
+		data, err := os.ReadFile(root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image content: %w", err)
+		}
+
+		return []File{{Path: filepath.Base(root), Mode: info.Mode().Perm(), Data: data}}, nil
+	}
+
+	var files []File
+
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		err = validateRelativePath(rel)
+		if err != nil {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, File{Path: rel, Mode: info.Mode().Perm(), Data: data})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image content: %w", err)
+	}
+
+	return files, nil
+}
+
+// ociError maps errors of the oci package to the storage errors.
+func ociError(err error) error {
+	switch {
+	case errors.Is(err, oci.ErrNotFound):
+		// the layout may not have been copied to the node yet.
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	case errors.Is(err, oci.ErrDigestMismatch):
+		return fmt.Errorf("%w: %w", ErrUntrusted, err)
+	case errors.Is(err, oci.ErrInvalidLayout), errors.Is(err, oci.ErrUnsupported), errors.Is(err, oci.ErrTooLarge):
+		return fmt.Errorf("%w: %w", ErrInvalidAttribute, err)
+	default:
+		return err
+	}
+}
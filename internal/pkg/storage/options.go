@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"csi-driver/internal/pkg/bundle"
+	"csi-driver/internal/pkg/cache"
+	"csi-driver/internal/pkg/command"
+	"csi-driver/internal/pkg/fetch"
+	"csi-driver/internal/pkg/jwt"
+	"csi-driver/internal/pkg/provider"
+	"csi-driver/internal/pkg/seal"
+)
+
+// Option configures optional features of the Filesystem backend.
+type Option func(*Filesystem)
+
+// CertificateIssuer signs TLS certificates for volumes that request one.
+type CertificateIssuer interface {
+	Issue(dnsNames []string, uris []*url.URL, duration time.Duration) ([]byte, []byte, error)
+	CertificatePEM() []byte
+}
+
+// WithCertificateIssuer enables the certificate attributes.
+func WithCertificateIssuer(issuer CertificateIssuer) Option {
+	return func(f *Filesystem) {
+		f.issuer = issuer
+	}
+}
+
+// WithAllowedCertificateNames lets pods request certificates for DNS names
+// and URIs matching patterns, as understood by path.Match, e.g.
+// *.example.com. Without it pods only get names of their own namespace.
+func WithAllowedCertificateNames(patterns []string) Option {
+	return func(f *Filesystem) {
+		f.certificateNames = patterns
+	}
+}
+
+// TokenIssuer signs identity tokens for volumes that request one.
+type TokenIssuer interface {
+	Issue(claims jwt.Claims, duration time.Duration) ([]byte, error)
+	Verify(token []byte) (*jwt.Claims, error)
+	JWKS() []byte
+}
+
+// WithTokenIssuer enables the identity token attributes.
+func WithTokenIssuer(issuer TokenIssuer) Option {
+	return func(f *Filesystem) {
+		f.tokenIssuer = issuer
+	}
+}
+
+// Unsealer decrypts sealed volume content.
+type Unsealer interface {
+	Open(sealed string, scope seal.Scope) ([]byte, error)
+}
+
+// WithUnsealer enables the encrypted-data attribute.
+func WithUnsealer(unsealer Unsealer) Option {
+	return func(f *Filesystem) {
+		f.unsealer = unsealer
+	}
+}
+
+// SignatureVerifier verifies detached signatures of volume content.
+type SignatureVerifier interface {
+	Verify(data []byte, signature []byte) error
+}
+
+// WithSignatureVerifier enables the data-signature attribute. If required is
+// set, content that is not signed by a trusted key is refused, and so is
+// content of sources that cannot be signed, such as bundles or commands.
+func WithSignatureVerifier(verifier SignatureVerifier, required bool) Option {
+	return func(f *Filesystem) {
+		f.verifier = verifier
+		f.signaturesRequired = required
+	}
+}
+
+// FilePolicy restricts the content of volumes by their attributes.
+type FilePolicy interface {
+	// AdmitFiles checks the paths of the files rendered for a volume.
+	AdmitFiles(vCtx map[string]string, paths []string) error
+	// SignatureRequired reports whether the content of a volume has to be
+	// signed.
+	SignatureRequired(vCtx map[string]string) bool
+}
+
+// WithFilePolicy checks the files of every volume against policy and
+// requires signed content for the volumes policy says so, as if
+// WithSignatureVerifier was given required for them.
+func WithFilePolicy(policy FilePolicy) Option {
+	return func(f *Filesystem) {
+		f.filePolicy = policy
+	}
+}
+
+// ContentFetcher downloads volume content.
+type ContentFetcher interface {
+	Fetch(ctx context.Context, req fetch.Request) ([]byte, error)
+}
+
+// WithFetcher enables the url source.
+func WithFetcher(fetcher ContentFetcher) Option {
+	return func(f *Filesystem) {
+		f.fetcher = fetcher
+	}
+}
+
+// ContentCache keeps content that is expensive to produce on the node.
+type ContentCache interface {
+	Get(ctx context.Context, key string, fill cache.Fill) (string, error)
+}
+
+// WithContentCache sets the node content cache used by sources that support
+// it.
+func WithContentCache(contentCache ContentCache) Option {
+	return func(f *Filesystem) {
+		f.cache = contentCache
+	}
+}
+
+// WithOCILayouts enables the oci source for image layouts below dir. Images
+// larger than maxSize once unpacked are refused. The oci source also needs a
+// content cache.
+func WithOCILayouts(dir string, maxSize int64) Option {
+	return func(f *Filesystem) {
+		f.ociLayoutsDir = dir
+		f.ociMaxSize = maxSize
+	}
+}
+
+// ContentProviders calls content providers by name.
+type ContentProviders interface {
+	Mount(ctx context.Context, name string, req *provider.MountRequest) (*provider.MountResponse, error)
+}
+
+// WithProviders enables the provider source.
+func WithProviders(providers ContentProviders) Option {
+	return func(f *Filesystem) {
+		f.providers = providers
+	}
+}
+
+// ContentRequest describes the volume a ContentProvider produces files for.
+type ContentRequest struct {
+	VolumeID   string
+	Attributes map[string]string
+	Secrets    map[string]string
+	// CurrentDir holds the content that is being replaced, or is empty for
+	// new volumes, so providers can keep content stable across republishes.
+	CurrentDir string
+}
+
+// ContentProvider produces the files of volumes whose source attribute names
+// it. Errors may wrap ErrInvalidAttribute, ErrNotConfigured, ErrUntrusted or
+// ErrUnavailable to select the gRPC code returned to kubelet.
+type ContentProvider interface {
+	Files(ctx context.Context, req *ContentRequest) ([]File, error)
+}
+
+// ContentProviderFunc adapts a function to ContentProvider.
+type ContentProviderFunc func(ctx context.Context, req *ContentRequest) ([]File, error)
+
+// Files implements ContentProvider.
+func (fn ContentProviderFunc) Files(ctx context.Context, req *ContentRequest) ([]File, error) {
+	return fn(ctx, req)
+}
+
+// WithContentProvider adds source as a value of the source attribute, served
+// by contentProvider. Built-in sources cannot be replaced.
+func WithContentProvider(source string, contentProvider ContentProvider) Option {
+	return func(f *Filesystem) {
+		if f.contentProviders == nil {
+			f.contentProviders = make(map[string]ContentProvider)
+		}
+
+		f.contentProviders[source] = contentProvider
+	}
+}
+
+// BundleSource provides node-local content bundles.
+type BundleSource interface {
+	Get(name string) (*bundle.Bundle, error)
+}
+
+// WithBundles enables the bundle attribute.
+func WithBundles(source BundleSource) Option {
+	return func(f *Filesystem) {
+		f.bundles = source
+	}
+}
+
+// WithMaxVolumeSize refuses volumes whose content exceeds maxSize bytes. It
+// also enables the synthetic source, which generates datasets up to that
+// size.
+func WithMaxVolumeSize(maxSize int64) Option {
+	return func(f *Filesystem) {
+		f.maxVolumeSize = maxSize
+	}
+}
+
+// CommandRunner runs commands configured on the driver.
+type CommandRunner interface {
+	Run(ctx context.Context, name string, req *command.Request) (*command.Output, error)
+}
+
+// WithCommands enables the command source.
+func WithCommands(runner CommandRunner) Option {
+	return func(f *Filesystem) {
+		f.commands = runner
+	}
+}
+
+// WithQuotas limits the bytes and volumes of every namespace on the node.
+func WithQuotas(quotas *Quotas) Option {
+	return func(f *Filesystem) {
+		f.quotas = quotas
+	}
+}
+
+// WithMaxVolumes limits the number of volumes on the node.
+func WithMaxVolumes(maxVolumes int) Option {
+	return func(f *Filesystem) {
+		f.maxVolumes = maxVolumes
+	}
+}
+
+// WithDriftRepair makes VerifyVolume restore content that drifted from what
+// was written. Volumes are always mounted read-only, so drift comes from the
+// node rather than from the pods using them.
+func WithDriftRepair() Option {
+	return func(f *Filesystem) {
+		f.repairDrift = true
+	}
+}
+
+// WithVersionHistory keeps the last keep versions of the content of every
+// volume when it is replaced, so it can be rolled back. Kept versions stay on
+// the tmpfs but count towards neither quotas nor the volume size limit.
+func WithVersionHistory(keep int) Option {
+	return func(f *Filesystem) {
+		f.keepVersions = keep
+	}
+}
+
+// WithMaxRetention enables the retention-period attribute, which keeps the
+// data of unpublished volumes for up to maxRetention.
+func WithMaxRetention(maxRetention time.Duration) Option {
+	return func(f *Filesystem) {
+		f.maxRetention = maxRetention
+	}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"csi-driver/internal/pkg/provider"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// providerFiles returns the files the provider named by the provider
+// attribute returns for the volume. Providers are installed on the node by its
+// administrator and are trusted like the driver itself, so their content is
+// not subject to data-signature verification.
+func (f *Filesystem) providerFiles(
+	ctx context.Context,
+	id string,
+	vCtx map[string]string,
+	secrets map[string]string,
+) ([]File, error) {
+	if f.providers == nil {
+		return nil, fmt.Errorf("%w: no providers dir", ErrNotConfigured)
+	}
+
+	name := vCtx[providerAttribute]
+	if name == "" {
+		return nil, fmt.Errorf("%w: source %q needs a provider", ErrInvalidAttribute, sourceProvider)
+	}
+
+	resp, err := f.providers.Mount(ctx, name, &provider.MountRequest{
+		VolumeID:   id,
+		Attributes: vCtx,
+		Secrets:    secrets,
+	})
+	if err != nil {
+		return nil, providerError(name, err)
+	}
+
+	files := make([]File, 0, len(resp.Files))
+
+	for _, file := range resp.Files {
+		files = append(files, File{Path: file.Path, Mode: fs.FileMode(file.Mode), Data: file.Contents})
+	}
+
+	return checkFiles("provider "+name, files)
+}
+
+// providerError maps provider failures to the storage errors, keeping the
+// message of the provider.
+func providerError(name string, err error) error {
+	if errors.Is(err, provider.ErrUnknownProvider) {
+		return fmt.Errorf("%w: %w", ErrInvalidAttribute, err)
+	}
+
+	if errors.Is(err, provider.ErrIncompatible) {
+		return fmt.Errorf("%w: %w", ErrNotConfigured, err)
+	}
+
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.NotFound:
+		return fmt.Errorf("%w: provider %s: %s", ErrInvalidAttribute, name, status.Convert(err).Message())
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return fmt.Errorf("%w: provider %s: %s", ErrUntrusted, name, status.Convert(err).Message())
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return fmt.Errorf("%w: provider %s: %s", ErrUnavailable, name, status.Convert(err).Message())
+	default:
+		return fmt.Errorf("provider %s failed: %w", name, err)
+	}
+}
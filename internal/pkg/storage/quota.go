@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Quota limits what the volumes of a namespace may use. Zero fields are not
+// limited. Only the current content of a volume counts, versions kept for
+// rollback and retained data do not.
+type Quota struct {
+	Bytes   int64 `json:"bytes,omitempty"`
+	Volumes int   `json:"volumes,omitempty"`
+}
+
+// Quotas configures the quotas of namespaces. Default applies to namespaces
+// that are not listed, including volumes without pod info.
+type Quotas struct {
+	Default    Quota            `json:"default"`
+	Namespaces map[string]Quota `json:"namespaces,omitempty"`
+}
+
+// Usage is what the volumes of a namespace use.
+type Usage struct {
+	Bytes   int64
+	Volumes int
+}
+
+// Capacity is the space of the tmpfs volumes are kept on.
+type Capacity struct {
+	Total     int64
+	Available int64
+	// MaxVolumeSize is the volume size limit, zero if there is none.
+	MaxVolumeSize int64
+}
+
+// volumeUsage is what a single volume accounts for.
+type volumeUsage struct {
+	namespace string
+	bytes     int64
+}
+
+func (q *Quotas) quota(namespace string) Quota {
+	if quota, ok := q.Namespaces[namespace]; ok {
+		return quota
+	}
+
+	return q.Default
+}
+
+// volumeNamespace returns the namespace of the pod a volume belongs to, as
+// passed by kubelet with podInfoOnMount.
+func volumeNamespace(vCtx map[string]string) string {
+	return vCtx[podInfoPrefix+"pod.namespace"]
+}
+
+func volumeServiceAccount(vCtx map[string]string) string {
+	return vCtx[podInfoPrefix+"serviceAccount.name"]
+}
+
+func contentSize(files []File) int64 {
+	var size int64
+	for _, file := range files {
+		size += int64(len(file.Data))
+	}
+
+	return size
+}
+
+// loadUsage restores the usage of the volumes on the node from their
+// metadata, so accounting survives driver restarts.
+func (f *Filesystem) loadUsage() error {
+	ids, err := f.VolumeIDs()
+	if err != nil {
+		return err
+	}
+
+	f.usageMu.Lock()
+	defer f.usageMu.Unlock()
+
+	for _, id := range ids {
+		meta, err := f.readMetadata(id)
+		if err != nil {
+			// volumes written by older versions are not accounted for.
+			continue
+		}
+
+		if !meta.RetainedUntil.IsZero() {
+			// retained data is not accounted for.
+			continue
+		}
+
+		f.usage[id] = volumeUsage{namespace: volumeNamespace(meta.Attributes), bytes: meta.Size}
+	}
+
+	return nil
+}
+
+// reserveUsage accounts size bytes of volume id to namespace if the quota of
+// namespace and the volume limit of the node allow it. The returned function
+// undoes the reservation.
+func (f *Filesystem) reserveUsage(id string, namespace string, size int64) (func(), error) {
+	f.usageMu.Lock()
+	defer f.usageMu.Unlock()
+
+	if _, existed := f.usage[id]; !existed && f.maxVolumes > 0 && len(f.usage) >= f.maxVolumes {
+		return nil, fmt.Errorf("%w: the node takes at most %d volumes", ErrQuotaExceeded, f.maxVolumes)
+	}
+
+	if f.quotas != nil {
+		used := Usage{Bytes: size, Volumes: 1}
+
+		for other, usage := range f.usage {
+			if other != id && usage.namespace == namespace {
+				used.Bytes += usage.bytes
+				used.Volumes++
+			}
+		}
+
+		quota := f.quotas.quota(namespace)
+
+		switch {
+		case quota.Volumes > 0 && used.Volumes > quota.Volumes:
+			return nil, fmt.Errorf("%w: namespace %q may have %d volumes on this node",
+				ErrQuotaExceeded, namespace, quota.Volumes,
+			)
+		case quota.Bytes > 0 && used.Bytes > quota.Bytes:
+			return nil, fmt.Errorf("%w: namespace %q would use %d of %d bytes on this node",
+				ErrQuotaExceeded, namespace, used.Bytes, quota.Bytes,
+			)
+		}
+	}
+
+	previous, existed := f.usage[id]
+	f.usage[id] = volumeUsage{namespace: namespace, bytes: size}
+
+	return func() {
+		f.usageMu.Lock()
+		defer f.usageMu.Unlock()
+
+		if existed {
+			f.usage[id] = previous
+		} else {
+			delete(f.usage, id)
+		}
+	}, nil
+}
+
+// recordUsage updates the size of volume id after its content changed
+// without a publish request. Quotas are not enforced then, as running pods
+// must not lose their content.
+func (f *Filesystem) recordUsage(id string, size int64) {
+	f.usageMu.Lock()
+	defer f.usageMu.Unlock()
+
+	if usage, ok := f.usage[id]; ok {
+		usage.bytes = size
+		f.usage[id] = usage
+	}
+}
+
+func (f *Filesystem) releaseUsage(id string) {
+	f.usageMu.Lock()
+	defer f.usageMu.Unlock()
+
+	delete(f.usage, id)
+}
+
+// NamespaceUsage returns what the volumes of every namespace on the node use.
+func (f *Filesystem) NamespaceUsage() map[string]Usage {
+	f.usageMu.Lock()
+	defer f.usageMu.Unlock()
+
+	namespaces := make(map[string]Usage)
+
+	for _, usage := range f.usage {
+		used := namespaces[usage.namespace]
+		used.Bytes += usage.bytes
+		used.Volumes++
+		namespaces[usage.namespace] = used
+	}
+
+	return namespaces
+}
+
+// Capacity reports the space left on the tmpfs volumes are kept on.
+func (f *Filesystem) Capacity() (*Capacity, error) {
+	var stat syscall.Statfs_t
+
+	err := syscall.Statfs(f.baseDir, &stat)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat storage dir: %w", os.NewSyscallError("statfs", err))
+	}
+
+	return &Capacity{
+		Total:         int64(stat.Blocks) * stat.Bsize,
+		Available:     int64(stat.Bavail) * stat.Bsize,
+		MaxVolumeSize: f.maxVolumeSize,
+	}, nil
+}
@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"csi-driver/internal/pkg/metrics"
+	"csi-driver/internal/pkg/refresh"
+
+	"go.uber.org/zap"
+)
+
+// refreshState tracks when the content of a volume is refreshed next and how
+// the last refreshes went.
+type refreshState struct {
+	// Next is zero when no content of the volume expires.
+	Next        time.Time `json:"next"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastFailure time.Time `json:"lastFailure"`
+	LastError   string    `json:"lastError,omitempty"`
+	Failures    int       `json:"failures,omitempty"`
+	// Fetched is when downloaded content was last fetched.
+	Fetched time.Time `json:"fetched"`
+}
+
+// DueVolumes returns the volumes whose content is due for a refresh at now.
+func (f *Filesystem) DueVolumes(now time.Time) ([]string, error) {
+	ids, err := f.VolumeIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var due []string
+
+	for _, id := range ids {
+		meta, err := f.readMetadata(id)
+		if err != nil {
+			continue
+		}
+
+		// retained data is refreshed once it is published again.
+		next := meta.Refresh.Next
+		if meta.RetainedUntil.IsZero() && !next.IsZero() && !next.After(now) {
+			due = append(due, id)
+		}
+	}
+
+	return due, nil
+}
+
+// RefreshVolume renders the content of volume id again from the attributes it
+// was published with and swaps it in atomically. Files that need the secrets
+// or tokens of a publish request are carried over. The outcome is recorded in
+// the volume metadata, a failed refresh is retried with backoff.
+func (f *Filesystem) RefreshVolume(ctx context.Context, id string) error {
+	unlock := f.lockVolume(id)
+	defer unlock()
+
+	meta, err := f.readMetadata(id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	return f.rerender(ctx, id, meta, versionRefresh, time.Now())
+}
+
+// rerender renders the content of volume id from meta and swaps it in as a
+// version written by source. The outcome is recorded in meta, which is
+// written back.
+func (f *Filesystem) rerender(
+	ctx context.Context,
+	id string,
+	meta *volumeMetadata,
+	source string,
+	now time.Time,
+) error {
+	datapath := f.PathForVolume(id)
+
+	current := f.currentDir(datapath)
+	if current == "" {
+		return nil
+	}
+
+	interval, _ := urlRefreshInterval(meta.Attributes)
+
+	content := &render{
+		id:        id,
+		current:   current,
+		previous:  meta.Attributes,
+		vCtx:      meta.Attributes,
+		refresh:   true,
+		refetch:   interval > 0 && !now.Before(meta.Refresh.Fetched.Add(interval)),
+		published: meta.Published,
+		expires:   meta.Expires,
+		pins:      meta.Pins,
+	}
+
+	retired := retire(meta, current)
+	changed := false
+
+	err := f.volumeFiles(ctx, content)
+	if err == nil {
+		changed, err = f.writeAtomic(datapath, content.files)
+	}
+
+	if err != nil {
+		meta.Refresh.Failures++
+		meta.Refresh.LastFailure = now
+		meta.Refresh.LastError = err.Error()
+		meta.Refresh.Next = now.Add(refresh.Backoff(meta.Refresh.Failures))
+
+		return errors.Join(err, f.writeMetadata(id, meta))
+	}
+
+	if content.expired && !meta.Expired {
+		metrics.Expirations.Add(1)
+		f.logger.Info("volume content expired", zap.String("volume_id", id))
+	}
+
+	meta.Bundles = content.bundles
+	meta.Pins = content.pins
+	meta.Published = content.published
+	meta.Size = contentSize(content.files)
+	meta.Manifest = newManifest(content.files)
+	f.recordUsage(id, meta.Size)
+	f.refreshSucceeded(meta, content, now)
+
+	if changed {
+		f.recordVersion(id, meta, retired, source, now)
+	}
+
+	return f.writeMetadata(id, meta)
+}
+
+// refreshSucceeded records that content was rendered at now and schedules
+// the next refresh. Expiry is not subject to jitter.
+func (f *Filesystem) refreshSucceeded(meta *volumeMetadata, content *render, now time.Time) {
+	if content.fetched {
+		meta.Refresh.Fetched = now
+	}
+
+	meta.Expired = content.expired
+	meta.Refresh.Failures = 0
+	meta.Refresh.LastSuccess = now
+	meta.Refresh.LastError = ""
+
+	if content.expired {
+		meta.Refresh.Next = time.Time{}
+
+		return
+	}
+
+	next := refresh.Delay(f.nextRefresh(content, meta.Refresh.Fetched), now)
+	if !meta.Expires.IsZero() && (next.IsZero() || meta.Expires.Before(next)) {
+		next = meta.Expires
+	}
+
+	meta.Refresh.Next = next
+}
+
+// nextRefresh returns when the first piece of content expires: certificates
+// and identity tokens once they are due for renewal, downloaded content once
+// its refresh interval has passed since fetched. It is zero if nothing
+// expires.
+func (f *Filesystem) nextRefresh(content *render, fetched time.Time) time.Time {
+	var next time.Time
+
+	consider := func(at time.Time) {
+		if !at.IsZero() && (next.IsZero() || at.Before(next)) {
+			next = at
+		}
+	}
+
+	if interval, _ := urlRefreshInterval(content.vCtx); interval > 0 && !fetched.IsZero() {
+		consider(fetched.Add(interval))
+	}
+
+	if enabled, _ := strconv.ParseBool(content.vCtx[certificateAttribute]); enabled {
+		consider(certificateRenewal(findFile(content.files, certificateFile)))
+	}
+
+	if spec := content.vCtx[identityTokenAttribute]; spec != "" && f.tokenIssuer != nil {
+		_, path, _, err := parseFileEntry(spec, defaultSecretPerms)
+		if err == nil {
+			consider(f.tokenRenewal(findFile(content.files, path)))
+		}
+	}
+
+	return next
+}
+
+func certificateRenewal(certPEM []byte) time.Time {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return time.Time{}
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return renewalTime(cert)
+}
+
+func (f *Filesystem) tokenRenewal(token []byte) time.Time {
+	if token == nil {
+		return time.Time{}
+	}
+
+	claims, err := f.tokenIssuer.Verify(token)
+	if err != nil {
+		return time.Time{}
+	}
+
+	lifetime := claims.Expiry - claims.IssuedAt
+
+	return time.Unix(claims.Expiry-lifetime/renewalDivisor, 0)
+}
+
+// findFile returns the content of the file at path, or nil.
+func findFile(files []File, path string) []byte {
+	path = filepath.Clean(path)
+
+	for _, file := range files {
+		if filepath.Clean(file.Path) == path {
+			return file.Data
+		}
+	}
+
+	return nil
+}
+
+// VolumeStatus reports the size of the content of volume id and whether it
+// expired, drifted or refreshing it is failing.
+func (f *Filesystem) VolumeStatus(id string) (*VolumeStatus, error) {
+	current := f.currentDir(f.PathForVolume(id))
+	if current == "" {
+		return nil, fmt.Errorf("%w: %s", ErrVolumeNotFound, id)
+	}
+
+	volumeStatus := &VolumeStatus{}
+
+	err := filepath.WalkDir(current, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		volumeStatus.UsedBytes += info.Size()
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure volume content: %w", err)
+	}
+
+	meta, err := f.readMetadata(id)
+	if err != nil {
+		f.logger.Warn("no metadata for volume", zap.String("volume_id", id), zap.Error(err))
+
+		return volumeStatus, nil
+	}
+
+	state := meta.Refresh
+
+	switch {
+	case meta.Expired || (!meta.Expires.IsZero() && !time.Now().Before(meta.Expires)):
+		volumeStatus.Abnormal = true
+		volumeStatus.Message = "content expired at " + meta.Expires.UTC().Format(time.RFC3339)
+	case meta.Integrity.Drift != "":
+		volumeStatus.Abnormal = true
+		volumeStatus.Message = "content drifted from what was written, as of " +
+			meta.Integrity.Checked.UTC().Format(time.RFC3339) + ": " + meta.Integrity.Drift
+	case state.Failures > 0:
+		volumeStatus.Abnormal = true
+		volumeStatus.Message = fmt.Sprintf("refreshing content failed %d times, last at %s: %s",
+			state.Failures, state.LastFailure.UTC().Format(time.RFC3339), state.LastError,
+		)
+	case !state.LastSuccess.IsZero():
+		volumeStatus.Message = "content up to date as of " + state.LastSuccess.UTC().Format(time.RFC3339)
+	}
+
+	return volumeStatus, nil
+}
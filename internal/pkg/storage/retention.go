@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"csi-driver/internal/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// retentionPeriod returns how long the data of volume is kept after it was
+// unpublished according to the retention-period attribute, or zero if it is
+// removed right away.
+func (f *Filesystem) retentionPeriod(vCtx map[string]string) (time.Duration, error) {
+	value, ok := vCtx[retentionPeriodAttribute]
+	if !ok {
+		return 0, nil
+	}
+
+	if f.maxRetention == 0 {
+		return 0, fmt.Errorf("%w: retaining volume data", ErrNotConfigured)
+	}
+
+	period, err := time.ParseDuration(value)
+	if err != nil || period <= 0 || period > f.maxRetention {
+		return 0, fmt.Errorf("%w: retention period %q must be positive and at most %s",
+			ErrInvalidAttribute, value, f.maxRetention,
+		)
+	}
+
+	return period, nil
+}
+
+// ReleaseVolume is called once volume id was unpublished. The data of volumes
+// with a retention period is kept until it ends, so republishing the volume
+// reuses it, everything else is removed. It reports whether the data was
+// retained. Retained data counts towards neither quotas nor the volume limit
+// of the node.
+func (f *Filesystem) ReleaseVolume(id string) (bool, error) {
+	unlock := f.lockVolume(id)
+	defer unlock()
+
+	meta, err := f.readMetadata(id)
+	if err != nil || f.currentDir(f.PathForVolume(id)) == "" {
+		return false, f.removeVolume(id)
+	}
+
+	period, err := f.retentionPeriod(meta.Attributes)
+	if err != nil || period == 0 {
+		return false, f.removeVolume(id)
+	}
+
+	meta.RetainedUntil = time.Now().Add(period)
+
+	err = f.writeMetadata(id, meta)
+	if err != nil {
+		return false, errors.Join(err, f.removeVolume(id))
+	}
+
+	f.releaseUsage(id)
+	f.logger.Info("retaining volume data",
+		zap.String("volume_id", id),
+		zap.Time("until", meta.RetainedUntil),
+	)
+
+	return true, nil
+}
+
+// VolumeRetained reports whether the data of volume id is retained, waiting
+// for the volume to be published again.
+func (f *Filesystem) VolumeRetained(id string) bool {
+	unlock := f.lockVolume(id)
+	defer unlock()
+
+	meta, err := f.readMetadata(id)
+
+	return err == nil && !meta.RetainedUntil.IsZero()
+}
+
+// reclaimRetained decides what happens to the retained data of volume id that
+// is published again with vCtx. It is reused if the retention period has not
+// ended and the volume belongs to the same namespace and service account, as
+// retained content such as generated secrets must not pass to other
+// workloads. Otherwise it is removed and the volume starts out empty. It
+// reports whether the data is reused.
+func (f *Filesystem) reclaimRetained(
+	id string,
+	previous *volumeMetadata,
+	vCtx map[string]string,
+) (*volumeMetadata, bool, error) {
+	if time.Now().Before(previous.RetainedUntil) &&
+		volumeNamespace(vCtx) == volumeNamespace(previous.Attributes) &&
+		volumeServiceAccount(vCtx) == volumeServiceAccount(previous.Attributes) {
+		metrics.RetainedReused.Add(1)
+		f.logger.Info("reusing retained volume data", zap.String("volume_id", id))
+
+		previous.RetainedUntil = time.Time{}
+
+		return previous, true, nil
+	}
+
+	err := f.removeVolume(id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &volumeMetadata{}, false, nil
+}
+
+// resumeRetained publishes the retained data of volume id again as it is,
+// without rendering it. It is only used when the data was rendered from the
+// same attributes and nothing in it came with a publish request.
+func (f *Filesystem) resumeRetained(id string, meta *volumeMetadata) error {
+	undo, err := f.reserveUsage(id, volumeNamespace(meta.Attributes), meta.Size)
+	if err != nil {
+		return err
+	}
+
+	err = f.writeMetadata(id, meta)
+	if err != nil {
+		// the data stays retained.
+		undo()
+
+		return err
+	}
+
+	return nil
+}
+
+// SweepRetained removes the retained data whose retention period ended before
+// now and returns the IDs of the volumes it removed.
+func (f *Filesystem) SweepRetained(now time.Time) ([]string, error) {
+	ids, err := f.VolumeIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		swept []string
+		errs  []error
+	)
+
+	for _, id := range ids {
+		removed, err := f.sweepVolume(id, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("volume %s: %w", id, err))
+		}
+
+		if removed {
+			swept = append(swept, id)
+		}
+	}
+
+	return swept, errors.Join(errs...)
+}
+
+func (f *Filesystem) sweepVolume(id string, now time.Time) (bool, error) {
+	unlock := f.lockVolume(id)
+	defer unlock()
+
+	meta, err := f.readMetadata(id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if meta.RetainedUntil.IsZero() || now.Before(meta.RetainedUntil) {
+		return false, nil
+	}
+
+	return true, f.removeVolume(id)
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"fmt"
+)
+
+const (
+	defaultSecretPerms = 0o400
+)
+
+// secretFiles projects the secret keys selected by the secrets attribute into
+// files. The attribute is a comma separated list of key[=path][:mode] entries,
+// e.g. "username=db/user:0440,password". Errors only ever name keys and paths,
+// never secret values.
+func secretFiles(vCtx map[string]string, secrets map[string]string) ([]File, error) {
+	var files []File
+
+	for _, entry := range splitEntries(vCtx[secretsAttribute]) {
+		key, path, mode, err := parseFileEntry(entry, defaultSecretPerms)
+		if err != nil {
+			return nil, err
+		}
+
+		value, ok := secrets[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: secret key %q not found in node publish secrets", ErrInvalidAttribute, key)
+		}
+
+		files = append(files, File{
+			Path: path,
+			Mode: mode,
+			Data: []byte(value),
+		})
+	}
+
+	return files, nil
+}
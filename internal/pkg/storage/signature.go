@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// verifyContent checks the data-signature attribute against data, the plain
+// text content of the volume, placed at path. The signature covers both, see
+// signedMessage, so signed content cannot be moved to another path. A
+// signature is mandatory when the backend or the file policy requires signed
+// content.
+func (f *Filesystem) verifyContent(path string, data []byte, vCtx map[string]string) error {
+	encoded, signed := vCtx[dataSignatureAttribute]
+	if !signed {
+		if f.signatureRequired(vCtx) {
+			return fmt.Errorf("%w: content is not signed", ErrUntrusted)
+		}
+
+		return nil
+	}
+
+	if f.verifier == nil {
+		return fmt.Errorf("%w: no trusted signing keys", ErrNotConfigured)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return fmt.Errorf("%w: data-signature is not base64", ErrInvalidAttribute)
+	}
+
+	err = f.verifier.Verify(signedMessage(path, data), signature)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUntrusted, err)
+	}
+
+	return nil
+}
+
+// signedMessage returns what the data-signature attribute signs: the length
+// of path in decimal, a colon, path and data, e.g. "6:configdata".
+func signedMessage(path string, data []byte) []byte {
+	return append([]byte(strconv.Itoa(len(path))+":"+path), data...)
+}
+
+// signatureRequired reports whether the content of vCtx has to be signed.
+func (f *Filesystem) signatureRequired(vCtx map[string]string) bool {
+	return f.signaturesRequired || (f.filePolicy != nil && f.filePolicy.SignatureRequired(vCtx))
+}
+
+// checkSignable refuses volumes with content that is not covered by the
+// data-signature attribute when signed content is required. Only the
+// inline, url and oci sources and structured values can be signed. Projected
+// secrets and service account tokens come from the cluster and are allowed.
+func (f *Filesystem) checkSignable(vCtx map[string]string) error {
+	if !f.signatureRequired(vCtx) {
+		return nil
+	}
+
+	switch source := vCtx[sourceAttribute]; source {
+	case "", sourceInline, sourceURL, sourceOCI:
+	default:
+		return fmt.Errorf("%w: content of source %q cannot be signed", ErrUntrusted, source)
+	}
+
+	unsigned := []string{bundleAttribute, generateAttribute, identityTokenAttribute, identityJWKSAttribute}
+	if enabled, _ := strconv.ParseBool(vCtx[certificateAttribute]); enabled {
+		unsigned = append(unsigned, certificateAttribute)
+	}
+
+	for _, attribute := range unsigned {
+		if vCtx[attribute] != "" {
+			return fmt.Errorf("%w: content of %s cannot be signed", ErrUntrusted, attribute)
+		}
+	}
+
+	return nil
+}
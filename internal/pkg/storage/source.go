@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"time"
+
+	"csi-driver/internal/pkg/fetch"
+	"csi-driver/internal/pkg/seal"
+)
+
+const (
+	sourceInline   = "inline"
+	sourceURL      = "url"
+	sourceOCI      = "oci"
+	sourceProvider = "provider"
+	// sourceSynthetic generates a deterministic dataset.
+	sourceSynthetic = "synthetic"
+	sourceCommand   = "command"
+
+	minURLRefreshInterval = time.Minute
+)
+
+// sourceFiles returns the file named by the filename attribute with content
+// from the source selected by the source attribute, or the files of an image
+// or provider. Content is verified against the data-signature attribute
+// before it is used.
+func (f *Filesystem) sourceFiles(ctx context.Context, content *render) ([]File, error) {
+	vCtx := content.vCtx
+	filename := vCtx[filenameAttribute]
+	source := vCtx[sourceAttribute]
+
+	if contentProvider, ok := f.contentProviders[source]; ok {
+		return f.contentProviderFiles(ctx, contentProvider, source, &ContentRequest{
+			VolumeID:   content.id,
+			Attributes: vCtx,
+			Secrets:    content.secrets,
+			CurrentDir: content.current,
+		})
+	}
+
+	if source == sourceCommand {
+		return f.commandFiles(ctx, content.id, filename, vCtx)
+	}
+
+	// these sources return a whole tree of files instead of a single one.
+	switch source {
+	case sourceOCI, sourceProvider, sourceSynthetic:
+		if filename != "" {
+			return nil, fmt.Errorf("%w: source %q takes no filename", ErrInvalidAttribute, source)
+		}
+
+		switch source {
+		case sourceOCI:
+			return f.ociFiles(ctx, vCtx)
+		case sourceSynthetic:
+			return f.syntheticFiles(content)
+		default:
+			return f.providerFiles(ctx, content.id, vCtx, content.secrets)
+		}
+	}
+
+	if filename == "" {
+		if source != "" && source != sourceInline {
+			return nil, fmt.Errorf("%w: source %q needs a filename", ErrInvalidAttribute, source)
+		}
+
+		return nil, nil
+	}
+
+	err := validateRelativePath(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		data []byte
+		mode fs.FileMode
+	)
+
+	switch source {
+	case "", sourceInline:
+		data, mode, err = f.inlineData(vCtx)
+	case sourceURL:
+		data, mode, err = f.urlData(ctx, content, filename)
+	default:
+		return nil, fmt.Errorf("%w: unknown source %q", ErrInvalidAttribute, source)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.verifyContent(filename, data, vCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	return []File{{Path: filename, Mode: mode, Data: data}}, nil
+}
+
+// isBuiltinSource reports whether source is handled by the backend itself.
+func isBuiltinSource(source string) bool {
+	switch source {
+	case "", sourceInline, sourceURL, sourceOCI, sourceProvider, sourceSynthetic, sourceCommand:
+		return true
+	default:
+		return false
+	}
+}
+
+// contentProviderFiles returns the files of an in-process content provider.
+func (f *Filesystem) contentProviderFiles(
+	ctx context.Context,
+	contentProvider ContentProvider,
+	source string,
+	req *ContentRequest,
+) ([]File, error) {
+	files, err := contentProvider.Files(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("source %s failed: %w", source, err)
+	}
+
+	return checkFiles(source, files)
+}
+
+// checkFiles makes sure files returned by a provider stay inside the volume
+// and have plain permission bits, defaulting unset modes.
+func checkFiles(source string, files []File) ([]File, error) {
+	checked := make([]File, 0, len(files))
+
+	for _, file := range files {
+		err := validateRelativePath(file.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%s returned an invalid file: %w", source, err)
+		}
+
+		if file.Mode&^fs.ModePerm != 0 {
+			return nil, fmt.Errorf("%w: %s returned mode %v for %q", ErrInvalidAttribute, source, file.Mode, file.Path)
+		}
+
+		if file.Mode == 0 {
+			file.Mode = defaultFilePerms
+		}
+
+		checked = append(checked, file)
+	}
+
+	return checked, nil
+}
+
+// inlineData returns the content given in the data attribute or decrypted
+// from encrypted-data.
+func (f *Filesystem) inlineData(vCtx map[string]string) ([]byte, fs.FileMode, error) {
+	sealed, ok := vCtx[encryptedDataAttribute]
+	if !ok {
+		return []byte(vCtx[dataAttribute]), defaultFilePerms, nil
+	}
+
+	if _, ok := vCtx[dataAttribute]; ok {
+		return nil, 0, fmt.Errorf("%w: data and encrypted-data are mutually exclusive", ErrInvalidAttribute)
+	}
+
+	if f.unsealer == nil {
+		return nil, 0, fmt.Errorf("%w: no sealing key", ErrNotConfigured)
+	}
+
+	// sealed content only opens in the namespace, and possibly the service
+	// account, it was sealed for.
+	data, err := f.unsealer.Open(sealed, seal.Scope{
+		Namespace:      volumeNamespace(vCtx),
+		ServiceAccount: volumeServiceAccount(vCtx),
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: failed to open encrypted-data: %w", ErrInvalidAttribute, err)
+	}
+
+	// decrypted content is as sensitive as a secret.
+	return data, defaultSecretPerms, nil
+}
+
+// urlData downloads the content from the url attribute. Downloaded content
+// is kept instead of being fetched again on every republish, for the lifetime
+// of the volume or until the refresh scheduler refetches it.
+func (f *Filesystem) urlData(ctx context.Context, content *render, filename string) ([]byte, fs.FileMode, error) {
+	vCtx := content.vCtx
+
+	if f.fetcher == nil {
+		return nil, 0, fmt.Errorf("%w: no content fetcher", ErrNotConfigured)
+	}
+
+	_, err := urlRefreshInterval(vCtx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if reused, ok := reuseFiles(content.current, []File{{Path: filename}}); ok && !content.refetch {
+		return reused[0].Data, defaultFilePerms, nil
+	}
+
+	req := fetch.Request{
+		URL:      vCtx[urlAttribute],
+		SHA256:   vCtx[urlSHA256Attribute],
+		CABundle: []byte(vCtx[urlCABundleAttribute]),
+	}
+
+	if value := vCtx[urlMaxSizeAttribute]; value != "" {
+		maxSize, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || maxSize <= 0 {
+			return nil, 0, fmt.Errorf("%w: invalid url max size %q", ErrInvalidAttribute, value)
+		}
+
+		req.MaxSize = maxSize
+	}
+
+	if value := vCtx[urlTimeoutAttribute]; value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return nil, 0, fmt.Errorf("%w: invalid url timeout %q", ErrInvalidAttribute, value)
+		}
+
+		req.Timeout = timeout
+	}
+
+	data, err := f.fetcher.Fetch(ctx, req)
+
+	switch {
+	case err == nil:
+		content.fetched = true
+
+		return data, defaultFilePerms, nil
+	case errors.Is(err, fetch.ErrInvalidRequest), errors.Is(err, fetch.ErrTooLarge), errors.Is(err, fetch.ErrForbidden):
+		return nil, 0, fmt.Errorf("%w: %w", ErrInvalidAttribute, err)
+	case errors.Is(err, fetch.ErrDigestMismatch):
+		return nil, 0, fmt.Errorf("%w: %w", ErrUntrusted, err)
+	default:
+		return nil, 0, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+}
+
+// urlRefreshInterval returns how often downloaded content is fetched again,
+// or zero if it is kept.
+func urlRefreshInterval(vCtx map[string]string) (time.Duration, error) {
+	value := vCtx[urlRefreshIntervalAttribute]
+	if value == "" {
+		return 0, nil
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < minURLRefreshInterval {
+		return 0, fmt.Errorf("%w: url refresh interval %q must be at least %s",
+			ErrInvalidAttribute, value, minURLRefreshInterval,
+		)
+	}
+
+	return interval, nil
+}
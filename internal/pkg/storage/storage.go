@@ -1,9 +1,86 @@
 // Package storage contains interfaces and backends for storing volumes.
 package storage
 
+import (
+	"context"
+	"errors"
+	"io/fs"
+)
+
+// ErrInvalidAttribute is returned when the volume attributes of a request
+// cannot be turned into volume content.
+var ErrInvalidAttribute = errors.New("invalid volume attribute")
+
+// ErrNotConfigured is returned when a volume requests a feature the driver
+// has not been configured for.
+var ErrNotConfigured = errors.New("feature not configured on driver")
+
+// ErrUntrusted is returned when volume content fails signature verification
+// or a volume asks for names its pod may not claim.
+var ErrUntrusted = errors.New("untrusted volume content")
+
+// ErrUnavailable is returned when volume content cannot be obtained right now
+// and publishing should be retried.
+var ErrUnavailable = errors.New("volume content unavailable")
+
+// ErrInvalidSource is returned when a content provider is registered under a
+// source name that is already taken.
+var ErrInvalidSource = errors.New("invalid content source")
+
+// ErrQuotaExceeded is returned when a volume does not fit into the quota of
+// its namespace.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ErrVolumeNotFound is returned when a volume does not exist on the node.
+var ErrVolumeNotFound = errors.New("volume not found")
+
 type Storage interface {
-	WriteVolume(id string, vCtx map[string]string) (bool, error)
+	WriteVolume(ctx context.Context, id string, vCtx map[string]string, secrets map[string]string) (bool, error)
 	PathForVolume(id string) string
 	ListVolumes() ([]string, error)
 	RemoveVolume(id string) error
 }
+
+// StatusReporter is implemented by storage backends that can report the
+// health of volume content.
+type StatusReporter interface {
+	VolumeStatus(id string) (*VolumeStatus, error)
+}
+
+// CapacityReporter is implemented by storage backends that know how much
+// space is left for volumes.
+type CapacityReporter interface {
+	Capacity() (*Capacity, error)
+}
+
+// AttributeReader is implemented by storage backends that keep the attributes
+// volumes were published with.
+type AttributeReader interface {
+	VolumeAttributes(id string) (map[string]string, error)
+}
+
+// Retainer is implemented by storage backends that can keep the data of
+// unpublished volumes for a while, so republishing them is fast.
+type Retainer interface {
+	// ReleaseVolume removes a volume that was unpublished or retains its
+	// data, reporting which.
+	ReleaseVolume(id string) (bool, error)
+	// VolumeRetained reports whether the data of a volume is retained.
+	VolumeRetained(id string) bool
+}
+
+// VolumeStatus describes the content of a volume.
+type VolumeStatus struct {
+	// Abnormal is set when the content is not what the volume asks for, e.g.
+	// because refreshing it keeps failing.
+	Abnormal  bool
+	Message   string
+	UsedBytes int64
+}
+
+// File is a single file to be written into a volume.
+type File struct {
+	Path string
+	Mode fs.FileMode
+	Data []byte
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"csi-driver/internal/pkg/structured"
+)
+
+// structuredFiles renders the values of the volume into the files requested
+// by the render attribute, a comma separated list of format[=path][:mode]
+// entries, e.g. "env=app.env,json". Without a path the file is called config
+// with the extension of the format. Values are verified like the content of
+// the filename attribute, see verifyValues.
+func (f *Filesystem) structuredFiles(vCtx map[string]string) ([]File, error) {
+	entries := splitEntries(vCtx[renderAttribute])
+	if len(entries) == 0 {
+		if _, ok := vCtx[valuesAttribute]; ok {
+			return nil, fmt.Errorf("%w: values need a render attribute", ErrInvalidAttribute)
+		}
+
+		return nil, nil
+	}
+
+	values, err := structuredValues(vCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.verifyValues(values, vCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]File, 0, len(entries))
+
+	for _, entry := range entries {
+		format, path, mode, err := parseFileEntry(entry, defaultFilePerms)
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.Contains(entry, "=") {
+			path = "config." + format
+		}
+
+		data, err := structured.Render(format, values)
+		if err != nil {
+			if errors.Is(err, structured.ErrUnsupported) {
+				return nil, fmt.Errorf("%w: %w, use one of %v", ErrInvalidAttribute, err, structured.Formats())
+			}
+
+			return nil, fmt.Errorf("%w: rendering %s: %w", ErrInvalidAttribute, format, err)
+		}
+
+		files = append(files, File{Path: path, Mode: mode, Data: data})
+	}
+
+	return files, nil
+}
+
+// structuredValues merges the JSON document of the values attribute with the
+// value.<key> attributes.
+func structuredValues(vCtx map[string]string) (map[string]any, error) {
+	values := make(map[string]any)
+
+	if document, ok := vCtx[valuesAttribute]; ok {
+		parsed, err := structured.Parse([]byte(document))
+		if err != nil {
+			return nil, fmt.Errorf("%w: values: %w", ErrInvalidAttribute, err)
+		}
+
+		values = parsed
+	}
+
+	for attribute, value := range vCtx {
+		key, ok := strings.CutPrefix(attribute, valueAttributePrefix)
+		if !ok {
+			continue
+		}
+
+		if key == "" {
+			return nil, fmt.Errorf("%w: empty key in %q", ErrInvalidAttribute, attribute)
+		}
+
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("%w: key %q is set in values and %s", ErrInvalidAttribute, key, attribute)
+		}
+
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+// verifyValues checks the data-signature attribute against the canonical
+// form of values, the compact JSON encoding with sorted keys of the values
+// attribute merged with the value.<key> attributes, placed at the render
+// attribute. Values have to be signed
+// when the backend requires signed content. Otherwise they are only verified
+// when the volume has no filename attribute the signature would belong to, so
+// a signed volume carries either a file or rendered values.
+func (f *Filesystem) verifyValues(values map[string]any, vCtx map[string]string) error {
+	_, signed := vCtx[dataSignatureAttribute]
+	if !f.signaturesRequired && (!signed || vCtx[filenameAttribute] != "") {
+		return nil
+	}
+
+	var canonical bytes.Buffer
+
+	encoder := json.NewEncoder(&canonical)
+	encoder.SetEscapeHTML(false)
+
+	err := encoder.Encode(values)
+	if err != nil {
+		return fmt.Errorf("%w: values: %w", ErrInvalidAttribute, err)
+	}
+
+	return f.verifyContent(vCtx[renderAttribute], bytes.TrimSuffix(canonical.Bytes(), []byte("\n")), vCtx)
+}
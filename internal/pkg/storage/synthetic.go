@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"csi-driver/internal/pkg/synthetic"
+)
+
+const (
+	defaultSyntheticManifest = "SHA256SUMS"
+	maxSyntheticFanout       = 256
+)
+
+// syntheticAttributes describe a dataset completely, the same values always
+// generate the same dataset.
+var syntheticAttributes = []string{
+	sourceAttribute,
+	syntheticFilesAttribute,
+	syntheticSizeAttribute,
+	syntheticFanoutAttribute,
+	syntheticDepthAttribute,
+	syntheticContentAttribute,
+	syntheticSeedAttribute,
+	syntheticManifestAttribute,
+}
+
+// syntheticFiles generates the dataset described by the synthetic-*
+// attributes, along with a manifest of checksums that `sha256sum -c` can
+// verify. The dataset has to fit into the volume size limit, so the source is
+// only available when one is configured. The current dataset is reused as
+// long as the attributes describing it are unchanged.
+func (f *Filesystem) syntheticFiles(content *render) ([]File, error) {
+	vCtx := content.vCtx
+
+	if f.maxVolumeSize == 0 {
+		return nil, fmt.Errorf("%w: no volume size limit", ErrNotConfigured)
+	}
+
+	spec, err := syntheticSpec(vCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestPath := defaultSyntheticManifest
+	if value, ok := vCtx[syntheticManifestAttribute]; ok {
+		manifestPath = value
+	}
+
+	err = validateRelativePath(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if reused, ok := reuseSynthetic(content, manifestPath); ok {
+		return reused, nil
+	}
+
+	generated, err := synthetic.Generate(spec, f.maxVolumeSize)
+	if err != nil {
+		if errors.Is(err, synthetic.ErrInvalidSpec) || errors.Is(err, synthetic.ErrTooLarge) {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidAttribute, err)
+		}
+
+		return nil, fmt.Errorf("failed to generate dataset: %w", err)
+	}
+
+	files := make([]File, 0, len(generated)+1)
+	for _, file := range generated {
+		files = append(files, File{Path: file.Path, Mode: defaultFilePerms, Data: file.Data})
+	}
+
+	return append(files, File{
+		Path: manifestPath,
+		Mode: defaultFilePerms,
+		Data: synthetic.Manifest(generated),
+	}), nil
+}
+
+// reuseSynthetic returns the dataset in the current content if it was
+// generated from the same attributes and still matches its manifest.
+func reuseSynthetic(content *render, manifestPath string) ([]File, bool) {
+	if content.current == "" {
+		return nil, false
+	}
+
+	for _, attribute := range syntheticAttributes {
+		if content.vCtx[attribute] != content.previous[attribute] {
+			return nil, false
+		}
+	}
+
+	manifest, err := os.ReadFile(filepath.Join(content.current, manifestPath))
+	if err != nil {
+		return nil, false
+	}
+
+	var files []File
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(manifest), "\n"), "\n") {
+		sum, path, found := strings.Cut(line, "  ")
+		if !found {
+			return nil, false
+		}
+
+		data, err := os.ReadFile(filepath.Join(content.current, path))
+		if err != nil {
+			return nil, false
+		}
+
+		digest := sha256.Sum256(data)
+		if hex.EncodeToString(digest[:]) != sum {
+			return nil, false
+		}
+
+		files = append(files, File{Path: path, Mode: defaultFilePerms, Data: data})
+	}
+
+	return append(files, File{Path: manifestPath, Mode: defaultFilePerms, Data: manifest}), true
+}
+
+func syntheticSpec(vCtx map[string]string) (*synthetic.Spec, error) {
+	spec := &synthetic.Spec{Content: synthetic.ContentRandom}
+
+	files, err := intArgument(vCtx[syntheticFilesAttribute], 0, synthetic.MaxFiles)
+	if err != nil || files == 0 {
+		return nil, fmt.Errorf("%w: synthetic source needs a file count between 1 and %d",
+			ErrInvalidAttribute, synthetic.MaxFiles,
+		)
+	}
+
+	spec.Files = files
+
+	spec.MinSize, spec.MaxSize, err = synthetic.ParseSizeRange(vCtx[syntheticSizeAttribute])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAttribute, err)
+	}
+
+	if value := vCtx[syntheticFanoutAttribute]; value != "" {
+		spec.Fanout, err = intArgument(value, 0, maxSyntheticFanout)
+		if err != nil {
+			return nil, err
+		}
+
+		spec.Depth = 1
+	}
+
+	if value := vCtx[syntheticDepthAttribute]; value != "" {
+		spec.Depth, err = intArgument(value, 0, synthetic.MaxDepth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if value := vCtx[syntheticContentAttribute]; value != "" {
+		spec.Content = value
+	}
+
+	if value := vCtx[syntheticSeedAttribute]; value != "" {
+		spec.Seed, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid synthetic seed %q", ErrInvalidAttribute, value)
+		}
+	}
+
+	return spec, nil
+}
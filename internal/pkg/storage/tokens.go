@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// serviceAccountToken is a single entry of the tokens kubelet passes in the
+// volume context, keyed by audience.
+type serviceAccountToken struct {
+	Token               string    `json:"token"`
+	ExpirationTimestamp time.Time `json:"expirationTimestamp"`
+}
+
+// tokenFiles writes the service account tokens selected by the tokens
+// attribute. The attribute is a comma separated list of audience[=path][:mode]
+// entries; every audience must also be listed in the tokenRequests of the
+// CSIDriver object so kubelet requests a token for it.
+func tokenFiles(vCtx map[string]string) ([]File, error) {
+	entries := splitEntries(vCtx[tokensAttribute])
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	raw, ok := vCtx[serviceAccountTokensKey]
+	if !ok {
+		return nil, fmt.Errorf("%w: no service account tokens in volume context, check the tokenRequests of the CSIDriver",
+			ErrInvalidAttribute,
+		)
+	}
+
+	var tokens map[string]serviceAccountToken
+
+	// the decoding error is not wrapped since it may quote token content.
+	err := json.Unmarshal([]byte(raw), &tokens)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed service account tokens", ErrInvalidAttribute)
+	}
+
+	files := make([]File, 0, len(entries))
+
+	for _, entry := range entries {
+		audience, path, mode, err := parseFileEntry(entry, defaultSecretPerms)
+		if err != nil {
+			return nil, err
+		}
+
+		token, ok := tokens[audience]
+		if !ok || token.Token == "" {
+			return nil, fmt.Errorf("%w: no service account token for audience %q", ErrInvalidAttribute, audience)
+		}
+
+		files = append(files, File{
+			Path: path,
+			Mode: mode,
+			Data: []byte(token.Token),
+		})
+	}
+
+	return files, nil
+}
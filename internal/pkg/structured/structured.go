@@ -0,0 +1,404 @@
+// Package structured renders a document of key/value data into common config
+// file formats.
+package structured
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// FormatEnv is a dotenv file. Nested keys are joined with underscores.
+	FormatEnv = "env"
+	// FormatJSON is an indented JSON document.
+	FormatJSON = "json"
+	// FormatYAML is a block style YAML document.
+	FormatYAML = "yaml"
+	// FormatProperties is a Java properties file. Nested keys are joined
+	// with dots.
+	FormatProperties = "properties"
+	// FormatINI is an INI file with one section per top level object.
+	FormatINI = "ini"
+)
+
+// ErrUnsupported is returned for unknown formats.
+var ErrUnsupported = errors.New("unsupported format")
+
+// ErrInvalidValue is returned when a value cannot be represented in the
+// requested format. The error names the offending key.
+var ErrInvalidValue = errors.New("invalid value")
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Formats lists the supported formats.
+func Formats() []string {
+	return []string{FormatEnv, FormatJSON, FormatYAML, FormatProperties, FormatINI}
+}
+
+// Parse decodes a JSON object. Numbers are kept as written.
+func Parse(document []byte) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+
+	var values map[string]any
+
+	err := decoder.Decode(&values)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidValue, err)
+	}
+
+	if values == nil {
+		return nil, fmt.Errorf("%w: document is not an object", ErrInvalidValue)
+	}
+
+	return values, nil
+}
+
+// Render renders values in format. Values are strings, json.Number, float64,
+// bool, nil, []any or nested map[string]any as returned by Parse.
+func Render(format string, values map[string]any) ([]byte, error) {
+	switch format {
+	case FormatEnv:
+		return renderFlat(values, "_", envLine)
+	case FormatJSON:
+		return renderJSON(values)
+	case FormatYAML:
+		return renderYAML(values)
+	case FormatProperties:
+		return renderFlat(values, ".", propertiesLine)
+	case FormatINI:
+		return renderINI(values)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupported, format)
+	}
+}
+
+func renderJSON(values map[string]any) ([]byte, error) {
+	var buf bytes.Buffer
+
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+
+	err := encoder.Encode(values)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidValue, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// renderFlat renders formats without nesting, joining nested keys with sep.
+func renderFlat(values map[string]any, sep string, line func(key string, value string) (string, error)) ([]byte, error) {
+	flat := make(map[string]string)
+
+	err := flatten(flat, "", sep, values)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	for _, key := range sortedKeys(flat) {
+		rendered, err := line(key, flat[key])
+		if err != nil {
+			return nil, err
+		}
+
+		buf.WriteString(rendered)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+func flatten(flat map[string]string, prefix string, sep string, values map[string]any) error {
+	for key, value := range values {
+		if prefix != "" {
+			key = prefix + sep + key
+		}
+
+		if nested, ok := value.(map[string]any); ok {
+			err := flatten(flat, key, sep, nested)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		scalar, err := scalarString(key, value)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := flat[key]; ok {
+			return fmt.Errorf("%w: key %q is set more than once", ErrInvalidValue, key)
+		}
+
+		flat[key] = scalar
+	}
+
+	return nil
+}
+
+func envLine(key string, value string) (string, error) {
+	if !envName.MatchString(key) {
+		return "", fmt.Errorf("%w: key %q is not a valid environment variable name", ErrInvalidValue, key)
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`)
+
+	return key + `="` + replacer.Replace(value) + `"`, nil
+}
+
+func propertiesLine(key string, value string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("%w: empty key", ErrInvalidValue)
+	}
+
+	return escapeProperty(key, true) + "=" + escapeProperty(value, false), nil
+}
+
+// escapeProperty escapes s as specified for java.util.Properties. Non-ASCII
+// characters are written as \u escapes so the file can be read as ISO 8859-1.
+func escapeProperty(s string, isKey bool) string {
+	var buf strings.Builder
+
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			buf.WriteString(`\\`)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case r == '\f':
+			buf.WriteString(`\f`)
+		case r == '=', r == ':', r == '#', r == '!':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r == ' ' && (isKey || i == 0):
+			buf.WriteString(`\ `)
+		case r < 0x20 || r > 0x7e:
+			for _, unit := range utf16Units(r) {
+				fmt.Fprintf(&buf, `\u%04x`, unit)
+			}
+		default:
+			buf.WriteRune(r)
+		}
+	}
+
+	return buf.String()
+}
+
+func utf16Units(r rune) []rune {
+	if r < 0x10000 {
+		return []rune{r}
+	}
+
+	r -= 0x10000
+
+	return []rune{0xd800 + (r>>10)&0x3ff, 0xdc00 + r&0x3ff}
+}
+
+// renderINI writes top level scalars first and every top level object as a
+// section. Deeper nesting has no INI representation.
+func renderINI(values map[string]any) ([]byte, error) {
+	var (
+		buf      bytes.Buffer
+		sections []string
+	)
+
+	for _, key := range sortedKeys(values) {
+		if _, ok := values[key].(map[string]any); ok {
+			sections = append(sections, key)
+
+			continue
+		}
+
+		err := iniLine(&buf, key, key, values[key])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, section := range sections {
+		if strings.ContainsAny(section, "[]\n") {
+			return nil, fmt.Errorf("%w: key %q is not a valid section name", ErrInvalidValue, section)
+		}
+
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+
+		buf.WriteString("[" + section + "]\n")
+
+		nested, _ := values[section].(map[string]any)
+
+		for _, key := range sortedKeys(nested) {
+			if _, ok := nested[key].(map[string]any); ok {
+				return nil, fmt.Errorf("%w: key %q nests too deep for ini", ErrInvalidValue, section+"."+key)
+			}
+
+			err := iniLine(&buf, section+"."+key, key, nested[key])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func iniLine(buf *bytes.Buffer, path string, key string, value any) error {
+	scalar, err := scalarString(path, value)
+	if err != nil {
+		return err
+	}
+
+	if key == "" || strings.ContainsAny(key, "=;#[]\n") || strings.ContainsAny(scalar, "\r\n") {
+		return fmt.Errorf("%w: key %q cannot be written to ini", ErrInvalidValue, path)
+	}
+
+	buf.WriteString(key + " = " + scalar + "\n")
+
+	return nil
+}
+
+func renderYAML(values map[string]any) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if len(values) == 0 {
+		return []byte("{}\n"), nil
+	}
+
+	err := writeYAMLMap(&buf, "", 0, values)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeYAMLMap(buf *bytes.Buffer, path string, indent int, values map[string]any) error {
+	for _, key := range sortedKeys(values) {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+
+		buf.WriteString(strings.Repeat("  ", indent) + yamlString(key) + ":")
+
+		err := writeYAMLValue(buf, keyPath, indent, values[key])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeYAMLValue writes value after a "key:" or "-" that is already on the
+// line.
+func writeYAMLValue(buf *bytes.Buffer, path string, indent int, value any) error {
+	switch typed := value.(type) {
+	case map[string]any:
+		if len(typed) == 0 {
+			buf.WriteString(" {}\n")
+
+			return nil
+		}
+
+		buf.WriteByte('\n')
+
+		return writeYAMLMap(buf, path, indent+1, typed)
+	case []any:
+		if len(typed) == 0 {
+			buf.WriteString(" []\n")
+
+			return nil
+		}
+
+		buf.WriteByte('\n')
+
+		for i, item := range typed {
+			buf.WriteString(strings.Repeat("  ", indent+1) + "-")
+
+			err := writeYAMLValue(buf, fmt.Sprintf("%s[%d]", path, i), indent+1, item)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case string:
+		buf.WriteString(" " + yamlString(typed) + "\n")
+
+		return nil
+	default:
+		scalar, err := scalarString(path, value)
+		if err != nil {
+			return err
+		}
+
+		if value == nil {
+			scalar = "null"
+		}
+
+		buf.WriteString(" " + scalar + "\n")
+
+		return nil
+	}
+}
+
+// yamlString quotes strings as JSON, which is valid YAML, so values such as
+// "yes" or "0755" keep being strings.
+func yamlString(s string) string {
+	quoted, _ := json.Marshal(s)
+
+	return string(quoted)
+}
+
+// scalarString returns the text of a scalar value. Lists and objects are
+// refused, key names the value in the error.
+func scalarString(key string, value any) (string, error) {
+	switch typed := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		if !utf8.ValidString(typed) {
+			return "", fmt.Errorf("%w: key %q is not valid UTF-8", ErrInvalidValue, key)
+		}
+
+		return typed, nil
+	case json.Number:
+		return typed.String(), nil
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(typed), nil
+	default:
+		return "", fmt.Errorf("%w: key %q must be a string, number or boolean", ErrInvalidValue, key)
+	}
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,130 @@
+package structured_test
+
+import (
+	"csi-driver/internal/pkg/structured"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const document = `{
+	"name": "app",
+	"port": 8080,
+	"debug": false,
+	"db": {"host": "db.local", "password": "p=ss word"},
+	"tags": ["a", "b"]
+}`
+
+func TestRender(t *testing.T) {
+	t.Parallel()
+
+	values, err := structured.Parse([]byte(document))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	scalars, err := structured.Parse([]byte(`{"name": "app", "greeting": "grüß $USER", "db": {"host": "db.local"}}`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		format  string
+		values  map[string]any
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "json",
+			format: structured.FormatJSON,
+			values: scalars,
+			want:   "{\n  \"db\": {\n    \"host\": \"db.local\"\n  },\n  \"greeting\": \"grüß $USER\",\n  \"name\": \"app\"\n}\n",
+		},
+		{
+			name:   "yaml",
+			format: structured.FormatYAML,
+			values: values,
+			want: "\"db\":\n  \"host\": \"db.local\"\n  \"password\": \"p=ss word\"\n\"debug\": false\n" +
+				"\"name\": \"app\"\n\"port\": 8080\n\"tags\":\n  - \"a\"\n  - \"b\"\n",
+		},
+		{
+			name:   "env",
+			format: structured.FormatEnv,
+			values: scalars,
+			want:   "db_host=\"db.local\"\ngreeting=\"grüß \\$USER\"\nname=\"app\"\n",
+		},
+		{
+			name:    "env list",
+			format:  structured.FormatEnv,
+			values:  values,
+			wantErr: `key "tags"`,
+		},
+		{
+			name:    "env name",
+			format:  structured.FormatEnv,
+			values:  map[string]any{"not-valid": "x"},
+			wantErr: `key "not-valid"`,
+		},
+		{
+			name:   "properties",
+			format: structured.FormatProperties,
+			values: scalars,
+			want:   "db.host=db.local\ngreeting=gr\\u00fc\\u00df $USER\nname=app\n",
+		},
+		{
+			name:   "ini",
+			format: structured.FormatINI,
+			values: scalars,
+			want:   "greeting = grüß $USER\nname = app\n\n[db]\nhost = db.local\n",
+		},
+		{
+			name:    "ini too deep",
+			format:  structured.FormatINI,
+			values:  map[string]any{"a": map[string]any{"b": map[string]any{"c": "d"}}},
+			wantErr: `key "a.b"`,
+		},
+		{
+			name:    "unsupported",
+			format:  "toml",
+			values:  scalars,
+			wantErr: "unsupported format",
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := structured.Render(testCase.format, testCase.values)
+			if testCase.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.wantErr) {
+					t.Errorf("Render() error = %v, want it to mention %s", err, testCase.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+
+			if string(got) != testCase.want {
+				t.Errorf("Render() = %q, want %q", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	for _, document := range []string{`[1, 2]`, `null`, `{"a":`} {
+		_, err := structured.Parse([]byte(document))
+		if !errors.Is(err, structured.ErrInvalidValue) {
+			t.Errorf("Parse(%s) error = %v, want %v", document, err, structured.ErrInvalidValue)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Package synthetic generates deterministic datasets for benchmarks and
+// tests. The same spec always produces the same files.
+package synthetic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ContentRandom fills files with pseudo-random bytes from the seed.
+	ContentRandom = "random"
+	// ContentPattern repeats the path of each file, so corruption and
+	// misplaced data are easy to spot.
+	ContentPattern = "pattern"
+	// ContentZero fills files with zero bytes.
+	ContentZero = "zero"
+
+	// MaxFiles bounds the number of files of a dataset.
+	MaxFiles = 100000
+	// MaxDepth bounds the directory levels of a dataset.
+	MaxDepth = 8
+)
+
+var (
+	// ErrInvalidSpec is returned for specs that cannot be generated.
+	ErrInvalidSpec = errors.New("invalid dataset spec")
+	// ErrTooLarge is returned when a dataset exceeds the size limit.
+	ErrTooLarge = errors.New("dataset too large")
+)
+
+// Spec describes a dataset.
+type Spec struct {
+	// Files is the number of files.
+	Files int
+	// MinSize and MaxSize bound the file sizes, which are spread uniformly
+	// between them.
+	MinSize int64
+	MaxSize int64
+	// Fanout is the number of subdirectories per level, Depth the number of
+	// levels. Files are spread evenly across the leaf directories. A zero
+	// Fanout puts all files in the root.
+	Fanout int
+	Depth  int
+	// Content is one of ContentRandom, ContentPattern or ContentZero.
+	Content string
+	Seed    int64
+}
+
+// File is a generated file.
+type File struct {
+	Path string
+	Data []byte
+}
+
+// Validate checks spec and that the largest dataset it can produce stays
+// within maxSize bytes, unless maxSize is zero.
+func (spec *Spec) Validate(maxSize int64) error {
+	switch {
+	case spec.Files <= 0 || spec.Files > MaxFiles:
+		return fmt.Errorf("%w: file count must be between 1 and %d", ErrInvalidSpec, MaxFiles)
+	case spec.MinSize < 0 || spec.MaxSize < spec.MinSize:
+		return fmt.Errorf("%w: invalid size range %d-%d", ErrInvalidSpec, spec.MinSize, spec.MaxSize)
+	case spec.Fanout < 0 || spec.Depth < 0 || spec.Depth > MaxDepth:
+		return fmt.Errorf("%w: fanout and depth must not be negative, depth at most %d", ErrInvalidSpec, MaxDepth)
+	case spec.Fanout > 0 && spec.Depth == 0:
+		return fmt.Errorf("%w: fanout needs a depth", ErrInvalidSpec)
+	}
+
+	switch spec.Content {
+	case ContentRandom, ContentPattern, ContentZero:
+	default:
+		return fmt.Errorf("%w: unknown content %q", ErrInvalidSpec, spec.Content)
+	}
+
+	if maxSize > 0 && spec.MaxSize > maxSize/int64(spec.Files) {
+		return fmt.Errorf("%w: %d files of up to %d bytes exceed %d bytes", ErrTooLarge, spec.Files, spec.MaxSize, maxSize)
+	}
+
+	return nil
+}
+
+// Generate generates the dataset described by spec.
+func Generate(spec *Spec, maxSize int64) ([]File, error) {
+	err := spec.Validate(maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:gosec // the dataset has to be reproducible from the seed.
+	sizes := rand.New(rand.NewSource(spec.Seed))
+	nameWidth := len(strconv.Itoa(spec.Files - 1))
+	files := make([]File, 0, spec.Files)
+
+	for i := 0; i < spec.Files; i++ {
+		size := spec.MinSize
+		if spec.MaxSize > spec.MinSize {
+			size += sizes.Int63n(spec.MaxSize - spec.MinSize + 1)
+		}
+
+		name := path.Join(spec.dir(i), fmt.Sprintf("file-%0*d.bin", nameWidth, i))
+
+		files = append(files, File{
+			Path: name,
+			Data: spec.content(i, name, size),
+		})
+	}
+
+	return files, nil
+}
+
+// dir returns the directory of file i. Consecutive files go to different
+// leaf directories.
+func (spec *Spec) dir(i int) string {
+	if spec.Fanout == 0 {
+		return "."
+	}
+
+	width := len(strconv.Itoa(spec.Fanout - 1))
+	elements := make([]string, 0, spec.Depth)
+
+	for level := 0; level < spec.Depth; level++ {
+		elements = append(elements, fmt.Sprintf("dir-%0*d", width, i%spec.Fanout))
+		i /= spec.Fanout
+	}
+
+	return path.Join(elements...)
+}
+
+func (spec *Spec) content(i int, name string, size int64) []byte {
+	data := make([]byte, size)
+
+	switch spec.Content {
+	case ContentRandom:
+		// every file has its own stream so it does not depend on the others.
+		//nolint:gosec // the dataset has to be reproducible from the seed.
+		_, _ = rand.New(rand.NewSource(spec.Seed ^ int64(i+1)*0x5851f42d4c957f2d)).Read(data)
+	case ContentPattern:
+		pattern := name + "\n"
+		for offset := range data {
+			data[offset] = pattern[offset%len(pattern)]
+		}
+	}
+
+	return data
+}
+
+// Manifest returns a checksum file for files in the format of sha256sum, so
+// `sha256sum -c` verifies the dataset.
+func Manifest(files []File) []byte {
+	var manifest strings.Builder
+
+	for _, file := range files {
+		sum := sha256.Sum256(file.Data)
+		manifest.WriteString(hex.EncodeToString(sum[:]) + "  " + file.Path + "\n")
+	}
+
+	return []byte(manifest.String())
+}
+
+// ParseSize parses a size in bytes with an optional K, M or G suffix for
+// powers of 1000 or Ki, Mi or Gi for powers of 1024.
+func ParseSize(value string) (int64, error) {
+	multipliers := []struct {
+		suffix string
+		factor int64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30},
+		{"K", 1e3}, {"M", 1e6}, {"G", 1e9},
+	}
+
+	number, factor := value, int64(1)
+
+	for _, multiplier := range multipliers {
+		if trimmed, ok := strings.CutSuffix(value, multiplier.suffix); ok {
+			number, factor = trimmed, multiplier.factor
+
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 || size > (1<<62)/factor {
+		return 0, fmt.Errorf("%w: invalid size %q", ErrInvalidSpec, value)
+	}
+
+	return size * factor, nil
+}
+
+// ParseSizeRange parses a single size or a min-max range of sizes.
+func ParseSizeRange(value string) (int64, int64, error) {
+	minValue, maxValue, found := strings.Cut(value, "-")
+
+	minSize, err := ParseSize(minValue)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if !found {
+		return minSize, minSize, nil
+	}
+
+	maxSize, err := ParseSize(maxValue)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return minSize, maxSize, nil
+}
@@ -0,0 +1,174 @@
+package synthetic_test
+
+import (
+	"bytes"
+	"csi-driver/internal/pkg/synthetic"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	spec := &synthetic.Spec{
+		Files:   12,
+		MinSize: 10,
+		MaxSize: 100,
+		Fanout:  2,
+		Depth:   2,
+		Content: synthetic.ContentRandom,
+		Seed:    42,
+	}
+
+	first, err := synthetic.Generate(spec, 0)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	second, err := synthetic.Generate(spec, 0)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(first, second) {
+		t.Error("Generate() is not deterministic")
+	}
+
+	dirs := make(map[string]int)
+
+	for _, file := range first {
+		if size := int64(len(file.Data)); size < spec.MinSize || size > spec.MaxSize {
+			t.Errorf("%s has %d bytes, want %d-%d", file.Path, size, spec.MinSize, spec.MaxSize)
+		}
+
+		dirs[file.Path[:strings.LastIndex(file.Path, "/")]]++
+	}
+
+	// 12 files spread over 2x2 leaf directories.
+	want := map[string]int{"dir-0/dir-0": 3, "dir-1/dir-0": 3, "dir-0/dir-1": 3, "dir-1/dir-1": 3}
+	if !reflect.DeepEqual(dirs, want) {
+		t.Errorf("files per dir = %v, want %v", dirs, want)
+	}
+
+	spec.Seed = 43
+
+	reseeded, err := synthetic.Generate(spec, 0)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if bytes.Equal(first[0].Data, reseeded[0].Data) {
+		t.Error("another seed produced the same content")
+	}
+}
+
+func TestGenerate_Content(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{content: synthetic.ContentZero, want: "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{content: synthetic.ContentPattern, want: "file-0.bin\nfile-"},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.content, func(t *testing.T) {
+			t.Parallel()
+
+			files, err := synthetic.Generate(&synthetic.Spec{
+				Files: 1, MinSize: 16, MaxSize: 16, Content: testCase.content,
+			}, 0)
+			if err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+
+			if files[0].Path != "file-0.bin" || string(files[0].Data) != testCase.want {
+				t.Errorf("Generate() = %s %q, want file-0.bin %q", files[0].Path, files[0].Data, testCase.want)
+			}
+		})
+	}
+}
+
+func TestGenerate_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		spec    synthetic.Spec
+		maxSize int64
+		wantErr error
+	}{
+		{name: "no files", spec: synthetic.Spec{Content: synthetic.ContentZero}, wantErr: synthetic.ErrInvalidSpec},
+		{
+			name:    "inverted sizes",
+			spec:    synthetic.Spec{Files: 1, MinSize: 10, MaxSize: 1, Content: synthetic.ContentZero},
+			wantErr: synthetic.ErrInvalidSpec,
+		},
+		{
+			name:    "unknown content",
+			spec:    synthetic.Spec{Files: 1, Content: "lorem"},
+			wantErr: synthetic.ErrInvalidSpec,
+		},
+		{
+			name:    "too large",
+			spec:    synthetic.Spec{Files: 10, MaxSize: 1024, Content: synthetic.ContentZero},
+			maxSize: 4096,
+			wantErr: synthetic.ErrTooLarge,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := synthetic.Generate(&testCase.spec, testCase.maxSize)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Errorf("Generate() error = %v, want %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseSizeRange(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value   string
+		wantMin int64
+		wantMax int64
+		wantErr bool
+	}{
+		{value: "512", wantMin: 512, wantMax: 512},
+		{value: "4Ki", wantMin: 4096, wantMax: 4096},
+		{value: "1K-2Mi", wantMin: 1000, wantMax: 2 << 20},
+		{value: "", wantErr: true},
+		{value: "1X", wantErr: true},
+		{value: "-1", wantErr: true},
+	}
+
+	for _, testCase := range tests {
+		gotMin, gotMax, err := synthetic.ParseSizeRange(testCase.value)
+		if (err != nil) != testCase.wantErr || gotMin != testCase.wantMin || gotMax != testCase.wantMax {
+			t.Errorf("ParseSizeRange(%q) = %d, %d, %v", testCase.value, gotMin, gotMax, err)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	t.Parallel()
+
+	manifest := synthetic.Manifest([]synthetic.File{{Path: "a/b.bin", Data: []byte("abc")}})
+
+	want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad  a/b.bin\n"
+	if string(manifest) != want {
+		t.Errorf("Manifest() = %q, want %q", manifest, want)
+	}
+}
@@ -0,0 +1,293 @@
+// Package csidriver is the embeddable API of the driver. It wires the CSI
+// identity and node servers to the tmpfs storage backend so other programs can
+// build their own driver on top of it, usually by adding content providers
+// for new values of the csi-driver.mattslater.io/source attribute. Providers
+// only decide which files a volume gets, writing them, swapping them in
+// atomically, refreshing them on republish and cleaning up is handled here.
+package csidriver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"csi-driver/internal/pkg/driver"
+	"csi-driver/internal/pkg/hook"
+	"csi-driver/internal/pkg/integrity"
+	"csi-driver/internal/pkg/policy"
+	"csi-driver/internal/pkg/prepare"
+	"csi-driver/internal/pkg/refresh"
+	"csi-driver/internal/pkg/retention"
+	"csi-driver/internal/pkg/server"
+	"csi-driver/internal/pkg/storage"
+
+	"go.uber.org/zap"
+	"k8s.io/mount-utils"
+)
+
+type (
+	// NodeServer implements csi.NodeServer.
+	NodeServer = driver.NodeServer
+	// IdentityServer implements csi.IdentityServer.
+	IdentityServer = driver.IdentityServer
+	// ControllerServer implements csi.ControllerServer.
+	ControllerServer = driver.ControllerServer
+	// Storage is a storage backend for volumes.
+	Storage = storage.Storage
+	// Filesystem is the tmpfs storage backend.
+	Filesystem = storage.Filesystem
+	// StorageOption configures the built-in content types of Filesystem.
+	StorageOption = storage.Option
+
+	// File is a file of a volume. A zero Mode selects 0644.
+	File = storage.File
+	// ContentRequest describes the volume a ContentProvider produces files
+	// for.
+	ContentRequest = storage.ContentRequest
+	// ContentProvider decides which files a volume gets.
+	ContentProvider = storage.ContentProvider
+	// ContentProviderFunc adapts a function to ContentProvider.
+	ContentProviderFunc = storage.ContentProviderFunc
+	// HookRunner runs lifecycle hooks around publishing and unpublishing.
+	HookRunner = hook.Runner
+	// PolicyStore holds the policy that admits volume attributes.
+	PolicyStore = policy.Store
+)
+
+var (
+	// ErrInvalidAttribute makes publishing fail with InvalidArgument.
+	ErrInvalidAttribute = storage.ErrInvalidAttribute
+	// ErrNotConfigured makes publishing fail with FailedPrecondition.
+	ErrNotConfigured = storage.ErrNotConfigured
+	// ErrUntrusted makes publishing fail with PermissionDenied.
+	ErrUntrusted = storage.ErrUntrusted
+	// ErrUnavailable makes publishing fail with Unavailable, so kubelet
+	// retries.
+	ErrUnavailable = storage.ErrUnavailable
+	// ErrQuotaExceeded makes publishing fail with ResourceExhausted.
+	ErrQuotaExceeded = storage.ErrQuotaExceeded
+
+	errNoName       = errors.New("driver name is required")
+	errNoStorageDir = errors.New("storage dir is required")
+)
+
+const (
+	defaultVersion        = "dev"
+	defaultPublishTimeout = 10 * time.Second
+	defaultRefresh        = 10 * time.Second
+	defaultSweep          = time.Minute
+	// keepPrepared outlasts the backoff of kubelet between publish retries,
+	// so prepared content is picked up by the next retry.
+	keepPrepared = 5 * time.Minute
+)
+
+// Options wires a driver together. Name and StorageDir are required.
+type Options struct {
+	// Name is the name of the driver, e.g. example.com/my-driver.
+	Name string
+	// Version is reported by GetPluginInfo and defaults to dev.
+	Version string
+	// NodeID is reported by NodeGetInfo.
+	NodeID string
+	// Logger defaults to a no-op logger.
+	Logger *zap.Logger
+	// Mounter defaults to the system mounter.
+	Mounter mount.Interface
+	// StorageDir is where volumes are kept. A tmpfs is mounted there unless
+	// it is a mount point already.
+	StorageDir string
+	// Providers maps values of the source attribute to the content providers
+	// serving them. Built-in sources cannot be replaced.
+	Providers map[string]ContentProvider
+	// StorageOptions configure built-in content types, see the With
+	// functions of this package.
+	StorageOptions []StorageOption
+	// PrepareWorkers enables background content preparation with that many
+	// workers. Publishing then waits at most PublishTimeout, which defaults to
+	// 10s, before asking kubelet to retry.
+	PrepareWorkers int
+	PublishTimeout time.Duration
+	// RefreshInterval is how often Serve looks for volumes whose content has
+	// to be refreshed, e.g. certificates due for renewal. It defaults to 10s.
+	RefreshInterval time.Duration
+	// VerifyInterval is how often Serve verifies volume content against what
+	// was written. Zero disables verification, drifted content is then only
+	// noticed on republish. RepairDrift restores drifted content.
+	VerifyInterval time.Duration
+	RepairDrift    bool
+	// KeepVersions is how many replaced versions of its content every volume
+	// keeps, so Storage can roll it back.
+	KeepVersions int
+	// MaxRetention enables the retention-period attribute, which keeps the
+	// data of unpublished volumes for a republish, up to that long. Serve
+	// sweeps data whose retention period ended every SweepInterval, which
+	// defaults to a minute.
+	MaxRetention  time.Duration
+	SweepInterval time.Duration
+	// Hooks run around publishing and unpublishing when set, see
+	// NewHookRunner.
+	Hooks *HookRunner
+	// Policy admits the attributes and the files of published volumes when
+	// set, see NewPolicyStore.
+	Policy *PolicyStore
+	// Topology holds the segments the node is in and is advertised along
+	// with accessibility constraints when set.
+	Topology map[string]string
+	// MaxVolumesPerNode limits the volumes on the node, zero means no limit.
+	MaxVolumesPerNode int64
+}
+
+// Driver is a wired driver.
+type Driver struct {
+	IdentityServer   *IdentityServer
+	ControllerServer *ControllerServer
+	NodeServer       *NodeServer
+	Storage          *Filesystem
+
+	logger          *zap.Logger
+	refreshInterval time.Duration
+	verifyInterval  time.Duration
+	sweepInterval   time.Duration
+}
+
+// New returns a Driver configured by opts.
+func New(opts Options) (*Driver, error) {
+	if opts.Name == "" {
+		return nil, errNoName
+	}
+
+	if opts.StorageDir == "" {
+		return nil, errNoStorageDir
+	}
+
+	if opts.Version == "" {
+		opts.Version = defaultVersion
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+
+	if opts.RefreshInterval == 0 {
+		opts.RefreshInterval = defaultRefresh
+	}
+
+	if opts.SweepInterval == 0 {
+		opts.SweepInterval = defaultSweep
+	}
+
+	if opts.Mounter == nil {
+		opts.Mounter = mount.New("")
+	}
+
+	storageOpts := append([]StorageOption{}, opts.StorageOptions...)
+	if opts.MaxVolumesPerNode > 0 {
+		storageOpts = append(storageOpts, storage.WithMaxVolumes(int(opts.MaxVolumesPerNode)))
+	}
+
+	if opts.KeepVersions > 0 {
+		storageOpts = append(storageOpts, storage.WithVersionHistory(opts.KeepVersions))
+	}
+
+	if opts.MaxRetention > 0 {
+		storageOpts = append(storageOpts, storage.WithMaxRetention(opts.MaxRetention))
+	}
+
+	if opts.Policy != nil {
+		storageOpts = append(storageOpts, storage.WithFilePolicy(opts.Policy))
+	}
+
+	if opts.RepairDrift {
+		storageOpts = append(storageOpts, storage.WithDriftRepair())
+	}
+
+	for source, contentProvider := range opts.Providers {
+		storageOpts = append(storageOpts, storage.WithContentProvider(source, contentProvider))
+	}
+
+	backend, err := storage.NewFilesystem(
+		opts.Logger.With(zap.String("subsystem", "fs storage backend")),
+		opts.StorageDir,
+		os.DirFS("/"),
+		opts.Mounter,
+		storageOpts...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage backend: %w", err)
+	}
+
+	nodeServer := &NodeServer{
+		Logger:         opts.Logger,
+		NodeID:         opts.NodeID,
+		Mounter:        opts.Mounter,
+		StorageBackend: backend,
+		Hooks:          opts.Hooks,
+		Policy:         opts.Policy,
+
+		Topology:          opts.Topology,
+		MaxVolumesPerNode: opts.MaxVolumesPerNode,
+	}
+
+	if opts.PrepareWorkers > 0 {
+		nodeServer.Preparer = prepare.NewPool(
+			opts.Logger.With(zap.String("subsystem", "preparer")),
+			opts.PrepareWorkers,
+			keepPrepared,
+		)
+		nodeServer.PublishTimeout = opts.PublishTimeout
+
+		if nodeServer.PublishTimeout == 0 {
+			nodeServer.PublishTimeout = defaultPublishTimeout
+		}
+	}
+
+	return &Driver{
+		IdentityServer:   &IdentityServer{Name: opts.Name, Version: opts.Version, Topology: len(opts.Topology) > 0},
+		ControllerServer: &ControllerServer{StorageBackend: backend},
+		NodeServer:       nodeServer,
+		Storage:          backend,
+		logger:           opts.Logger,
+		refreshInterval:  opts.RefreshInterval,
+		verifyInterval:   opts.VerifyInterval,
+		sweepInterval:    opts.SweepInterval,
+	}, nil
+}
+
+// Serve serves the CSI services on listener and refreshes and verifies volume
+// content and sweeps retained data in the background until ctx is done and
+// then stops gracefully.
+func (d *Driver) Serve(ctx context.Context, listener net.Listener) error {
+	go refresh.Run(ctx, d.logger.With(zap.String("subsystem", "refresh")), d.Storage, d.refreshInterval)
+
+	if d.verifyInterval > 0 {
+		go integrity.Run(ctx, d.logger.With(zap.String("subsystem", "integrity")), d.Storage, d.verifyInterval)
+	}
+
+	go retention.Run(ctx, d.logger.With(zap.String("subsystem", "retention")), d.Storage, d.sweepInterval)
+
+	grpcServer := server.NewExtendedGRPCServer(
+		listener,
+		d.IdentityServer,
+		d.ControllerServer,
+		d.NodeServer,
+		d.logger,
+	)
+
+	errChan := make(chan error, 1)
+
+	go func() {
+		errChan <- grpcServer.Run()
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		grpcServer.GracefulStop()
+
+		return nil
+	}
+}
@@ -0,0 +1,180 @@
+package csidriver_test
+
+import (
+	"context"
+	"csi-driver/pkg/csidriver"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/mount-utils"
+)
+
+func greeting(_ context.Context, req *csidriver.ContentRequest) ([]csidriver.File, error) {
+	name := req.Attributes["example.com/name"]
+	if name == "" {
+		return nil, errors.Join(csidriver.ErrInvalidAttribute, errors.New("example.com/name is required"))
+	}
+
+	return []csidriver.File{{Path: "greeting.txt", Data: []byte("hello " + name)}}, nil
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		opts    csidriver.Options
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			opts: csidriver.Options{Name: "example.com/driver", StorageDir: t.TempDir()},
+		},
+		{
+			name:    "missing name",
+			opts:    csidriver.Options{StorageDir: t.TempDir()},
+			wantErr: true,
+		},
+		{
+			name:    "missing storage dir",
+			opts:    csidriver.Options{Name: "example.com/driver"},
+			wantErr: true,
+		},
+		{
+			name: "provider replaces built-in source",
+			opts: csidriver.Options{
+				Name:       "example.com/driver",
+				StorageDir: t.TempDir(),
+				Providers:  map[string]csidriver.ContentProvider{"url": csidriver.ContentProviderFunc(greeting)},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			testCase.opts.Logger = zaptest.NewLogger(t)
+			testCase.opts.Mounter = mount.NewFakeMounter([]mount.MountPoint{})
+
+			_, err := csidriver.New(testCase.opts)
+			if (err != nil) != testCase.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestDriver_ContentProvider(t *testing.T) {
+	t.Parallel()
+
+	csiDriver, err := csidriver.New(csidriver.Options{
+		Name:       "example.com/driver",
+		Logger:     zaptest.NewLogger(t),
+		Mounter:    mount.NewFakeMounter([]mount.MountPoint{}),
+		StorageDir: t.TempDir(),
+		Providers: map[string]csidriver.ContentProvider{
+			"greeting": csidriver.ContentProviderFunc(greeting),
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	_, err = csiDriver.NodeServer.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "vol",
+		TargetPath: filepath.Join(t.TempDir(), "target"),
+		VolumeContext: map[string]string{
+			"csi-driver.mattslater.io/source": "greeting",
+			"example.com/name":                "world",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(csiDriver.Storage.PathForVolume("vol"), "greeting.txt"))
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("greeting.txt = %q, %v, want %q", data, err, "hello world")
+	}
+
+	_, err = csiDriver.NodeServer.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:      "invalid",
+		TargetPath:    filepath.Join(t.TempDir(), "target"),
+		VolumeContext: map[string]string{"csi-driver.mattslater.io/source": "greeting"},
+	})
+	if got := status.Code(err); got != codes.InvalidArgument {
+		t.Errorf("NodePublishVolume() code = %v, want %v", got, codes.InvalidArgument)
+	}
+}
+
+func TestDriver_StorageOptions(t *testing.T) {
+	t.Parallel()
+
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+
+	err := os.WriteFile(policyPath, []byte(`{
+	"default": "allow",
+	"rules": [{"name": "no team-b", "match": {"namespaces": ["team-b"]}, "deny": true}]
+}`), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write policy: %v", err)
+	}
+
+	admission, err := csidriver.NewPolicyStore(zaptest.NewLogger(t), policyPath)
+	if err != nil {
+		t.Fatalf("NewPolicyStore() error = %v", err)
+	}
+
+	csiDriver, err := csidriver.New(csidriver.Options{
+		Name:       "example.com/driver",
+		Logger:     zaptest.NewLogger(t),
+		Mounter:    mount.NewFakeMounter([]mount.MountPoint{}),
+		StorageDir: t.TempDir(),
+		Policy:     admission,
+		StorageOptions: []csidriver.StorageOption{
+			csidriver.WithQuotas(&csidriver.Quotas{Default: csidriver.Quota{Volumes: 1}}),
+			csidriver.WithMaxVolumeSize(8),
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		id        string
+		namespace string
+		data      string
+		want      codes.Code
+	}{
+		{name: "admitted", id: "vol-1", namespace: "team-a", data: "data", want: codes.OK},
+		{name: "over the quota", id: "vol-2", namespace: "team-a", data: "data", want: codes.ResourceExhausted},
+		{name: "over the size limit", id: "vol-3", namespace: "team-c", data: "too much data", want: codes.InvalidArgument},
+		{name: "denied by policy", id: "vol-4", namespace: "team-b", data: "data", want: codes.PermissionDenied},
+	}
+
+	for _, testCase := range tests {
+		_, err := csiDriver.NodeServer.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:   testCase.id,
+			TargetPath: filepath.Join(t.TempDir(), "target"),
+			VolumeContext: map[string]string{
+				"csi-driver.mattslater.io/filename": "file",
+				"csi-driver.mattslater.io/data":     testCase.data,
+				"csi.storage.k8s.io/pod.namespace":  testCase.namespace,
+			},
+		})
+		if got := status.Code(err); got != testCase.want {
+			t.Errorf("%s: NodePublishVolume() code = %v, want %v", testCase.name, got, testCase.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package csidriver_test
+
+import (
+	"context"
+	"csi-driver/pkg/csidriver"
+	"fmt"
+	"log"
+	"net"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// A driver with a content type that writes the current time of the node,
+// refreshed whenever kubelet republishes the volume.
+func Example() {
+	clock := csidriver.ContentProviderFunc(func(_ context.Context, req *csidriver.ContentRequest) ([]csidriver.File, error) {
+		layout := req.Attributes["example.com/layout"]
+		if layout == "" {
+			layout = time.RFC3339
+		}
+
+		return []csidriver.File{{Path: "now", Data: []byte(time.Now().Format(layout))}}, nil
+	})
+
+	csiDriver, err := csidriver.New(csidriver.Options{
+		Name:       "example.com/clock",
+		NodeID:     "node-1",
+		StorageDir: "/storage-dir",
+		Providers:  map[string]csidriver.ContentProvider{"clock": clock},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	listener, err := net.Listen("unix", "/csi/csi.sock")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+
+	err = csiDriver.Serve(ctx, listener)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
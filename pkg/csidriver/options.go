@@ -0,0 +1,141 @@
+package csidriver
+
+import (
+	"fmt"
+	"time"
+
+	"csi-driver/internal/pkg/bundle"
+	"csi-driver/internal/pkg/cache"
+	"csi-driver/internal/pkg/command"
+	"csi-driver/internal/pkg/fetch"
+	"csi-driver/internal/pkg/hook"
+	"csi-driver/internal/pkg/policy"
+	"csi-driver/internal/pkg/signature"
+	"csi-driver/internal/pkg/storage"
+
+	"go.uber.org/zap"
+)
+
+type (
+	// Quotas configures what the volumes of every namespace on the node may
+	// use.
+	Quotas = storage.Quotas
+	// Quota limits what the volumes of a namespace may use.
+	Quota = storage.Quota
+	// BundleStore holds the content bundles of the node.
+	BundleStore = bundle.Store
+	// Bundle is a version of a content bundle.
+	Bundle = bundle.Bundle
+)
+
+// NewHookRunner loads the lifecycle hooks configured in the file at path.
+// Command hooks run in workDir and every run is logged to audit.
+func NewHookRunner(audit *zap.Logger, path string, workDir string) (*HookRunner, error) {
+	hooks, err := hook.Load(audit, path, workDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load hooks: %w", err)
+	}
+
+	return hooks, nil
+}
+
+// NewPolicyStore loads the policy in the file at path. Its Watch method keeps
+// it up to date with the file.
+func NewPolicyStore(logger *zap.Logger, path string) (*PolicyStore, error) {
+	admission, err := policy.NewStore(logger, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load policy: %w", err)
+	}
+
+	return admission, nil
+}
+
+// NewBundleStore loads the content bundles below dir. Its Watch method
+// reloads them, passing Driver.Storage.RefreshBundle as the callback pushes
+// new versions into the volumes.
+func NewBundleStore(logger *zap.Logger, dir string) (*BundleStore, error) {
+	bundles, err := bundle.NewStore(logger, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bundles: %w", err)
+	}
+
+	return bundles, nil
+}
+
+// WithBundles enables the bundle attribute for the bundles of store.
+func WithBundles(store *BundleStore) StorageOption {
+	return storage.WithBundles(store)
+}
+
+// WithFetcher enables the url source for the hosts in allowedHosts, which
+// lists host names, *.suffix wildcards and CIDRs. Downloads are limited to
+// maxSize bytes and timeout per attempt, failed attempts are retried up to
+// retries times with exponential backoff starting at backoff.
+func WithFetcher(
+	logger *zap.Logger,
+	allowedHosts []string,
+	maxSize int64,
+	timeout time.Duration,
+	retries int,
+	backoff time.Duration,
+) (StorageOption, error) {
+	allowed, err := fetch.ParseAllowlist(allowedHosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse allowed hosts: %w", err)
+	}
+
+	return storage.WithFetcher(fetch.NewFetcher(logger, allowed, maxSize, timeout, retries, backoff)), nil
+}
+
+// WithCommands enables the command source for the commands configured in the
+// file at path, which run in workDir.
+func WithCommands(logger *zap.Logger, path string, workDir string) (StorageOption, error) {
+	commands, err := command.Load(logger, path, workDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load commands: %w", err)
+	}
+
+	return storage.WithCommands(commands), nil
+}
+
+// WithSignatureVerifier enables the data-signature attribute for the PEM
+// encoded public keys in the file at path. If required is set, content that
+// is not signed by one of them is refused, and so is content of sources that
+// cannot be signed.
+func WithSignatureVerifier(path string, required bool) (StorageOption, error) {
+	verifier, err := signature.LoadVerifier(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load trusted keys: %w", err)
+	}
+
+	return storage.WithSignatureVerifier(verifier, required), nil
+}
+
+// WithContentCache keeps content that is expensive to produce, such as
+// unpacked images, in dir.
+func WithContentCache(logger *zap.Logger, dir string) (StorageOption, error) {
+	contentCache, err := cache.New(logger, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open content cache: %w", err)
+	}
+
+	return storage.WithContentCache(contentCache), nil
+}
+
+// WithOCILayouts enables the oci source for image layouts below dir. Images
+// larger than maxSize once unpacked are refused. The oci source also needs
+// WithContentCache.
+func WithOCILayouts(dir string, maxSize int64) StorageOption {
+	return storage.WithOCILayouts(dir, maxSize)
+}
+
+// WithQuotas limits the bytes and volumes of every namespace on the node.
+func WithQuotas(quotas *Quotas) StorageOption {
+	return storage.WithQuotas(quotas)
+}
+
+// WithMaxVolumeSize refuses volumes whose content exceeds maxSize bytes. It
+// also enables the synthetic source.
+func WithMaxVolumeSize(maxSize int64) StorageOption {
+	return storage.WithMaxVolumeSize(maxSize)
+}